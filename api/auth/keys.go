@@ -0,0 +1,168 @@
+// Package auth authenticates API clients by API key and checks their scopes.
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeFilesRead allows reading CIDs, file contents, history and listings.
+	ScopeFilesRead Scope = "files:read"
+	// ScopeFilesWrite allows uploading and removing files.
+	ScopeFilesWrite Scope = "files:write"
+	// ScopeAdmin allows everything, on every path, including transaction and access control management.
+	ScopeAdmin Scope = "admin"
+)
+
+// Key is an API key as stored at rest: only the SHA-256 hash of the secret is kept. A key with a
+// Wallet instead of a Hash lets that wallet use the API with the session of a SIWE sign-in.
+type Key struct {
+	// ID names the key in logs and responses; it is not secret.
+	ID string `json:"id"`
+	// Hash is the hex SHA-256 of the key, see HashKey.
+	Hash string `json:"hash,omitempty"`
+	// Wallet is the wallet allowed to sign in. Wallet keys may not have the admin scope.
+	Wallet *common.Address `json:"wallet,omitempty"`
+	Scopes []Scope         `json:"scopes"`
+	// Prefixes restricts the file scopes to paths starting with one of the prefixes. Empty means every path.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope. The admin scope implies every other scope.
+func (k *Key) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPath reports whether the key may use path, or list the paths below it.
+func (k *Key) AllowsPath(path string) bool {
+	if len(k.Prefixes) == 0 || k.HasScope(ScopeAdmin) {
+		return true
+	}
+	for _, prefix := range k.Prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashKey returns the hex SHA-256 of key, the form keys are stored in. API keys are long random
+// strings, so a fast hash is enough to keep them safe at rest.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyRing holds the keys accepted by the API.
+type KeyRing struct {
+	byHash   map[string]*Key
+	byWallet map[common.Address]*Key
+}
+
+// keyFile is the layout of the file read by LoadKeyRing.
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewKeyRing validates keys and returns a KeyRing accepting them.
+func NewKeyRing(keys []Key) (*KeyRing, error) {
+	ring := &KeyRing{byHash: make(map[string]*Key), byWallet: make(map[common.Address]*Key)}
+	ids := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := keys[i]
+		if key.ID == "" {
+			return nil, fmt.Errorf("key %d has no id", i)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ids[key.ID] = true
+
+		if key.Wallet != nil {
+			if key.Hash != "" {
+				return nil, fmt.Errorf("key %q has both a hash and a wallet", key.ID)
+			}
+			if _, ok := ring.byWallet[*key.Wallet]; ok {
+				return nil, fmt.Errorf("key %q: wallet is used by another key", key.ID)
+			}
+			if key.HasScope(ScopeAdmin) {
+				return nil, fmt.Errorf("key %q: wallets may not have the admin scope", key.ID)
+			}
+		} else {
+			key.Hash = strings.ToLower(key.Hash)
+			if raw, err := hex.DecodeString(key.Hash); err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("key %q: hash must be a hex SHA-256", key.ID)
+			}
+			if _, ok := ring.byHash[key.Hash]; ok {
+				return nil, fmt.Errorf("key %q: hash is used by another key", key.ID)
+			}
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("key %q has no scopes", key.ID)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeFilesRead && scope != ScopeFilesWrite && scope != ScopeAdmin {
+				return nil, fmt.Errorf("key %q: unknown scope %q", key.ID, scope)
+			}
+		}
+		for _, prefix := range key.Prefixes {
+			if prefix == "" {
+				return nil, fmt.Errorf("key %q: empty prefix", key.ID)
+			}
+		}
+		if key.Wallet != nil {
+			ring.byWallet[*key.Wallet] = &key
+		} else {
+			ring.byHash[key.Hash] = &key
+		}
+	}
+	return ring, nil
+}
+
+// LoadKeyRing reads keys from a JSON file of the form {"keys": [{"id": ..., "hash": ..., "scopes": [...]}]},
+// where wallet keys have a "wallet" address instead of a "hash".
+func LoadKeyRing(path string) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	ring, err := NewKeyRing(file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys: %w", err)
+	}
+	return ring, nil
+}
+
+// Authenticate returns the key matching the secret key presented by a client.
+func (r *KeyRing) Authenticate(key string) (*Key, bool) {
+	if key == "" {
+		return nil, false
+	}
+	k, ok := r.byHash[HashKey(key)]
+	return k, ok
+}
+
+// Wallet returns the key of a wallet signed in with SIWE.
+func (r *KeyRing) Wallet(address common.Address) (*Key, bool) {
+	k, ok := r.byWallet[address]
+	return k, ok
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing_Authenticate(t *testing.T) {
+	ring, err := NewKeyRing([]Key{
+		{ID: "team-a", Hash: HashKey("secret-a"), Scopes: []Scope{ScopeFilesRead, ScopeFilesWrite}, Prefixes: []string{"/team-a/"}},
+		{ID: "ops", Hash: HashKey("secret-ops"), Scopes: []Scope{ScopeAdmin}, Prefixes: []string{"/ignored/"}},
+	})
+	require.NoError(t, err)
+
+	key, ok := ring.Authenticate("secret-a")
+	require.True(t, ok)
+	assert.Equal(t, "team-a", key.ID)
+	assert.True(t, key.HasScope(ScopeFilesWrite))
+	assert.False(t, key.HasScope(ScopeAdmin))
+	assert.True(t, key.AllowsPath("/team-a/docs/a.txt"))
+	assert.False(t, key.AllowsPath("/team-b/a.txt"))
+
+	admin, ok := ring.Authenticate("secret-ops")
+	require.True(t, ok)
+	assert.True(t, admin.HasScope(ScopeFilesWrite))
+	assert.True(t, admin.AllowsPath("/team-b/a.txt"))
+
+	_, ok = ring.Authenticate("wrong")
+	assert.False(t, ok)
+	_, ok = ring.Authenticate("")
+	assert.False(t, ok)
+}
+
+func TestKeyRing_Wallet(t *testing.T) {
+	wallet := common.HexToAddress("0x00000000000000000000000000000000000000AA")
+	ring, err := NewKeyRing([]Key{
+		{ID: "alice", Wallet: &wallet, Scopes: []Scope{ScopeFilesRead, ScopeFilesWrite}, Prefixes: []string{"/alice/"}},
+	})
+	require.NoError(t, err)
+
+	key, ok := ring.Wallet(wallet)
+	require.True(t, ok)
+	assert.Equal(t, "alice", key.ID)
+	assert.True(t, key.AllowsPath("/alice/a.txt"))
+	assert.False(t, key.AllowsPath("/bob/a.txt"))
+
+	_, ok = ring.Wallet(common.HexToAddress("0x00000000000000000000000000000000000000BB"))
+	assert.False(t, ok)
+	// Wallet keys have no secret to authenticate with.
+	_, ok = ring.Authenticate("")
+	assert.False(t, ok)
+}
+
+func TestNewKeyRing_Invalid(t *testing.T) {
+	hash := HashKey("secret")
+	wallet := common.HexToAddress("0x00000000000000000000000000000000000000AA")
+	cases := map[string][]Key{
+		"missing id":       {{Hash: hash, Scopes: []Scope{ScopeFilesRead}}},
+		"duplicate id":     {{ID: "a", Hash: hash, Scopes: []Scope{ScopeFilesRead}}, {ID: "a", Hash: HashKey("other"), Scopes: []Scope{ScopeFilesRead}}},
+		"duplicate hash":   {{ID: "a", Hash: hash, Scopes: []Scope{ScopeFilesRead}}, {ID: "b", Hash: hash, Scopes: []Scope{ScopeFilesRead}}},
+		"bad hash":         {{ID: "a", Hash: "secret", Scopes: []Scope{ScopeFilesRead}}},
+		"no scopes":        {{ID: "a", Hash: hash}},
+		"unknown scope":    {{ID: "a", Hash: hash, Scopes: []Scope{"files:delete"}}},
+		"empty prefix":     {{ID: "a", Hash: hash, Scopes: []Scope{ScopeFilesRead}, Prefixes: []string{""}}},
+		"hash and wallet":  {{ID: "a", Hash: hash, Wallet: &wallet, Scopes: []Scope{ScopeFilesRead}}},
+		"duplicate wallet": {{ID: "a", Wallet: &wallet, Scopes: []Scope{ScopeFilesRead}}, {ID: "b", Wallet: &wallet, Scopes: []Scope{ScopeFilesRead}}},
+		"admin wallet":     {{ID: "a", Wallet: &wallet, Scopes: []Scope{ScopeAdmin}}},
+	}
+	for name, keys := range cases {
+		_, err := NewKeyRing(keys)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"keys": [{"id": "team-a", "hash": "` + HashKey("secret-a") + `", "scopes": ["files:read"]}]}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	ring, err := LoadKeyRing(path)
+	require.NoError(t, err)
+	key, ok := ring.Authenticate("secret-a")
+	require.True(t, ok)
+	assert.Equal(t, []Scope{ScopeFilesRead}, key.Scopes)
+
+	_, err = LoadKeyRing(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
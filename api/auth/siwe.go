@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Sign-In with Ethereum (EIP-4361) errors.
+var (
+	ErrInvalidMessage   = errors.New("invalid sign-in message")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUnknownNonce     = errors.New("unknown or used nonce")
+	ErrMessageExpired   = errors.New("sign-in message expired or not yet valid")
+	ErrWrongDomain      = errors.New("sign-in message is for another domain or chain")
+	ErrTooManyNonces    = errors.New("too many outstanding sign-in nonces")
+)
+
+// Defaults of Sessions.
+const (
+	DefaultSessionTTL = 24 * time.Hour
+	nonceTTL          = 10 * time.Minute
+	// maxNonces bounds the nonces issued and not used yet, so clients asking for nonces they never
+	// sign cannot grow the store without limit.
+	maxNonces = 10000
+	// sweepInterval is how often expired nonces and sessions are dropped.
+	sweepInterval = time.Minute
+)
+
+// SIWEMessage is a parsed EIP-4361 sign-in message.
+type SIWEMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+const siwePreamble = " wants you to sign in with your Ethereum account:"
+
+// ParseSIWEMessage parses the text of an EIP-4361 message.
+func ParseSIWEMessage(text string) (*SIWEMessage, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, fmt.Errorf("%w: missing preamble", ErrInvalidMessage)
+	}
+	msg := &SIWEMessage{Domain: strings.TrimSuffix(lines[0], siwePreamble)}
+	if msg.Domain == "" {
+		return nil, fmt.Errorf("%w: missing domain", ErrInvalidMessage)
+	}
+	if !common.IsHexAddress(lines[1]) {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidMessage, lines[1])
+	}
+	msg.Address = common.HexToAddress(lines[1])
+
+	// An empty line, then either a statement and an empty line, or just another empty line.
+	rest := lines[2:]
+	if len(rest) < 2 || rest[0] != "" {
+		return nil, fmt.Errorf("%w: missing empty line after the address", ErrInvalidMessage)
+	}
+	switch {
+	case rest[1] == "":
+		rest = rest[2:]
+	case len(rest) > 2 && rest[2] == "":
+		msg.Statement, rest = rest[1], rest[3:]
+	default:
+		return nil, fmt.Errorf("%w: missing empty line after the statement", ErrInvalidMessage)
+	}
+
+	var err error
+	for i := 0; i < len(rest); i++ {
+		line := rest[i]
+		if line == "Resources:" {
+			for _, resource := range rest[i+1:] {
+				if !strings.HasPrefix(resource, "- ") {
+					return nil, fmt.Errorf("%w: invalid resource %q", ErrInvalidMessage, resource)
+				}
+				msg.Resources = append(msg.Resources, strings.TrimPrefix(resource, "- "))
+			}
+			break
+		}
+		field, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid line %q", ErrInvalidMessage, line)
+		}
+		switch field {
+		case "URI":
+			msg.URI = value
+		case "Version":
+			msg.Version = value
+		case "Chain ID":
+			msg.ChainID, err = strconv.ParseInt(value, 10, 64)
+		case "Nonce":
+			msg.Nonce = value
+		case "Issued At":
+			msg.IssuedAt, err = time.Parse(time.RFC3339, value)
+		case "Expiration Time":
+			msg.ExpirationTime, err = parseTimePtr(value)
+		case "Not Before":
+			msg.NotBefore, err = parseTimePtr(value)
+		case "Request ID":
+			msg.RequestID = value
+		default:
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidMessage, field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s: %v", ErrInvalidMessage, field, err)
+		}
+	}
+
+	if msg.URI == "" || msg.Version != "1" || msg.ChainID == 0 || len(msg.Nonce) < 8 || msg.IssuedAt.IsZero() {
+		return nil, fmt.Errorf("%w: URI, Version 1, Chain ID, Nonce and Issued At are required", ErrInvalidMessage)
+	}
+	return msg, nil
+}
+
+func parseTimePtr(value string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RecoverPersonalSigner returns the account that signed message with personal_sign (EIP-191).
+func RecoverPersonalSigner(message string, signature []byte) (common.Address, error) {
+	return recoverSigner(accounts.TextHash([]byte(message)), signature)
+}
+
+// recoverSigner recovers the signer of hash from a 65 byte signature whose recovery ID is 0/1 or 27/28.
+func recoverSigner(hash, signature []byte) (common.Address, error) {
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("%w: length %d", ErrInvalidSignature, len(signature))
+	}
+	sig := append([]byte(nil), signature...)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// Session is a wallet signed in with SIWE.
+type Session struct {
+	Address   common.Address `json:"address"`
+	ExpiresAt time.Time      `json:"expiresAt"`
+}
+
+// Sessions issues SIWE nonces and the session tokens of signed in wallets. Sessions live in memory,
+// so they end when the API restarts. It is safe for concurrent use.
+type Sessions struct {
+	chainID int64
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // nonce -> expiry
+	sessions  map[string]Session   // token hash -> session
+	nextSweep time.Time
+}
+
+// NewSessions creates the session store of a chain. Non-positive ttl keeps DefaultSessionTTL.
+func NewSessions(chainID int64, ttl time.Duration) *Sessions {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &Sessions{
+		chainID:  chainID,
+		ttl:      ttl,
+		now:      time.Now,
+		nonces:   make(map[string]time.Time),
+		sessions: make(map[string]Session),
+	}
+}
+
+// Nonce issues a nonce for a sign-in message. It is valid once, for ten minutes. It fails with
+// ErrTooManyNonces while too many issued nonces are neither used nor expired.
+func (s *Sessions) Nonce() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(len(s.nonces) >= maxNonces)
+	if len(s.nonces) >= maxNonces {
+		return "", ErrTooManyNonces
+	}
+	nonce := randomHex(16)
+	s.nonces[nonce] = s.now().Add(nonceTTL)
+	return nonce, nil
+}
+
+// Login verifies a signed SIWE message for domain and returns a new session token.
+func (s *Sessions) Login(domain, message string, signature []byte) (string, Session, error) {
+	msg, err := ParseSIWEMessage(message)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if msg.Domain != domain || msg.ChainID != s.chainID {
+		return "", Session{}, ErrWrongDomain
+	}
+	now := s.now()
+	if (msg.ExpirationTime != nil && !now.Before(*msg.ExpirationTime)) || (msg.NotBefore != nil && now.Before(*msg.NotBefore)) {
+		return "", Session{}, ErrMessageExpired
+	}
+	signer, err := RecoverPersonalSigner(message, signature)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if signer != msg.Address {
+		return "", Session{}, fmt.Errorf("%w: signed by %s, not %s", ErrInvalidSignature, signer.Hex(), msg.Address.Hex())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(false)
+	if expiry, ok := s.nonces[msg.Nonce]; !ok || !now.Before(expiry) {
+		return "", Session{}, ErrUnknownNonce
+	}
+	delete(s.nonces, msg.Nonce)
+
+	session := Session{Address: signer, ExpiresAt: now.Add(s.ttl)}
+	if msg.ExpirationTime != nil && msg.ExpirationTime.Before(session.ExpiresAt) {
+		session.ExpiresAt = *msg.ExpirationTime
+	}
+	token := randomHex(32)
+	s.sessions[HashKey(token)] = session
+	return token, session, nil
+}
+
+// Authenticate returns the live session of token.
+func (s *Sessions) Authenticate(token string) (Session, bool) {
+	if token == "" {
+		return Session{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[HashKey(token)]
+	if !ok || !s.now().Before(session.ExpiresAt) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// sweep drops expired nonces and sessions, at most once per sweepInterval unless force is set. The
+// caller holds s.mu.
+func (s *Sessions) sweep(force bool) {
+	now := s.now()
+	if !force && now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(sweepInterval)
+	for nonce, expiry := range s.nonces {
+		if !now.Before(expiry) {
+			delete(s.nonces, nonce)
+		}
+	}
+	for hash, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, hash)
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func siweMessage(address, nonce string, expires *time.Time) string {
+	lines := []string{
+		"files.example.com wants you to sign in with your Ethereum account:",
+		address,
+		"",
+		"Sign in to the file registry.",
+		"",
+		"URI: https://files.example.com",
+		"Version: 1",
+		"Chain ID: 1337",
+		"Nonce: " + nonce,
+		"Issued At: 2024-01-01T00:00:00Z",
+	}
+	if expires != nil {
+		lines = append(lines, "Expiration Time: "+expires.Format(time.RFC3339))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func personalSign(t *testing.T, key *ecdsa.PrivateKey, message string) []byte {
+	sig, err := crypto.Sign(accounts.TextHash([]byte(message)), key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig
+}
+
+func TestParseSIWEMessage(t *testing.T) {
+	address := "0x00000000000000000000000000000000000000AA"
+	msg, err := ParseSIWEMessage(siweMessage(address, "abcdef123456", nil) + "\nResources:\n- ipfs://Qm1\n- https://example.com/a")
+	require.NoError(t, err)
+	assert.Equal(t, "files.example.com", msg.Domain)
+	assert.Equal(t, address, msg.Address.Hex())
+	assert.Equal(t, "Sign in to the file registry.", msg.Statement)
+	assert.Equal(t, int64(1337), msg.ChainID)
+	assert.Equal(t, "abcdef123456", msg.Nonce)
+	assert.Equal(t, []string{"ipfs://Qm1", "https://example.com/a"}, msg.Resources)
+
+	noStatement := strings.Replace(siweMessage(address, "abcdef123456", nil), "Sign in to the file registry.\n\n", "\n", 1)
+	msg, err = ParseSIWEMessage(noStatement)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Statement)
+
+	for _, bad := range []string{
+		"hello",
+		strings.Replace(siweMessage(address, "abcdef123456", nil), "Version: 1", "Version: 2", 1),
+		strings.Replace(siweMessage(address, "abcdef123456", nil), "Nonce: abcdef123456", "Nonce: short", 1),
+		strings.Replace(siweMessage(address, "abcdef123456", nil), address, "0x1234", 1),
+	} {
+		_, err := ParseSIWEMessage(bad)
+		assert.ErrorIs(t, err, ErrInvalidMessage, bad)
+	}
+}
+
+func TestSessions_Login(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	sessions := NewSessions(1337, time.Hour)
+
+	nonce, err := sessions.Nonce()
+	require.NoError(t, err)
+	message := siweMessage(address, nonce, nil)
+	token, session, err := sessions.Login("files.example.com", message, personalSign(t, key, message))
+	require.NoError(t, err)
+	assert.Equal(t, address, session.Address.Hex())
+
+	authenticated, ok := sessions.Authenticate(token)
+	require.True(t, ok)
+	assert.Equal(t, session, authenticated)
+	_, ok = sessions.Authenticate("other")
+	assert.False(t, ok)
+
+	// A nonce is accepted once.
+	_, _, err = sessions.Login("files.example.com", message, personalSign(t, key, message))
+	assert.ErrorIs(t, err, ErrUnknownNonce)
+
+	// Sessions expire.
+	sessions.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, ok = sessions.Authenticate(token)
+	assert.False(t, ok)
+}
+
+func TestSessions_LoginErrors(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	sessions := NewSessions(1337, 0)
+	nonce := func() string {
+		n, err := sessions.Nonce()
+		require.NoError(t, err)
+		return n
+	}
+
+	message := siweMessage(address, nonce(), nil)
+	_, _, err = sessions.Login("evil.example.com", message, personalSign(t, key, message))
+	assert.ErrorIs(t, err, ErrWrongDomain)
+
+	_, _, err = sessions.Login("files.example.com", message, personalSign(t, other, message))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	expired := time.Now().Add(-time.Minute)
+	message = siweMessage(address, nonce(), &expired)
+	_, _, err = sessions.Login("files.example.com", message, personalSign(t, key, message))
+	assert.ErrorIs(t, err, ErrMessageExpired)
+
+	message = siweMessage(address, "unissued-nonce", nil)
+	_, _, err = sessions.Login("files.example.com", message, personalSign(t, key, message))
+	assert.ErrorIs(t, err, ErrUnknownNonce)
+}
+
+func TestSessions_NonceCap(t *testing.T) {
+	sessions := NewSessions(1337, time.Hour)
+	for i := 0; i < maxNonces; i++ {
+		_, err := sessions.Nonce()
+		require.NoError(t, err)
+	}
+	_, err := sessions.Nonce()
+	assert.ErrorIs(t, err, ErrTooManyNonces)
+
+	// Expired nonces are swept to make room.
+	sessions.now = func() time.Time { return time.Now().Add(nonceTTL) }
+	_, err = sessions.Nonce()
+	require.NoError(t, err)
+	assert.Len(t, sessions.nonces, 1)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// EIP-712 domain name and version of signed uploads.
+const (
+	UploadDomainName    = "FileRegistry"
+	UploadDomainVersion = "1"
+)
+
+// uploadTypes are the EIP-712 types of signed uploads.
+var uploadTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	},
+	"Upload": {
+		{Name: "filePath", Type: "string"},
+		{Name: "cid", Type: "string"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "deadline", Type: "uint256"},
+	},
+}
+
+// UploadDomain binds signed uploads to one registry contract on one chain.
+type UploadDomain struct {
+	ChainID  *big.Int
+	Contract common.Address
+}
+
+// Upload is the EIP-712 Upload message a wallet signs to authorize saving cid at filePath. Nonce is
+// chosen by the wallet and is accepted once; Deadline is a unix time after which the signature expires.
+type Upload struct {
+	FilePath string
+	CID      string
+	Nonce    *big.Int
+	Deadline *big.Int
+}
+
+// TypedData returns the EIP-712 typed data of upload, as passed to eth_signTypedData_v4.
+func (d UploadDomain) TypedData(upload Upload) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types:       uploadTypes,
+		PrimaryType: "Upload",
+		Domain: apitypes.TypedDataDomain{
+			Name:              UploadDomainName,
+			Version:           UploadDomainVersion,
+			ChainId:           (*math.HexOrDecimal256)(d.ChainID),
+			VerifyingContract: d.Contract.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"filePath": upload.FilePath,
+			"cid":      upload.CID,
+			"nonce":    upload.Nonce.String(),
+			"deadline": upload.Deadline.String(),
+		},
+	}
+}
+
+// Hash returns the EIP-712 hash a wallet signs for upload.
+func (d UploadDomain) Hash(upload Upload) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(d.TypedData(upload))
+	return hash, err
+}
+
+// RecoverUploadSigner returns the wallet that signed upload.
+func (d UploadDomain) RecoverUploadSigner(upload Upload, signature []byte) (common.Address, error) {
+	hash, err := d.Hash(upload)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return recoverSigner(hash, signature)
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadDomain_RecoverUploadSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	domain := UploadDomain{ChainID: big.NewInt(1337), Contract: common.HexToAddress("0x00000000000000000000000000000000000000cc")}
+	upload := Upload{FilePath: "/docs/a.txt", CID: "QmFakeCID", Nonce: big.NewInt(7), Deadline: big.NewInt(1700000000)}
+
+	hash, err := domain.Hash(upload)
+	require.NoError(t, err)
+	sig, err := crypto.Sign(hash, key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+
+	signer, err := domain.RecoverUploadSigner(upload, sig)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey), signer)
+
+	// Any change of the message or domain yields another signer.
+	changed := upload
+	changed.CID = "QmOtherCID"
+	signer, err = domain.RecoverUploadSigner(changed, sig)
+	require.NoError(t, err)
+	assert.NotEqual(t, crypto.PubkeyToAddress(key.PublicKey), signer)
+
+	otherChain := UploadDomain{ChainID: big.NewInt(1), Contract: domain.Contract}
+	signer, err = otherChain.RecoverUploadSigner(upload, sig)
+	require.NoError(t, err)
+	assert.NotEqual(t, crypto.PubkeyToAddress(key.PublicKey), signer)
+
+	_, err = domain.RecoverUploadSigner(upload, sig[:64])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	"math/big"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Validation struct {
@@ -17,18 +19,111 @@ type Validation struct {
 	IpfsUrl         string `envconfig:"IPFS_URL" validate:"required,url"`
 	Port            string `envconfig:"PORT" validate:"required,numeric"`
 	ChainID         string `envconfig:"CHAIN_ID" validate:"numeric"`
-	PrivateKeyHex   string `envconfig:"PRIVATE_KEY" validate:"required,hexadecimal,len=66,startswith=0x"`
+	PrivateKeyHex   string `envconfig:"PRIVATE_KEY" validate:"omitempty,hexadecimal,len=66,startswith=0x"`
+	Signer          string `envconfig:"SIGNER" validate:"omitempty,oneof=key keystore remote"`
+	KeystorePath    string `envconfig:"KEYSTORE_PATH"`
+	KeystorePass    string `envconfig:"KEYSTORE_PASSWORD_FILE"`
+	RemoteSignerURL string `envconfig:"REMOTE_SIGNER_URL" validate:"omitempty,url"`
+	SignerAddress   string `envconfig:"SIGNER_ADDRESS" validate:"omitempty,len=42,startswith=0x"`
+	PoolKeys        string `envconfig:"PRIVATE_KEYS"`
+	PoolAddresses   string `envconfig:"SIGNER_ADDRESSES"`
+	SignerDispatch  string `envconfig:"SIGNER_DISPATCH" validate:"omitempty,oneof=round-robin least-pending"`
+	MinBalanceGwei  string `envconfig:"SIGNER_MIN_BALANCE_GWEI" validate:"omitempty,numeric"`
+	BalanceInterval string `envconfig:"SIGNER_BALANCE_INTERVAL" validate:"omitempty,numeric"`
+	MaxUploadSize   string `envconfig:"MAX_UPLOAD_SIZE" validate:"omitempty,numeric"`
+	Confirmations   string `envconfig:"TX_CONFIRMATIONS" validate:"omitempty,numeric"`
+	TxWaitTimeout   string `envconfig:"TX_WAIT_TIMEOUT" validate:"omitempty,numeric"`
+	FeeMode         string `envconfig:"FEE_MODE" validate:"omitempty,oneof=legacy dynamic"`
+	MaxFeeGwei      string `envconfig:"MAX_FEE_GWEI" validate:"omitempty,numeric"`
+	TipMultiplier   string `envconfig:"TIP_MULTIPLIER" validate:"omitempty,numeric"`
+	GasMultiplier   string `envconfig:"GAS_LIMIT_MULTIPLIER" validate:"omitempty,numeric"`
+	TxBudgetGwei    string `envconfig:"TX_BUDGET_GWEI" validate:"omitempty,numeric"`
+	TxStuckTimeout  string `envconfig:"TX_STUCK_TIMEOUT" validate:"omitempty,numeric"`
+	FeeBumpPercent  string `envconfig:"TX_FEE_BUMP_PERCENT" validate:"omitempty,numeric"`
+	MaxFeeBumps     string `envconfig:"TX_MAX_BUMPS" validate:"omitempty,numeric"`
+	IndexerDBPath   string `envconfig:"INDEXER_DB_PATH"`
+	IndexerStart    string `envconfig:"INDEXER_START_BLOCK" validate:"omitempty,numeric"`
+	OutboxDir       string `envconfig:"OUTBOX_DIR"`
+	OutboxWorkers   string `envconfig:"OUTBOX_WORKERS" validate:"omitempty,numeric"`
+	OutboxAttempts  string `envconfig:"OUTBOX_MAX_ATTEMPTS" validate:"omitempty,numeric"`
+	OutboxRetention string `envconfig:"OUTBOX_RETENTION" validate:"omitempty,numeric"`
+	WebhooksDBPath  string `envconfig:"WEBHOOKS_DB_PATH"`
+	WebhookAttempts string `envconfig:"WEBHOOK_MAX_ATTEMPTS" validate:"omitempty,numeric"`
+	BatchGasLimit   string `envconfig:"BATCH_GAS_LIMIT" validate:"omitempty,numeric"`
+	BatchWorkers    string `envconfig:"BATCH_IPFS_CONCURRENCY" validate:"omitempty,numeric"`
+	BatchMaxSize    string `envconfig:"BATCH_MAX_SIZE" validate:"omitempty,numeric"`
+	APIKeysFile     string `envconfig:"API_KEYS_FILE"`
+	SIWEEnabled     string `envconfig:"SIWE_ENABLED" validate:"omitempty,boolean"`
+	SIWEDomain      string `envconfig:"SIWE_DOMAIN"`
+	SessionTTL      string `envconfig:"SESSION_TTL" validate:"omitempty,numeric"`
+	SignedUploads   string `envconfig:"SIGNED_UPLOADS" validate:"omitempty,boolean"`
+	Forwarder       string `envconfig:"FORWARDER_ADDRESS" validate:"omitempty,len=42,startswith=0x"`
+	RelayMaxGas     string `envconfig:"RELAY_MAX_GAS" validate:"omitempty,numeric"`
+	RelayQuota      string `envconfig:"RELAY_QUOTA" validate:"omitempty,numeric"`
+	RelayWindow     string `envconfig:"RELAY_QUOTA_WINDOW" validate:"omitempty,numeric"`
+	EventOrigins    string `envconfig:"EVENTS_ALLOWED_ORIGINS"`
+	EventsBacklog   string `envconfig:"EVENTS_MAX_BACKLOG" validate:"omitempty,numeric"`
 }
 
 type GlobalConfig struct {
-	ContractAddress common.Address
-	EthRpcUrl       string
-	IpfsUrl         string
-	Port            string
-	ChainID         *big.Int
-	PrivateKey      []byte
+	ContractAddress    common.Address
+	EthRpcUrl          string
+	IpfsUrl            string
+	Port               string
+	ChainID            *big.Int
+	PrivateKey         []byte // only set for the key signer
+	Signer             string // SignerKey, SignerKeystore or SignerRemote
+	KeystorePath       string
+	KeystorePassword   string // path of the file holding the keystore password
+	RemoteSignerURL    string
+	SignerAddress      common.Address   // account of the keystore or remote signer, zero when unset
+	PoolPrivateKeys    [][]byte         // further accounts of the key signer
+	PoolAddresses      []common.Address // further accounts of the keystore or remote signer
+	SignerDispatch     string           // round-robin or least-pending
+	SignerMinBalance   *big.Int         // wei, nil never pauses accounts
+	BalanceInterval    time.Duration
+	MaxUploadSize      int64 // bytes, 0 means the API default
+	Confirmations      uint64
+	TxWaitTimeout      time.Duration
+	FeeMode            string
+	MaxFeePerGas       *big.Int // wei, nil means no cap
+	TipMultiplier      float64
+	GasLimitMultiplier float64
+	TxBudget           *big.Int      // wei, nil means no budget
+	TxStuckTimeout     time.Duration // 0 disables automatic speed-ups
+	FeeBumpPercent     int64
+	MaxFeeBumps        int
+	IndexerDBPath      string // empty disables the event indexer
+	IndexerStartBlock  uint64
+	OutboxDir          string        // empty saves uploads synchronously
+	OutboxWorkers      int           // 0 means the outbox default
+	OutboxMaxAttempts  int           // 0 means the outbox default
+	OutboxRetention    time.Duration // 0 keeps finished jobs forever
+	WebhooksDBPath     string        // empty disables webhooks
+	WebhookMaxAttempts int           // 0 means the webhook default
+	BatchGasLimit      uint64        // 0 means the contract API default
+	BatchConcurrency   int           // 0 means the handler default
+	BatchMaxSize       int64         // bytes, 0 means the handler default
+	APIKeysFile        string        // empty disables API key authentication
+	SIWEEnabled        bool
+	SIWEDomain         string // required when SIWEEnabled
+	SessionTTL         time.Duration
+	SignedUploads      bool
+	ForwarderAddress   common.Address // zero disables the relayer
+	RelayMaxGas        uint64
+	RelayQuota         int // relays per account and window, 0 means unlimited
+	RelayQuotaWindow   time.Duration
+	EventOrigins       []string // origins of WebSocket event streams besides the API host, "*" for all
+	EventsMaxBacklog   uint64   // blocks before the head a stream may start at, 0 means the default
 }
 
+// Signer backends, selected with SIGNER.
+const (
+	SignerKey      = "key"      // raw PRIVATE_KEY, for development
+	SignerKeystore = "keystore" // encrypted JSON keystore
+	SignerRemote   = "remote"   // external signer such as Clef
+)
+
 var Config GlobalConfig
 
 // LoadConfig loads environment variables into Config, then validates them.
@@ -66,6 +161,259 @@ func LoadConfig() error {
 		}
 	}
 
+	Config.MaxUploadSize = 0
+	if cfg.MaxUploadSize != "" {
+		Config.MaxUploadSize, err = strconv.ParseInt(cfg.MaxUploadSize, 10, 64)
+		if err != nil || Config.MaxUploadSize <= 0 {
+			return fmt.Errorf("invalid MAX_UPLOAD_SIZE: %s", cfg.MaxUploadSize)
+		}
+	}
+
+	Config.Confirmations = 1 // a transaction in the latest block has one confirmation
+	if cfg.Confirmations != "" {
+		Config.Confirmations, err = strconv.ParseUint(cfg.Confirmations, 10, 64)
+		if err != nil || Config.Confirmations == 0 {
+			return fmt.Errorf("invalid TX_CONFIRMATIONS: %s", cfg.Confirmations)
+		}
+	}
+
+	Config.TxWaitTimeout = 2 * time.Minute
+	if cfg.TxWaitTimeout != "" {
+		seconds, err := strconv.ParseUint(cfg.TxWaitTimeout, 10, 32)
+		if err != nil || seconds == 0 {
+			return fmt.Errorf("invalid TX_WAIT_TIMEOUT: %s", cfg.TxWaitTimeout)
+		}
+		Config.TxWaitTimeout = time.Duration(seconds) * time.Second
+	}
+
+	Config.FeeMode = cfg.FeeMode
+	if Config.MaxFeePerGas, err = parseGwei("MAX_FEE_GWEI", cfg.MaxFeeGwei); err != nil {
+		return err
+	}
+	if Config.SignerMinBalance, err = parseGwei("SIGNER_MIN_BALANCE_GWEI", cfg.MinBalanceGwei); err != nil {
+		return err
+	}
+	if Config.TxBudget, err = parseGwei("TX_BUDGET_GWEI", cfg.TxBudgetGwei); err != nil {
+		return err
+	}
+	if Config.TipMultiplier, err = parseMultiplier("TIP_MULTIPLIER", cfg.TipMultiplier); err != nil {
+		return err
+	}
+	if Config.GasLimitMultiplier, err = parseMultiplier("GAS_LIMIT_MULTIPLIER", cfg.GasMultiplier); err != nil {
+		return err
+	}
+	if Config.GasLimitMultiplier != 0 && Config.GasLimitMultiplier < 1 {
+		return fmt.Errorf("invalid GAS_LIMIT_MULTIPLIER: %s (must be at least 1)", cfg.GasMultiplier)
+	}
+
+	Config.TxStuckTimeout = 3 * time.Minute
+	if cfg.TxStuckTimeout != "" {
+		seconds, err := strconv.ParseUint(cfg.TxStuckTimeout, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid TX_STUCK_TIMEOUT: %s", cfg.TxStuckTimeout)
+		}
+		Config.TxStuckTimeout = time.Duration(seconds) * time.Second
+	}
+	Config.FeeBumpPercent = 20
+	if cfg.FeeBumpPercent != "" {
+		Config.FeeBumpPercent, err = strconv.ParseInt(cfg.FeeBumpPercent, 10, 64)
+		if err != nil || Config.FeeBumpPercent < 10 {
+			return fmt.Errorf("invalid TX_FEE_BUMP_PERCENT: %s (must be at least 10)", cfg.FeeBumpPercent)
+		}
+	}
+	Config.MaxFeeBumps = 5
+	if cfg.MaxFeeBumps != "" {
+		Config.MaxFeeBumps, err = strconv.Atoi(cfg.MaxFeeBumps)
+		if err != nil || Config.MaxFeeBumps < 0 {
+			return fmt.Errorf("invalid TX_MAX_BUMPS: %s", cfg.MaxFeeBumps)
+		}
+	}
+
+	Config.IndexerDBPath = cfg.IndexerDBPath
+	if cfg.IndexerStart != "" {
+		Config.IndexerStartBlock, err = strconv.ParseUint(cfg.IndexerStart, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid INDEXER_START_BLOCK: %s", cfg.IndexerStart)
+		}
+	}
+
+	Config.OutboxDir = cfg.OutboxDir
+	Config.OutboxWorkers, Config.OutboxMaxAttempts = 0, 0
+	if cfg.OutboxWorkers != "" {
+		Config.OutboxWorkers, err = strconv.Atoi(cfg.OutboxWorkers)
+		if err != nil || Config.OutboxWorkers <= 0 {
+			return fmt.Errorf("invalid OUTBOX_WORKERS: %s", cfg.OutboxWorkers)
+		}
+	}
+	if cfg.OutboxAttempts != "" {
+		Config.OutboxMaxAttempts, err = strconv.Atoi(cfg.OutboxAttempts)
+		if err != nil || Config.OutboxMaxAttempts <= 0 {
+			return fmt.Errorf("invalid OUTBOX_MAX_ATTEMPTS: %s", cfg.OutboxAttempts)
+		}
+	}
+	Config.OutboxRetention = 7 * 24 * time.Hour
+	if cfg.OutboxRetention != "" {
+		seconds, err := strconv.ParseUint(cfg.OutboxRetention, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid OUTBOX_RETENTION: %s", cfg.OutboxRetention)
+		}
+		Config.OutboxRetention = time.Duration(seconds) * time.Second
+	}
+
+	Config.WebhooksDBPath, Config.WebhookMaxAttempts = cfg.WebhooksDBPath, 0
+	if cfg.WebhookAttempts != "" {
+		Config.WebhookMaxAttempts, err = strconv.Atoi(cfg.WebhookAttempts)
+		if err != nil || Config.WebhookMaxAttempts <= 0 {
+			return fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS: %s", cfg.WebhookAttempts)
+		}
+	}
+
+	Config.BatchGasLimit, Config.BatchConcurrency, Config.BatchMaxSize = 0, 0, 0
+	if cfg.BatchGasLimit != "" {
+		Config.BatchGasLimit, err = strconv.ParseUint(cfg.BatchGasLimit, 10, 64)
+		if err != nil || Config.BatchGasLimit == 0 {
+			return fmt.Errorf("invalid BATCH_GAS_LIMIT: %s", cfg.BatchGasLimit)
+		}
+	}
+	if cfg.BatchWorkers != "" {
+		Config.BatchConcurrency, err = strconv.Atoi(cfg.BatchWorkers)
+		if err != nil || Config.BatchConcurrency <= 0 {
+			return fmt.Errorf("invalid BATCH_IPFS_CONCURRENCY: %s", cfg.BatchWorkers)
+		}
+	}
+	if cfg.BatchMaxSize != "" {
+		Config.BatchMaxSize, err = strconv.ParseInt(cfg.BatchMaxSize, 10, 64)
+		if err != nil || Config.BatchMaxSize <= 0 {
+			return fmt.Errorf("invalid BATCH_MAX_SIZE: %s", cfg.BatchMaxSize)
+		}
+	}
+
+	Config.APIKeysFile = cfg.APIKeysFile
+	if cfg.SIWEEnabled != "" {
+		if Config.SIWEEnabled, err = strconv.ParseBool(cfg.SIWEEnabled); err != nil {
+			return fmt.Errorf("invalid SIWE_ENABLED: %s", cfg.SIWEEnabled)
+		}
+	}
+	Config.SIWEDomain = cfg.SIWEDomain
+	if Config.SIWEEnabled && Config.SIWEDomain == "" {
+		return fmt.Errorf("SIWE_ENABLED requires SIWE_DOMAIN, the domain sign-in messages must name")
+	}
+	Config.SessionTTL = 24 * time.Hour
+	if cfg.SessionTTL != "" {
+		seconds, err := strconv.ParseUint(cfg.SessionTTL, 10, 32)
+		if err != nil || seconds == 0 {
+			return fmt.Errorf("invalid SESSION_TTL: %s", cfg.SessionTTL)
+		}
+		Config.SessionTTL = time.Duration(seconds) * time.Second
+	}
+	if cfg.SignedUploads != "" {
+		if Config.SignedUploads, err = strconv.ParseBool(cfg.SignedUploads); err != nil {
+			return fmt.Errorf("invalid SIGNED_UPLOADS: %s", cfg.SignedUploads)
+		}
+	}
+	if Config.SignedUploads && Config.IndexerDBPath == "" {
+		return fmt.Errorf("SIGNED_UPLOADS requires INDEXER_DB_PATH to store signers and nonces")
+	}
+
+	Config.ForwarderAddress = common.Address{}
+	if cfg.Forwarder != "" {
+		if !common.IsHexAddress(cfg.Forwarder) {
+			return fmt.Errorf("invalid FORWARDER_ADDRESS: %s", cfg.Forwarder)
+		}
+		Config.ForwarderAddress = common.HexToAddress(cfg.Forwarder)
+	}
+	Config.RelayMaxGas = 1_000_000
+	if cfg.RelayMaxGas != "" {
+		Config.RelayMaxGas, err = strconv.ParseUint(cfg.RelayMaxGas, 10, 64)
+		if err != nil || Config.RelayMaxGas == 0 {
+			return fmt.Errorf("invalid RELAY_MAX_GAS: %s", cfg.RelayMaxGas)
+		}
+	}
+	Config.RelayQuota = 100
+	if cfg.RelayQuota != "" {
+		Config.RelayQuota, err = strconv.Atoi(cfg.RelayQuota)
+		if err != nil || Config.RelayQuota < 0 {
+			return fmt.Errorf("invalid RELAY_QUOTA: %s", cfg.RelayQuota)
+		}
+	}
+	Config.RelayQuotaWindow = 24 * time.Hour
+	if cfg.RelayWindow != "" {
+		seconds, err := strconv.ParseUint(cfg.RelayWindow, 10, 32)
+		if err != nil || seconds == 0 {
+			return fmt.Errorf("invalid RELAY_QUOTA_WINDOW: %s", cfg.RelayWindow)
+		}
+		Config.RelayQuotaWindow = time.Duration(seconds) * time.Second
+	}
+
+	Config.EventOrigins = splitList(cfg.EventOrigins)
+	Config.EventsMaxBacklog = 0
+	if cfg.EventsBacklog != "" {
+		Config.EventsMaxBacklog, err = strconv.ParseUint(cfg.EventsBacklog, 10, 64)
+		if err != nil || Config.EventsMaxBacklog == 0 {
+			return fmt.Errorf("invalid EVENTS_MAX_BACKLOG: %s", cfg.EventsBacklog)
+		}
+	}
+
+	Config.SignerDispatch = cfg.SignerDispatch
+	if Config.SignerDispatch == "" {
+		Config.SignerDispatch = "round-robin"
+	}
+	Config.BalanceInterval = time.Minute
+	if cfg.BalanceInterval != "" {
+		seconds, err := strconv.ParseUint(cfg.BalanceInterval, 10, 32)
+		if err != nil || seconds == 0 {
+			return fmt.Errorf("invalid SIGNER_BALANCE_INTERVAL: %s", cfg.BalanceInterval)
+		}
+		Config.BalanceInterval = time.Duration(seconds) * time.Second
+	}
+
+	return loadSigner(cfg)
+}
+
+// loadSigner checks that the settings of the selected signer backend are present.
+func loadSigner(cfg Validation) error {
+	Config.Signer = cfg.Signer
+	if Config.Signer == "" {
+		Config.Signer = SignerKey
+	}
+	Config.SignerAddress = common.Address{}
+	if cfg.SignerAddress != "" {
+		if !common.IsHexAddress(cfg.SignerAddress) {
+			return fmt.Errorf("invalid SIGNER_ADDRESS: %s", cfg.SignerAddress)
+		}
+		Config.SignerAddress = common.HexToAddress(cfg.SignerAddress)
+	}
+	Config.PoolAddresses = nil
+	for _, address := range splitList(cfg.PoolAddresses) {
+		if !common.IsHexAddress(address) {
+			return fmt.Errorf("invalid SIGNER_ADDRESSES: %s", address)
+		}
+		Config.PoolAddresses = append(Config.PoolAddresses, common.HexToAddress(address))
+	}
+	Config.PrivateKey, Config.PoolPrivateKeys = nil, nil
+	Config.KeystorePath, Config.KeystorePassword = cfg.KeystorePath, cfg.KeystorePass
+	Config.RemoteSignerURL = cfg.RemoteSignerURL
+
+	switch {
+	case Config.Signer == SignerKey && len(Config.PoolAddresses) > 0:
+		return fmt.Errorf("SIGNER_ADDRESSES requires SIGNER=keystore or SIGNER=remote, use PRIVATE_KEYS")
+	case Config.Signer != SignerKey && cfg.PoolKeys != "":
+		return fmt.Errorf("PRIVATE_KEYS requires SIGNER=key, use SIGNER_ADDRESSES")
+	}
+
+	switch Config.Signer {
+	case SignerKeystore:
+		if Config.KeystorePath == "" || Config.KeystorePassword == "" {
+			return fmt.Errorf("SIGNER=keystore requires KEYSTORE_PATH and KEYSTORE_PASSWORD_FILE")
+		}
+		return nil
+	case SignerRemote:
+		if Config.RemoteSignerURL == "" || Config.SignerAddress == (common.Address{}) {
+			return fmt.Errorf("SIGNER=remote requires REMOTE_SIGNER_URL and SIGNER_ADDRESS")
+		}
+		return nil
+	}
+
 	// Remove 0x prefix from PRIVATE_KEY if present
 	privateKeyHex := strings.TrimPrefix(cfg.PrivateKeyHex, "0x")
 	if privateKeyHex == "" {
@@ -76,6 +424,48 @@ func LoadConfig() error {
 		return fmt.Errorf("failed to decode private key hex: %w", err)
 	}
 	Config.PrivateKey = privateKeyBytes
-
+	for _, key := range splitList(cfg.PoolKeys) {
+		keyBytes, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+		if err != nil || len(keyBytes) != 32 {
+			return fmt.Errorf("invalid PRIVATE_KEYS: key %d is not a 32 byte hex key", len(Config.PoolPrivateKeys)+1)
+		}
+		Config.PoolPrivateKeys = append(Config.PoolPrivateKeys, keyBytes)
+	}
 	return nil
 }
+
+// splitList splits a comma separated setting, dropping blanks around and between the items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseGwei converts an optional decimal gwei amount to wei. An empty value yields nil.
+func parseGwei(name, value string) (*big.Int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	gwei, ok := new(big.Float).SetString(value)
+	if !ok || gwei.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	wei, _ := gwei.Mul(gwei, big.NewFloat(1e9)).Int(nil)
+	return wei, nil
+}
+
+// parseMultiplier parses an optional positive factor. An empty value yields 0, meaning the default.
+func parseMultiplier(name, value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return f, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,6 +23,28 @@ func TestLoadConfig_Success_WithSetEnv(t *testing.T) {
 	t.Setenv("PORT", "8001")
 	t.Setenv("CHAIN_ID", "1338")
 	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("MAX_UPLOAD_SIZE", "1048576")
+	t.Setenv("TX_CONFIRMATIONS", "3")
+	t.Setenv("TX_WAIT_TIMEOUT", "30")
+	t.Setenv("FEE_MODE", "legacy")
+	t.Setenv("MAX_FEE_GWEI", "1.5")
+	t.Setenv("TIP_MULTIPLIER", "2")
+	t.Setenv("GAS_LIMIT_MULTIPLIER", "1.3")
+	t.Setenv("TX_BUDGET_GWEI", "1000000")
+	t.Setenv("TX_STUCK_TIMEOUT", "60")
+	t.Setenv("TX_FEE_BUMP_PERCENT", "15")
+	t.Setenv("TX_MAX_BUMPS", "2")
+	t.Setenv("INDEXER_DB_PATH", "data/index")
+	t.Setenv("API_KEYS_FILE", "keys.json")
+	t.Setenv("SIWE_ENABLED", "true")
+	t.Setenv("SIWE_DOMAIN", "files.example.com")
+	t.Setenv("SESSION_TTL", "3600")
+	t.Setenv("SIGNED_UPLOADS", "true")
+	t.Setenv("FORWARDER_ADDRESS", "0x0000000000000000000000000000000000000003")
+	t.Setenv("RELAY_MAX_GAS", "500000")
+	t.Setenv("RELAY_QUOTA", "10")
+	t.Setenv("RELAY_QUOTA_WINDOW", "3600")
+	t.Setenv("INDEXER_START_BLOCK", "42")
 
 	err := config.LoadConfig()
 	assert.NoError(t, err, "Expected no error with environment variables set via t.Setenv")
@@ -39,6 +62,28 @@ func TestLoadConfig_Success_WithSetEnv(t *testing.T) {
 
 	expectedPrivateKey, _ := hex.DecodeString("1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
 	assert.Equal(t, expectedPrivateKey, config.Config.PrivateKey, "PrivateKey mismatch")
+	assert.Equal(t, int64(1048576), config.Config.MaxUploadSize, "MaxUploadSize mismatch")
+	assert.Equal(t, uint64(3), config.Config.Confirmations, "Confirmations mismatch")
+	assert.Equal(t, 30*time.Second, config.Config.TxWaitTimeout, "TxWaitTimeout mismatch")
+	assert.Equal(t, "legacy", config.Config.FeeMode, "FeeMode mismatch")
+	assert.Equal(t, big.NewInt(1_500_000_000), config.Config.MaxFeePerGas, "MaxFeePerGas mismatch")
+	assert.Equal(t, 2.0, config.Config.TipMultiplier, "TipMultiplier mismatch")
+	assert.Equal(t, 1.3, config.Config.GasLimitMultiplier, "GasLimitMultiplier mismatch")
+	assert.Equal(t, big.NewInt(1_000_000_000_000_000), config.Config.TxBudget, "TxBudget mismatch")
+	assert.Equal(t, time.Minute, config.Config.TxStuckTimeout, "TxStuckTimeout mismatch")
+	assert.Equal(t, int64(15), config.Config.FeeBumpPercent, "FeeBumpPercent mismatch")
+	assert.Equal(t, 2, config.Config.MaxFeeBumps, "MaxFeeBumps mismatch")
+	assert.Equal(t, "data/index", config.Config.IndexerDBPath, "IndexerDBPath mismatch")
+	assert.Equal(t, "keys.json", config.Config.APIKeysFile, "APIKeysFile mismatch")
+	assert.True(t, config.Config.SIWEEnabled, "SIWEEnabled mismatch")
+	assert.Equal(t, "files.example.com", config.Config.SIWEDomain, "SIWEDomain mismatch")
+	assert.Equal(t, time.Hour, config.Config.SessionTTL, "SessionTTL mismatch")
+	assert.True(t, config.Config.SignedUploads, "SignedUploads mismatch")
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000003"), config.Config.ForwarderAddress, "ForwarderAddress mismatch")
+	assert.Equal(t, uint64(500000), config.Config.RelayMaxGas, "RelayMaxGas mismatch")
+	assert.Equal(t, 10, config.Config.RelayQuota, "RelayQuota mismatch")
+	assert.Equal(t, time.Hour, config.Config.RelayQuotaWindow, "RelayQuotaWindow mismatch")
+	assert.Equal(t, uint64(42), config.Config.IndexerStartBlock, "IndexerStartBlock mismatch")
 }
 
 func TestLoadConfig_MissingRequiredVariables(t *testing.T) {
@@ -55,3 +100,228 @@ func TestLoadConfig_MissingRequiredVariables(t *testing.T) {
 	assert.Error(t, err, "Expected validation error due to missing required variables")
 	assert.Contains(t, err.Error(), "config validation error", "Error message should indicate validation issues")
 }
+
+func TestLoadConfig_InvalidMaxUploadSize(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("MAX_UPLOAD_SIZE", "0")
+
+	err := config.LoadConfig()
+	assert.Error(t, err, "Expected error for a zero MAX_UPLOAD_SIZE")
+	assert.Contains(t, err.Error(), "invalid MAX_UPLOAD_SIZE")
+}
+
+func TestLoadConfig_NegativeMaxFeeBumps(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("TX_MAX_BUMPS", "-1")
+
+	err := config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid TX_MAX_BUMPS")
+}
+
+func TestLoadConfig_InvalidFeeMode(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("FEE_MODE", "cheap")
+
+	err := config.LoadConfig()
+	assert.Error(t, err, "Expected validation error for an unknown FEE_MODE")
+	assert.Contains(t, err.Error(), "config validation error")
+}
+
+func TestLoadConfig_SignerBackends(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "")
+	t.Setenv("SIGNER", "keystore")
+	t.Setenv("KEYSTORE_PATH", "keystore")
+	t.Setenv("KEYSTORE_PASSWORD_FILE", "password.txt")
+
+	err := config.LoadConfig()
+	assert.NoError(t, err, "A keystore signer does not need PRIVATE_KEY")
+	assert.Equal(t, config.SignerKeystore, config.Config.Signer)
+	assert.Equal(t, "keystore", config.Config.KeystorePath)
+	assert.Equal(t, "password.txt", config.Config.KeystorePassword)
+	assert.Nil(t, config.Config.PrivateKey)
+
+	t.Setenv("SIGNER", "remote")
+	t.Setenv("REMOTE_SIGNER_URL", "http://localhost:8550")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "SIGNER_ADDRESS")
+
+	t.Setenv("SIGNER_ADDRESS", "0x0000000000000000000000000000000000000004")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, common.HexToAddress("0x0000000000000000000000000000000000000004"), config.Config.SignerAddress)
+
+	t.Setenv("SIGNER", "key")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "PRIVATE_KEY not set")
+}
+
+func TestLoadConfig_SignerPool(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("SIGNER", "remote")
+	t.Setenv("REMOTE_SIGNER_URL", "http://localhost:8550")
+	t.Setenv("SIGNER_ADDRESS", "0x0000000000000000000000000000000000000004")
+	t.Setenv("SIGNER_ADDRESSES", "0x0000000000000000000000000000000000000005, 0x0000000000000000000000000000000000000006")
+	t.Setenv("SIGNER_DISPATCH", "least-pending")
+	t.Setenv("SIGNER_MIN_BALANCE_GWEI", "50000000")
+
+	err := config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Address{
+		common.HexToAddress("0x0000000000000000000000000000000000000005"),
+		common.HexToAddress("0x0000000000000000000000000000000000000006"),
+	}, config.Config.PoolAddresses)
+	assert.Equal(t, "least-pending", config.Config.SignerDispatch)
+	assert.Equal(t, "50000000000000000", config.Config.SignerMinBalance.String())
+	assert.Equal(t, time.Minute, config.Config.BalanceInterval)
+
+	t.Setenv("SIGNER_ADDRESSES", "0x05")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid SIGNER_ADDRESSES")
+
+	t.Setenv("SIGNER_ADDRESSES", "")
+	t.Setenv("PRIVATE_KEYS", "0x0000000000000000000000000000000000000000000000000000000000000001")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "PRIVATE_KEYS requires SIGNER=key")
+
+	t.Setenv("SIGNER", "key")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Len(t, config.Config.PoolPrivateKeys, 1)
+
+	t.Setenv("SIGNER_DISPATCH", "random")
+	err = config.LoadConfig()
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_Outbox(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("OUTBOX_DIR", "/var/lib/file-registry/outbox")
+	t.Setenv("OUTBOX_WORKERS", "8")
+
+	err := config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/file-registry/outbox", config.Config.OutboxDir)
+	assert.Equal(t, 8, config.Config.OutboxWorkers)
+	assert.Zero(t, config.Config.OutboxMaxAttempts)
+	assert.Equal(t, 7*24*time.Hour, config.Config.OutboxRetention)
+
+	t.Setenv("OUTBOX_RETENTION", "0")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Zero(t, config.Config.OutboxRetention)
+
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "0")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid OUTBOX_MAX_ATTEMPTS")
+
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "")
+	t.Setenv("WEBHOOKS_DB_PATH", "/var/lib/file-registry/webhooks")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/file-registry/webhooks", config.Config.WebhooksDBPath)
+	assert.Equal(t, 3, config.Config.WebhookMaxAttempts)
+	assert.Zero(t, config.Config.BatchGasLimit)
+
+	t.Setenv("BATCH_GAS_LIMIT", "5000000")
+	t.Setenv("BATCH_IPFS_CONCURRENCY", "16")
+	t.Setenv("BATCH_MAX_SIZE", "1048576")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5_000_000), config.Config.BatchGasLimit)
+	assert.Equal(t, 16, config.Config.BatchConcurrency)
+	assert.Equal(t, int64(1<<20), config.Config.BatchMaxSize)
+
+	t.Setenv("BATCH_IPFS_CONCURRENCY", "0")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid BATCH_IPFS_CONCURRENCY")
+
+	t.Setenv("BATCH_IPFS_CONCURRENCY", "")
+	t.Setenv("BATCH_MAX_SIZE", "0")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid BATCH_MAX_SIZE")
+}
+
+func TestLoadConfig_SIWERequiresDomain(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("SIWE_ENABLED", "true")
+
+	err := config.LoadConfig()
+	assert.ErrorContains(t, err, "SIWE_ENABLED requires SIWE_DOMAIN")
+}
+
+func TestLoadConfig_Events(t *testing.T) {
+	// Reset the global Config before the test
+	config.Config = config.GlobalConfig{}
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+
+	err := config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Empty(t, config.Config.EventOrigins)
+	assert.Zero(t, config.Config.EventsMaxBacklog)
+
+	t.Setenv("EVENTS_ALLOWED_ORIGINS", "https://dashboard.example.com, https://ops.example.com")
+	t.Setenv("EVENTS_MAX_BACKLOG", "5000")
+	err = config.LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://dashboard.example.com", "https://ops.example.com"}, config.Config.EventOrigins)
+	assert.Equal(t, uint64(5000), config.Config.EventsMaxBacklog)
+
+	t.Setenv("EVENTS_MAX_BACKLOG", "0")
+	err = config.LoadConfig()
+	assert.ErrorContains(t, err, "invalid EVENTS_MAX_BACKLOG")
+}
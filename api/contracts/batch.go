@@ -0,0 +1,152 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DefaultBatchGasLimit is the gas a saveBatch transaction is sized for unless SetBatchGasLimit
+// changes it, a third of the 30M block gas limit of mainnet.
+const DefaultBatchGasLimit uint64 = 10_000_000
+
+// ErrBatchLength is returned by SaveBatch when paths and cids differ in length.
+var ErrBatchLength = errors.New("paths and cids differ in length")
+
+// BatchSave is the outcome of one file of SaveBatch: the hash of the transaction saving it, or the
+// error that kept it from being sent.
+type BatchSave struct {
+	TxHash string
+	Err    error
+}
+
+// SetBatchGasLimit sets the gas a saveBatch transaction may use, headroom of the fee policy included.
+// Zero keeps the default. It must be called before the ContractAPI is used concurrently.
+func (api *ContractAPI) SetBatchGasLimit(limit uint64) {
+	if limit > 0 {
+		api.batchGas = limit
+	}
+}
+
+// SaveBatch stores the CIDs of many paths in as few saveBatch transactions as the batch gas limit
+// allows, returning the outcome of every path in order.
+//
+// Paths are grouped by the account of the pool that may write them, as Save would send them, and
+// every group is sent in order from its account. Chunks are sized with EstimateGas: a chunk over the
+// limit is halved until it fits. A chunk that reverts is halved as well, down to the paths that revert
+// on their own, so that a path the account may not write fails alone instead of taking its chunk down.
+func (api *ContractAPI) SaveBatch(paths, cids []string) ([]BatchSave, error) {
+	if len(paths) != len(cids) {
+		return nil, api.translate(fmt.Errorf("%w: %d paths, %d cids", ErrBatchLength, len(paths), len(cids)))
+	}
+	ctx := context.Background()
+	picked, err := api.pickAccount()
+	if err != nil {
+		return nil, api.translate(err)
+	}
+	pick := func() (*poolAccount, error) { return picked, nil }
+
+	results := make([]BatchSave, len(paths))
+	var accounts []*poolAccount
+	groups := make(map[*poolAccount][]int)
+	for i, path := range paths {
+		account, err := api.accountFor(ctx, path, pick)
+		if err != nil {
+			results[i].Err = api.translate(err)
+			continue
+		}
+		if _, ok := groups[account]; !ok {
+			accounts = append(accounts, account)
+		}
+		groups[account] = append(groups[account], i)
+	}
+	for _, account := range accounts {
+		indexes := groups[account]
+		groupPaths, groupCids := make([]string, len(indexes)), make([]string, len(indexes))
+		for j, i := range indexes {
+			groupPaths[j], groupCids[j] = paths[i], cids[i]
+		}
+		for j, result := range api.saveBatchFrom(ctx, account, groupPaths, groupCids) {
+			results[indexes[j]] = result
+		}
+	}
+	return results, nil
+}
+
+// batchChunk is the part lo..hi of the paths of a batch sent in one transaction, with its gas limit.
+type batchChunk struct {
+	lo, hi int
+	gas    uint64
+}
+
+// saveBatchFrom is SaveBatch sending every chunk from account.
+func (api *ContractAPI) saveBatchFrom(ctx context.Context, account *poolAccount, paths, cids []string) []BatchSave {
+	results := make([]BatchSave, len(paths))
+	var chunks []batchChunk
+	var plan func(lo, hi int)
+	plan = func(lo, hi int) {
+		gas, err := api.estimateBatch(ctx, account, paths[lo:hi], cids[lo:hi])
+		if err == nil && (gas <= api.batchGas || hi-lo == 1) {
+			// A single path over the limit is sent anyway; only the block gas limit is binding.
+			chunks = append(chunks, batchChunk{lo: lo, hi: hi, gas: gas})
+			return
+		}
+		if err != nil && (hi-lo == 1 || !isBatchRevert(err)) {
+			for i := lo; i < hi; i++ {
+				results[i].Err = err
+			}
+			return
+		}
+		mid := lo + (hi-lo)/2
+		plan(lo, mid)
+		plan(mid, hi)
+	}
+	if len(paths) > 0 {
+		plan(0, len(paths))
+	}
+
+	for _, chunk := range chunks {
+		chunkPaths, chunkCids := paths[chunk.lo:chunk.hi], cids[chunk.lo:chunk.hi]
+		input, err := api.instance.abi.Pack("saveBatch", chunkPaths, chunkCids)
+		var hash string
+		if err == nil {
+			// The chunk is sent with the gas planned for it rather than estimated again.
+			hash, err = api.transactFrom(ctx, account, api.instance.address, input, chunk.gas, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+				return api.instance.SaveBatch(opts, chunkPaths, chunkCids)
+			})
+		}
+		for i := chunk.lo; i < chunk.hi; i++ {
+			results[i] = BatchSave{TxHash: hash, Err: api.translate(err)}
+		}
+	}
+	return results
+}
+
+// estimateBatch returns the gas limit a saveBatch call of the paths from account would be sent with.
+func (api *ContractAPI) estimateBatch(ctx context.Context, account *poolAccount, paths, cids []string) (uint64, error) {
+	input, err := api.instance.abi.Pack("saveBatch", paths, cids)
+	if err != nil {
+		return 0, api.translate(fmt.Errorf("failed to pack saveBatch call: %w", err))
+	}
+	gas, err := api.client.EstimateGas(ctx, ethereum.CallMsg{From: account.auth.From, To: &api.instance.address, Data: input})
+	if err != nil {
+		return 0, api.translate(fmt.Errorf("failed to estimate gas: %w", err))
+	}
+	return uint64(math.Ceil(float64(gas) * api.fees.GasLimitMultiplier)), nil
+}
+
+// isBatchRevert reports whether a failed estimate may pass for a part of the batch: the call reverted
+// or needed more gas than a block holds.
+func isBatchRevert(err error) bool {
+	var revert *RevertError
+	if errors.As(err, &revert) {
+		return true
+	}
+	code := Classify(err).Code
+	return code == CodeReverted || code == CodeGas
+}
@@ -0,0 +1,176 @@
+package contracts_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// scanningContract is init code deploying a contract that reads its calldata byte by byte and reverts
+// when one of them is 0xff, so gas grows with the calldata and single paths can be made to revert.
+var scanningContract = common.FromHex("0x6023600c60003960236000f3" +
+	"60005b36811015601b57803560f81c60ff14601d576001016002565b005b60006000fd")
+
+func TestSaveBatch_ChunksByGasLimit(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	saveBatch := registryABI(t).Methods["saveBatch"]
+	address := deployCode(t, sim, auth, scanningContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetBatchGasLimit(100_000)
+
+	var paths, cids []string
+	for i := 0; i < 12; i++ {
+		paths = append(paths, fmt.Sprintf("/release/file-%d.txt", i))
+		cids = append(cids, fmt.Sprintf("QmFakeCID%d", i))
+	}
+	paths[5] = "/release/\xff.txt"
+
+	results, err := api.SaveBatch(paths, cids)
+	require.NoError(t, err)
+	require.Len(t, results, len(paths))
+
+	// The reverting path fails alone.
+	var classified *contracts.Error
+	require.ErrorAs(t, results[5].Err, &classified)
+	assert.Equal(t, contracts.CodeReverted, classified.Code, classified.Error())
+	assert.Empty(t, results[5].TxHash)
+
+	var saved []string
+	seen := make(map[string]bool)
+	for i, result := range results {
+		if i == 5 {
+			continue
+		}
+		require.NoError(t, result.Err, paths[i])
+		if seen[result.TxHash] {
+			continue
+		}
+		seen[result.TxHash] = true
+		tx, _, err := sim.Client().TransactionByHash(context.Background(), common.HexToHash(result.TxHash))
+		require.NoError(t, err)
+		assert.Equal(t, saveBatch.ID, tx.Data()[:4])
+		assert.LessOrEqual(t, tx.Gas(), uint64(100_000))
+		args, err := saveBatch.Inputs.Unpack(tx.Data()[4:])
+		require.NoError(t, err)
+		saved = append(saved, args[0].([]string)...)
+	}
+	assert.Greater(t, len(seen), 1)
+	assert.Equal(t, append(append([]string{}, paths[:5]...), paths[6:]...), saved)
+
+	sim.Commit()
+	for hash := range seen {
+		receipt, err := sim.Client().TransactionReceipt(context.Background(), common.HexToHash(hash))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), receipt.Status)
+	}
+}
+
+// countingBackend counts the gas estimates of the backend it wraps.
+type countingBackend struct {
+	contracts.Backend
+	estimates atomic.Int32
+}
+
+func (b *countingBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	b.estimates.Add(1)
+	return b.Backend.EstimateGas(ctx, call)
+}
+
+func TestSaveBatch_SendsPlannedGas(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	backend := &countingBackend{Backend: sim.Client()}
+	api := newBackendAPI(t, backend, auth, address)
+
+	results, err := api.SaveBatch([]string{"/a.txt", "/b.txt"}, []string{"QmA", "QmB"})
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, int32(1), backend.estimates.Load(), "the chunk is not estimated again when sent")
+
+	tx, _, err := sim.Client().TransactionByHash(context.Background(), common.HexToHash(results[0].TxHash))
+	require.NoError(t, err)
+	estimate, err := sim.Client().EstimateGas(context.Background(), callMsg(auth.From, address, tx.Data()))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.Ceil(float64(estimate)*contracts.DefaultFeePolicy().GasLimitMultiplier)), tx.Gas())
+}
+
+func TestSaveBatch_SingleTransaction(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	results, err := api.SaveBatch([]string{"/a.txt", "/b.txt"}, []string{"QmA", "QmB"})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, results[0], results[1])
+
+	_, err = api.SaveBatch([]string{"/a.txt"}, nil)
+	assert.ErrorIs(t, err, contracts.ErrBatchLength)
+}
+
+// TestSaveBatch_FileRegistryOwners tests SaveBatch against the compiled FileRegistry with a pool of
+// accounts: a path another account owns fails alone, and every sent chunk is mined successfully.
+func TestSaveBatch_FileRegistryOwners(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	// The registry is deployed by an account outside the pool, which then owns a path of the batch.
+	other := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+	address := deployArtifact(t, sim, other, "FileRegistry", common.Address{})
+	api := newSimulatedAPI(t, sim, auth, address)
+	for i := 0; i < 2; i++ {
+		api.AddAccount(newPoolAccount(t, sim, auth, big.NewInt(1e18)))
+	}
+	otherAPI := newSimulatedAPI(t, sim, other, address)
+	_, err := otherAPI.Save("/shared/owned.txt", "QmOther")
+	require.NoError(t, err)
+	sim.Commit()
+
+	paths := []string{"/shared/file-0.txt", "/shared/owned.txt", "/shared/file-1.txt", "/shared/file-2.txt"}
+	save := func(prefix string) []contracts.BatchSave {
+		cids := make([]string, len(paths))
+		for i := range paths {
+			cids[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		results, err := api.SaveBatch(paths, cids)
+		require.NoError(t, err)
+		require.Len(t, results, len(paths))
+		sim.Commit()
+		for i, result := range results {
+			if paths[i] == "/shared/owned.txt" {
+				assert.Error(t, result.Err)
+				assert.Empty(t, result.TxHash)
+				continue
+			}
+			require.NoError(t, result.Err, paths[i])
+			receipt, err := sim.Client().TransactionReceipt(context.Background(), common.HexToHash(result.TxHash))
+			require.NoError(t, err)
+			assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status, paths[i])
+		}
+		return results
+	}
+
+	// First saves, then overwrites of the paths the pool now owns.
+	for _, prefix := range []string{"QmFirst", "QmSecond"} {
+		save(prefix)
+		for i, path := range paths {
+			cid, err := api.Get(path)
+			require.NoError(t, err)
+			if path == "/shared/owned.txt" {
+				assert.Equal(t, "QmOther", cid)
+				continue
+			}
+			assert.Equal(t, fmt.Sprintf("%s%d", prefix, i), cid)
+		}
+	}
+}
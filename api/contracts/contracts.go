@@ -2,12 +2,17 @@ package contracts
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/avkos/file-registry/api/config"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
@@ -18,6 +23,9 @@ import (
 type FileRegistry struct {
 	FileRegistryCaller
 	FileRegistryTransactor
+	FileRegistryFilterer
+	address common.Address
+	abi     abi.ABI
 }
 
 type FileRegistryCaller struct {
@@ -28,22 +36,102 @@ type FileRegistryTransactor struct {
 	contract *bind.BoundContract
 }
 
-// LoadTransactor loads a transactor using the PRIVATE_KEY from config.
+type FileRegistryFilterer struct {
+	contract *bind.BoundContract
+	abi      abi.ABI
+}
+
+// FileRegistryFileSaved is a decoded FileSaved event together with the log it came from.
+type FileRegistryFileSaved struct {
+	FilePath string
+	Cid      string
+	Raw      types.Log
+}
+
+// FileRegistryFileRemoved is a decoded FileRemoved event together with the log it came from.
+type FileRegistryFileRemoved struct {
+	FilePath string
+	Cid      string
+	Raw      types.Log
+}
+
+// LoadSigners creates a signer for every account configured for the signer backend selected by
+// SIGNER in config: the primary account first, then the accounts of the pool.
+func LoadSigners(ctx context.Context) ([]Signer, error) {
+	var signers []Signer
+	switch config.Config.Signer {
+	case config.SignerKeystore:
+		for _, address := range append([]common.Address{config.Config.SignerAddress}, config.Config.PoolAddresses...) {
+			signer, err := LoadKeystoreSigner(config.Config.KeystorePath, config.Config.KeystorePassword, address)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+		}
+	case config.SignerRemote:
+		for _, address := range append([]common.Address{config.Config.SignerAddress}, config.Config.PoolAddresses...) {
+			signer, err := DialRemoteSigner(ctx, config.Config.RemoteSignerURL, address)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+		}
+	default:
+		for _, key := range append([][]byte{config.Config.PrivateKey}, config.Config.PoolPrivateKeys...) {
+			privateKey, err := crypto.ToECDSA(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+			signers = append(signers, NewKeySigner(privateKey))
+		}
+	}
+	seen := make(map[common.Address]bool)
+	for _, signer := range signers {
+		if seen[signer.Address()] {
+			return nil, fmt.Errorf("account %s is configured twice", signer.Address().Hex())
+		}
+		seen[signer.Address()] = true
+	}
+	return signers, nil
+}
+
+// LoadTransactor loads a transactor signing as the primary account of the signer backend from config.
 func LoadTransactor() (*bind.TransactOpts, error) {
-	privateKey, err := crypto.ToECDSA(config.Config.PrivateKey)
+	auths, err := LoadTransactors(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
 	}
+	return auths[0], nil
+}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, config.Config.ChainID)
+// LoadTransactors loads a transactor for every account of LoadSigners, the primary account first.
+func LoadTransactors(ctx context.Context) ([]*bind.TransactOpts, error) {
+	signers, err := LoadSigners(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transactor with chain ID: %w", err)
+		return nil, err
 	}
-	return auth, nil
+	auths := make([]*bind.TransactOpts, len(signers))
+	for i, signer := range signers {
+		auths[i] = NewTransactor(signer, config.Config.ChainID)
+	}
+	return auths, nil
+}
+
+// ErrFutureBlock is returned for reads at a block the chain has not reached yet.
+var ErrFutureBlock = errors.New("block is after the latest block")
+
+// Backend is the subset of ethclient.Client used by ContractAPI. It is also satisfied by
+// go-ethereum's simulated backend, which the tests rely on.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
 }
 
 // NewFileRegistry creates a new instance of FileRegistry, bound to a specific deployed contract.
-func NewFileRegistry(address common.Address, backend *ethclient.Client) (*FileRegistry, error) {
+func NewFileRegistry(address common.Address, backend bind.ContractBackend) (*FileRegistry, error) {
 	abiJson, err := loadABI(filepath.Join("contracts", "file_registry.abi"))
 	if err != nil {
 		return nil, err
@@ -52,6 +140,9 @@ func NewFileRegistry(address common.Address, backend *ethclient.Client) (*FileRe
 	return &FileRegistry{
 		FileRegistryCaller:     FileRegistryCaller{contract: contract},
 		FileRegistryTransactor: FileRegistryTransactor{contract: contract},
+		FileRegistryFilterer:   FileRegistryFilterer{contract: contract, abi: abiJson},
+		address:                address,
+		abi:                    abiJson,
 	}, nil
 }
 
@@ -67,6 +158,92 @@ func (f *FileRegistryTransactor) Save(opts *bind.TransactOpts, filePath string,
 	return f.contract.Transact(opts, "save", filePath, cid)
 }
 
+func (f *FileRegistryTransactor) SaveBatch(opts *bind.TransactOpts, paths []string, cids []string) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "saveBatch", paths, cids)
+}
+
+func (f *FileRegistryTransactor) Remove(opts *bind.TransactOpts, filePath string) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "remove", filePath)
+}
+
+func (f *FileRegistryTransactor) ClaimNamespace(opts *bind.TransactOpts, namespace string) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "claimNamespace", namespace)
+}
+
+func (f *FileRegistryTransactor) GrantWriter(opts *bind.TransactOpts, path string, account common.Address) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "grantWriter", path, account)
+}
+
+func (f *FileRegistryTransactor) RevokeWriter(opts *bind.TransactOpts, path string, account common.Address) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "revokeWriter", path, account)
+}
+
+func (f *FileRegistryTransactor) TransferOwnership(opts *bind.TransactOpts, path string, newOwner common.Address) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "transferOwnership", path, newOwner)
+}
+
+func (f *FileRegistryCaller) OwnerOf(opts *bind.CallOpts, path string) (common.Address, error) {
+	var out []interface{}
+	err := f.contract.Call(opts, &out, "ownerOf", path)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return out[0].(common.Address), nil
+}
+
+func (f *FileRegistryCaller) IsWriter(opts *bind.CallOpts, path string, account common.Address) (bool, error) {
+	var out []interface{}
+	err := f.contract.Call(opts, &out, "isWriter", path, account)
+	if err != nil {
+		return false, err
+	}
+	return out[0].(bool), nil
+}
+
+func (f *FileRegistryCaller) CanWrite(opts *bind.CallOpts, filePath string, account common.Address) (bool, error) {
+	var out []interface{}
+	err := f.contract.Call(opts, &out, "canWrite", filePath, account)
+	if err != nil {
+		return false, err
+	}
+	return out[0].(bool), nil
+}
+
+// Address returns the address of the bound contract.
+func (f *FileRegistry) Address() common.Address {
+	return f.address
+}
+
+// FileSavedTopic returns the topic identifying FileSaved logs.
+func (f *FileRegistryFilterer) FileSavedTopic() common.Hash {
+	return f.abi.Events["FileSaved"].ID
+}
+
+// ParseFileSaved decodes a FileSaved log emitted by the contract.
+func (f *FileRegistryFilterer) ParseFileSaved(log types.Log) (*FileRegistryFileSaved, error) {
+	event := new(FileRegistryFileSaved)
+	if err := f.contract.UnpackLog(event, "FileSaved", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
+// FileRemovedTopic returns the topic identifying FileRemoved logs.
+func (f *FileRegistryFilterer) FileRemovedTopic() common.Hash {
+	return f.abi.Events["FileRemoved"].ID
+}
+
+// ParseFileRemoved decodes a FileRemoved log emitted by the contract.
+func (f *FileRegistryFilterer) ParseFileRemoved(log types.Log) (*FileRegistryFileRemoved, error) {
+	event := new(FileRegistryFileRemoved)
+	if err := f.contract.UnpackLog(event, "FileRemoved", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
+
 func (f *FileRegistryCaller) Get(opts *bind.CallOpts, filePath string) (string, error) {
 	var out []interface{}
 	err := f.contract.Call(opts, &out, "get", filePath)
@@ -78,11 +255,17 @@ func (f *FileRegistryCaller) Get(opts *bind.CallOpts, filePath string) (string,
 }
 
 // ContractAPI provides a simpler interface that handlers can use directly.
-// It wraps the FileRegistry contract and a TransactOpts for sending transactions.
+// It wraps the FileRegistry contract and a pool of signing accounts for sending transactions.
 type ContractAPI struct {
-	instance *FileRegistry
-	auth     *bind.TransactOpts
-	client   *ethclient.Client
+	instance    *FileRegistry
+	client      Backend
+	accounts    []*poolAccount // the primary account first
+	pool        PoolPolicy
+	pickMu      sync.Mutex
+	nextAccount int
+	fees        FeePolicy
+	batchGas    uint64
+	tracker     *TxTracker
 }
 
 // NewContractAPI connects to the Ethereum client, loads the contract and transactor.
@@ -93,35 +276,269 @@ func NewContractAPI() (*ContractAPI, error) {
 		return nil, fmt.Errorf("failed to connect to Ethereum: %w", err)
 	}
 
-	// Load contract instance
-	registry, err := NewFileRegistry(config.Config.ContractAddress, client)
+	// Load transactors
+	auths, err := LoadTransactors(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate contract: %w", err)
+		return nil, fmt.Errorf("failed to load transactor: %w", err)
 	}
 
-	// Load transactor
-	auth, err := LoadTransactor()
+	api, err := NewContractAPIWithBackend(client, config.Config.ContractAddress, auths[0])
 	if err != nil {
-		return nil, fmt.Errorf("failed to load transactor: %w", err)
+		return nil, err
+	}
+	for _, auth := range auths[1:] {
+		api.AddAccount(auth)
 	}
+	api.SetPoolPolicy(PoolPolicy{
+		Dispatch:   config.Config.SignerDispatch,
+		MinBalance: config.Config.SignerMinBalance,
+	})
+	api.SetFeePolicy(FeePolicy{
+		Mode:               config.Config.FeeMode,
+		MaxFeePerGas:       config.Config.MaxFeePerGas,
+		TipMultiplier:      config.Config.TipMultiplier,
+		GasLimitMultiplier: config.Config.GasLimitMultiplier,
+		MaxTxCost:          config.Config.TxBudget,
+	})
+	api.SetReplacementPolicy(ReplacementPolicy{
+		StuckAfter:  config.Config.TxStuckTimeout,
+		BumpPercent: config.Config.FeeBumpPercent,
+		MaxBumps:    config.Config.MaxFeeBumps,
+	})
+	api.SetBatchGasLimit(config.Config.BatchGasLimit)
+	return api, nil
+}
 
-	return &ContractAPI{
+// NewContractAPIWithBackend builds a ContractAPI on top of an already connected backend.
+func NewContractAPIWithBackend(backend Backend, address common.Address, auth *bind.TransactOpts) (*ContractAPI, error) {
+	// Load contract instance
+	registry, err := NewFileRegistry(address, backend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate contract: %w", err)
+	}
+
+	api := &ContractAPI{
 		instance: registry,
-		auth:     auth,
-		client:   client,
-	}, nil
+		client:   backend,
+		pool:     DefaultPoolPolicy(),
+		fees:     DefaultFeePolicy(),
+		batchGas: DefaultBatchGasLimit,
+	}
+	api.AddAccount(auth)
+	api.tracker = newTxTracker(api)
+	return api, nil
+}
+
+// SetFeePolicy replaces the fee policy. Zero multipliers and an empty mode keep the defaults.
+// It must be called before the ContractAPI is used concurrently.
+func (api *ContractAPI) SetFeePolicy(policy FeePolicy) {
+	defaults := DefaultFeePolicy()
+	if policy.Mode == "" {
+		policy.Mode = defaults.Mode
+	}
+	if policy.TipMultiplier <= 0 {
+		policy.TipMultiplier = defaults.TipMultiplier
+	}
+	if policy.GasLimitMultiplier <= 0 {
+		policy.GasLimitMultiplier = defaults.GasLimitMultiplier
+	}
+	api.fees = policy
 }
 
-// Save stores the CID for the given filePath on-chain.
+// Save stores the CID for the given filePath on-chain. It is safe for concurrent use: every call is
+// sent from an account of the pool that may write filePath, with its own nonce from the NonceManager
+// of the account.
 func (api *ContractAPI) Save(filePath, cid string) (string, error) {
-	tx, err := api.instance.Save(api.auth, filePath, cid)
+	return api.SaveRecorded(context.Background(), filePath, cid, nil)
+}
+
+// SaveRecorded is Save calling record, when not nil, with the signed transaction before it is
+// broadcast, so that callers can persist it and send it again with Rebroadcast. The transaction is not
+// sent when record fails.
+func (api *ContractAPI) SaveRecorded(ctx context.Context, filePath, cid string, record func(*types.Transaction) error) (string, error) {
+	return api.transactPooled(ctx, filePath, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		if record != nil {
+			signer := opts.Signer
+			opts.Signer = func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+				signed, err := signer(from, tx)
+				if err != nil {
+					return nil, err
+				}
+				if err := record(signed); err != nil {
+					return nil, err
+				}
+				return signed, nil
+			}
+		}
+		return api.instance.Save(opts, filePath, cid)
+	}, "save", filePath, cid)
+}
+
+// Remove deletes the CID stored for the given filePath on-chain. The contract reverts when the path
+// holds no CID. Like Save, it is sent from an account of the pool.
+func (api *ContractAPI) Remove(filePath string) (string, error) {
+	return api.transactPooled(context.Background(), filePath, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return api.instance.Remove(opts, filePath)
+	}, "remove", filePath)
+}
+
+// ClaimNamespace makes the API account owner of a path prefix ending in "/".
+func (api *ContractAPI) ClaimNamespace(namespace string) (string, error) {
+	return api.transact(context.Background(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return api.instance.ClaimNamespace(opts, namespace)
+	}, "claimNamespace", namespace)
+}
+
+// GrantWriter lets account write a path or namespace owned by the API account.
+func (api *ContractAPI) GrantWriter(path string, account common.Address) (string, error) {
+	return api.transact(context.Background(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return api.instance.GrantWriter(opts, path, account)
+	}, "grantWriter", path, account)
+}
+
+// RevokeWriter withdraws a grant made with GrantWriter.
+func (api *ContractAPI) RevokeWriter(path string, account common.Address) (string, error) {
+	return api.transact(context.Background(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return api.instance.RevokeWriter(opts, path, account)
+	}, "revokeWriter", path, account)
+}
+
+// TransferOwnership hands a path or namespace owned by the API account to newOwner.
+func (api *ContractAPI) TransferOwnership(path string, newOwner common.Address) (string, error) {
+	return api.transact(context.Background(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return api.instance.TransferOwnership(opts, path, newOwner)
+	}, "transferOwnership", path, newOwner)
+}
+
+// Access describes who controls a path or namespace.
+type Access struct {
+	Owner common.Address `json:"owner"`
+	// Writer and CanWrite are only set when an account is given.
+	Writer   *bool `json:"writer,omitempty"`
+	CanWrite *bool `json:"canWrite,omitempty"`
+}
+
+// Access returns the owner of path and, when account is not nil, whether account is a granted
+// writer of path and whether it may write it at all.
+func (api *ContractAPI) Access(path string, account *common.Address) (*Access, error) {
+	owner, err := api.instance.OwnerOf(nil, path)
 	if err != nil {
-		return "", err
+		return nil, api.translate(err)
+	}
+	access := &Access{Owner: owner}
+	if account == nil {
+		return access, nil
+	}
+	writer, err := api.instance.IsWriter(nil, path, *account)
+	if err != nil {
+		return nil, api.translate(err)
+	}
+	canWrite, err := api.instance.CanWrite(nil, path, *account)
+	if err != nil {
+		return nil, api.translate(err)
+	}
+	access.Writer, access.CanWrite = &writer, &canWrite
+	return access, nil
+}
+
+// transact prices the method call, assigns it a nonce of the primary account and sends it with send,
+// tracking the transaction until it is mined. Errors are returned classified as *Error, with reverts
+// found while estimating gas decoded into a RevertError.
+func (api *ContractAPI) transact(ctx context.Context, send func(*bind.TransactOpts) (*types.Transaction, error), method string, args ...interface{}) (string, error) {
+	input, err := api.instance.abi.Pack(method, args...)
+	if err != nil {
+		return "", api.translate(fmt.Errorf("failed to pack %s call: %w", method, err))
+	}
+	return api.transactFrom(ctx, api.primary(), api.instance.address, input, 0, send)
+}
+
+// transactPooled is transact writing path from an account of the pool: one that may write it, picked
+// by the dispatch policy of the pool when several may.
+func (api *ContractAPI) transactPooled(ctx context.Context, path string, send func(*bind.TransactOpts) (*types.Transaction, error), method string, args ...interface{}) (string, error) {
+	input, err := api.instance.abi.Pack(method, args...)
+	if err != nil {
+		return "", api.translate(fmt.Errorf("failed to pack %s call: %w", method, err))
+	}
+	account, err := api.accountFor(ctx, path, api.pickAccount)
+	if err != nil {
+		return "", api.translate(err)
+	}
+	return api.transactFrom(ctx, account, api.instance.address, input, 0, send)
+}
+
+// transactTo is transactPooled for a call of any contract, given as its address and calldata. The
+// Relayer uses it to send forwarder transactions through the same pool, fee policy and tracker.
+func (api *ContractAPI) transactTo(ctx context.Context, to common.Address, input []byte, send func(*bind.TransactOpts) (*types.Transaction, error)) (string, error) {
+	account, err := api.pickAccount()
+	if err != nil {
+		return "", api.translate(err)
+	}
+	return api.transactFrom(ctx, account, to, input, 0, send)
+}
+
+// transactFrom sends a call of to with input from account, with the given gas limit or, when it is
+// zero, an estimated one.
+func (api *ContractAPI) transactFrom(ctx context.Context, account *poolAccount, to common.Address, input []byte, gasLimit uint64, send func(*bind.TransactOpts) (*types.Transaction, error)) (string, error) {
+	opts, err := api.transactOpts(ctx, account, to, input, gasLimit)
+	if err != nil {
+		api.pauseForFunds(account, err)
+		return "", api.translate(err)
+	}
+
+	nonce, err := account.nonces.Next(ctx)
+	if err != nil {
+		return "", api.translate(err)
 	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+
+	tx, err := send(opts)
+	if err != nil {
+		account.nonces.Fail(nonce, err)
+		api.pauseForFunds(account, err)
+		return "", api.translate(err)
+	}
+	api.tracker.track(account, tx)
 	return tx.Hash().Hex(), nil
 }
 
+// transactOpts returns a per-transaction copy of the TransactOpts of account with gas and fees set by
+// the fee policy for calling to with input. A zero gasLimit is estimated.
+func (api *ContractAPI) transactOpts(ctx context.Context, account *poolAccount, to common.Address, input []byte, gasLimit uint64) (*bind.TransactOpts, error) {
+	opts := withContext(account.auth, ctx)
+	opts.GasLimit = gasLimit
+	call := ethereum.CallMsg{From: opts.From, To: &to, Data: input}
+	if err := api.applyFees(ctx, opts, call); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// Client returns the backend the API is connected to.
+func (api *ContractAPI) Client() Backend {
+	return api.client
+}
+
+// Registry returns the contract binding used by the API.
+func (api *ContractAPI) Registry() *FileRegistry {
+	return api.instance
+}
+
 // Get retrieves the CID for the given filePath from the contract.
 func (api *ContractAPI) Get(filePath string) (string, error) {
-	return api.instance.Get(nil, filePath)
+	cid, err := api.instance.Get(nil, filePath)
+	return cid, api.translate(err)
+}
+
+// GetAt retrieves the CID the filePath had at the end of the given block. Old blocks need an archive node.
+func (api *ContractAPI) GetAt(filePath string, block uint64) (string, error) {
+	ctx := context.Background()
+	head, err := api.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return "", api.translate(fmt.Errorf("failed to get latest block: %w", err))
+	}
+	if block > head.Number.Uint64() {
+		return "", api.translate(fmt.Errorf("%w: block %d, latest %d", ErrFutureBlock, block, head.Number.Uint64()))
+	}
+	cid, err := api.instance.Get(&bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}, filePath)
+	return cid, api.translate(err)
 }
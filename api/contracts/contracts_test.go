@@ -1,11 +1,15 @@
 package contracts_test
 
 import (
+	"context"
 	"github.com/avkos/file-registry/api/config"
 	"testing"
 
 	"github.com/avkos/file-registry/api/contracts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadTransactor_InvalidKey(t *testing.T) {
@@ -29,3 +33,40 @@ func TestLoadTransactor_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, auth)
 }
+
+func TestLoadTransactors_Pool(t *testing.T) {
+	t.Setenv("CONTRACT_ADDRESS", "0x0000000000000000000000000000000000000002")
+	t.Setenv("ETH_RPC_URL", "http://localhost:8546")
+	t.Setenv("IPFS_URL", "http://localhost:5002")
+	t.Setenv("PORT", "8001")
+	t.Setenv("CHAIN_ID", "1338")
+	t.Setenv("PRIVATE_KEY", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	t.Setenv("PRIVATE_KEYS", "0x0000000000000000000000000000000000000000000000000000000000000001, 0x0000000000000000000000000000000000000000000000000000000000000002")
+	require.NoError(t, config.LoadConfig())
+
+	auths, err := contracts.LoadTransactors(context.Background())
+	require.NoError(t, err)
+	require.Len(t, auths, 3)
+	primary, err := contracts.LoadTransactor()
+	require.NoError(t, err)
+	assert.Equal(t, primary.From, auths[0].From)
+	assert.Equal(t, common.HexToAddress("0x7E5F4552091A69125d5DfCb7b8C2659029395Bdf"), auths[1].From)
+
+	t.Setenv("PRIVATE_KEYS", "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef")
+	require.NoError(t, config.LoadConfig())
+	_, err = contracts.LoadTransactors(context.Background())
+	assert.ErrorContains(t, err, "configured twice")
+}
+
+func TestRemove_SendsRemoveCall(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	hash, err := api.Remove("/test/file.txt")
+	require.NoError(t, err)
+	tx, _, err := sim.Client().TransactionByHash(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	assert.Equal(t, crypto.Keccak256([]byte("remove(string)"))[:4], tx.Data()[:4])
+	assert.Equal(t, address, *tx.To())
+}
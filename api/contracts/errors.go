@@ -0,0 +1,182 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrorCode is the stable, machine readable class of a contract or node failure.
+type ErrorCode string
+
+const (
+	CodeInvalidArgument   ErrorCode = "invalid_argument"
+	CodeUnauthorized      ErrorCode = "unauthorized"
+	CodeNotFound          ErrorCode = "not_found"
+	CodeConflict          ErrorCode = "conflict"
+	CodeReverted          ErrorCode = "execution_reverted"
+	CodeGas               ErrorCode = "gas_error"
+	CodeInsufficientFunds ErrorCode = "insufficient_funds"
+	CodeNonce             ErrorCode = "nonce_error"
+	CodeFeeTooHigh        ErrorCode = "fee_too_high"
+	CodeQuotaExceeded     ErrorCode = "quota_exceeded"
+	CodeRPCUnavailable    ErrorCode = "rpc_unavailable"
+	CodeRPCTimeout        ErrorCode = "rpc_timeout"
+	CodeInternal          ErrorCode = "internal"
+)
+
+// revertCodes maps the custom errors of the contract to their error codes. Other reverts are CodeReverted.
+var revertCodes = map[string]ErrorCode{
+	"NotAuthorized":    CodeUnauthorized,
+	"NotOwner":         CodeUnauthorized,
+	"NotAdmin":         CodeUnauthorized,
+	"AlreadyOwned":     CodeConflict,
+	"NamespaceInUse":   CodeConflict,
+	"FileNotFound":     CodeNotFound,
+	"InvalidFilePath":  CodeInvalidArgument,
+	"InvalidNamespace": CodeInvalidArgument,
+	"InvalidOwner":     CodeInvalidArgument,
+	"LengthMismatch":   CodeInvalidArgument,
+	// Errors of the forwarder.
+	"InvalidSignature": CodeUnauthorized,
+	"InvalidNonce":     CodeConflict,
+	"InsufficientGas":  CodeGas,
+}
+
+// Node error messages by class. Errors of remote nodes only keep their message, so they are matched
+// by text; nonce errors use the list of the NonceManager.
+var (
+	fundsErrors = []string{"insufficient funds"}
+	gasErrors   = []string{
+		"intrinsic gas too low",
+		"gas required exceeds allowance",
+		"exceeds block gas limit",
+		"gas limit reached",
+		"out of gas",
+	}
+	// feeErrors mean the fees of the transaction are below what the node or the current base fee
+	// accept; they pass once the fees rise or the base fee falls.
+	feeErrors = []string{
+		"max fee per gas less than block base fee",
+		"transaction underpriced",
+	}
+	connectionErrors = []string{
+		"connection refused",
+		"connection reset",
+		"no such host",
+		"broken pipe",
+		"i/o timeout",
+	}
+)
+
+// Error is a failure of the contract API classified by Code. It wraps the original error, so
+// errors.Is and errors.As keep matching sentinel errors and RevertError.
+type Error struct {
+	Code ErrorCode
+	// Details holds structured data about the failure, such as the decoded revert.
+	Details map[string]interface{}
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns err as an *Error, classifying it when it is not one already. It returns nil for
+// a nil err.
+func Classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified
+	}
+
+	var revert *RevertError
+	if errors.As(err, &revert) {
+		code, ok := revertCodes[revert.Name]
+		if !ok {
+			code = CodeReverted
+		}
+		return &Error{Code: code, Details: map[string]interface{}{"error": revert.Name, "args": revert.Args}, Err: err}
+	}
+	return &Error{Code: classify(err), Err: err}
+}
+
+func classify(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrUnauthorized):
+		return CodeUnauthorized
+	case errors.Is(err, ErrAlreadyOwned), errors.Is(err, ErrTxAlreadyMined), errors.Is(err, ErrNonceUsed):
+		return CodeConflict
+	case errors.Is(err, ErrTxNotFound), errors.Is(err, ErrTxNotTracked):
+		return CodeNotFound
+	case errors.Is(err, ErrFutureBlock), errors.Is(err, ErrInvalidForwardRequest):
+		return CodeInvalidArgument
+	case errors.Is(err, ErrForwardSignature):
+		return CodeUnauthorized
+	case errors.Is(err, ErrForwardNonce):
+		return CodeConflict
+	case errors.Is(err, ErrRelayQuotaExceeded):
+		return CodeQuotaExceeded
+	case errors.Is(err, ErrFeeCapExceeded), errors.Is(err, ErrBudgetExceeded):
+		return CodeFeeTooHigh
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeRPCTimeout
+	case IsNonceError(err):
+		return CodeNonce
+	case errors.Is(err, ErrNoAccountAvailable), containsAny(err, fundsErrors):
+		return CodeInsufficientFunds
+	case containsAny(err, feeErrors):
+		return CodeFeeTooHigh
+	case containsAny(err, gasErrors):
+		return CodeGas
+	case strings.Contains(strings.ToLower(err.Error()), "execution reverted"):
+		return CodeReverted
+	case isConnectionError(err):
+		return CodeRPCUnavailable
+	}
+	return CodeInternal
+}
+
+// isConnectionError reports whether err means the node could not be reached or answered with an
+// HTTP error instead of a JSON-RPC response.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	var httpErr rpc.HTTPError
+	switch {
+	case errors.As(err, &netErr), errors.As(err, &httpErr):
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return containsAny(err, connectionErrors)
+}
+
+func containsAny(err error, fragments []string) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range fragments {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// translate decodes revert data in err with the contract ABI and classifies the result.
+func (api *ContractAPI) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	return Classify(api.instance.decodeRevert(err))
+}
@@ -0,0 +1,63 @@
+package contracts_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err  error
+		code contracts.ErrorCode
+	}{
+		{errors.New("nonce too low: next nonce 5, tx nonce 4"), contracts.CodeNonce},
+		{errors.New("replacement transaction underpriced"), contracts.CodeNonce},
+		{errors.New("insufficient funds for gas * price + value"), contracts.CodeInsufficientFunds},
+		{errors.New("intrinsic gas too low: have 100, want 21000"), contracts.CodeGas},
+		{errors.New("gas required exceeds allowance (30000000)"), contracts.CodeGas},
+		{errors.New("transaction underpriced: tip needed 1, tip permitted 0"), contracts.CodeFeeTooHigh},
+		{errors.New("max fee per gas less than block base fee: address 0x1, maxFeePerGas: 1, baseFee: 7"), contracts.CodeFeeTooHigh},
+		{errors.New("execution reverted"), contracts.CodeReverted},
+		{errors.New(`Post "http://localhost:8545": dial tcp: connection refused`), contracts.CodeRPCUnavailable},
+		{rpc.HTTPError{StatusCode: 502, Status: "502 Bad Gateway"}, contracts.CodeRPCUnavailable},
+		{fmt.Errorf("failed to estimate gas: %w", context.DeadlineExceeded), contracts.CodeRPCTimeout},
+		{fmt.Errorf("%w: 100 wei, budget 10 wei", contracts.ErrBudgetExceeded), contracts.CodeFeeTooHigh},
+		{fmt.Errorf("%w: block 9, latest 1", contracts.ErrFutureBlock), contracts.CodeInvalidArgument},
+		{&contracts.RevertError{Name: "NotOwner"}, contracts.CodeUnauthorized},
+		{&contracts.RevertError{Name: "FileNotFound"}, contracts.CodeNotFound},
+		{&contracts.RevertError{Name: "Error", Args: map[string]interface{}{"reason": "x"}}, contracts.CodeReverted},
+		{errors.New("something else"), contracts.CodeInternal},
+	}
+	for _, c := range cases {
+		classified := contracts.Classify(c.err)
+		assert.Equal(t, c.code, classified.Code, c.err.Error())
+		assert.Equal(t, c.err, classified.Err)
+	}
+	assert.Nil(t, contracts.Classify(nil))
+}
+
+func TestSave_InsufficientFunds(t *testing.T) {
+	sim, funded := newSimulatedBackend(t)
+	address := deployCode(t, sim, funded, acceptingContract)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, simulatedChainID)
+	require.NoError(t, err)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	_, err = api.Save("/test/file.txt", "QmFakeCID")
+	var classified *contracts.Error
+	require.ErrorAs(t, err, &classified)
+	assert.Equal(t, contracts.CodeInsufficientFunds, classified.Code, err.Error())
+}
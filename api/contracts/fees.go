@@ -0,0 +1,114 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+// Fee modes supported by FeePolicy.
+const (
+	FeeModeLegacy  = "legacy"
+	FeeModeDynamic = "dynamic"
+)
+
+var (
+	// ErrFeeCapExceeded is returned when the current network fee is above the configured cap.
+	ErrFeeCapExceeded = errors.New("network fee exceeds the configured max fee")
+	// ErrBudgetExceeded is returned when the worst case cost of a transaction is above the configured budget.
+	ErrBudgetExceeded = errors.New("estimated transaction cost exceeds the configured budget")
+)
+
+// FeePolicy controls how transactions are priced and how much gas they are given.
+type FeePolicy struct {
+	// Mode is FeeModeDynamic (EIP-1559) or FeeModeLegacy (gas price).
+	Mode string
+	// MaxFeePerGas caps the fee cap (dynamic) or gas price (legacy) in wei. Nil means no cap.
+	MaxFeePerGas *big.Int
+	// TipMultiplier scales the node's suggested priority tip (dynamic) or gas price (legacy).
+	TipMultiplier float64
+	// GasLimitMultiplier is the headroom applied over EstimateGas.
+	GasLimitMultiplier float64
+	// MaxTxCost is the budget in wei for gas limit times fee cap. Nil means no budget.
+	MaxTxCost *big.Int
+}
+
+// DefaultFeePolicy uses EIP-1559 fees with the node's suggested tip and 20% gas headroom.
+func DefaultFeePolicy() FeePolicy {
+	return FeePolicy{
+		Mode:               FeeModeDynamic,
+		TipMultiplier:      1,
+		GasLimitMultiplier: 1.2,
+	}
+}
+
+// applyFees fills the gas limit and fee fields of opts according to the policy. The gas limit is
+// estimated for the call unless opts already has one.
+func (api *ContractAPI) applyFees(ctx context.Context, opts *bind.TransactOpts, call ethereum.CallMsg) error {
+	if opts.GasLimit == 0 {
+		gas, err := api.client.EstimateGas(ctx, call)
+		if err != nil {
+			return fmt.Errorf("failed to estimate gas: %w", err)
+		}
+		opts.GasLimit = uint64(math.Ceil(float64(gas) * api.fees.GasLimitMultiplier))
+	}
+
+	var feeCap *big.Int
+	if api.fees.Mode == FeeModeLegacy {
+		suggested, err := api.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to suggest gas price: %w", err)
+		}
+		feeCap = scale(suggested, api.fees.TipMultiplier)
+		if api.fees.MaxFeePerGas != nil && feeCap.Cmp(api.fees.MaxFeePerGas) > 0 {
+			return fmt.Errorf("%w: gas price %s wei, max %s wei", ErrFeeCapExceeded, feeCap, api.fees.MaxFeePerGas)
+		}
+		opts.GasPrice = feeCap
+	} else {
+		head, err := api.client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get latest block: %w", err)
+		}
+		if head.BaseFee == nil {
+			return errors.New("dynamic fees are not supported by the network, use the legacy fee mode")
+		}
+		suggested, err := api.client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to suggest gas tip: %w", err)
+		}
+		tip := scale(suggested, api.fees.TipMultiplier)
+		// Leave room for the base fee to double before the transaction gets mined.
+		feeCap = new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+		if api.fees.MaxFeePerGas != nil && feeCap.Cmp(api.fees.MaxFeePerGas) > 0 {
+			minimum := new(big.Int).Add(tip, head.BaseFee)
+			if minimum.Cmp(api.fees.MaxFeePerGas) > 0 {
+				return fmt.Errorf("%w: base fee plus tip %s wei, max %s wei", ErrFeeCapExceeded, minimum, api.fees.MaxFeePerGas)
+			}
+			feeCap = new(big.Int).Set(api.fees.MaxFeePerGas)
+		}
+		opts.GasTipCap = tip
+		opts.GasFeeCap = feeCap
+	}
+
+	if api.fees.MaxTxCost != nil {
+		cost := new(big.Int).Mul(feeCap, new(big.Int).SetUint64(opts.GasLimit))
+		if cost.Cmp(api.fees.MaxTxCost) > 0 {
+			return fmt.Errorf("%w: %s wei, budget %s wei", ErrBudgetExceeded, cost, api.fees.MaxTxCost)
+		}
+	}
+	return nil
+}
+
+// scale multiplies a wei amount by a float factor.
+func scale(amount *big.Int, factor float64) *big.Int {
+	if factor == 1 {
+		return new(big.Int).Set(amount)
+	}
+	scaled, _ := new(big.Float).Mul(new(big.Float).SetInt(amount), big.NewFloat(factor)).Int(nil)
+	return scaled
+}
@@ -0,0 +1,92 @@
+package contracts_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+func savedTx(t *testing.T, api *contracts.ContractAPI, client interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}) *types.Transaction {
+	hash, err := api.Save("/test/file.txt", "QmFakeCID")
+	require.NoError(t, err)
+	tx, _, err := client.TransactionByHash(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	return tx
+}
+
+func TestSave_DynamicFees(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{Mode: contracts.FeeModeDynamic, TipMultiplier: 2, GasLimitMultiplier: 1.5})
+
+	tx := savedTx(t, api, sim.Client())
+
+	assert.Equal(t, uint8(types.DynamicFeeTxType), tx.Type())
+	suggested, err := sim.Client().SuggestGasTipCap(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, new(big.Int).Mul(suggested, big.NewInt(2)), tx.GasTipCap())
+
+	estimate, err := sim.Client().EstimateGas(context.Background(), callMsg(auth.From, address, tx.Data()))
+	require.NoError(t, err)
+	assert.InDelta(t, float64(estimate)*1.5, float64(tx.Gas()), 1)
+}
+
+func TestSave_LegacyFees(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{Mode: contracts.FeeModeLegacy})
+
+	tx := savedTx(t, api, sim.Client())
+
+	assert.Equal(t, uint8(types.LegacyTxType), tx.Type())
+	suggested, err := sim.Client().SuggestGasPrice(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, suggested, tx.GasPrice())
+}
+
+func TestSave_MaxFeeCapsFeeCap(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	head, err := sim.Client().HeaderByNumber(context.Background(), nil)
+	require.NoError(t, err)
+	tip, err := sim.Client().SuggestGasTipCap(context.Background())
+	require.NoError(t, err)
+	maxFee := new(big.Int).Add(head.BaseFee, tip)
+	maxFee.Add(maxFee, big.NewInt(1))
+	api.SetFeePolicy(contracts.FeePolicy{MaxFeePerGas: maxFee})
+
+	tx := savedTx(t, api, sim.Client())
+	assert.Equal(t, maxFee, tx.GasFeeCap())
+
+	api.SetFeePolicy(contracts.FeePolicy{MaxFeePerGas: big.NewInt(1)})
+	_, err = api.Save("/test/file.txt", "QmFakeCID")
+	assert.ErrorIs(t, err, contracts.ErrFeeCapExceeded)
+}
+
+func TestSave_BudgetExceeded(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{MaxTxCost: big.NewInt(1000)})
+
+	_, err := api.Save("/test/file.txt", "QmFakeCID")
+	assert.ErrorIs(t, err, contracts.ErrBudgetExceeded)
+
+	// The nonce was never used, so the next save still gets nonce 1 after the deployment.
+	api.SetFeePolicy(contracts.FeePolicy{})
+	tx := savedTx(t, api, sim.Client())
+	assert.Equal(t, uint64(1), tx.Nonce())
+}
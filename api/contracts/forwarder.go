@@ -0,0 +1,152 @@
+package contracts
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// EIP-712 domain name and version of the FileForwarder contract.
+const (
+	ForwarderDomainName    = "FileForwarder"
+	ForwarderDomainVersion = "1"
+)
+
+// forwardRequestTypes are the EIP-712 types of forward requests.
+var forwardRequestTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	},
+	"ForwardRequest": {
+		{Name: "from", Type: "address"},
+		{Name: "to", Type: "address"},
+		{Name: "value", Type: "uint256"},
+		{Name: "gas", Type: "uint256"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "data", Type: "bytes"},
+	},
+}
+
+// ForwardRequest is a call From signs for the forwarder to make on its behalf (EIP-2771). Its fields
+// match the ForwardRequest struct of the contract, so it is passed to execute as is.
+type ForwardRequest struct {
+	From  common.Address
+	To    common.Address
+	Value *big.Int
+	Gas   *big.Int
+	Nonce *big.Int
+	Data  []byte
+}
+
+// Forwarder is a binding of the FileForwarder contract.
+type Forwarder struct {
+	contract *bind.BoundContract
+	address  common.Address
+	abi      abi.ABI
+}
+
+// NewForwarder binds the FileForwarder contract deployed at address.
+func NewForwarder(address common.Address, backend bind.ContractBackend) (*Forwarder, error) {
+	abiJson, err := loadABI(filepath.Join("contracts", "file_forwarder.abi"))
+	if err != nil {
+		return nil, err
+	}
+	return &Forwarder{
+		contract: bind.NewBoundContract(address, abiJson, backend, backend, backend),
+		address:  address,
+		abi:      abiJson,
+	}, nil
+}
+
+// Address returns the address of the bound contract.
+func (f *Forwarder) Address() common.Address {
+	return f.address
+}
+
+func (f *Forwarder) GetNonce(opts *bind.CallOpts, from common.Address) (*big.Int, error) {
+	var out []interface{}
+	err := f.contract.Call(opts, &out, "getNonce", from)
+	if err != nil {
+		return nil, err
+	}
+	return out[0].(*big.Int), nil
+}
+
+func (f *Forwarder) Execute(opts *bind.TransactOpts, req ForwardRequest, signature []byte) (*types.Transaction, error) {
+	return f.contract.Transact(opts, "execute", req, signature)
+}
+
+// RequestOf returns the request a transaction calling execute of the forwarder relays, or false for
+// any other transaction.
+func (f *Forwarder) RequestOf(tx *types.Transaction) (*ForwardRequest, bool) {
+	data := tx.Data()
+	if tx.To() == nil || *tx.To() != f.address || len(data) < 4 {
+		return nil, false
+	}
+	method, err := f.abi.MethodById(data[:4])
+	if err != nil || method.Name != "execute" {
+		return nil, false
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil || len(args) == 0 {
+		return nil, false
+	}
+	req, ok := abi.ConvertType(args[0], new(ForwardRequest)).(*ForwardRequest)
+	return req, ok
+}
+
+// ForwardTypedData returns the EIP-712 typed data of req for the forwarder at forwarder on chainID,
+// as passed to eth_signTypedData_v4.
+func ForwardTypedData(chainID *big.Int, forwarder common.Address, req ForwardRequest) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types:       forwardRequestTypes,
+		PrimaryType: "ForwardRequest",
+		Domain: apitypes.TypedDataDomain{
+			Name:              ForwarderDomainName,
+			Version:           ForwarderDomainVersion,
+			ChainId:           (*math.HexOrDecimal256)(chainID),
+			VerifyingContract: forwarder.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"from":  req.From.Hex(),
+			"to":    req.To.Hex(),
+			"value": req.Value.String(),
+			"gas":   req.Gas.String(),
+			"nonce": req.Nonce.String(),
+			"data":  hexutil.Encode(req.Data),
+		},
+	}
+}
+
+// RecoverForwardSigner returns the account that signed req. Signatures with a v of 27 or 28, as
+// produced by wallets, are accepted as well as raw ones.
+func RecoverForwardSigner(chainID *big.Int, forwarder common.Address, req ForwardRequest, signature []byte) (common.Address, error) {
+	hash, _, err := apitypes.TypedDataAndHash(ForwardTypedData(chainID, forwarder, req))
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("signature must be %d bytes", crypto.SignatureLength)
+	}
+	sig := common.CopyBytes(signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
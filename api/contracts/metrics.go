@@ -0,0 +1,58 @@
+package contracts
+
+import (
+	"math/big"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	accountQueueDepthDesc = prometheus.NewDesc(
+		"file_registry_account_queue_depth",
+		"Transactions of the signing account that are sent but not mined.",
+		[]string{"account"}, nil,
+	)
+	accountBalanceDesc = prometheus.NewDesc(
+		"file_registry_account_balance_wei",
+		"Balance of the signing account at the latest refresh.",
+		[]string{"account"}, nil,
+	)
+	accountPausedDesc = prometheus.NewDesc(
+		"file_registry_account_paused",
+		"Whether the signing account is paused for a low balance.",
+		[]string{"account"}, nil,
+	)
+)
+
+// PoolCollector exports the state of the signing accounts of a ContractAPI as Prometheus metrics.
+// Balances are the ones of the latest refresh, so scrapes never reach the node.
+type PoolCollector struct {
+	api *ContractAPI
+}
+
+// NewPoolCollector returns a collector for the accounts of api.
+func NewPoolCollector(api *ContractAPI) *PoolCollector {
+	return &PoolCollector{api: api}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accountQueueDepthDesc
+	ch <- accountBalanceDesc
+	ch <- accountPausedDesc
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, account := range c.api.Accounts() {
+		address := account.Address.Hex()
+		ch <- prometheus.MustNewConstMetric(accountQueueDepthDesc, prometheus.GaugeValue, float64(account.QueueDepth), address)
+		paused := 0.0
+		if account.Paused {
+			paused = 1
+		}
+		ch <- prometheus.MustNewConstMetric(accountPausedDesc, prometheus.GaugeValue, paused, address)
+		if account.Balance != nil {
+			balance, _ := new(big.Float).SetInt(account.Balance).Float64()
+			ch <- prometheus.MustNewConstMetric(accountBalanceDesc, prometheus.GaugeValue, balance, address)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// nonceResyncInterval is how often the NonceManager reloads the pending nonce on its own, forgetting
+// in-flight nonces the node already knows even when nobody reports them as mined.
+const nonceResyncInterval = time.Minute
+
+// NonceSource is the part of the backend the NonceManager synchronizes from.
+type NonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// nonceErrors are the node errors meaning the local view of the account nonce is out of date.
+var nonceErrors = []string{
+	"nonce too low",
+	"nonce too high",
+	"already known",
+	"replacement transaction underpriced",
+}
+
+// rejectionErrors are the node errors meaning a transaction was refused and never entered the mempool.
+var rejectionErrors = []string{
+	"insufficient funds",
+	"intrinsic gas too low",
+	"gas required exceeds allowance",
+	"exceeds block gas limit",
+	"gas limit reached",
+	"transaction underpriced",
+	"max fee per gas less than block base fee",
+	"max priority fee per gas higher than max fee per gas",
+	"exceeds the configured cap",
+	"oversized data",
+	"invalid sender",
+	"execution reverted",
+}
+
+// NonceManager hands out nonces for a single signer to concurrent senders. It tracks the nonces that
+// are in flight, reuses nonces whose transactions never made it to the node and resynchronizes from
+// the node after nonce related errors.
+type NonceManager struct {
+	mu       sync.Mutex
+	source   NonceSource
+	account  common.Address
+	synced   bool
+	syncedAt time.Time
+	next     uint64
+	inFlight map[uint64]struct{}
+	gaps     []uint64 // released nonces below next, sorted ascending
+}
+
+// NewNonceManager creates a NonceManager for the account. The first allocation reads the pending
+// nonce from the source.
+func NewNonceManager(source NonceSource, account common.Address) *NonceManager {
+	return &NonceManager{
+		source:   source,
+		account:  account,
+		inFlight: make(map[uint64]struct{}),
+	}
+}
+
+// Next allocates a nonce, filling released gaps first. Every allocated nonce must eventually be
+// reported back through Fail, Dropped or Mined.
+func (m *NonceManager) Next(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.synced || time.Since(m.syncedAt) >= nonceResyncInterval {
+		if err := m.resync(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	var nonce uint64
+	if len(m.gaps) > 0 {
+		nonce, m.gaps = m.gaps[0], m.gaps[1:]
+	} else {
+		nonce = m.next
+		m.next++
+	}
+	m.inFlight[nonce] = struct{}{}
+	return nonce, nil
+}
+
+// Fail reports that sending the transaction with this nonce failed. When the node definitely
+// rejected the transaction the nonce is handed out again. Nonce errors and ambiguous failures, such
+// as a timeout after the transaction may have reached the node, trigger a resync before the next
+// allocation instead, which reuses the nonce only if the node does not know it.
+func (m *NonceManager) Fail(nonce uint64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, nonce)
+	if IsNonceError(err) || !isRejection(err) {
+		m.synced = false
+		return
+	}
+	m.release(nonce)
+}
+
+// Dropped reports that the transaction with this nonce was evicted from the mempool, so the nonce
+// must be filled by the next allocation.
+func (m *NonceManager) Dropped(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, nonce)
+	m.release(nonce)
+}
+
+// Mined reports that the transaction with this nonce was included in a block.
+func (m *NonceManager) Mined(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, nonce)
+}
+
+// Resync reloads the pending nonce from the node.
+func (m *NonceManager) Resync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.resync(ctx)
+}
+
+// InFlight returns the allocated nonces that were not reported as mined, failed or dropped, sorted ascending.
+func (m *NonceManager) InFlight() []uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonces := make([]uint64, 0, len(m.inFlight))
+	for n := range m.inFlight {
+		nonces = append(nonces, n)
+	}
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+	return nonces
+}
+
+// resync aligns the local state with the node's pending nonce. Nonces below it are known to the node
+// and forgotten; nonces between it and the local next nonce that are not in flight become gaps.
+func (m *NonceManager) resync(ctx context.Context) error {
+	pending, err := m.source.PendingNonceAt(ctx, m.account)
+	if err != nil {
+		return fmt.Errorf("failed to get pending nonce: %w", err)
+	}
+
+	for n := range m.inFlight {
+		if n < pending {
+			delete(m.inFlight, n)
+		}
+	}
+	m.gaps = m.gaps[:0]
+	if pending >= m.next {
+		m.next = pending
+	} else {
+		for n := pending; n < m.next; n++ {
+			if _, ok := m.inFlight[n]; !ok {
+				m.gaps = append(m.gaps, n)
+			}
+		}
+	}
+	m.synced = true
+	m.syncedAt = time.Now()
+	return nil
+}
+
+// release makes an unused nonce available again, shrinking next when it was the last one allocated.
+func (m *NonceManager) release(nonce uint64) {
+	if nonce >= m.next {
+		return
+	}
+	if nonce == m.next-1 {
+		m.next--
+		// Trailing gaps collapse into next as well.
+		for len(m.gaps) > 0 && m.gaps[len(m.gaps)-1] == m.next-1 {
+			m.gaps = m.gaps[:len(m.gaps)-1]
+			m.next--
+		}
+		return
+	}
+	i := sort.Search(len(m.gaps), func(i int) bool { return m.gaps[i] >= nonce })
+	if i < len(m.gaps) && m.gaps[i] == nonce {
+		return
+	}
+	m.gaps = append(m.gaps, 0)
+	copy(m.gaps[i+1:], m.gaps[i:])
+	m.gaps[i] = nonce
+}
+
+// IsNonceError reports whether err is a node error caused by a stale or conflicting nonce.
+func IsNonceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range nonceErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaybeSent reports whether a transaction whose send failed with err may have reached the node
+// nonetheless: the failure was ambiguous, such as a timeout, or the node already knew the transaction.
+func MaybeSent(err error) bool {
+	if err == nil {
+		return false
+	}
+	return containsAny(err, []string{"already known"}) || !isRejection(err) && !IsNonceError(err)
+}
+
+// isRejection reports whether err means the node refused the transaction: it answered with a
+// JSON-RPC error or with one of the known validation errors of its transaction pool.
+func isRejection(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return true
+	}
+	return err != nil && containsAny(err, rejectionErrors)
+}
@@ -0,0 +1,176 @@
+package contracts_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+func TestNonceManager_ConcurrentSends(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	client := sim.Client()
+	nonces := contracts.NewNonceManager(client, auth.From)
+	ctx := context.Background()
+
+	const senders = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := nonces.Next(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := client.SendTransaction(ctx, signTransfer(t, sim, auth, nonce)); err != nil {
+				nonces.Fail(nonce, err)
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Len(t, nonces.InFlight(), senders)
+
+	sim.Commit()
+	mined, err := client.NonceAt(ctx, auth.From, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(senders), mined)
+
+	require.NoError(t, nonces.Resync(ctx))
+	assert.Empty(t, nonces.InFlight())
+}
+
+func TestNonceManager_FillsGaps(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	nonces := contracts.NewNonceManager(sim.Client(), auth.From)
+	ctx := context.Background()
+
+	for want := uint64(0); want < 4; want++ {
+		got, err := nonces.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// A failure that never reached the node and a dropped transaction both leave gaps.
+	nonces.Fail(2, errors.New("gas required exceeds allowance"))
+	nonces.Dropped(0)
+	assert.Equal(t, []uint64{1, 3}, nonces.InFlight())
+
+	got, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), got)
+	got, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), got)
+	got, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), got)
+
+	// Releasing the highest nonces shrinks the sequence instead of leaving gaps.
+	nonces.Fail(4, errors.New("insufficient funds"))
+	nonces.Fail(3, errors.New("insufficient funds"))
+	got, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), got)
+}
+
+func TestNonceManager_ResyncsOnNonceError(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	client := sim.Client()
+	nonces := contracts.NewNonceManager(client, auth.From)
+	ctx := context.Background()
+
+	nonce, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), nonce)
+
+	// Another process uses the same key behind the manager's back.
+	require.NoError(t, client.SendTransaction(ctx, signTransfer(t, sim, auth, 0)))
+	sim.Commit()
+
+	err = client.SendTransaction(ctx, signTransfer(t, sim, auth, nonce))
+	require.Error(t, err)
+	assert.True(t, contracts.IsNonceError(err), err.Error())
+	nonces.Fail(nonce, err)
+
+	nonce, err = nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), nonce)
+	assert.NoError(t, client.SendTransaction(ctx, signTransfer(t, sim, auth, nonce)))
+}
+
+func TestNonceManager_AmbiguousFailureResyncs(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	client := sim.Client()
+	nonces := contracts.NewNonceManager(client, auth.From)
+	ctx := context.Background()
+
+	// The send times out on the client side although the node accepted the transaction.
+	nonce, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	require.NoError(t, client.SendTransaction(ctx, signTransfer(t, sim, auth, nonce)))
+	nonces.Fail(nonce, fmt.Errorf("failed to send: %w", context.DeadlineExceeded))
+
+	next, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, nonce+1, next)
+
+	// A timeout before the transaction reached the node leaves the nonce to the next allocation.
+	nonces.Fail(next, fmt.Errorf("failed to send: %w", context.DeadlineExceeded))
+	again, err := nonces.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, next, again)
+}
+
+func TestContractAPI_ConcurrentSaves(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	const uploads = 10
+	var wg sync.WaitGroup
+	hashes := make(chan string, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash, err := api.Save("/test/file.txt", "QmFakeCID")
+			assert.NoError(t, err)
+			hashes <- hash
+		}()
+	}
+	wg.Wait()
+	close(hashes)
+	sim.Commit()
+
+	seen := make(map[string]bool)
+	for hash := range hashes {
+		assert.False(t, seen[hash], "duplicate transaction %s", hash)
+		seen[hash] = true
+		status, err := api.TxStatus(context.Background(), hash)
+		require.NoError(t, err)
+		assert.Equal(t, contracts.TxMined, status.Status)
+	}
+	assert.Len(t, seen, uploads)
+}
+
+func TestMaybeSent(t *testing.T) {
+	assert.True(t, contracts.MaybeSent(fmt.Errorf("failed to send: %w", context.DeadlineExceeded)))
+	assert.True(t, contracts.MaybeSent(errors.New("already known")))
+	assert.False(t, contracts.MaybeSent(errors.New("nonce too low: next nonce 5, tx nonce 4")))
+	assert.False(t, contracts.MaybeSent(errors.New("insufficient funds for gas * price + value")))
+	assert.False(t, contracts.MaybeSent(nil))
+}
@@ -0,0 +1,238 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Dispatch policies of the account pool.
+const (
+	// DispatchRoundRobin sends every transaction from the next account in turn.
+	DispatchRoundRobin = "round-robin"
+	// DispatchLeastPending sends from the account with the fewest transactions in flight.
+	DispatchLeastPending = "least-pending"
+)
+
+// ErrNoAccountAvailable is returned when every account of the pool is paused for a low balance.
+var ErrNoAccountAvailable = errors.New("every signing account is paused for low balance")
+
+// PoolPolicy configures how saves are spread over the signing accounts of a ContractAPI.
+type PoolPolicy struct {
+	// Dispatch is DispatchRoundRobin or DispatchLeastPending.
+	Dispatch string
+	// MinBalance pauses accounts whose balance drops below it, in wei; nil never pauses.
+	MinBalance *big.Int
+}
+
+// DefaultPoolPolicy returns the policy used when none is configured.
+func DefaultPoolPolicy() PoolPolicy {
+	return PoolPolicy{Dispatch: DispatchRoundRobin}
+}
+
+// poolAccount is a signing account with its own nonces. Balance and paused are refreshed by
+// RefreshBalances; an account also pauses itself when the node rejects a transaction for lack of funds.
+type poolAccount struct {
+	auth   *bind.TransactOpts
+	nonces *NonceManager
+
+	mu      sync.Mutex
+	balance *big.Int // nil until the first refresh
+	paused  bool
+}
+
+func (a *poolAccount) isPaused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
+
+// queueDepth is the number of transactions of the account that are sent but not mined.
+func (a *poolAccount) queueDepth() int {
+	return len(a.nonces.InFlight())
+}
+
+// AccountStatus describes a signing account of the pool.
+type AccountStatus struct {
+	Address common.Address
+	// Balance is the balance seen by the latest refresh, nil before the first one.
+	Balance    *big.Int
+	Paused     bool
+	QueueDepth int
+}
+
+// AddAccount adds a signing account to the pool Save, Remove and relayed calls are dispatched over.
+// The account given to NewContractAPIWithBackend stays the primary one, which sends the ACL calls
+// since it owns the namespaces. It must be called before the ContractAPI is used concurrently.
+func (api *ContractAPI) AddAccount(auth *bind.TransactOpts) {
+	api.accounts = append(api.accounts, &poolAccount{auth: auth, nonces: NewNonceManager(api.client, auth.From)})
+}
+
+// SetPoolPolicy replaces the pool policy. An empty dispatch keeps the default.
+// It must be called before the ContractAPI is used concurrently.
+func (api *ContractAPI) SetPoolPolicy(policy PoolPolicy) {
+	if policy.Dispatch == "" {
+		policy.Dispatch = DefaultPoolPolicy().Dispatch
+	}
+	api.pool = policy
+}
+
+// Accounts reports the signing accounts of the pool, the primary one first.
+func (api *ContractAPI) Accounts() []AccountStatus {
+	statuses := make([]AccountStatus, 0, len(api.accounts))
+	for _, account := range api.accounts {
+		account.mu.Lock()
+		status := AccountStatus{Address: account.auth.From, Paused: account.paused}
+		if account.balance != nil {
+			status.Balance = new(big.Int).Set(account.balance)
+		}
+		account.mu.Unlock()
+		status.QueueDepth = account.queueDepth()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// primary returns the account that owns the namespaces of the API.
+func (api *ContractAPI) primary() *poolAccount {
+	return api.accounts[0]
+}
+
+// pickAccount returns the unpaused account the next pooled transaction is sent from.
+func (api *ContractAPI) pickAccount() (*poolAccount, error) {
+	api.pickMu.Lock()
+	defer api.pickMu.Unlock()
+
+	var picked *poolAccount
+	depth := 0
+	for i := range api.accounts {
+		index := (api.nextAccount + i) % len(api.accounts)
+		account := api.accounts[index]
+		if account.isPaused() {
+			continue
+		}
+		if api.pool.Dispatch != DispatchLeastPending {
+			api.nextAccount = index + 1
+			return account, nil
+		}
+		// Ties go to the next account in turn, so idle accounts still share the load.
+		if d := account.queueDepth(); picked == nil || d < depth {
+			picked, depth = account, d
+		}
+	}
+	if picked == nil {
+		return nil, ErrNoAccountAvailable
+	}
+	api.nextAccount++
+	return picked, nil
+}
+
+// accountFor returns the account a save or removal of path is sent from. The registry gives a path
+// nobody owns to its first writer and lets only that writer change it afterwards, so the dispatch
+// policy cannot choose freely:
+//   - a path open to anyone goes to the account its hash selects, so that concurrent first saves of
+//     it come from the account that ends up owning it;
+//   - otherwise the account pick returns is kept when it may write path, and else the first unpaused
+//     account that may.
+//
+// The lookup only steers the choice: when it fails or no account may write path, the account pick
+// returns is used and the registry decides.
+func (api *ContractAPI) accountFor(ctx context.Context, path string, pick func() (*poolAccount, error)) (*poolAccount, error) {
+	if len(api.accounts) == 1 {
+		return pick()
+	}
+	opts := &bind.CallOpts{Context: ctx}
+	// canWrite holds for the zero address only on paths outside any namespace that nobody owns.
+	open, err := api.instance.CanWrite(opts, path, common.Address{})
+	if err != nil {
+		return pick()
+	}
+	if open {
+		hash := fnv.New32a()
+		hash.Write([]byte(path))
+		if account := api.accounts[hash.Sum32()%uint32(len(api.accounts))]; !account.isPaused() {
+			return account, nil
+		}
+		return pick()
+	}
+
+	picked, err := pick()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := api.instance.CanWrite(opts, path, picked.auth.From); err != nil || ok {
+		return picked, nil
+	}
+	for _, account := range api.accounts {
+		if account == picked || account.isPaused() {
+			continue
+		}
+		if ok, err := api.instance.CanWrite(opts, path, account.auth.From); err == nil && ok {
+			return account, nil
+		}
+	}
+	return picked, nil
+}
+
+// RefreshBalances reads the balance of every account, pausing those below the minimum balance of the
+// pool and resuming those above it.
+func (api *ContractAPI) RefreshBalances(ctx context.Context) error {
+	var errs []error
+	for _, account := range api.accounts {
+		balance, err := api.client.BalanceAt(ctx, account.auth.From, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get balance of %s: %w", account.auth.From.Hex(), err))
+			continue
+		}
+		paused := api.pool.MinBalance != nil && balance.Cmp(api.pool.MinBalance) < 0
+
+		account.mu.Lock()
+		changed := paused != account.paused
+		account.balance, account.paused = balance, paused
+		account.mu.Unlock()
+		if changed && paused {
+			log.Printf("account pool: pausing %s, balance %s wei is below %s", account.auth.From.Hex(), balance, api.pool.MinBalance)
+		} else if changed {
+			log.Printf("account pool: resuming %s, balance %s wei", account.auth.From.Hex(), balance)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WatchBalances refreshes the balances right away and then every interval until ctx is done.
+func (api *ContractAPI) WatchBalances(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := api.RefreshBalances(ctx); err != nil {
+			log.Printf("account pool: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pauseForFunds pauses account after the node rejected its transaction for lack of funds. The next
+// balance refresh resumes it once it is funded again.
+func (api *ContractAPI) pauseForFunds(account *poolAccount, err error) {
+	if Classify(err).Code != CodeInsufficientFunds || len(api.accounts) == 1 {
+		return
+	}
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if !account.paused {
+		account.paused = true
+		log.Printf("account pool: pausing %s: %v", account.auth.From.Hex(), err)
+	}
+}
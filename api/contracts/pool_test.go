@@ -0,0 +1,259 @@
+package contracts_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// newPoolAccount creates an account funded with balance wei by funded.
+func newPoolAccount(t *testing.T, sim *simulated.Backend, funded *bind.TransactOpts, balance *big.Int) *bind.TransactOpts {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, simulatedChainID)
+	require.NoError(t, err)
+	if balance.Sign() > 0 {
+		sendValue(t, sim, funded, auth.From, balance)
+	}
+	return auth
+}
+
+// ownedContract returns init code deploying a contract that answers canWrite(string,address) with
+// whether the account is owner and reverts any other call not sent by owner.
+func ownedContract(owner common.Address) []byte {
+	code := common.FromHex("0x6052600c60003960526000f360003560e01c63355eb2c01460305773")
+	code = append(code, owner.Bytes()...)
+	code = append(code, common.FromHex("0x3314602e5760006000fd5b005b60243573")...)
+	code = append(code, owner.Bytes()...)
+	return append(code, common.FromHex("0x1460005260206000f3")...)
+}
+
+// senderOf returns the account that sent the transaction with the given hash.
+func senderOf(t *testing.T, client simulated.Client, hash string) common.Address {
+	tx, _, err := client.TransactionByHash(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	sender, err := types.Sender(types.LatestSignerForChainID(simulatedChainID), tx)
+	require.NoError(t, err)
+	return sender
+}
+
+// saveFrom saves a file and returns the account that sent the transaction.
+func saveFrom(t *testing.T, api *contracts.ContractAPI, client simulated.Client) common.Address {
+	hash, err := api.Save("/test/file.txt", "QmFakeCID")
+	require.NoError(t, err)
+	return senderOf(t, client, hash)
+}
+
+func TestPool_RoundRobin(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	second := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.AddAccount(second)
+
+	var senders []common.Address
+	for i := 0; i < 4; i++ {
+		senders = append(senders, saveFrom(t, api, sim.Client()))
+	}
+	assert.Equal(t, []common.Address{auth.From, second.From, auth.From, second.From}, senders)
+
+	// ACL calls stay with the primary account, which owns the namespaces.
+	hash, err := api.ClaimNamespace("/team/")
+	require.NoError(t, err)
+	tx, _, err := sim.Client().TransactionByHash(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	sender, err := types.Sender(types.LatestSignerForChainID(simulatedChainID), tx)
+	require.NoError(t, err)
+	assert.Equal(t, auth.From, sender)
+}
+
+func TestPool_LeastPending(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	second := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.AddAccount(second)
+	api.SetPoolPolicy(contracts.PoolPolicy{Dispatch: contracts.DispatchLeastPending})
+
+	// Accounts with as many transactions in flight take turns.
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+	assert.Equal(t, second.From, saveFrom(t, api, sim.Client()))
+	sim.Commit()
+	api.Tracker().Check(context.Background())
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+	assert.Equal(t, second.From, saveFrom(t, api, sim.Client()))
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+
+	// The second account has the fewest transactions in flight, so it gets the next save.
+	assert.Equal(t, second.From, saveFrom(t, api, sim.Client()))
+	accounts := api.Accounts()
+	require.Len(t, accounts, 2)
+	assert.Equal(t, 2, accounts[0].QueueDepth)
+	assert.Equal(t, 2, accounts[1].QueueDepth)
+
+	sim.Commit()
+	api.Tracker().Check(context.Background())
+	for _, account := range api.Accounts() {
+		assert.Equal(t, 0, account.QueueDepth)
+	}
+}
+
+func TestPool_PausesLowBalance(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	second := newPoolAccount(t, sim, auth, big.NewInt(1e15))
+	// The top-up comes from another account, since the API holds the nonces of auth.
+	funder := newPoolAccount(t, sim, auth, big.NewInt(2e18))
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.AddAccount(second)
+	api.SetPoolPolicy(contracts.PoolPolicy{Dispatch: contracts.DispatchRoundRobin, MinBalance: big.NewInt(1e16)})
+
+	require.NoError(t, api.RefreshBalances(context.Background()))
+	accounts := api.Accounts()
+	assert.False(t, accounts[0].Paused)
+	assert.True(t, accounts[1].Paused)
+	assert.Equal(t, big.NewInt(1e15), accounts[1].Balance)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+	}
+	sim.Commit()
+
+	// Funding the account resumes it on the next refresh.
+	sendValue(t, sim, funder, second.From, big.NewInt(1e18))
+	require.NoError(t, api.RefreshBalances(context.Background()))
+	assert.False(t, api.Accounts()[1].Paused)
+	senders := []common.Address{saveFrom(t, api, sim.Client()), saveFrom(t, api, sim.Client())}
+	assert.ElementsMatch(t, []common.Address{auth.From, second.From}, senders)
+}
+
+func TestPool_AllPaused(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.AddAccount(newPoolAccount(t, sim, auth, big.NewInt(0)))
+	api.SetPoolPolicy(contracts.PoolPolicy{MinBalance: new(big.Int).Mul(big.NewInt(10_000), big.NewInt(1e18))})
+	require.NoError(t, api.RefreshBalances(context.Background()))
+
+	_, err := api.Save("/test/file.txt", "QmFakeCID")
+	require.ErrorIs(t, err, contracts.ErrNoAccountAvailable)
+	assert.Equal(t, contracts.CodeInsufficientFunds, contracts.Classify(err).Code)
+}
+
+func TestPool_PausesOnInsufficientFunds(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	empty := newPoolAccount(t, sim, auth, big.NewInt(0))
+	api.AddAccount(empty)
+
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+	_, err := api.Save("/test/file.txt", "QmFakeCID")
+	assert.Equal(t, contracts.CodeInsufficientFunds, contracts.Classify(err).Code)
+	assert.True(t, api.Accounts()[1].Paused)
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+	assert.Equal(t, auth.From, saveFrom(t, api, sim.Client()))
+}
+
+func TestPool_RoutesToWriter(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	second := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+	address := deployCode(t, sim, auth, ownedContract(second.From))
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.AddAccount(second)
+
+	// Only the second account may write, so every write goes to it whatever the dispatch policy.
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, second.From, saveFrom(t, api, sim.Client()))
+	}
+	hash, err := api.Remove("/test/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, second.From, senderOf(t, sim.Client(), hash))
+	results, err := api.SaveBatch([]string{"/test/a.txt", "/test/b.txt"}, []string{"QmA", "QmB"})
+	require.NoError(t, err)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+		assert.Equal(t, second.From, senderOf(t, sim.Client(), result.TxHash))
+	}
+}
+
+// TestPool_FileRegistryOwners writes through the compiled FileRegistry, which lets only the first
+// writer of a path change it.
+func TestPool_FileRegistryOwners(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	// The registry is deployed by another account, so that no account of the pool is its admin.
+	deployer := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+	address := deployArtifact(t, sim, deployer, "FileRegistry", common.Address{})
+	api := newSimulatedAPI(t, sim, auth, address)
+	pool := []*bind.TransactOpts{auth}
+	for i := 0; i < 2; i++ {
+		account := newPoolAccount(t, sim, auth, big.NewInt(1e18))
+		api.AddAccount(account)
+		pool = append(pool, account)
+	}
+	mined := func(hashes ...string) {
+		sim.Commit()
+		for _, hash := range hashes {
+			receipt, err := sim.Client().TransactionReceipt(context.Background(), common.HexToHash(hash))
+			require.NoError(t, err)
+			assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status, hash)
+		}
+	}
+
+	// Concurrent first saves of a path come from one account, which ends up owning it.
+	var hashes []string
+	for i := 0; i < 6; i++ {
+		path := fmt.Sprintf("/shared/file-%d.txt", i%3)
+		hash, err := api.Save(path, fmt.Sprintf("QmFirst%d", i))
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	mined(hashes...)
+	for i := 0; i < 3; i++ {
+		access, err := api.Access(fmt.Sprintf("/shared/file-%d.txt", i), nil)
+		require.NoError(t, err)
+		assert.Contains(t, []common.Address{pool[0].From, pool[1].From, pool[2].From}, access.Owner)
+		assert.Equal(t, access.Owner, senderOf(t, sim.Client(), hashes[i]))
+		assert.Equal(t, access.Owner, senderOf(t, sim.Client(), hashes[i+3]))
+	}
+
+	// Overwrites, batches and removals of the owned paths all succeed.
+	hashes = nil
+	for i := 0; i < 6; i++ {
+		hash, err := api.Save(fmt.Sprintf("/shared/file-%d.txt", i%3), fmt.Sprintf("QmSecond%d", i))
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	results, err := api.SaveBatch(
+		[]string{"/shared/file-0.txt", "/shared/file-1.txt", "/shared/file-2.txt"},
+		[]string{"QmBatch0", "QmBatch1", "QmBatch2"})
+	require.NoError(t, err)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+		hashes = append(hashes, result.TxHash)
+	}
+	mined(hashes...)
+	for i := 0; i < 3; i++ {
+		cid, err := api.Get(fmt.Sprintf("/shared/file-%d.txt", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("QmBatch%d", i), cid)
+	}
+
+	hashes = nil
+	for i := 0; i < 3; i++ {
+		hash, err := api.Remove(fmt.Sprintf("/shared/file-%d.txt", i))
+		require.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+	mined(hashes...)
+}
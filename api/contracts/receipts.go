@@ -0,0 +1,168 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Transaction states reported by TxStatus. TxCancelled is a transaction whose nonce was mined by a
+// cancel replacement, so its call never executed.
+const (
+	TxPending   = "pending"
+	TxMined     = "mined"
+	TxReverted  = "reverted"
+	TxCancelled = "cancelled"
+)
+
+// ErrTxNotFound is returned when the node knows neither a receipt nor a pending transaction for a hash.
+var ErrTxNotFound = errors.New("transaction not found")
+
+// confirmationPollInterval is how often the chain head is polled while waiting for confirmations.
+const confirmationPollInterval = time.Second
+
+// TxStatus describes the on-chain state of a transaction. When the transaction was replaced by a
+// speed-up or cancel, ReplacedBy holds the hash of the replacement that is pending or got mined.
+type TxStatus struct {
+	Hash          string `json:"hash"`
+	Status        string `json:"status"`
+	BlockNumber   uint64 `json:"blockNumber,omitempty"`
+	GasUsed       uint64 `json:"gasUsed,omitempty"`
+	Confirmations uint64 `json:"confirmations"`
+	ReplacedBy    string `json:"replacedBy,omitempty"`
+	// SavedLogs are the log indexes of the FileSaved events of the mined transaction.
+	SavedLogs []uint `json:"-"`
+}
+
+// MinedHash returns the hash of the transaction that got mined: the replacement when the original
+// transaction was replaced, the original otherwise.
+func (s *TxStatus) MinedHash() common.Hash {
+	if s.ReplacedBy != "" {
+		return common.HexToHash(s.ReplacedBy)
+	}
+	return common.HexToHash(s.Hash)
+}
+
+// TxStatus reports whether the transaction is pending, mined, reverted or cancelled, with its
+// confirmation count. Replacements sent through SpeedUp or Cancel are followed, so the status of the
+// original hash reflects whichever transaction of the same nonce gets mined.
+func (api *ContractAPI) TxStatus(ctx context.Context, txHash string) (*TxStatus, error) {
+	hash := common.HexToHash(txHash)
+	hashes := api.tracker.related(hash)
+	for _, h := range hashes {
+		receipt, err := api.client.TransactionReceipt(ctx, h)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transaction receipt: %w", err)
+		}
+		status, err := api.receiptStatus(ctx, receipt)
+		if err != nil {
+			return nil, err
+		}
+		if h != hash {
+			status.Hash, status.ReplacedBy = hash.Hex(), h.Hex()
+			if api.tracker.isCancel(h) && status.Status == TxMined {
+				status.Status = TxCancelled
+			}
+		}
+		return status, nil
+	}
+
+	// Nothing is mined yet: the transaction is pending as long as the node knows any of them.
+	for i := len(hashes) - 1; i >= 0; i-- {
+		if _, _, err := api.client.TransactionByHash(ctx, hashes[i]); err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get transaction: %w", err)
+		}
+		status := &TxStatus{Hash: hash.Hex(), Status: TxPending}
+		if latest := hashes[len(hashes)-1]; latest != hash {
+			status.ReplacedBy = latest.Hex()
+		}
+		return status, nil
+	}
+	return nil, ErrTxNotFound
+}
+
+// WaitMined blocks until the transaction, or a replacement of it, is mined and has at least the given
+// number of confirmations (a transaction in the latest block has one), or until ctx is done. A
+// reverted or cancelled transaction is returned as soon as it is mined.
+//
+// It polls TxStatus rather than using bind.WaitMined so that it follows replacements. The links from
+// a transaction to its replacements are only kept in memory by the TxTracker, and are forgotten
+// replacementRetention after the nonce is mined: a wait that outlives them, or one for a transaction
+// sent before the process restarted, no longer sees the replacement: once the node drops the original
+// transaction, the wait fails with ErrTxNotFound or lasts until ctx is done.
+func (api *ContractAPI) WaitMined(ctx context.Context, txHash string, confirmations uint64) (*TxStatus, error) {
+	status, err := api.TxStatus(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(confirmationPollInterval)
+	defer ticker.Stop()
+	for {
+		if status.Status == TxReverted || status.Status == TxCancelled || (status.Status == TxMined && status.Confirmations >= confirmations) {
+			return status, nil
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+
+		next, err := api.TxStatus(ctx, txHash)
+		switch {
+		case err == nil:
+			status = next
+		case errors.Is(err, ErrTxNotFound):
+			// The node may briefly forget a pending transaction, e.g. while it is being replaced.
+		case ctx.Err() != nil:
+			return status, ctx.Err()
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (api *ContractAPI) receiptStatus(ctx context.Context, receipt *types.Receipt) (*TxStatus, error) {
+	head, err := api.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest block: %w", err)
+	}
+
+	status := &TxStatus{
+		Hash:        receipt.TxHash.Hex(),
+		Status:      TxMined,
+		BlockNumber: receipt.BlockNumber.Uint64(),
+		GasUsed:     receipt.GasUsed,
+	}
+	if receipt.Status == types.ReceiptStatusFailed {
+		status.Status = TxReverted
+	}
+	for _, l := range receipt.Logs {
+		if l.Address == api.instance.address && len(l.Topics) > 0 && l.Topics[0] == api.instance.FileSavedTopic() {
+			status.SavedLogs = append(status.SavedLogs, l.Index)
+		}
+	}
+	if head.Number.Cmp(receipt.BlockNumber) >= 0 {
+		status.Confirmations = new(big.Int).Sub(head.Number, receipt.BlockNumber).Uint64() + 1
+	}
+	return status, nil
+}
+
+// isNotFound reports whether a transaction or receipt lookup failed only because the node does not know
+// it (yet), either because it is not mined or because the node is still indexing transactions.
+func isNotFound(err error) bool {
+	return errors.Is(err, ethereum.NotFound) || (err != nil && strings.Contains(err.Error(), "transaction indexing is in progress"))
+}
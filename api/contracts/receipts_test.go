@@ -0,0 +1,123 @@
+package contracts_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+func TestTxStatus_PendingThenMined(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	api := newSimulatedAPI(t, sim, auth, common.Address{})
+	ctx := context.Background()
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	tx := sendTx(t, sim, auth, &to, 21000, nil)
+
+	status, err := api.TxStatus(ctx, tx.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxPending, status.Status)
+	assert.Equal(t, uint64(0), status.Confirmations)
+
+	sim.Commit()
+	status, err = api.TxStatus(ctx, tx.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+	assert.Equal(t, uint64(1), status.BlockNumber)
+	assert.Equal(t, uint64(21000), status.GasUsed)
+	assert.Equal(t, uint64(1), status.Confirmations)
+
+	sim.Commit()
+	status, err = api.TxStatus(ctx, tx.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), status.Confirmations)
+}
+
+func TestTxStatus_NotFound(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	api := newSimulatedAPI(t, sim, auth, common.Address{})
+
+	_, err := api.TxStatus(context.Background(), "0x1111111111111111111111111111111111111111111111111111111111111111")
+	assert.ErrorIs(t, err, contracts.ErrTxNotFound)
+}
+
+func TestTxStatus_Reverted(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	api := newSimulatedAPI(t, sim, auth, common.Address{})
+
+	target := deployCode(t, sim, auth, revertingContract)
+	tx := sendTx(t, sim, auth, &target, 100_000, nil)
+	sim.Commit()
+
+	status, err := api.TxStatus(context.Background(), tx.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxReverted, status.Status)
+}
+
+func TestTxStatus_SavedLogs(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	// The runtime code emits a log with the FileSaved topic: PUSH32 topic PUSH1 0 PUSH1 0 LOG1 STOP.
+	topic := crypto.Keccak256Hash([]byte("FileSaved(string,string)"))
+	runtime := append(append([]byte{0x7f}, topic.Bytes()...), 0x60, 0x00, 0x60, 0x00, 0xa1, 0x00)
+	address := deployCode(t, sim, auth, append(common.FromHex("0x6027600c60003960276000f3"), runtime...))
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	hash, err := api.Save("/a.txt", "QmA")
+	require.NoError(t, err)
+	sim.Commit()
+
+	status, err := api.TxStatus(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+	assert.Equal(t, []uint{0}, status.SavedLogs)
+	assert.Equal(t, common.HexToHash(hash), status.MinedHash())
+}
+
+func TestWaitMined_Confirmations(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	api := newSimulatedAPI(t, sim, auth, common.Address{})
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	tx := sendTx(t, sim, auth, &to, 21000, nil)
+	sim.Commit()
+	sim.Commit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := api.WaitMined(ctx, tx.Hash().Hex(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+	assert.Equal(t, uint64(2), status.Confirmations)
+}
+
+func TestWaitMined_Timeout(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	api := newSimulatedAPI(t, sim, auth, common.Address{})
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	tx := sendTx(t, sim, auth, &to, 21000, nil)
+	sim.Commit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	status, err := api.WaitMined(ctx, tx.Hash().Hex(), 3)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotNil(t, status)
+	assert.Equal(t, uint64(1), status.Confirmations)
+}
+
+func TestGetAt_FutureBlock(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	_, err := api.GetAt("/test/file.txt", 100)
+	assert.ErrorIs(t, err, contracts.ErrFutureBlock)
+}
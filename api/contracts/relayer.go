@@ -0,0 +1,250 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// ErrInvalidForwardRequest is returned for requests the relayer does not pay for: calls of other
+	// contracts than the registry or of registry functions other than save, saveBatch and remove, calls
+	// transferring ether and calls asking for too much gas.
+	ErrInvalidForwardRequest = errors.New("invalid forward request")
+	// ErrForwardSignature is returned when a request is not signed by its From account.
+	ErrForwardSignature = errors.New("forward request is not signed by its sender")
+	// ErrForwardNonce is returned when a request does not carry the next forwarder nonce of its sender.
+	ErrForwardNonce = errors.New("forward request nonce is not the next nonce of its sender")
+	// ErrRelayQuotaExceeded is returned when the sender of a request used up its relay quota.
+	ErrRelayQuotaExceeded = errors.New("relay quota exceeded")
+)
+
+// RelayPolicy limits what a Relayer pays for.
+type RelayPolicy struct {
+	// MaxGas is the largest gas a request may ask the forwarder to call the registry with.
+	MaxGas uint64
+	// Quota is the number of requests relayed per sender and Window; 0 means unlimited.
+	Quota  int
+	Window time.Duration
+}
+
+// DefaultRelayPolicy returns the policy used when none is configured.
+func DefaultRelayPolicy() RelayPolicy {
+	return RelayPolicy{MaxGas: 1_000_000, Quota: 100, Window: 24 * time.Hour}
+}
+
+// Relayer submits forward requests signed by users to the trusted forwarder of the registry, paying
+// the gas from the API account. The registry attributes the calls to the signers.
+type Relayer struct {
+	api       *ContractAPI
+	forwarder *Forwarder
+	chainID   *big.Int
+	policy    RelayPolicy
+	quota     *relayQuota
+}
+
+// NewRelayer builds a Relayer sending through api to the forwarder deployed at forwarder on chainID.
+func NewRelayer(api *ContractAPI, forwarder common.Address, chainID *big.Int, policy RelayPolicy) (*Relayer, error) {
+	binding, err := NewForwarder(forwarder, api.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate forwarder: %w", err)
+	}
+	return &Relayer{
+		api:       api,
+		forwarder: binding,
+		chainID:   chainID,
+		policy:    policy,
+		quota:     newRelayQuota(policy.Quota, policy.Window),
+	}, nil
+}
+
+// Forwarder returns the address of the forwarder requests are sent to.
+func (r *Relayer) Forwarder() common.Address {
+	return r.forwarder.address
+}
+
+// ChainID returns the chain ID requests are signed for.
+func (r *Relayer) ChainID() *big.Int {
+	return r.chainID
+}
+
+// Nonce returns the forwarder nonce the next request of from must carry, counting pending relays.
+func (r *Relayer) Nonce(ctx context.Context, from common.Address) (*big.Int, error) {
+	nonce, err := r.forwarder.GetNonce(&bind.CallOpts{Context: ctx, Pending: true}, from)
+	return nonce, r.translate(err)
+}
+
+// Remaining returns the number of requests of from that are still relayed in the current window,
+// or -1 when relays are unlimited.
+func (r *Relayer) Remaining(from common.Address) int {
+	return r.quota.remaining(from)
+}
+
+// Relay checks req and its signature and sends it to the forwarder, returning the transaction hash.
+// Requests are only relayed to the registry, for the functions WritePaths accepts, without value and
+// within the gas limit of the policy; each one counts against the quota of its sender unless it fails
+// to be sent.
+func (r *Relayer) Relay(ctx context.Context, req ForwardRequest, signature []byte) (string, error) {
+	switch {
+	case req.To != r.api.instance.address:
+		return "", r.translate(fmt.Errorf("%w: target %s is not the registry", ErrInvalidForwardRequest, req.To.Hex()))
+	case req.Value == nil || req.Value.Sign() != 0:
+		return "", r.translate(fmt.Errorf("%w: value must be 0", ErrInvalidForwardRequest))
+	case req.Gas == nil || req.Gas.Sign() <= 0 || !req.Gas.IsUint64() || req.Gas.Uint64() > r.policy.MaxGas:
+		return "", r.translate(fmt.Errorf("%w: gas must be between 1 and %d", ErrInvalidForwardRequest, r.policy.MaxGas))
+	case req.Nonce == nil:
+		return "", r.translate(fmt.Errorf("%w: missing nonce", ErrInvalidForwardRequest))
+	}
+	if _, err := r.WritePaths(req.Data); err != nil {
+		return "", err
+	}
+
+	signer, err := RecoverForwardSigner(r.chainID, r.forwarder.address, req, signature)
+	if err != nil || signer != req.From {
+		return "", r.translate(ErrForwardSignature)
+	}
+	nonce, err := r.Nonce(ctx, req.From)
+	if err != nil {
+		return "", err
+	}
+	if nonce.Cmp(req.Nonce) != 0 {
+		return "", r.translate(fmt.Errorf("%w: expected %s", ErrForwardNonce, nonce))
+	}
+
+	if !r.quota.take(req.From) {
+		return "", r.translate(fmt.Errorf("%w: %d relays per %s", ErrRelayQuotaExceeded, r.policy.Quota, r.policy.Window))
+	}
+	input, err := r.forwarder.abi.Pack("execute", req, signature)
+	if err != nil {
+		r.quota.refund(req.From)
+		return "", r.translate(fmt.Errorf("failed to pack execute call: %w", err))
+	}
+	hash, err := r.api.transactTo(ctx, r.forwarder.address, input, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return r.forwarder.Execute(opts, req, signature)
+	})
+	if err != nil {
+		r.quota.refund(req.From)
+		return "", r.translate(err)
+	}
+	return hash, nil
+}
+
+// relayedMethods are the registry functions the relayer pays for. Grants, ownership and admin changes
+// are left to the accounts making them.
+var relayedMethods = map[string]bool{"save": true, "saveBatch": true, "remove": true}
+
+// WritePaths decodes the registry call data of a forward request and returns the paths it writes.
+// Calls of other functions than save, saveBatch and remove fail with ErrInvalidForwardRequest.
+func (r *Relayer) WritePaths(data []byte) ([]string, error) {
+	if len(data) < 4 {
+		return nil, r.translate(fmt.Errorf("%w: data is not a registry call", ErrInvalidForwardRequest))
+	}
+	method, err := r.api.instance.abi.MethodById(data[:4])
+	if err != nil {
+		return nil, r.translate(fmt.Errorf("%w: data is not a registry call", ErrInvalidForwardRequest))
+	}
+	if !relayedMethods[method.Name] {
+		return nil, r.translate(fmt.Errorf("%w: %s is not relayed", ErrInvalidForwardRequest, method.Name))
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, r.translate(fmt.Errorf("%w: invalid %s arguments: %v", ErrInvalidForwardRequest, method.Name, err))
+	}
+	switch paths := args[0].(type) {
+	case string:
+		return []string{paths}, nil
+	case []string:
+		return paths, nil
+	}
+	return nil, r.translate(fmt.Errorf("%w: invalid %s arguments", ErrInvalidForwardRequest, method.Name))
+}
+
+// translate classifies err like the ContractAPI does, decoding reverts of the forwarder as well as
+// the registry reverts it bubbles up.
+func (r *Relayer) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		// Reverts the registry ABI decoded carry details; others may be errors of the forwarder.
+		if classified.Code != CodeReverted || classified.Details != nil {
+			return err
+		}
+		err = classified.Err
+	}
+	return Classify(decodeRevert(r.forwarder.abi, err))
+}
+
+// relayQuota counts the relays of every sender in a sliding window.
+type relayQuota struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	used   map[common.Address][]time.Time
+}
+
+func newRelayQuota(limit int, window time.Duration) *relayQuota {
+	return &relayQuota{limit: limit, window: window, now: time.Now, used: make(map[common.Address][]time.Time)}
+}
+
+// take records a relay of account, returning false when its quota is used up.
+func (q *relayQuota) take(account common.Address) bool {
+	if q.limit <= 0 {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	used := q.prune(account)
+	if len(used) >= q.limit {
+		return false
+	}
+	q.used[account] = append(used, q.now())
+	return true
+}
+
+// refund forgets the latest relay of account, for relays that were not sent.
+func (q *relayQuota) refund(account common.Address) {
+	if q.limit <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if used := q.used[account]; len(used) > 0 {
+		q.used[account] = used[:len(used)-1]
+	}
+}
+
+func (q *relayQuota) remaining(account common.Address) int {
+	if q.limit <= 0 {
+		return -1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit - len(q.prune(account))
+}
+
+// prune drops the relays of account that left the window and returns the rest. q.mu must be held.
+func (q *relayQuota) prune(account common.Address) []time.Time {
+	used := q.used[account]
+	cutoff := q.now().Add(-q.window)
+	i := 0
+	for i < len(used) && !used[i].After(cutoff) {
+		i++
+	}
+	used = used[i:]
+	if len(used) == 0 {
+		delete(q.used, account)
+		return nil
+	}
+	q.used[account] = used
+	return used
+}
@@ -0,0 +1,278 @@
+package contracts_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// forwarderStandIn is init code deploying a stand-in for FileForwarder without signature checks,
+// which the relayer makes before sending. getNonce(address) returns the nonce stored at the address;
+// execute(req, signature) increments the nonce of req.from, calls req.to with req.data followed by
+// req.from and bubbles up reverts:
+//
+//	selector == getNonce: RETURN sload(calldata[4])
+//	selector == execute:  from := calldata[0x44]; sstore(from, sload(from)+1)
+//	                      data := 0x44 + calldata[0xe4]; mem[0:] = calldata[data+32 : data+32+len]
+//	                      mem[len:] = from; CALL(gas, calldata[0x64], 0, 0, len+20)
+//	                      REVERT with the returndata unless the call succeeded
+var forwarderStandIn = common.FromHex("0x606d600c600039606d6000f360003560e01c80632d0335ab14601d576347153f8214602a57600080fd5b6004355460005260206000f35b6044358054600101905560e4356044018035808260200160003760443560601b81526014016000600082600060006064355af1606b573d600060003e3d6000fd5b00")
+
+// recordingContract is init code deploying a contract that emits its calldata as the data of a LOG0.
+var recordingContract = common.FromHex("0x600b600c600039600b6000f3366000600037366000a000")
+
+// newRelayer deploys the forwarder stand-in and returns a relayer sending through it to the registry
+// at registry.
+func newRelayer(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, registry common.Address, policy contracts.RelayPolicy) *contracts.Relayer {
+	forwarder := deployCode(t, sim, auth, forwarderStandIn)
+	api := newSimulatedAPI(t, sim, auth, registry)
+	relayer, err := contracts.NewRelayer(api, forwarder, simulatedChainID, policy)
+	require.NoError(t, err)
+	return relayer
+}
+
+// signForward signs req as EIP-712 typed data for the forwarder of relayer, like a wallet would.
+func signForward(t *testing.T, relayer *contracts.Relayer, key *ecdsa.PrivateKey, req contracts.ForwardRequest) []byte {
+	hash, _, err := apitypes.TypedDataAndHash(contracts.ForwardTypedData(relayer.ChainID(), relayer.Forwarder(), req))
+	require.NoError(t, err)
+	sig, err := crypto.Sign(hash, key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+	return sig
+}
+
+// relayedSave is the call data of save("/relayed/file.txt", "QmRelayed").
+var relayedSave = common.FromHex("0x962939b80000000000000000000000000000000000000000000000000000000000000040000000000000000000000000000000000000000000000000000000000000008000000000000000000000000000000000000000000000000000000000000000112f72656c617965642f66696c652e7478740000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000009516d52656c617965640000000000000000000000000000000000000000000000")
+
+// forwardRequest returns a request of from saving /relayed/file.txt through the registry at to.
+func forwardRequest(from, to common.Address, nonce int64) contracts.ForwardRequest {
+	return contracts.ForwardRequest{
+		From:  from,
+		To:    to,
+		Value: big.NewInt(0),
+		Gas:   big.NewInt(100_000),
+		Nonce: big.NewInt(nonce),
+		Data:  relayedSave,
+	}
+}
+
+func TestRelay_ForwardsForSigner(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	registry := deployCode(t, sim, auth, recordingContract)
+	relayer := newRelayer(t, sim, auth, registry, contracts.DefaultRelayPolicy())
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(key.PublicKey)
+
+	req := forwardRequest(user, registry, 0)
+	hash, err := relayer.Relay(context.Background(), req, signForward(t, relayer, key, req))
+	require.NoError(t, err)
+	sim.Commit()
+
+	receipt, err := sim.Client().TransactionReceipt(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	require.Len(t, receipt.Logs, 1)
+	assert.Equal(t, registry, receipt.Logs[0].Address)
+	// The registry sees the request data followed by the signer, which _msgSender reads.
+	assert.Equal(t, append(req.Data, user.Bytes()...), receipt.Logs[0].Data)
+
+	nonce, err := relayer.Nonce(context.Background(), user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), nonce.Int64())
+	assert.Equal(t, 99, relayer.Remaining(user))
+
+	// The request cannot be replayed.
+	_, err = relayer.Relay(context.Background(), req, signForward(t, relayer, key, req))
+	require.ErrorIs(t, err, contracts.ErrForwardNonce)
+	assert.Equal(t, contracts.CodeConflict, contracts.Classify(err).Code)
+}
+
+func TestRelay_RejectsRequests(t *testing.T) {
+	parsed := registryABI(t)
+	sim, auth := newSimulatedBackend(t)
+	registry := deployCode(t, sim, auth, recordingContract)
+	relayer := newRelayer(t, sim, auth, registry, contracts.DefaultRelayPolicy())
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(key.PublicKey)
+
+	req := forwardRequest(user, registry, 0)
+	_, err = relayer.Relay(context.Background(), req, signForward(t, relayer, other, req))
+	require.ErrorIs(t, err, contracts.ErrForwardSignature)
+	assert.Equal(t, contracts.CodeUnauthorized, contracts.Classify(err).Code)
+
+	otherTarget := forwardRequest(user, relayer.Forwarder(), 0)
+	tooMuchGas := forwardRequest(user, registry, 0)
+	tooMuchGas.Gas = big.NewInt(2_000_000)
+	withValue := forwardRequest(user, registry, 0)
+	withValue.Value = big.NewInt(1)
+	// ACL changes and calls the registry does not know are not paid for.
+	grant := forwardRequest(user, registry, 0)
+	grant.Data, err = parsed.Pack("grantWriter", "/relayed/file.txt", user)
+	require.NoError(t, err)
+	unknown := forwardRequest(user, registry, 0)
+	unknown.Data = common.FromHex("0xdeadbeef")
+	for _, req := range []contracts.ForwardRequest{otherTarget, tooMuchGas, withValue, grant, unknown} {
+		_, err = relayer.Relay(context.Background(), req, signForward(t, relayer, key, req))
+		require.ErrorIs(t, err, contracts.ErrInvalidForwardRequest)
+		assert.Equal(t, contracts.CodeInvalidArgument, contracts.Classify(err).Code)
+	}
+	assert.Equal(t, 100, relayer.Remaining(user))
+}
+
+func TestRelay_WritePaths(t *testing.T) {
+	parsed := registryABI(t)
+	sim, auth := newSimulatedBackend(t)
+	registry := deployCode(t, sim, auth, recordingContract)
+	relayer := newRelayer(t, sim, auth, registry, contracts.DefaultRelayPolicy())
+	pack := func(method string, args ...interface{}) []byte {
+		data, err := parsed.Pack(method, args...)
+		require.NoError(t, err)
+		return data
+	}
+
+	paths, err := relayer.WritePaths(pack("save", "/a.txt", "QmA"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt"}, paths)
+	paths, err = relayer.WritePaths(pack("saveBatch", []string{"/a.txt", "/b/c.txt"}, []string{"QmA", "QmC"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt", "/b/c.txt"}, paths)
+	paths, err = relayer.WritePaths(pack("remove", "/a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt"}, paths)
+
+	for _, data := range [][]byte{
+		pack("claimNamespace", "/b/"),
+		pack("transferOwnership", "/a.txt", auth.From),
+		pack("setAdmin", auth.From),
+		pack("save", "/a.txt", "QmA")[:40],
+		{0x01},
+	} {
+		_, err := relayer.WritePaths(data)
+		assert.ErrorIs(t, err, contracts.ErrInvalidForwardRequest, hexutil.Encode(data))
+	}
+}
+
+func TestRelay_Quota(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	registry := deployCode(t, sim, auth, recordingContract)
+	relayer := newRelayer(t, sim, auth, registry, contracts.RelayPolicy{MaxGas: 200_000, Quota: 2, Window: 200 * time.Millisecond})
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(key.PublicKey)
+
+	relay := func(nonce int64) error {
+		req := forwardRequest(user, registry, nonce)
+		_, err := relayer.Relay(context.Background(), req, signForward(t, relayer, key, req))
+		sim.Commit()
+		return err
+	}
+	require.NoError(t, relay(0))
+	require.NoError(t, relay(1))
+	err = relay(2)
+	require.ErrorIs(t, err, contracts.ErrRelayQuotaExceeded)
+	assert.Equal(t, contracts.CodeQuotaExceeded, contracts.Classify(err).Code)
+	assert.Equal(t, 0, relayer.Remaining(user))
+
+	time.Sleep(250 * time.Millisecond)
+	assert.Equal(t, 2, relayer.Remaining(user))
+	require.NoError(t, relay(2))
+}
+
+func TestRelay_RegistryRevert(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(key.PublicKey)
+	notAuthorized := registryABI(t).Errors["NotAuthorized"]
+	args, err := notAuthorized.Inputs.Pack("/alice/file.txt", user)
+	require.NoError(t, err)
+	registry := deployCode(t, sim, auth, revertWith(append(notAuthorized.ID[:4], args...)))
+	relayer := newRelayer(t, sim, auth, registry, contracts.DefaultRelayPolicy())
+
+	req := forwardRequest(user, registry, 0)
+	_, err = relayer.Relay(context.Background(), req, signForward(t, relayer, key, req))
+	require.ErrorIs(t, err, contracts.ErrUnauthorized)
+	var revert *contracts.RevertError
+	require.ErrorAs(t, err, &revert)
+	assert.Equal(t, user.Hex(), revert.Args["account"])
+	// Requests that are not sent do not count against the quota.
+	assert.Equal(t, 100, relayer.Remaining(user))
+}
+
+// TestRelay_CompiledForwarder relays through the compiled FileForwarder and FileRegistry, which check
+// the signatures themselves.
+func TestRelay_CompiledForwarder(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	forwarder := deployArtifact(t, sim, auth, "FileForwarder")
+	registry := deployArtifact(t, sim, auth, "FileRegistry", forwarder)
+	parsed := registryABI(t)
+	file, err := os.Open("file_forwarder.abi")
+	require.NoError(t, err)
+	defer file.Close()
+	forwarderABI, err := abi.JSON(file)
+	require.NoError(t, err)
+
+	api := newSimulatedAPI(t, sim, auth, registry)
+	relayer, err := contracts.NewRelayer(api, forwarder, simulatedChainID, contracts.DefaultRelayPolicy())
+	require.NoError(t, err)
+	alice, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	bob, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	user := crypto.PubkeyToAddress(alice.PublicKey)
+
+	data, err := parsed.Pack("save", "/alice/relayed.txt", "QmRelayed")
+	require.NoError(t, err)
+	req := forwardRequest(user, registry, 0)
+	req.Gas, req.Data = big.NewInt(500_000), data
+	hash, err := relayer.Relay(context.Background(), req, signForward(t, relayer, alice, req))
+	require.NoError(t, err)
+	sim.Commit()
+	receipt, err := sim.Client().TransactionReceipt(context.Background(), common.HexToHash(hash))
+	require.NoError(t, err)
+	assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	access, err := api.Access("/alice/relayed.txt", nil)
+	require.NoError(t, err)
+	assert.Equal(t, user, access.Owner)
+
+	// The forwarder refuses requests not signed by their sender, even when sent to it directly.
+	invalidSignature := hexutil.Encode(forwarderABI.Errors["InvalidSignature"].ID.Bytes()[:4])
+	forged := forwardRequest(user, registry, 1)
+	fromZero := forwardRequest(common.Address{}, registry, 0)
+	for _, call := range []struct {
+		req       contracts.ForwardRequest
+		signature []byte
+	}{
+		{forged, signForward(t, relayer, bob, forged)},
+		{fromZero, make([]byte, 65)},
+		{fromZero, nil},
+	} {
+		input, err := forwarderABI.Pack("execute", call.req, call.signature)
+		require.NoError(t, err)
+		_, err = sim.Client().CallContract(context.Background(), callMsg(auth.From, forwarder, input), nil)
+		var dataErr rpc.DataError
+		require.ErrorAs(t, err, &dataErr)
+		assert.Contains(t, dataErr.ErrorData(), invalidSignature)
+	}
+}
@@ -0,0 +1,118 @@
+package contracts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	// ErrUnauthorized is matched by reverts of the contract's access control: the account may not
+	// write the path or manage its grants.
+	ErrUnauthorized = errors.New("not authorized")
+	// ErrAlreadyOwned is matched by reverts of namespace claims on paths that are already owned or in use.
+	ErrAlreadyOwned = errors.New("already owned")
+)
+
+// revertKinds maps the custom errors of the contract to the sentinel errors they match.
+var revertKinds = map[string]error{
+	"NotAuthorized":  ErrUnauthorized,
+	"NotOwner":       ErrUnauthorized,
+	"NotAdmin":       ErrUnauthorized,
+	"AlreadyOwned":   ErrAlreadyOwned,
+	"NamespaceInUse": ErrAlreadyOwned,
+}
+
+// RevertError is a call or transaction reverted by the contract, decoded with the contract ABI.
+type RevertError struct {
+	// Name is the custom error name, or "Error" for a revert string.
+	Name string
+	// Args holds the custom error arguments by name, or the revert string under "reason".
+	Args map[string]interface{}
+	// order lists the argument names as declared by the custom error; Args are sorted without it.
+	order []string
+	err   error
+}
+
+func (e *RevertError) Error() string {
+	if e.Name == "Error" {
+		return fmt.Sprintf("execution reverted: %v", e.Args["reason"])
+	}
+	names := e.order
+	if names == nil {
+		for name := range e.Args {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%v", name, e.Args[name]))
+	}
+	return fmt.Sprintf("execution reverted: %s(%s)", e.Name, strings.Join(parts, ", "))
+}
+
+// Is lets errors.Is match the sentinel error of the custom error, e.g. ErrUnauthorized.
+func (e *RevertError) Is(target error) bool {
+	kind, ok := revertKinds[e.Name]
+	return ok && kind == target
+}
+
+func (e *RevertError) Unwrap() error {
+	return e.err
+}
+
+// dataError is implemented by RPC errors carrying revert data.
+type dataError interface {
+	ErrorData() interface{}
+}
+
+// decodeRevert replaces err with a RevertError when it carries revert data the ABI can decode.
+// Other errors are returned unchanged.
+func (f *FileRegistry) decodeRevert(err error) error {
+	return decodeRevert(f.abi, err)
+}
+
+// decodeRevert decodes the revert data in err with the custom errors of contractABI.
+func decodeRevert(contractABI abi.ABI, err error) error {
+	var de dataError
+	if err == nil || !errors.As(err, &de) {
+		return err
+	}
+	raw, ok := de.ErrorData().(string)
+	if !ok {
+		return err
+	}
+	data, decodeErr := hexutil.Decode(raw)
+	if decodeErr != nil || len(data) < 4 {
+		return err
+	}
+
+	if reason, unpackErr := abi.UnpackRevert(data); unpackErr == nil {
+		return &RevertError{Name: "Error", Args: map[string]interface{}{"reason": reason}, err: err}
+	}
+	for name, abiErr := range contractABI.Errors {
+		if !bytes.Equal(abiErr.ID[:4], data[:4]) {
+			continue
+		}
+		args := make(map[string]interface{})
+		if unpackErr := abiErr.Inputs.UnpackIntoMap(args, data[4:]); unpackErr != nil {
+			return err
+		}
+		order := make([]string, len(abiErr.Inputs))
+		for i, input := range abiErr.Inputs {
+			order[i] = input.Name
+			if address, ok := args[input.Name].(common.Address); ok {
+				args[input.Name] = address.Hex()
+			}
+		}
+		return &RevertError{Name: name, Args: args, order: order, err: err}
+	}
+	return err
+}
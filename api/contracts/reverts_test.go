@@ -0,0 +1,76 @@
+package contracts_test
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// revertWith returns init code deploying a contract that reverts every call with data.
+func revertWith(data []byte) []byte {
+	size := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	// PUSH2 len PUSH2 15 PUSH1 0 CODECOPY PUSH2 len PUSH1 0 REVERT <data>
+	runtime := append([]byte{0x61, size[0], size[1], 0x61, 0x00, 0x0f, 0x60, 0x00, 0x39, 0x61, size[0], size[1], 0x60, 0x00, 0xfd}, data...)
+	rsize := binary.BigEndian.AppendUint16(nil, uint16(len(runtime)))
+	// PUSH2 len PUSH1 14 PUSH1 0 CODECOPY PUSH2 len PUSH1 0 RETURN <runtime>
+	return append([]byte{0x61, rsize[0], rsize[1], 0x60, 0x0e, 0x60, 0x00, 0x39, 0x61, rsize[0], rsize[1], 0x60, 0x00, 0xf3}, runtime...)
+}
+
+func registryABI(t *testing.T) abi.ABI {
+	file, err := os.Open("file_registry.abi")
+	require.NoError(t, err)
+	defer file.Close()
+	parsed, err := abi.JSON(file)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestSave_NotAuthorizedRevert(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	notAuthorized := registryABI(t).Errors["NotAuthorized"]
+	args, err := notAuthorized.Inputs.Pack("/test/file.txt", auth.From)
+	require.NoError(t, err)
+	address := deployCode(t, sim, auth, revertWith(append(notAuthorized.ID[:4], args...)))
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	_, err = api.Save("/test/file.txt", "QmFakeCID")
+	require.ErrorIs(t, err, contracts.ErrUnauthorized)
+	assert.NotErrorIs(t, err, contracts.ErrAlreadyOwned)
+
+	var revert *contracts.RevertError
+	require.ErrorAs(t, err, &revert)
+	assert.Equal(t, "NotAuthorized", revert.Name)
+	assert.Equal(t, "/test/file.txt", revert.Args["path"])
+	assert.Equal(t, auth.From.Hex(), revert.Args["account"])
+	assert.Equal(t, "execution reverted: NotAuthorized(path=/test/file.txt, account="+auth.From.Hex()+")", revert.Error())
+
+	var classified *contracts.Error
+	require.ErrorAs(t, err, &classified)
+	assert.Equal(t, contracts.CodeUnauthorized, classified.Code)
+	assert.Equal(t, "NotAuthorized", classified.Details["error"])
+}
+
+func TestSave_RevertString(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	// Error(string) with reason "nope".
+	data := common.FromHex("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000")
+	address := deployCode(t, sim, auth, revertWith(data))
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	_, err := api.Save("/test/file.txt", "QmFakeCID")
+	var revert *contracts.RevertError
+	require.ErrorAs(t, err, &revert)
+	assert.Equal(t, "Error", revert.Name)
+	assert.Equal(t, "nope", revert.Args["reason"])
+	assert.NotErrorIs(t, err, contracts.ErrUnauthorized)
+}
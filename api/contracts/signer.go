@@ -0,0 +1,257 @@
+package contracts
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Signer signs the transactions of one account. KeySigner holds the key in memory, loaded from a raw
+// key or an encrypted keystore; RemoteSigner asks an external signer such as Clef.
+type Signer interface {
+	Address() common.Address
+	SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// transactorSigners holds the signer function of every TransactOpts made by NewTransactor for a
+// given context, see withContext.
+var transactorSigners sync.Map // *bind.TransactOpts -> func(context.Context) bind.SignerFn
+
+// NewTransactor returns TransactOpts that sign with signer for chainID. The copies the ContractAPI
+// makes for a transaction sign within the context of the transaction.
+func NewTransactor(signer Signer, chainID *big.Int) *bind.TransactOpts {
+	from := signer.Address()
+	signerFn := func(ctx context.Context) bind.SignerFn {
+		return func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+			return signer.SignTx(ctx, tx, chainID)
+		}
+	}
+	opts := &bind.TransactOpts{From: from, Signer: signerFn(context.Background()), Context: context.Background()}
+	transactorSigners.Store(opts, signerFn)
+	return opts
+}
+
+// withContext returns a copy of opts for a transaction sent within ctx. Transactors of NewTransactor
+// sign within ctx as well, so a remote signer gives up when the request is cancelled.
+func withContext(opts *bind.TransactOpts, ctx context.Context) *bind.TransactOpts {
+	copied := *opts
+	copied.Context = ctx
+	if signerFn, ok := transactorSigners.Load(opts); ok {
+		copied.Signer = signerFn.(func(context.Context) bind.SignerFn)(ctx)
+	}
+	return &copied
+}
+
+// KeySigner signs with a private key held in memory.
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewKeySigner returns a signer for key.
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (s *KeySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *KeySigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+// LoadKeystoreSigner decrypts a go-ethereum JSON keystore key with the password stored in
+// passwordFile; a trailing newline of the file is ignored. path is a key file or a keystore
+// directory, in which address selects the key. address may be zero for a key file or a directory
+// holding a single key; otherwise it must match the key.
+func LoadKeystoreSigner(path, passwordFile string, address common.Address) (*KeySigner, error) {
+	password, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore password: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keystore: %w", err)
+	}
+	keyFile := path
+	if info.IsDir() {
+		if keyFile, err = findKeyFile(path, address); err != nil {
+			return nil, err
+		}
+	}
+
+	keyJson, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore key: %w", err)
+	}
+	key, err := keystore.DecryptKey(keyJson, strings.TrimRight(string(password), "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore key %s: %w", keyFile, err)
+	}
+	if address != (common.Address{}) && key.Address != address {
+		return nil, fmt.Errorf("keystore key %s is for %s, not %s", keyFile, key.Address.Hex(), address.Hex())
+	}
+	return NewKeySigner(key.PrivateKey), nil
+}
+
+// findKeyFile returns the key file of address in a keystore directory, or its only key file when
+// address is zero. Key files name their account in the unencrypted address field.
+func findKeyFile(dir string, address common.Address) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read keystore: %w", err)
+	}
+	var found []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read keystore: %w", err)
+		}
+		var key struct {
+			Address string `json:"address"`
+		}
+		if json.Unmarshal(raw, &key) != nil || !common.IsHexAddress(key.Address) {
+			continue
+		}
+		if address == (common.Address{}) || common.HexToAddress(key.Address) == address {
+			found = append(found, file)
+		}
+	}
+	switch {
+	case len(found) == 0 && address != (common.Address{}):
+		return "", fmt.Errorf("keystore %s has no key for %s", dir, address.Hex())
+	case len(found) == 0:
+		return "", fmt.Errorf("keystore %s has no keys", dir)
+	case len(found) > 1:
+		return "", fmt.Errorf("keystore %s has %d keys, set the signer address to choose one", dir, len(found))
+	}
+	return found[0], nil
+}
+
+// DefaultRemoteSignTimeout bounds how long RemoteSigner waits for a signature, which may need the
+// approval of an operator.
+const DefaultRemoteSignTimeout = time.Minute
+
+// RemoteSigner signs through an external signer speaking Clef's JSON-RPC API, which keeps the key
+// and may ask an operator or a rule set to approve every transaction.
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	timeout time.Duration
+}
+
+// DialRemoteSigner connects to the external signer at url, to sign as address.
+func DialRemoteSigner(ctx context.Context, url string, address common.Address) (*RemoteSigner, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to remote signer: %w", err)
+	}
+	return &RemoteSigner{client: client, address: address, timeout: DefaultRemoteSignTimeout}, nil
+}
+
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// signTransactionResult is the answer of account_signTransaction.
+type signTransactionResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// SignTx sends tx to account_signTransaction. The signed transaction is checked to be the one
+// requested and signed by the account, since the signer may be shared with other clients or change
+// the fields it is allowed to.
+func (s *RemoteSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	data := hexutil.Bytes(tx.Data())
+	args := &apitypes.SendTxArgs{
+		From:    common.NewMixedcaseAddress(s.address),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   hexutil.Big(*tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Input:   &data,
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	if tx.To() != nil {
+		to := common.NewMixedcaseAddress(*tx.To())
+		args.To = &to
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+		accessList := tx.AccessList()
+		args.AccessList = &accessList
+	default:
+		return nil, fmt.Errorf("remote signer: unsupported transaction type %d", tx.Type())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var res signTransactionResult
+	if err := s.client.CallContext(ctx, &res, "account_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	if res.Tx == nil {
+		return nil, errors.New("remote signer: empty response")
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), res.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	if sender != s.address || !sameTx(res.Tx, tx, chainID) {
+		return nil, errors.New("remote signer: signed transaction does not match the request")
+	}
+	return res.Tx, nil
+}
+
+// sameTx reports whether signed carries every field of the unsigned transaction tx, for chainID.
+func sameTx(signed, tx *types.Transaction, chainID *big.Int) bool {
+	sameTo := (signed.To() == nil) == (tx.To() == nil) && (tx.To() == nil || *signed.To() == *tx.To())
+	return sameTo &&
+		signed.Type() == tx.Type() &&
+		signed.ChainId().Cmp(chainID) == 0 &&
+		signed.Nonce() == tx.Nonce() &&
+		signed.Gas() == tx.Gas() &&
+		signed.Value().Cmp(tx.Value()) == 0 &&
+		signed.GasPrice().Cmp(tx.GasPrice()) == 0 &&
+		signed.GasFeeCap().Cmp(tx.GasFeeCap()) == 0 &&
+		signed.GasTipCap().Cmp(tx.GasTipCap()) == 0 &&
+		bytes.Equal(signed.Data(), tx.Data()) &&
+		len(signed.AccessList()) == len(tx.AccessList()) &&
+		(len(tx.AccessList()) == 0 || reflect.DeepEqual(signed.AccessList(), tx.AccessList()))
+}
+
+// Close disconnects from the external signer.
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,227 @@
+package contracts_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// clefStandIn serves account_signTransaction like Clef with every request approved. tamper, when set,
+// changes the request before it is signed, like a signer rewriting fields would; delay slows down
+// every signature.
+type clefStandIn struct {
+	key    *ecdsa.PrivateKey
+	tamper func(args *apitypes.SendTxArgs)
+	delay  time.Duration
+}
+
+type signTransactionResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+func (c *clefStandIn) SignTransaction(args apitypes.SendTxArgs) (*signTransactionResult, error) {
+	time.Sleep(c.delay)
+	if c.tamper != nil {
+		c.tamper(&args)
+	}
+	tx, err := args.ToTransaction()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID((*big.Int)(args.ChainID)), c.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &signTransactionResult{Raw: raw, Tx: signed}, nil
+}
+
+// startClefStandIn serves a stand-in external signer holding key and returns its URL.
+func startClefStandIn(t *testing.T, key *ecdsa.PrivateKey) string {
+	return serveClef(t, &clefStandIn{key: key})
+}
+
+// serveClef serves clef over HTTP and returns its URL.
+func serveClef(t *testing.T, clef *clefStandIn) string {
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("account", clef))
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	return httpServer.URL
+}
+
+// writeKeystore encrypts keys into a new keystore directory and writes its password file.
+func writeKeystore(t *testing.T, keys ...*ecdsa.PrivateKey) (string, string) {
+	dir := t.TempDir()
+	ks := keystore.NewKeyStore(filepath.Join(dir, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+	for _, key := range keys {
+		_, err := ks.ImportECDSA(key, "secret")
+		require.NoError(t, err)
+	}
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+	return filepath.Join(dir, "keystore"), passwordFile
+}
+
+func TestLoadKeystoreSigner(t *testing.T) {
+	first, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	second, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	dir, passwordFile := writeKeystore(t, first, second)
+	secondAddress := crypto.PubkeyToAddress(second.PublicKey)
+
+	signer, err := contracts.LoadKeystoreSigner(dir, passwordFile, secondAddress)
+	require.NoError(t, err)
+	assert.Equal(t, secondAddress, signer.Address())
+
+	// Two keys need an address to choose from.
+	_, err = contracts.LoadKeystoreSigner(dir, passwordFile, common.Address{})
+	assert.ErrorContains(t, err, "has 2 keys")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		signer, err := contracts.LoadKeystoreSigner(file, passwordFile, common.Address{})
+		require.NoError(t, err)
+		assert.Contains(t, []common.Address{crypto.PubkeyToAddress(first.PublicKey), secondAddress}, signer.Address())
+	}
+
+	wrongPassword := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(wrongPassword, []byte("guess"), 0o600))
+	_, err = contracts.LoadKeystoreSigner(dir, wrongPassword, secondAddress)
+	assert.ErrorIs(t, err, keystore.ErrDecrypt)
+}
+
+func TestRemoteSigner_Save(t *testing.T) {
+	sim, funded := newSimulatedBackend(t)
+	address := deployCode(t, sim, funded, acceptingContract)
+
+	// The API account is held by the external signer only.
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sendValue(t, sim, funded, from, big.NewInt(1e18))
+
+	signer, err := contracts.DialRemoteSigner(context.Background(), startClefStandIn(t, key), from)
+	require.NoError(t, err)
+	defer signer.Close()
+	api := newSimulatedAPI(t, sim, contracts.NewTransactor(signer, simulatedChainID), address)
+
+	txHash, err := api.Save("/test/file.txt", "QmFakeCID")
+	require.NoError(t, err)
+	sim.Commit()
+
+	tx, _, err := sim.Client().TransactionByHash(context.Background(), common.HexToHash(txHash))
+	require.NoError(t, err)
+	sender, err := types.Sender(types.LatestSignerForChainID(simulatedChainID), tx)
+	require.NoError(t, err)
+	assert.Equal(t, from, sender)
+}
+
+func TestRemoteSigner_WrongAccount(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	signer, err := contracts.DialRemoteSigner(context.Background(), startClefStandIn(t, other), crypto.PubkeyToAddress(key.PublicKey))
+	require.NoError(t, err)
+	defer signer.Close()
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	tx := types.NewTx(&types.DynamicFeeTx{ChainID: simulatedChainID, To: &to, Gas: 21000, GasFeeCap: big.NewInt(1e9), GasTipCap: big.NewInt(1), Value: big.NewInt(0)})
+	_, err = signer.SignTx(context.Background(), tx, simulatedChainID)
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestRemoteSigner_TamperedTx(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	dynamic := types.NewTx(&types.DynamicFeeTx{ChainID: simulatedChainID, To: &to, Gas: 21000, GasFeeCap: big.NewInt(1e9), GasTipCap: big.NewInt(1), Value: big.NewInt(0)})
+	legacy := types.NewTx(&types.LegacyTx{To: &to, Gas: 21000, GasPrice: big.NewInt(1e9), Value: big.NewInt(0)})
+
+	cases := map[string]struct {
+		tx     *types.Transaction
+		tamper func(args *apitypes.SendTxArgs)
+	}{
+		"value":     {dynamic, func(args *apitypes.SendTxArgs) { args.Value = hexutil.Big(*big.NewInt(1e18)) }},
+		"fee cap":   {dynamic, func(args *apitypes.SendTxArgs) { args.MaxFeePerGas = (*hexutil.Big)(big.NewInt(1e12)) }},
+		"tip":       {dynamic, func(args *apitypes.SendTxArgs) { args.MaxPriorityFeePerGas = (*hexutil.Big)(big.NewInt(1e9)) }},
+		"chain ID":  {dynamic, func(args *apitypes.SendTxArgs) { args.ChainID = (*hexutil.Big)(big.NewInt(1)) }},
+		"gas price": {legacy, func(args *apitypes.SendTxArgs) { args.GasPrice = (*hexutil.Big)(big.NewInt(1e12)) }},
+		"to": {dynamic, func(args *apitypes.SendTxArgs) {
+			other := common.NewMixedcaseAddress(common.HexToAddress("0x0000000000000000000000000000000000000043"))
+			args.To = &other
+		}},
+		"data": {dynamic, func(args *apitypes.SendTxArgs) {
+			data := hexutil.Bytes{0xde, 0xad}
+			args.Input = &data
+		}},
+	}
+	for name, tc := range cases {
+		signer, err := contracts.DialRemoteSigner(context.Background(), serveClef(t, &clefStandIn{key: key, tamper: tc.tamper}), crypto.PubkeyToAddress(key.PublicKey))
+		require.NoError(t, err)
+		_, err = signer.SignTx(context.Background(), tc.tx, simulatedChainID)
+		want := "does not match"
+		if name == "chain ID" {
+			// A signature for another chain does not even recover to an account of this chain.
+			want = "invalid chain id"
+		}
+		assert.ErrorContains(t, err, want, name)
+		signer.Close()
+	}
+}
+
+// TestRemoteSigner_RequestContext tests that signing a transaction gives up with the context of the call.
+func TestRemoteSigner_RequestContext(t *testing.T) {
+	sim, funded := newSimulatedBackend(t)
+	address := deployCode(t, sim, funded, acceptingContract)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	sendValue(t, sim, funded, from, big.NewInt(1e18))
+
+	clef := &clefStandIn{key: key}
+	signer, err := contracts.DialRemoteSigner(context.Background(), serveClef(t, clef), from)
+	require.NoError(t, err)
+	defer signer.Close()
+	api := newSimulatedAPI(t, sim, contracts.NewTransactor(signer, simulatedChainID), address)
+
+	txHash, err := api.Save("/test/file.txt", "QmFakeCID")
+	require.NoError(t, err)
+
+	clef.delay = 500 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = api.SpeedUp(ctx, txHash)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+}
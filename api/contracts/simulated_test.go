@@ -0,0 +1,154 @@
+package contracts_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// simulatedChainID is the chain ID used by go-ethereum's simulated backend.
+var simulatedChainID = big.NewInt(1337)
+
+// acceptingContract is init code deploying a contract whose runtime code is STOP, so every call to it
+// succeeds whatever the calldata.
+var acceptingContract = common.FromHex("0x6001600c60003960016000f300")
+
+// revertingContract is init code deploying a contract whose runtime code is PUSH1 0 PUSH1 0 REVERT,
+// so every call to it reverts.
+var revertingContract = common.FromHex("0x6005600c60003960056000f360006000fd")
+
+// newSimulatedBackend starts a simulated chain with a funded account and returns a transactor for it.
+func newSimulatedBackend(t *testing.T) (*simulated.Backend, *bind.TransactOpts) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, simulatedChainID)
+	require.NoError(t, err)
+
+	balance := new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+	sim := simulated.NewBackend(types.GenesisAlloc{auth.From: {Balance: balance}})
+	t.Cleanup(func() { sim.Close() })
+	return sim, auth
+}
+
+// newSimulatedAPI builds a ContractAPI on the simulated backend. The ABI is loaded relative to the
+// api directory, like in the running service.
+func newSimulatedAPI(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, address common.Address) *contracts.ContractAPI {
+	return newBackendAPI(t, sim.Client(), auth, address)
+}
+
+// newBackendAPI is newSimulatedAPI on any backend, e.g. one wrapping the simulated client.
+func newBackendAPI(t *testing.T, backend contracts.Backend, auth *bind.TransactOpts, address common.Address) *contracts.ContractAPI {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	api, err := contracts.NewContractAPIWithBackend(backend, address, auth)
+	require.NoError(t, err)
+	return api
+}
+
+// sendTx signs and sends a legacy transaction from the transactor account.
+func sendTx(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, to *common.Address, gas uint64, data []byte) *types.Transaction {
+	ctx := context.Background()
+	client := sim.Client()
+	nonce, err := client.PendingNonceAt(ctx, auth.From)
+	require.NoError(t, err)
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	require.NoError(t, err)
+
+	tx := types.NewTx(&types.LegacyTx{Nonce: nonce, To: to, Value: big.NewInt(0), Gas: gas, GasPrice: gasPrice, Data: data})
+	signed, err := auth.Signer(auth.From, tx)
+	require.NoError(t, err)
+	require.NoError(t, client.SendTransaction(ctx, signed))
+	return signed
+}
+
+// sendValue transfers value from the transactor account to another account and mines it.
+func sendValue(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, to common.Address, value *big.Int) {
+	ctx := context.Background()
+	nonce, err := sim.Client().PendingNonceAt(ctx, auth.From)
+	require.NoError(t, err)
+	gasPrice, err := sim.Client().SuggestGasPrice(ctx)
+	require.NoError(t, err)
+
+	tx := types.NewTx(&types.LegacyTx{Nonce: nonce, To: &to, Value: value, Gas: 21000, GasPrice: gasPrice})
+	signed, err := auth.Signer(auth.From, tx)
+	require.NoError(t, err)
+	require.NoError(t, sim.Client().SendTransaction(ctx, signed))
+	sim.Commit()
+}
+
+// deployCode deploys raw init code and mines it.
+func deployCode(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, code []byte) common.Address {
+	tx := sendTx(t, sim, auth, nil, 1_000_000, code)
+	sim.Commit()
+	address := crypto.CreateAddress(auth.From, tx.Nonce())
+	deployed, err := sim.Client().CodeAt(context.Background(), address, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, deployed)
+	return address
+}
+
+// deployArtifact deploys a contract compiled by Hardhat with address constructor arguments and mines it.
+// The contracts are compiled by `npx hardhat compile` in hardhat/, which tests.unit.sh runs. Without
+// them the test is skipped, or fails when CONTRACT_ARTIFACTS_REQUIRED is set, as tests.unit.sh does.
+func deployArtifact(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, name string, args ...common.Address) common.Address {
+	_, file, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(file), "..", "..", "hardhat", "artifacts", "contracts", name+".sol", name+".json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if os.Getenv("CONTRACT_ARTIFACTS_REQUIRED") != "" {
+			t.Fatalf("%s is not compiled: run npx hardhat compile in hardhat/", name)
+		}
+		t.Skipf("%s is not compiled: run npx hardhat compile in hardhat/", name)
+	}
+	require.NoError(t, err)
+	var artifact struct {
+		Bytecode string `json:"bytecode"`
+	}
+	require.NoError(t, json.Unmarshal(data, &artifact))
+
+	code := common.FromHex(artifact.Bytecode)
+	for _, arg := range args {
+		code = append(code, common.LeftPadBytes(arg.Bytes(), 32)...)
+	}
+	tx := sendTx(t, sim, auth, nil, 5_000_000, code)
+	sim.Commit()
+	receipt, err := sim.Client().TransactionReceipt(context.Background(), tx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status, "deploying %s failed", name)
+	return receipt.ContractAddress
+}
+
+// signTransfer signs a zero value transfer with the given nonce without sending it.
+func signTransfer(t *testing.T, sim *simulated.Backend, auth *bind.TransactOpts, nonce uint64) *types.Transaction {
+	gasPrice, err := sim.Client().SuggestGasPrice(context.Background())
+	require.NoError(t, err)
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	tx := types.NewTx(&types.LegacyTx{Nonce: nonce, To: &to, Value: big.NewInt(0), Gas: 21000, GasPrice: gasPrice})
+	signed, err := auth.Signer(auth.From, tx)
+	require.NoError(t, err)
+	return signed
+}
+
+// callMsg builds a call from one account to a contract with the given calldata.
+func callMsg(from, to common.Address, data []byte) ethereum.CallMsg {
+	return ethereum.CallMsg{From: from, To: &to, Data: data}
+}
@@ -0,0 +1,571 @@
+package contracts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// ErrTxNotTracked is returned when replacing a transaction that was not sent by this API or is no longer pending.
+	ErrTxNotTracked = errors.New("transaction is not a pending transaction sent by this API")
+	// ErrTxAlreadyMined is returned when replacing a transaction that was mined in the meantime.
+	ErrTxAlreadyMined = errors.New("transaction is already mined")
+	// ErrNonceUsed is returned by Rebroadcast when a transaction with the same account and nonce was mined
+	// that is neither the rebroadcast transaction nor a replacement of it the tracker knows.
+	ErrNonceUsed = errors.New("nonce of the transaction is already used")
+)
+
+// minBumpPercent is the smallest fee increase nodes accept for a replacement transaction.
+const minBumpPercent = 10
+
+// replacementRetention is how long the replacements of a mined nonce stay known, so that the status of
+// the original hash keeps resolving to the replacement that got mined.
+const replacementRetention = time.Hour
+
+// findMinedBlocks is the number of blocks FindMined reads per FilterLogs call.
+const findMinedBlocks = 2000
+
+// ReplacementPolicy controls when the TxTracker rebroadcasts stuck transactions.
+type ReplacementPolicy struct {
+	// StuckAfter is how long a transaction may stay pending before it is sped up. Zero disables automatic speed-ups.
+	StuckAfter time.Duration
+	// BumpPercent is the fee increase applied to every replacement, at least 10.
+	BumpPercent int64
+	// MaxBumps limits the automatic speed-ups of a single transaction.
+	MaxBumps int
+}
+
+// DefaultReplacementPolicy speeds up transactions pending for three minutes by 20%, at most five times.
+func DefaultReplacementPolicy() ReplacementPolicy {
+	return ReplacementPolicy{
+		StuckAfter:  3 * time.Minute,
+		BumpPercent: 20,
+		MaxBumps:    5,
+	}
+}
+
+// txKey identifies a pending nonce of one account of the signer pool.
+type txKey struct {
+	from  common.Address
+	nonce uint64
+}
+
+// trackedTx is a pending nonce of a signer together with every transaction broadcast for it.
+type trackedTx struct {
+	account *poolAccount
+	tx      *types.Transaction // latest broadcast transaction
+	hashes  []common.Hash      // all broadcast transactions, oldest first
+	sentAt  time.Time
+	bumps   int
+	missing int // consecutive checks where the node knew none of the hashes
+}
+
+// replacement links a transaction to the one broadcast in its place. finished is set once the nonce
+// is no longer tracked, and the link is forgotten replacementRetention later.
+type replacement struct {
+	hash     common.Hash
+	finished time.Time
+}
+
+// TxTracker watches the transactions sent by every account of a ContractAPI until they are mined, rebroadcasting them
+// with bumped fees when they are stuck, and replaces them on demand.
+type TxTracker struct {
+	api    *ContractAPI
+	policy ReplacementPolicy
+
+	mu         sync.Mutex
+	byNonce    map[txKey]*trackedTx
+	byHash     map[common.Hash]txKey
+	replacedBy map[common.Hash]*replacement // kept after mining so old hashes still resolve
+	cancels    map[common.Hash]bool         // replacements that cancel the original call
+}
+
+func newTxTracker(api *ContractAPI) *TxTracker {
+	return &TxTracker{
+		api:        api,
+		policy:     DefaultReplacementPolicy(),
+		byNonce:    make(map[txKey]*trackedTx),
+		byHash:     make(map[common.Hash]txKey),
+		replacedBy: make(map[common.Hash]*replacement),
+		cancels:    make(map[common.Hash]bool),
+	}
+}
+
+// track starts watching a transaction account just sent.
+func (t *TxTracker) track(account *poolAccount, tx *types.Transaction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := txKey{from: account.auth.From, nonce: tx.Nonce()}
+	t.byNonce[key] = &trackedTx{account: account, tx: tx, hashes: []common.Hash{tx.Hash()}, sentAt: time.Now()}
+	t.byHash[tx.Hash()] = key
+}
+
+// retrack is track for a transaction sent again, which keeps the transactions already watched for its nonce.
+func (t *TxTracker) retrack(account *poolAccount, tx *types.Transaction) {
+	t.mu.Lock()
+	_, ok := t.byNonce[txKey{from: account.auth.From, nonce: tx.Nonce()}]
+	t.mu.Unlock()
+	if !ok {
+		t.track(account, tx)
+	}
+}
+
+// related returns every hash broadcast for the same nonce as hash, oldest first. Unknown hashes are
+// returned alone, followed by the replacement recorded for them, if any.
+func (t *TxTracker) related(hash common.Hash) []common.Hash {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if key, ok := t.byHash[hash]; ok {
+		return append([]common.Hash(nil), t.byNonce[key].hashes...)
+	}
+	hashes := []common.Hash{hash}
+	for next, ok := t.replacedBy[hash]; ok; next, ok = t.replacedBy[next.hash] {
+		hashes = append(hashes, next.hash)
+	}
+	return hashes
+}
+
+// isCancel reports whether hash is a cancel replacement, or a speed-up of one.
+func (t *TxTracker) isCancel(hash common.Hash) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.cancels[hash]
+}
+
+// Pending returns the number of transactions that are not mined yet.
+func (t *TxTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.byNonce)
+}
+
+// Run checks the tracked transactions every interval until ctx is done.
+func (t *TxTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Check(ctx)
+		}
+	}
+}
+
+// Check makes a single pass over the tracked transactions: mined ones are released, dropped ones
+// give their nonce back and stuck ones are sped up. Replacement links of nonces finished longer than
+// replacementRetention ago are forgotten.
+func (t *TxTracker) Check(ctx context.Context) {
+	t.mu.Lock()
+	keys := make([]txKey, 0, len(t.byNonce))
+	for key := range t.byNonce {
+		keys = append(keys, key)
+	}
+	for hash, next := range t.replacedBy {
+		if !next.finished.IsZero() && time.Since(next.finished) >= replacementRetention {
+			delete(t.replacedBy, hash)
+			delete(t.cancels, next.hash)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range keys {
+		if err := t.check(ctx, key); err != nil {
+			log.Printf("tx tracker: %s nonce %d: %v", key.from.Hex(), key.nonce, err)
+		}
+	}
+}
+
+func (t *TxTracker) check(ctx context.Context, key txKey) error {
+	t.mu.Lock()
+	tracked, ok := t.byNonce[key]
+	if !ok {
+		t.mu.Unlock()
+		return nil
+	}
+	hashes := append([]common.Hash(nil), tracked.hashes...)
+	t.mu.Unlock()
+
+	for _, hash := range hashes {
+		_, err := t.api.client.TransactionReceipt(ctx, hash)
+		if err == nil {
+			t.finish(key)
+			tracked.account.nonces.Mined(key.nonce)
+			return nil
+		}
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get receipt: %w", err)
+		}
+	}
+
+	known := false
+	for _, hash := range hashes {
+		if _, _, err := t.api.client.TransactionByHash(ctx, hash); err == nil {
+			known = true
+			break
+		}
+	}
+
+	t.mu.Lock()
+	if known {
+		tracked.missing = 0
+	} else {
+		tracked.missing++
+	}
+	// A single miss may be a lagging node behind a load balancer, two in a row mean the pool dropped it.
+	dropped := tracked.missing >= 2
+	stuck := known && t.policy.StuckAfter > 0 && time.Since(tracked.sentAt) >= t.policy.StuckAfter && tracked.bumps < t.policy.MaxBumps
+	t.mu.Unlock()
+
+	if dropped {
+		t.finish(key)
+		tracked.account.nonces.Dropped(key.nonce)
+		return nil
+	}
+	if stuck {
+		_, err := t.replace(ctx, hashes[len(hashes)-1], false)
+		return err
+	}
+	return nil
+}
+
+// finish stops tracking a nonce, keeping its replacement links.
+func (t *TxTracker) finish(key txKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked, ok := t.byNonce[key]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, hash := range tracked.hashes {
+		delete(t.byHash, hash)
+		if next, ok := t.replacedBy[hash]; ok {
+			next.finished = now
+		}
+	}
+	delete(t.byNonce, key)
+}
+
+// replace rebroadcasts the pending transaction behind hash at the same nonce with bumped fees. A
+// speed-up resends the same call; a cancel sends an empty transfer to the signer itself.
+func (t *TxTracker) replace(ctx context.Context, hash common.Hash, cancel bool) (common.Hash, error) {
+	t.mu.Lock()
+	key, ok := t.byHash[hash]
+	if !ok {
+		t.mu.Unlock()
+		return common.Hash{}, ErrTxNotTracked
+	}
+	tracked := t.byNonce[key]
+	old, auth, hashes := tracked.tx, tracked.account.auth, append([]common.Hash(nil), tracked.hashes...)
+	t.mu.Unlock()
+
+	nonce := key.nonce
+	to, value, data, gas := old.To(), old.Value(), old.Data(), old.Gas()
+	if cancel {
+		self := auth.From
+		to, value, data, gas = &self, new(big.Int), nil, 21000
+	}
+
+	var inner types.TxData
+	if old.Type() == types.LegacyTxType {
+		gasPrice, err := t.bumpedGasPrice(ctx, old)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		inner = &types.LegacyTx{Nonce: nonce, GasPrice: gasPrice, Gas: gas, To: to, Value: value, Data: data}
+	} else {
+		tip, feeCap, err := t.bumpedDynamicFees(ctx, old)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		inner = &types.DynamicFeeTx{ChainID: old.ChainId(), Nonce: nonce, GasTipCap: tip, GasFeeCap: feeCap, Gas: gas, To: to, Value: value, Data: data}
+	}
+
+	signed, err := withContext(auth, ctx).Signer(auth.From, types.NewTx(inner))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to sign replacement: %w", err)
+	}
+	// "already known" means this very replacement is in the pool already, so it counts as sent.
+	if err := t.api.client.SendTransaction(ctx, signed); err != nil && !strings.Contains(strings.ToLower(err.Error()), "already known") {
+		if strings.Contains(strings.ToLower(err.Error()), "nonce too low") && t.mined(ctx, tracked, key, hashes) {
+			return common.Hash{}, ErrTxAlreadyMined
+		}
+		return common.Hash{}, fmt.Errorf("failed to send replacement: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tracked, ok := t.byNonce[key]; ok {
+		if cancel || t.cancels[tracked.tx.Hash()] {
+			t.cancels[signed.Hash()] = true
+		}
+		t.replacedBy[tracked.tx.Hash()] = &replacement{hash: signed.Hash()}
+		tracked.tx = signed
+		tracked.hashes = append(tracked.hashes, signed.Hash())
+		tracked.sentAt = time.Now()
+		tracked.bumps++
+		t.byHash[signed.Hash()] = key
+	}
+	return signed.Hash(), nil
+}
+
+// mined reports whether one of the transactions broadcast for a nonce has a receipt, releasing the
+// nonce when it does. A nonce used by a transaction sent behind the tracker's back is not mined.
+func (t *TxTracker) mined(ctx context.Context, tracked *trackedTx, key txKey, hashes []common.Hash) bool {
+	for _, hash := range hashes {
+		if _, err := t.api.client.TransactionReceipt(ctx, hash); err == nil {
+			t.finish(key)
+			tracked.account.nonces.Mined(key.nonce)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TxTracker) bumpPercent() int64 {
+	if t.policy.BumpPercent < minBumpPercent {
+		return minBumpPercent
+	}
+	return t.policy.BumpPercent
+}
+
+func (t *TxTracker) bumpedGasPrice(ctx context.Context, old *types.Transaction) (*big.Int, error) {
+	suggested, err := t.api.client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest gas price: %w", err)
+	}
+	bumped := bump(old.GasPrice(), t.bumpPercent())
+	return capFee(maxBig(bumped, scale(suggested, t.api.fees.TipMultiplier)), bumped, t.api.fees.MaxFeePerGas)
+}
+
+func (t *TxTracker) bumpedDynamicFees(ctx context.Context, old *types.Transaction) (*big.Int, *big.Int, error) {
+	head, err := t.api.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	suggested, err := t.api.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to suggest gas tip: %w", err)
+	}
+
+	tip := maxBig(bump(old.GasTipCap(), t.bumpPercent()), scale(suggested, t.api.fees.TipMultiplier))
+	bumped := bump(old.GasFeeCap(), t.bumpPercent())
+	wanted := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	feeCap, err := capFee(maxBig(bumped, wanted), bumped, t.api.fees.MaxFeePerGas)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+	return tip, feeCap, nil
+}
+
+// capFee limits fee to max, failing when even the minimum required bump is above it.
+func capFee(fee, minimum, max *big.Int) (*big.Int, error) {
+	if max == nil || fee.Cmp(max) <= 0 {
+		return fee, nil
+	}
+	if minimum.Cmp(max) > 0 {
+		return nil, fmt.Errorf("%w: replacement needs %s wei, max %s wei", ErrFeeCapExceeded, minimum, max)
+	}
+	return new(big.Int).Set(max), nil
+}
+
+// bump increases amount by percent, rounding up.
+func bump(amount *big.Int, percent int64) *big.Int {
+	bumped := new(big.Int).Mul(amount, big.NewInt(100+percent))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// Tracker returns the tracker watching the transactions sent by the API.
+func (api *ContractAPI) Tracker() *TxTracker {
+	return api.tracker
+}
+
+// SetReplacementPolicy replaces the policy used for automatic speed-ups.
+// It must be called before the tracker runs.
+func (api *ContractAPI) SetReplacementPolicy(policy ReplacementPolicy) {
+	api.tracker.policy = policy
+}
+
+// SpeedUp replaces a pending transaction with the same call at the same nonce and higher fees,
+// returning the hash of the replacement.
+func (api *ContractAPI) SpeedUp(ctx context.Context, txHash string) (string, error) {
+	hash, err := api.tracker.replace(ctx, api.latestHash(txHash), false)
+	if err != nil {
+		return "", err
+	}
+	return hash.Hex(), nil
+}
+
+// Rebroadcast sends a transaction signed earlier by an account of the pool again, e.g. one the node
+// dropped from its mempool or one recorded by SaveRecorded before a restart, and tracks it until it is
+// mined. When its nonce is already mined by the transaction itself or by a replacement the tracker
+// knows, nothing is sent and its hash is returned, so that TxStatus and WaitMined report the mined one.
+// It returns ErrNonceUsed when the account mined another transaction with its nonce.
+func (api *ContractAPI) Rebroadcast(ctx context.Context, rawTx []byte) (string, error) {
+	tx, from, err := decodeSigned(rawTx)
+	if err != nil {
+		return "", api.translate(err)
+	}
+	mined, err := api.client.NonceAt(ctx, from, nil)
+	if err != nil {
+		return "", api.translate(fmt.Errorf("failed to get nonce: %w", err))
+	}
+	if mined > tx.Nonce() {
+		for _, hash := range api.tracker.related(tx.Hash()) {
+			_, err := api.client.TransactionReceipt(ctx, hash)
+			if err == nil {
+				return tx.Hash().Hex(), nil
+			}
+			if !isNotFound(err) {
+				return "", api.translate(fmt.Errorf("failed to get transaction receipt: %w", err))
+			}
+		}
+		return "", api.translate(ErrNonceUsed)
+	}
+	if err := api.client.SendTransaction(ctx, tx); err != nil && !containsAny(err, []string{"already known"}) {
+		return "", api.translate(err)
+	}
+	for _, account := range api.accounts {
+		if account.auth.From == from {
+			api.tracker.retrack(account, tx)
+		}
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// FindMined looks among the saves of the registry since the given time for the transaction that used
+// the nonce of a transaction signed earlier, for when Rebroadcast returns ErrNonceUsed because the
+// links to its replacements were lost, e.g. in a restart. It returns the status of the original hash,
+// with ReplacedBy set to the mined transaction when it is another one, or ErrTxNotFound when no save
+// used the nonce, e.g. because it was cancelled.
+func (api *ContractAPI) FindMined(ctx context.Context, rawTx []byte, since time.Time) (*TxStatus, error) {
+	tx, from, err := decodeSigned(rawTx)
+	if err != nil {
+		return nil, api.translate(err)
+	}
+	head, err := api.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, api.translate(fmt.Errorf("failed to get latest block: %w", err))
+	}
+	for end := head.Number.Uint64(); ; {
+		start := end - min(end, findMinedBlocks-1)
+		hash, err := api.findNonce(ctx, from, tx.Nonce(), start, end)
+		if err != nil {
+			return nil, api.translate(err)
+		}
+		if hash != (common.Hash{}) {
+			receipt, err := api.client.TransactionReceipt(ctx, hash)
+			if err != nil {
+				return nil, api.translate(fmt.Errorf("failed to get transaction receipt: %w", err))
+			}
+			status, err := api.receiptStatus(ctx, receipt)
+			if err != nil {
+				return nil, api.translate(err)
+			}
+			if hash != tx.Hash() {
+				status.Hash, status.ReplacedBy = tx.Hash().Hex(), hash.Hex()
+			}
+			return status, nil
+		}
+		if start == 0 {
+			return nil, ErrTxNotFound
+		}
+		header, err := api.client.HeaderByNumber(ctx, new(big.Int).SetUint64(start))
+		if err != nil {
+			return nil, api.translate(fmt.Errorf("failed to get block %d: %w", start, err))
+		}
+		if time.Unix(int64(header.Time), 0).Before(since) {
+			return nil, ErrTxNotFound
+		}
+		end = start - 1
+	}
+}
+
+// findNonce returns the hash of the transaction of the given account and nonce that emitted a FileSaved
+// log in the blocks start..end, or the zero hash when there is none.
+func (api *ContractAPI) findNonce(ctx context.Context, from common.Address, nonce, start, end uint64) (common.Hash, error) {
+	logs, err := api.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(start),
+		ToBlock:   new(big.Int).SetUint64(end),
+		Addresses: []common.Address{api.instance.address},
+		Topics:    [][]common.Hash{{api.instance.FileSavedTopic()}},
+	})
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to filter logs: %w", err)
+	}
+	seen := make(map[common.Hash]bool)
+	for _, l := range logs {
+		if seen[l.TxHash] {
+			continue
+		}
+		seen[l.TxHash] = true
+		mined, _, err := api.client.TransactionByHash(ctx, l.TxHash)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to get transaction: %w", err)
+		}
+		if mined.Nonce() != nonce {
+			continue
+		}
+		if sender, err := types.Sender(types.LatestSignerForChainID(mined.ChainId()), mined); err == nil && sender == from {
+			return l.TxHash, nil
+		}
+	}
+	return common.Hash{}, nil
+}
+
+// decodeSigned decodes a signed transaction and recovers its sender.
+func decodeSigned(rawTx []byte) (*types.Transaction, common.Address, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, common.Address{}, fmt.Errorf("invalid transaction: %w", err)
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("invalid transaction: %w", err)
+	}
+	return tx, from, nil
+}
+
+// Cancel replaces a pending transaction with an empty transfer to the signer at the same nonce,
+// returning the hash of the replacement.
+func (api *ContractAPI) Cancel(ctx context.Context, txHash string) (string, error) {
+	hash, err := api.tracker.replace(ctx, api.latestHash(txHash), true)
+	if err != nil {
+		return "", err
+	}
+	return hash.Hex(), nil
+}
+
+// latestHash resolves a transaction hash to the latest replacement broadcast for it.
+func (api *ContractAPI) latestHash(txHash string) common.Hash {
+	hashes := api.tracker.related(common.HexToHash(txHash))
+	return hashes[len(hashes)-1]
+}
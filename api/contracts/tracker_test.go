@@ -0,0 +1,228 @@
+package contracts_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+func TestSpeedUp_ReplacesPendingSave(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	ctx := context.Background()
+
+	original := savedTx(t, api, sim.Client())
+	replacement, err := api.SpeedUp(ctx, original.Hash().Hex())
+	require.NoError(t, err)
+
+	tx, _, err := sim.Client().TransactionByHash(ctx, common.HexToHash(replacement))
+	require.NoError(t, err)
+	assert.Equal(t, original.Nonce(), tx.Nonce())
+	assert.Equal(t, original.Data(), tx.Data())
+	assert.Equal(t, original.To(), tx.To())
+	assert.True(t, tx.GasTipCap().Cmp(original.GasTipCap()) > 0)
+	assert.True(t, tx.GasFeeCap().Cmp(original.GasFeeCap()) > 0)
+
+	status, err := api.TxStatus(ctx, original.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxPending, status.Status)
+	assert.Equal(t, replacement, status.ReplacedBy)
+
+	sim.Commit()
+	status, err = api.TxStatus(ctx, original.Hash().Hex())
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+	assert.Equal(t, replacement, status.ReplacedBy)
+
+	api.Tracker().Check(ctx)
+	assert.Equal(t, 0, api.Tracker().Pending())
+
+	_, err = api.SpeedUp(ctx, original.Hash().Hex())
+	assert.ErrorIs(t, err, contracts.ErrTxNotTracked)
+}
+
+func TestCancel_SendsEmptyTransferToSelf(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{Mode: contracts.FeeModeLegacy})
+	ctx := context.Background()
+
+	original := savedTx(t, api, sim.Client())
+	replacement, err := api.Cancel(ctx, original.Hash().Hex())
+	require.NoError(t, err)
+
+	tx, _, err := sim.Client().TransactionByHash(ctx, common.HexToHash(replacement))
+	require.NoError(t, err)
+	assert.Equal(t, original.Nonce(), tx.Nonce())
+	assert.Equal(t, auth.From, *tx.To())
+	assert.Empty(t, tx.Data())
+	assert.Equal(t, uint64(21000), tx.Gas())
+	assert.Equal(t, 0, tx.Value().Sign())
+	minimum := new(big.Int).Div(new(big.Int).Mul(original.GasPrice(), big.NewInt(110)), big.NewInt(100))
+	assert.True(t, tx.GasPrice().Cmp(minimum) >= 0)
+
+	sim.Commit()
+	status, err := api.WaitMined(ctx, original.Hash().Hex(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxCancelled, status.Status)
+	assert.Equal(t, replacement, status.ReplacedBy)
+	assert.Equal(t, uint64(21000), status.GasUsed)
+
+	// The cancel itself was mined like any other transaction.
+	status, err = api.TxStatus(ctx, replacement)
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+}
+
+func TestTracker_SpeedsUpStuckTransactions(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetReplacementPolicy(contracts.ReplacementPolicy{BumpPercent: 10, MaxBumps: 1, StuckAfter: 1})
+	ctx := context.Background()
+
+	original := savedTx(t, api, sim.Client())
+	api.Tracker().Check(ctx)
+	api.Tracker().Check(ctx) // MaxBumps stops a second replacement
+
+	status, err := api.TxStatus(ctx, original.Hash().Hex())
+	require.NoError(t, err)
+	require.NotEmpty(t, status.ReplacedBy)
+	replaced, _, err := sim.Client().TransactionByHash(ctx, common.HexToHash(status.ReplacedBy))
+	require.NoError(t, err)
+	assert.Equal(t, original.Nonce(), replaced.Nonce())
+
+	sim.Commit()
+	api.Tracker().Check(ctx)
+	assert.Equal(t, 0, api.Tracker().Pending())
+}
+
+func TestSpeedUp_AlreadyMined(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+
+	original := savedTx(t, api, sim.Client())
+	sim.Commit()
+
+	_, err := api.SpeedUp(context.Background(), original.Hash().Hex())
+	assert.ErrorIs(t, err, contracts.ErrTxAlreadyMined)
+}
+
+func TestSpeedUp_UnderpricedIsNotMined(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{Mode: contracts.FeeModeLegacy})
+	ctx := context.Background()
+
+	// Another process replaced the save with a much higher fee, so a 20% bump is underpriced.
+	original := savedTx(t, api, sim.Client())
+	to := *original.To()
+	replaced := types.NewTx(&types.LegacyTx{Nonce: original.Nonce(), To: &to, Gas: original.Gas(), GasPrice: new(big.Int).Mul(original.GasPrice(), big.NewInt(10)), Data: original.Data()})
+	signed, err := auth.Signer(auth.From, replaced)
+	require.NoError(t, err)
+	require.NoError(t, sim.Client().SendTransaction(ctx, signed))
+
+	_, err = api.SpeedUp(ctx, original.Hash().Hex())
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, contracts.ErrTxAlreadyMined)
+	assert.Equal(t, 1, api.Tracker().Pending())
+}
+
+func TestRebroadcast_RecordedSave(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	address := deployCode(t, sim, auth, acceptingContract)
+	api := newSimulatedAPI(t, sim, auth, address)
+	ctx := context.Background()
+
+	// A failing record keeps the transaction from being sent.
+	_, err := api.SaveRecorded(ctx, "/test/file.txt", "QmFakeCID", func(tx *types.Transaction) error {
+		return errors.New("disk full")
+	})
+	require.Error(t, err)
+	assert.Equal(t, 0, api.Tracker().Pending())
+
+	var recorded *types.Transaction
+	hash, err := api.SaveRecorded(ctx, "/test/file.txt", "QmFakeCID", func(tx *types.Transaction) error {
+		recorded = tx
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, recorded)
+	assert.Equal(t, recorded.Hash().Hex(), hash)
+	assert.Equal(t, uint64(1), recorded.Nonce(), "the nonce of the unsent transaction is used")
+
+	// Sending a pending transaction again is harmless.
+	rawTx, err := recorded.MarshalBinary()
+	require.NoError(t, err)
+	again, err := api.Rebroadcast(ctx, rawTx)
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	// Once mined, nothing is sent and the hash still resolves to the mined transaction.
+	sim.Commit()
+	again, err = api.Rebroadcast(ctx, rawTx)
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+	status, err := api.TxStatus(ctx, again)
+	require.NoError(t, err)
+	assert.Equal(t, contracts.TxMined, status.Status)
+}
+
+func TestRebroadcast_NonceUsed(t *testing.T) {
+	sim, auth := newSimulatedBackend(t)
+	// The runtime code emits a log with the FileSaved topic: PUSH32 topic PUSH1 0 PUSH1 0 LOG1 STOP.
+	topic := crypto.Keccak256Hash([]byte("FileSaved(string,string)"))
+	runtime := append(append([]byte{0x7f}, topic.Bytes()...), 0x60, 0x00, 0x60, 0x00, 0xa1, 0x00)
+	address := deployCode(t, sim, auth, append(common.FromHex("0x6027600c60003960276000f3"), runtime...))
+	api := newSimulatedAPI(t, sim, auth, address)
+	api.SetFeePolicy(contracts.FeePolicy{Mode: contracts.FeeModeLegacy})
+	ctx := context.Background()
+
+	original := savedTx(t, api, sim.Client())
+	rawTx, err := original.MarshalBinary()
+	require.NoError(t, err)
+
+	// Another process replaced the save, so the tracker knows nothing of the mined transaction.
+	to := *original.To()
+	replaced := types.NewTx(&types.LegacyTx{Nonce: original.Nonce(), To: &to, Gas: original.Gas(), GasPrice: new(big.Int).Mul(original.GasPrice(), big.NewInt(10)), Data: original.Data()})
+	signed, err := auth.Signer(auth.From, replaced)
+	require.NoError(t, err)
+	require.NoError(t, sim.Client().SendTransaction(ctx, signed))
+	sim.Commit()
+
+	_, err = api.Rebroadcast(ctx, rawTx)
+	assert.ErrorIs(t, err, contracts.ErrNonceUsed)
+	assert.Equal(t, contracts.CodeConflict, contracts.Classify(err).Code)
+
+	status, err := api.FindMined(ctx, rawTx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, original.Hash().Hex(), status.Hash)
+	assert.Equal(t, signed.Hash().Hex(), status.ReplacedBy)
+	assert.Equal(t, contracts.TxMined, status.Status)
+	assert.Equal(t, []uint{0}, status.SavedLogs)
+
+	// A nonce used by a transaction that saved nothing, like a cancel, is not found.
+	recipient := common.HexToAddress("0x0000000000000000000000000000000000000042")
+	transfer := sendTx(t, sim, auth, &recipient, 21000, nil)
+	sim.Commit()
+	unsent, err := auth.Signer(auth.From, types.NewTx(&types.LegacyTx{Nonce: transfer.Nonce(), To: &to, Gas: original.Gas(), GasPrice: original.GasPrice(), Data: original.Data()}))
+	require.NoError(t, err)
+	rawUnsent, err := unsent.MarshalBinary()
+	require.NoError(t, err)
+	_, err = api.FindMined(ctx, rawUnsent, time.Time{})
+	assert.ErrorIs(t, err, contracts.ErrTxNotFound)
+}
@@ -0,0 +1,457 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// Defaults used when no Option overrides them.
+const (
+	DefaultPollInterval        = 5 * time.Second
+	DefaultBatchSize    uint64 = 2000
+	DefaultBuffer              = 256
+	DefaultMaxBacklog   uint64 = 100_000
+)
+
+// Backend is the part of the Ethereum client events are read from. SubscribeFilterLogs only works
+// over websocket or IPC connections; over HTTP the Broadcaster falls back to polling.
+type Backend interface {
+	ethereum.LogFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+var (
+	// ErrInvalidEventID is returned by ParseID for IDs the Broadcaster did not issue.
+	ErrInvalidEventID = errors.New("invalid event ID")
+	// ErrBacklogTooLong is returned by Subscribe for streams starting further back than the maximum backlog.
+	ErrBacklogTooLong = errors.New("stream starts too far back")
+)
+
+// Event is a decoded FileSaved log.
+type Event struct {
+	// ID is "<block>-<log index>", which orders events and is the position to resume a stream from.
+	ID          string `json:"id"`
+	FilePath    string `json:"filePath"`
+	CID         string `json:"cid"`
+	BlockNumber uint64 `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	TxHash      string `json:"txHash"`
+	LogIndex    uint   `json:"logIndex"`
+	// Removed marks an event the client got before whose block was dropped by a chain reorganisation.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// ResumeID returns the ID a client resumes after once it got the event: its ID, or for a removed
+// event the position right before it, so that the events replacing it are sent.
+func (e Event) ResumeID() string {
+	if !e.Removed {
+		return e.ID
+	}
+	p := e.position().prev()
+	return fmt.Sprintf("%d-%d", p.Block, p.Index)
+}
+
+// Position is the place of an event in the chain.
+type Position struct {
+	Block uint64
+	Index uint
+}
+
+// After reports whether p comes after other.
+func (p Position) After(other Position) bool {
+	return p.Block > other.Block || (p.Block == other.Block && p.Index > other.Index)
+}
+
+// Next returns the position right after p, to resume a stream after the event at p.
+func (p Position) Next() Position {
+	return Position{Block: p.Block, Index: p.Index + 1}
+}
+
+// prev returns the position right before p. Log indexes fit 32 bits, as in event IDs.
+func (p Position) prev() Position {
+	switch {
+	case p.Index > 0:
+		return Position{Block: p.Block, Index: p.Index - 1}
+	case p.Block > 0:
+		return Position{Block: p.Block - 1, Index: math.MaxUint32}
+	}
+	return p
+}
+
+// ParseID returns the position of an event ID.
+func ParseID(id string) (Position, error) {
+	block, index, ok := strings.Cut(id, "-")
+	if !ok {
+		return Position{}, ErrInvalidEventID
+	}
+	b, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return Position{}, ErrInvalidEventID
+	}
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return Position{}, ErrInvalidEventID
+	}
+	return Position{Block: b, Index: uint(i)}, nil
+}
+
+func (e Event) position() Position {
+	return Position{Block: e.BlockNumber, Index: e.LogIndex}
+}
+
+// Broadcaster watches the FileSaved logs of the registry and passes them to every stream. It holds
+// one log subscription for all streams, or polls the chain when subscriptions are unavailable.
+type Broadcaster struct {
+	backend      Backend
+	registry     *contracts.FileRegistry
+	pollInterval time.Duration
+	batchSize    uint64
+	buffer       int
+	maxBacklog   uint64
+
+	mu      sync.Mutex
+	streams map[*stream]bool
+	// next is the first block the next poll reads, 0 before the first poll.
+	next uint64
+}
+
+// Option configures a Broadcaster.
+type Option func(*Broadcaster)
+
+// WithPollInterval sets how often the chain is polled when subscriptions are unavailable. Zero keeps
+// the default.
+func WithPollInterval(interval time.Duration) Option {
+	return func(b *Broadcaster) {
+		if interval > 0 {
+			b.pollInterval = interval
+		}
+	}
+}
+
+// WithBuffer sets the number of events a stream may fall behind before it is closed. Zero keeps the
+// default.
+func WithBuffer(n int) Option {
+	return func(b *Broadcaster) {
+		if n > 0 {
+			b.buffer = n
+		}
+	}
+}
+
+// WithMaxBacklog sets how many blocks before the head a stream may start at. Zero keeps the default.
+func WithMaxBacklog(blocks uint64) Option {
+	return func(b *Broadcaster) {
+		if blocks > 0 {
+			b.maxBacklog = blocks
+		}
+	}
+}
+
+// New creates a Broadcaster of the FileSaved logs of registry. Live events are passed once Run is called.
+func New(backend Backend, registry *contracts.FileRegistry, opts ...Option) *Broadcaster {
+	b := &Broadcaster{
+		backend:      backend,
+		registry:     registry,
+		pollInterval: DefaultPollInterval,
+		batchSize:    DefaultBatchSize,
+		buffer:       DefaultBuffer,
+		maxBacklog:   DefaultMaxBacklog,
+		streams:      make(map[*stream]bool),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// stream receives the events of files under a prefix, in chain order and without duplicates.
+type stream struct {
+	b      *Broadcaster
+	prefix string
+	events chan Event
+	// live holds the events passed on by the Broadcaster until they are forwarded to events. It is
+	// closed by whoever removes the stream from the Broadcaster.
+	live chan Event
+}
+
+// Subscribe returns a channel of the events of files under prefix, in chain order and without
+// duplicates. With a from position, the events at or after it are replayed first, read with
+// FilterLogs, followed by the live events. The from position may be at most the maximum backlog
+// before the head, or Subscribe returns ErrBacklogTooLong.
+//
+// Events the client got whose block is dropped by a chain reorganisation are sent again marked as
+// removed, followed by the events of the new chain from there on.
+//
+// The channel is closed when ctx is done, and when the client fell too far behind or the backlog
+// could not be read; the client may then subscribe again from the position after its last event.
+func (b *Broadcaster) Subscribe(ctx context.Context, prefix string, from *Position) (<-chan Event, error) {
+	s := &stream{b: b, prefix: prefix, events: make(chan Event), live: make(chan Event, b.buffer)}
+	// Live events are collected from now on, so that none is missed while the backlog is read.
+	b.mu.Lock()
+	b.streams[s] = true
+	b.mu.Unlock()
+
+	var head uint64
+	if from != nil {
+		header, err := b.backend.HeaderByNumber(ctx, nil)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to get latest block: %w", err)
+		}
+		head = header.Number.Uint64()
+		if head > b.maxBacklog && from.Block < head-b.maxBacklog {
+			s.close()
+			return nil, fmt.Errorf("%w: the earliest block is %d", ErrBacklogTooLong, head-b.maxBacklog)
+		}
+	}
+	go s.forward(ctx, from, head)
+	return s.events, nil
+}
+
+// close unregisters the stream from the Broadcaster.
+func (s *stream) close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s)
+}
+
+// forward sends the events from the from position up to the head block, then the live events, to
+// the client.
+func (s *stream) forward(ctx context.Context, from *Position, head uint64) {
+	defer close(s.events)
+	defer s.close()
+
+	// last is the position the stream continues after, sent the furthest event the client got.
+	var last, sent *Position
+	send := func(event Event) bool {
+		position := event.position()
+		if event.Removed {
+			if sent == nil || position.After(*sent) {
+				return true
+			}
+		} else if (last != nil && !position.After(*last)) || (last == nil && from != nil && from.After(position)) {
+			// Live events may repeat backlog events, and polling may repeat subscribed ones.
+			return true
+		}
+		select {
+		case s.events <- event:
+			switch {
+			case event.Removed:
+				if prev := position.prev(); !prev.After(*last) {
+					last = &prev
+				}
+			case sent == nil || position.After(*sent):
+				last, sent = &position, &position
+			default:
+				last = &position
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if from != nil {
+		for block := from.Block; block <= head; block += s.b.batchSize {
+			events, err := s.b.read(ctx, block, min(block+s.b.batchSize-1, head))
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("events: %v", err)
+				}
+				return
+			}
+			for _, event := range events {
+				if strings.HasPrefix(event.FilePath, s.prefix) && !send(event) {
+					return
+				}
+			}
+		}
+	}
+	for {
+		select {
+		case event, ok := <-s.live:
+			if !ok || !send(event) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// remove unregisters a stream and closes its live channel. b.mu must be held.
+func (b *Broadcaster) remove(s *stream) {
+	if b.streams[s] {
+		delete(b.streams, s)
+		close(s.live)
+	}
+}
+
+// Run follows new logs until ctx is done. Logs arrive through SubscribeFilterLogs when the backend
+// supports it; otherwise, or when the subscription fails, the chain is polled. Logs dropped by a chain
+// reorganisation are passed on as removed events, which the subscription reports only.
+func (b *Broadcaster) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("events: %v", err)
+		}
+
+		logs := make(chan types.Log)
+		sub, err := b.backend.SubscribeFilterLogs(ctx, b.query(nil, nil), logs)
+		if err != nil {
+			// Polling only: every tick catches up with the head.
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				continue
+			}
+		}
+
+		// Logs mined between the poll and the subscription are only read by polling again; streams
+		// drop the ones the subscription repeats.
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("events: %v", err)
+		}
+		if !b.follow(ctx, sub, logs) {
+			return
+		}
+	}
+}
+
+// follow passes subscribed logs on as they arrive. It returns false when ctx is done and true when
+// the subscription failed.
+func (b *Broadcaster) follow(ctx context.Context, sub ethereum.Subscription, logs <-chan types.Log) bool {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-sub.Err():
+			log.Printf("events: log subscription failed, polling: %v", err)
+			return true
+		case l := <-logs:
+			event, err := b.decode(l)
+			if err != nil {
+				log.Printf("events: %v", err)
+				continue
+			}
+			event.Removed = l.Removed
+			b.mu.Lock()
+			// Polling resumes at this block; streams drop the events they already got.
+			b.next = l.BlockNumber
+			b.mu.Unlock()
+			b.broadcast(event)
+		}
+	}
+}
+
+// poll passes on the logs of the blocks up to the head that the previous poll or subscription did
+// not cover. The first poll starts at the head.
+func (b *Broadcaster) poll(ctx context.Context) error {
+	head, err := b.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block: %w", err)
+	}
+	to := head.Number.Uint64()
+	b.mu.Lock()
+	from := b.next
+	if from == 0 {
+		from = to
+	}
+	b.mu.Unlock()
+	if from > to {
+		return nil
+	}
+
+	events, err := b.read(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		b.broadcast(event)
+	}
+	b.mu.Lock()
+	b.next = to + 1
+	b.mu.Unlock()
+	return nil
+}
+
+// read returns the events of the blocks from..to, in batches of FilterLogs calls.
+func (b *Broadcaster) read(ctx context.Context, from, to uint64) ([]Event, error) {
+	var events []Event
+	for from <= to {
+		end := min(from+b.batchSize-1, to)
+		logs, err := b.backend.FilterLogs(ctx, b.query(new(big.Int).SetUint64(from), new(big.Int).SetUint64(end)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter logs in blocks %d-%d: %w", from, end, err)
+		}
+		for _, l := range logs {
+			event, err := b.decode(l)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		from = end + 1
+	}
+	return events, nil
+}
+
+// broadcast passes event to the streams of its prefix. Streams that fell too far behind are closed.
+func (b *Broadcaster) broadcast(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.streams {
+		if !strings.HasPrefix(event.FilePath, s.prefix) {
+			continue
+		}
+		select {
+		case s.live <- event:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+func (b *Broadcaster) query(from, to *big.Int) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		FromBlock: from,
+		ToBlock:   to,
+		Addresses: []common.Address{b.registry.Address()},
+		Topics:    [][]common.Hash{{b.registry.FileSavedTopic()}},
+	}
+}
+
+func (b *Broadcaster) decode(l types.Log) (Event, error) {
+	saved, err := b.registry.ParseFileSaved(l)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to decode log %s/%d: %w", l.TxHash.Hex(), l.Index, err)
+	}
+	return Event{
+		ID:          fmt.Sprintf("%d-%d", l.BlockNumber, l.Index),
+		FilePath:    saved.FilePath,
+		CID:         saved.Cid,
+		BlockNumber: l.BlockNumber,
+		BlockHash:   l.BlockHash.Hex(),
+		TxHash:      l.TxHash.Hex(),
+		LogIndex:    l.Index,
+	}, nil
+}
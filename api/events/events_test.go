@@ -0,0 +1,255 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/events"
+)
+
+// emittingContract returns init code for a contract that emits every call's arguments as a FileSaved
+// log, see the indexer tests.
+func emittingContract() []byte {
+	topic := crypto.Keccak256([]byte("FileSaved(string,string)"))
+	runtime := common.FromHex("0x600436038060046000377f")
+	runtime = append(runtime, topic...)
+	runtime = append(runtime, common.FromHex("0x906000a100")...)
+	initCode := common.FromHex("0x6030600c60003960306000f3")
+	return append(initCode, runtime...)
+}
+
+type testChain struct {
+	sim *simulated.Backend
+	api *contracts.ContractAPI
+}
+
+func newTestChain(t *testing.T) *testChain {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(t, err)
+	sim := simulated.NewBackend(types.GenesisAlloc{auth.From: {Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))}})
+	t.Cleanup(func() { sim.Close() })
+
+	ctx := context.Background()
+	gasPrice, err := sim.Client().SuggestGasPrice(ctx)
+	require.NoError(t, err)
+	deploy, err := auth.Signer(auth.From, types.NewTx(&types.LegacyTx{Gas: 1_000_000, GasPrice: gasPrice, Data: emittingContract()}))
+	require.NoError(t, err)
+	require.NoError(t, sim.Client().SendTransaction(ctx, deploy))
+	sim.Commit()
+
+	// The ABI is loaded relative to the api directory, like in the running service.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	api, err := contracts.NewContractAPIWithBackend(sim.Client(), crypto.CreateAddress(auth.From, 0), auth)
+	require.NoError(t, err)
+	return &testChain{sim: sim, api: api}
+}
+
+func (c *testChain) save(t *testing.T, filePath, cid string) {
+	_, err := c.api.Save(filePath, cid)
+	require.NoError(t, err)
+	c.sim.Commit()
+}
+
+// pollingBackend hides the log subscriptions of a backend, like an HTTP RPC URL.
+type pollingBackend struct {
+	events.Backend
+}
+
+func (pollingBackend) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errors.New("notifications not supported")
+}
+
+// gapBackend runs gap before the first log subscription, like a block mined between the first poll
+// and the subscription.
+type gapBackend struct {
+	events.Backend
+	gap  func()
+	once sync.Once
+}
+
+func (b *gapBackend) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	b.once.Do(b.gap)
+	return b.Backend.SubscribeFilterLogs(ctx, q, ch)
+}
+
+func run(t *testing.T, b *events.Broadcaster) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go b.Run(ctx)
+}
+
+func subscribe(t *testing.T, b *events.Broadcaster, prefix string, from *events.Position) <-chan events.Event {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, err := b.Subscribe(ctx, prefix, from)
+	require.NoError(t, err)
+	return stream
+}
+
+func next(t *testing.T, stream <-chan events.Event) events.Event {
+	select {
+	case event, ok := <-stream:
+		require.True(t, ok, "stream closed")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return events.Event{}
+	}
+}
+
+func TestBroadcaster_StreamsLiveEvents(t *testing.T) {
+	for name, polling := range map[string]bool{"subscription": false, "polling": true} {
+		t.Run(name, func(t *testing.T) {
+			chain := newTestChain(t)
+			var backend events.Backend = chain.sim.Client()
+			if polling {
+				backend = pollingBackend{backend}
+			}
+			b := events.New(backend, chain.api.Registry(), events.WithPollInterval(20*time.Millisecond))
+			stream := subscribe(t, b, "/a/", nil)
+			run(t, b)
+			// Let the first poll pass the current head, so that the saves below are new blocks.
+			time.Sleep(100 * time.Millisecond)
+
+			chain.save(t, "/a/one.txt", "QmOne")
+			chain.save(t, "/b/two.txt", "QmTwo")
+			chain.save(t, "/a/three.txt", "QmThree")
+
+			first := next(t, stream)
+			assert.Equal(t, "/a/one.txt", first.FilePath)
+			assert.Equal(t, "QmOne", first.CID)
+			assert.NotEmpty(t, first.TxHash)
+			second := next(t, stream)
+			assert.Equal(t, "/a/three.txt", second.FilePath)
+			assert.Equal(t, second.BlockNumber, first.BlockNumber+2)
+		})
+	}
+}
+
+func TestBroadcaster_ResumesFromPosition(t *testing.T) {
+	chain := newTestChain(t)
+	chain.save(t, "/a/one.txt", "QmOne")
+	chain.save(t, "/a/two.txt", "QmTwo")
+	chain.save(t, "/b/other.txt", "QmOther")
+	chain.save(t, "/a/three.txt", "QmThree")
+
+	b := events.New(chain.sim.Client(), chain.api.Registry(), events.WithPollInterval(20*time.Millisecond))
+	run(t, b)
+
+	all := subscribe(t, b, "", &events.Position{})
+	first := next(t, all)
+	assert.Equal(t, "/a/one.txt", first.FilePath)
+
+	// A stream ends with its context.
+	ctx, cancel := context.WithCancel(context.Background())
+	closing, err := b.Subscribe(ctx, "", nil)
+	require.NoError(t, err)
+	cancel()
+	for range closing {
+	}
+
+	// Resuming after the first event replays the later ones of the prefix, then continues live.
+	position, err := events.ParseID(first.ID)
+	require.NoError(t, err)
+	from := position.Next()
+	stream := subscribe(t, b, "/a/", &from)
+	assert.Equal(t, "/a/two.txt", next(t, stream).FilePath)
+	assert.Equal(t, "/a/three.txt", next(t, stream).FilePath)
+
+	chain.save(t, "/a/four.txt", "QmFour")
+	assert.Equal(t, "/a/four.txt", next(t, stream).FilePath)
+}
+
+func TestBroadcaster_LogsBeforeSubscription(t *testing.T) {
+	chain := newTestChain(t)
+	backend := &gapBackend{Backend: chain.sim.Client(), gap: func() { chain.save(t, "/a/gap.txt", "QmGap") }}
+	b := events.New(backend, chain.api.Registry(), events.WithPollInterval(time.Hour))
+	stream := subscribe(t, b, "/a/", nil)
+	run(t, b)
+
+	assert.Equal(t, "/a/gap.txt", next(t, stream).FilePath)
+	chain.save(t, "/a/live.txt", "QmLive")
+	assert.Equal(t, "/a/live.txt", next(t, stream).FilePath)
+}
+
+func TestBroadcaster_Reorg(t *testing.T) {
+	chain := newTestChain(t)
+	b := events.New(chain.sim.Client(), chain.api.Registry(), events.WithPollInterval(time.Hour))
+	stream := subscribe(t, b, "/a/", nil)
+	run(t, b)
+	// Let the subscription start before the save below.
+	time.Sleep(100 * time.Millisecond)
+
+	parent, err := chain.sim.Client().HeaderByNumber(context.Background(), nil)
+	require.NoError(t, err)
+	chain.save(t, "/a/one.txt", "QmOne")
+	saved := next(t, stream)
+	assert.Equal(t, "/a/one.txt", saved.FilePath)
+	assert.False(t, saved.Removed)
+	assert.Equal(t, saved.ID, saved.ResumeID())
+
+	// A longer side chain without the save replaces its block.
+	require.NoError(t, chain.sim.Fork(parent.Hash()))
+	chain.sim.Commit()
+	chain.sim.Commit()
+
+	removed := next(t, stream)
+	assert.True(t, removed.Removed)
+	assert.Equal(t, saved.ID, removed.ID)
+	assert.Equal(t, fmt.Sprintf("%d-%d", saved.BlockNumber-1, math.MaxUint32), removed.ResumeID())
+	// The save mined again in the new chain is sent again, although it has the same position.
+	again := next(t, stream)
+	assert.Equal(t, "/a/one.txt", again.FilePath)
+	assert.False(t, again.Removed)
+}
+
+func TestBroadcaster_MaxBacklog(t *testing.T) {
+	chain := newTestChain(t)
+	for i := 0; i < 3; i++ {
+		chain.save(t, "/a/file.txt", "QmFile")
+	}
+	b := events.New(chain.sim.Client(), chain.api.Registry(), events.WithMaxBacklog(2))
+
+	// The saves are in blocks 2 to 4.
+	_, err := b.Subscribe(context.Background(), "", &events.Position{Block: 1})
+	assert.ErrorIs(t, err, events.ErrBacklogTooLong)
+	stream := subscribe(t, b, "", &events.Position{Block: 2})
+	assert.Equal(t, uint64(2), next(t, stream).BlockNumber)
+}
+
+func TestParseID(t *testing.T) {
+	position, err := events.ParseID("12-3")
+	require.NoError(t, err)
+	assert.Equal(t, events.Position{Block: 12, Index: 3}, position)
+	assert.True(t, events.Position{Block: 12, Index: 4}.After(position))
+	assert.False(t, events.Position{Block: 11, Index: 9}.After(position))
+	assert.Equal(t, events.Position{Block: 12, Index: 4}, position.Next())
+
+	for _, id := range []string{"", "12", "12-", "a-3", "12-3-4"} {
+		_, err := events.ParseID(id)
+		assert.ErrorIs(t, err, events.ErrInvalidEventID, id)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/gin-gonic/gin"
+)
+
+// AccountPool reports the signing accounts transactions are sent from.
+type AccountPool interface {
+	Accounts() []contracts.AccountStatus
+}
+
+// CodeAccountsDisabled is answered by GET /v1/accounts when no account pool is configured.
+const CodeAccountsDisabled = "accounts_disabled"
+
+// WithAccounts enables GET /v1/accounts.
+func WithAccounts(pool AccountPool) Option {
+	return func(h *Handlers) {
+		h.Accounts = pool
+	}
+}
+
+// WithMetrics serves metrics, such as the Prometheus handler, at GET /metrics for admin keys.
+func WithMetrics(metrics http.Handler) Option {
+	return func(h *Handlers) {
+		h.Metrics = metrics
+	}
+}
+
+// AccountResponse is the state of a signing account. Balance is in wei, empty until it is first read.
+type AccountResponse struct {
+	Address    string `json:"address"`
+	Balance    string `json:"balance,omitempty"`
+	Paused     bool   `json:"paused"`
+	QueueDepth int    `json:"queueDepth"`
+}
+
+// GetAccounts lists the signing accounts with their balance, whether they are paused for a low
+// balance and the number of their transactions waiting to be mined.
+func (h *Handlers) GetAccounts(c *gin.Context) {
+	if h.Accounts == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeAccountsDisabled, "Account pool is not enabled")
+		return
+	}
+	accounts := []AccountResponse{}
+	for _, account := range h.Accounts.Accounts() {
+		response := AccountResponse{Address: account.Address.Hex(), Paused: account.Paused, QueueDepth: account.QueueDepth}
+		if account.Balance != nil {
+			response.Balance = account.Balance.String()
+		}
+		accounts = append(accounts, response)
+	}
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
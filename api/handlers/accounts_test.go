@@ -0,0 +1,61 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+type mockAccountPool []contracts.AccountStatus
+
+func (m mockAccountPool) Accounts() []contracts.AccountStatus {
+	return m
+}
+
+// TestGetAccounts tests that the signing accounts are listed for admin keys only.
+func TestGetAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	pool := mockAccountPool{
+		{Address: common.HexToAddress("0x01"), Balance: new(big.Int).Mul(big.NewInt(5), big.NewInt(1e18)), QueueDepth: 3},
+		{Address: common.HexToAddress("0x02"), Paused: true},
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithAccounts(pool), handlers.WithAPIKeys(newKeyRing(t)))
+
+	w := authRequest(router, http.MethodGet, "/v1/accounts", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodGet, "/v1/accounts", "ops-key", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Accounts []handlers.AccountResponse `json:"accounts"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.AccountResponse{
+		{Address: common.HexToAddress("0x01").Hex(), Balance: "5000000000000000000", QueueDepth: 3},
+		{Address: common.HexToAddress("0x02").Hex(), Paused: true},
+	}, resp.Accounts)
+}
+
+// TestGetAccounts_Disabled tests that 503 is returned without an account pool.
+func TestGetAccounts_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	w := getPath(router, "/v1/accounts")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeAccountsDisabled, errorCode(t, w))
+
+	w = getPath(router, "/metrics")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// AccessRequest names a path or namespace and the account a grant or transfer applies to.
+type AccessRequest struct {
+	Path    string `json:"path"`
+	Account string `json:"account"`
+}
+
+// GetAccess returns the owner of ?path= and, with ?account=, whether that account may write it.
+// Like the other read routes, it is limited to the path prefixes of the API key.
+func (h *Handlers) GetAccess(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		abortBadRequest(c, "Missing path query parameter")
+		return
+	}
+	if !allowPath(c, path) {
+		return
+	}
+	var account *common.Address
+	if raw := c.Query("account"); raw != "" {
+		address, ok := parseAccount(c, raw)
+		if !ok {
+			return
+		}
+		account = &address
+	}
+
+	access, err := h.Contract.Access(path, account)
+	if err != nil {
+		abortContractError(c, "Contract access error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": path, "access": access})
+}
+
+// ClaimNamespace makes the API account owner of the namespace in the body's path, which must end in "/".
+func (h *Handlers) ClaimNamespace(c *gin.Context) {
+	var req AccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return
+	}
+	if !strings.HasSuffix(req.Path, "/") {
+		abortBadRequest(c, "Namespace must end with /: "+req.Path)
+		return
+	}
+
+	txHash, err := h.Contract.ClaimNamespace(req.Path)
+	if err != nil {
+		abortContractError(c, "Contract claim error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": req.Path, "txHash": txHash})
+}
+
+// GrantWriter lets the body's account write the body's path or namespace.
+func (h *Handlers) GrantWriter(c *gin.Context) {
+	req, account, ok := bindAccessRequest(c)
+	if !ok {
+		return
+	}
+	txHash, err := h.Contract.GrantWriter(req.Path, account)
+	if err != nil {
+		abortContractError(c, "Contract grant error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": req.Path, "account": account.Hex(), "txHash": txHash})
+}
+
+// RevokeWriter withdraws the writer grant of ?account= on ?path=.
+func (h *Handlers) RevokeWriter(c *gin.Context) {
+	req := AccessRequest{Path: c.Query("path"), Account: c.Query("account")}
+	if req.Path == "" {
+		abortBadRequest(c, "Missing path query parameter")
+		return
+	}
+	account, ok := parseAccount(c, req.Account)
+	if !ok {
+		return
+	}
+	txHash, err := h.Contract.RevokeWriter(req.Path, account)
+	if err != nil {
+		abortContractError(c, "Contract revoke error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": req.Path, "account": account.Hex(), "txHash": txHash})
+}
+
+// TransferOwnership hands the body's path or namespace to the body's account.
+func (h *Handlers) TransferOwnership(c *gin.Context) {
+	req, account, ok := bindAccessRequest(c)
+	if !ok {
+		return
+	}
+	// The zero address would leave the path unowned, and so open to every writer.
+	if account == (common.Address{}) {
+		abortBadRequest(c, "The owner must not be the zero address")
+		return
+	}
+	txHash, err := h.Contract.TransferOwnership(req.Path, account)
+	if err != nil {
+		abortContractError(c, "Contract transfer error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": req.Path, "owner": account.Hex(), "txHash": txHash})
+}
+
+func bindAccessRequest(c *gin.Context) (AccessRequest, common.Address, bool) {
+	var req AccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return req, common.Address{}, false
+	}
+	if req.Path == "" {
+		abortBadRequest(c, "Missing path")
+		return req, common.Address{}, false
+	}
+	account, ok := parseAccount(c, req.Account)
+	return req, account, ok
+}
+
+func parseAccount(c *gin.Context, raw string) (common.Address, bool) {
+	if !common.IsHexAddress(raw) {
+		abortBadRequest(c, "Invalid account address: "+raw)
+		return common.Address{}, false
+	}
+	return common.HexToAddress(raw), true
+}
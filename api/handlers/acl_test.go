@@ -0,0 +1,140 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+const testAccount = "0x00000000000000000000000000000000000000aa"
+
+func sendJSON(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestUploadFile_Unauthorized tests that a save rejected by the contract's access control returns 403.
+func TestUploadFile_Unauthorized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newWaitMocks(nil)
+	mockC.saveFunc = func(filePath, cid string) (string, error) {
+		return "", fmt.Errorf("failed to estimate gas: %w", &contracts.RevertError{Name: "NotAuthorized"})
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := postUpload(router, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "NotAuthorized")
+}
+
+// TestGetAccess_Success tests that the owner and write permission of an account are returned.
+func TestGetAccess_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	yes := true
+	mockC := &mockContract{
+		accessFunc: func(path string, account *common.Address) (*contracts.Access, error) {
+			assert.Equal(t, "/docs/", path)
+			assert.Equal(t, common.HexToAddress(testAccount), *account)
+			return &contracts.Access{Owner: common.HexToAddress(testAccount), Writer: &yes, CanWrite: &yes}, nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := getPath(router, "/v1/acl?path=/docs/&account="+testAccount)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Path   string           `json:"path"`
+		Access contracts.Access `json:"access"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "/docs/", resp.Path)
+	assert.Equal(t, common.HexToAddress(testAccount), resp.Access.Owner)
+	assert.True(t, *resp.Access.CanWrite)
+
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/acl").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/acl?path=/a&account=nope").Code)
+}
+
+// TestGetAccess_PathNotAllowed tests that keys restricted to prefixes cannot query other paths.
+func TestGetAccess_PathNotAllowed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		accessFunc: func(path string, account *common.Address) (*contracts.Access, error) {
+			return &contracts.Access{}, nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{}, handlers.WithAPIKeys(newKeyRing(t)))
+
+	assert.Equal(t, http.StatusOK, authRequest(router, http.MethodGet, "/v1/acl?path=/team-a/", "team-a-key", nil).Code)
+	w := authRequest(router, http.MethodGet, "/v1/acl?path=/team-b/", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+}
+
+// TestClaimNamespace tests that namespaces must end with a slash and that owned ones return 409.
+func TestClaimNamespace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		claimFunc: func(namespace string) (string, error) {
+			if namespace == "/taken/" {
+				return "", &contracts.RevertError{Name: "AlreadyOwned"}
+			}
+			return testTxHash, nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := sendJSON(router, http.MethodPost, "/v1/acl/namespaces", `{"path":"/docs/"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), testTxHash)
+
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodPost, "/v1/acl/namespaces", `{"path":"/docs"}`).Code)
+	assert.Equal(t, http.StatusConflict, sendJSON(router, http.MethodPost, "/v1/acl/namespaces", `{"path":"/taken/"}`).Code)
+}
+
+// TestWriterGrants tests granting, revoking and transferring, and that non-owners get 403.
+func TestWriterGrants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var calls []string
+	record := func(name string) func(path string, account common.Address) (string, error) {
+		return func(path string, account common.Address) (string, error) {
+			if path == "/other/" {
+				return "", &contracts.RevertError{Name: "NotOwner"}
+			}
+			calls = append(calls, name+" "+path+" "+account.Hex())
+			return testTxHash, nil
+		}
+	}
+	mockC := &mockContract{grantFunc: record("grant"), revokeFunc: record("revoke"), transferFunc: record("transfer")}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+	account := common.HexToAddress(testAccount).Hex()
+
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPost, "/v1/acl/writers", `{"path":"/docs/","account":"`+testAccount+`"}`).Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodDelete, "/v1/acl/writers?path=/docs/&account="+testAccount, "").Code)
+	assert.Equal(t, http.StatusOK, sendJSON(router, http.MethodPut, "/v1/acl/owner", `{"path":"/docs/","account":"`+testAccount+`"}`).Code)
+	assert.Equal(t, []string{"grant /docs/ " + account, "revoke /docs/ " + account, "transfer /docs/ " + account}, calls)
+
+	assert.Equal(t, http.StatusForbidden, sendJSON(router, http.MethodPost, "/v1/acl/writers", `{"path":"/other/","account":"`+testAccount+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodPost, "/v1/acl/writers", `{"path":"/docs/","account":"0x12"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodDelete, "/v1/acl/writers?account="+testAccount, "").Code)
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, http.MethodPut, "/v1/acl/owner", `{"path":"/docs/","account":"0x0000000000000000000000000000000000000000"}`).Code)
+	assert.Len(t, calls, 3)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the API key of a request; "Authorization: Bearer <key>" works as well.
+const APIKeyHeader = "X-API-Key"
+
+// Error codes of authentication failures.
+const (
+	CodeUnauthenticated   = "unauthenticated"
+	CodeInsufficientScope = "insufficient_scope"
+	CodePathNotAllowed    = "path_not_allowed"
+	CodeWalletNotAllowed  = "wallet_not_allowed"
+)
+
+const apiKeyKey = "apiKey"
+
+// WithAPIKeys requires every request to present one of the keys of ring. Without it the API is open.
+func WithAPIKeys(ring *auth.KeyRing) Option {
+	return func(h *Handlers) {
+		h.Keys = ring
+	}
+}
+
+// requireScope authenticates the API key or SIWE session token of the request and answers 401 or 403
+// unless it was granted scope. With API keys, a session has the scopes and prefixes of the wallet key
+// of its wallet, and wallets without a key are refused. Without API keys every request is let through,
+// with the wallet of its session if it has one, and sessions have the file scopes only.
+func (h *Handlers) requireScope(scope auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader(APIKeyHeader)
+		if raw == "" {
+			raw, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if h.Sessions != nil {
+			if session, ok := h.Sessions.Authenticate(raw); ok {
+				if scope == auth.ScopeAdmin {
+					abortError(c, http.StatusForbidden, CodeInsufficientScope, "Wallet sessions lack the admin scope")
+					return
+				}
+				c.Set(walletKey, session.Address)
+				if h.Keys == nil {
+					return
+				}
+				key, ok := h.Keys.Wallet(session.Address)
+				if !ok {
+					abortError(c, http.StatusForbidden, CodeWalletNotAllowed, "Wallet "+session.Address.Hex()+" has no API key")
+					return
+				}
+				if !key.HasScope(scope) {
+					abortError(c, http.StatusForbidden, CodeInsufficientScope, "Wallet key "+key.ID+" lacks the "+string(scope)+" scope")
+					return
+				}
+				c.Set(apiKeyKey, key)
+				return
+			}
+		}
+		if h.Keys == nil {
+			return
+		}
+		if raw == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortError(c, http.StatusUnauthorized, CodeUnauthenticated, "Missing API key")
+			return
+		}
+		key, ok := h.Keys.Authenticate(raw)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortError(c, http.StatusUnauthorized, CodeUnauthenticated, "Invalid API key or session token")
+			return
+		}
+		if !key.HasScope(scope) {
+			abortError(c, http.StatusForbidden, CodeInsufficientScope, "API key "+key.ID+" lacks the "+string(scope)+" scope")
+			return
+		}
+		c.Set(apiKeyKey, key)
+	}
+}
+
+// APIKeyFrom returns the key the request was authenticated with, or nil when API keys are disabled.
+func APIKeyFrom(c *gin.Context) *auth.Key {
+	if key, ok := c.Get(apiKeyKey); ok {
+		return key.(*auth.Key)
+	}
+	return nil
+}
+
+// allowPath answers 403 and returns false when the API key of the request is restricted to prefixes
+// that do not cover path.
+func allowPath(c *gin.Context, path string) bool {
+	key := APIKeyFrom(c)
+	if key == nil || key.AllowsPath(path) {
+		return true
+	}
+	abortError(c, http.StatusForbidden, CodePathNotAllowed, "API key "+key.ID+" may not access "+path)
+	return false
+}
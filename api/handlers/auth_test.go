@@ -0,0 +1,123 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+func newKeyRing(t *testing.T) *auth.KeyRing {
+	ring, err := auth.NewKeyRing([]auth.Key{
+		{ID: "reader", Hash: auth.HashKey("reader-key"), Scopes: []auth.Scope{auth.ScopeFilesRead}},
+		{ID: "team-a", Hash: auth.HashKey("team-a-key"), Scopes: []auth.Scope{auth.ScopeFilesRead, auth.ScopeFilesWrite}, Prefixes: []string{"/team-a/"}},
+		{ID: "ops", Hash: auth.HashKey("ops-key"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	require.NoError(t, err)
+	return ring
+}
+
+func authRequest(router *gin.Engine, method, path, key string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(handlers.APIKeyHeader, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var resp handlers.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Code
+}
+
+// TestAPIKeys_Authentication tests that requests without a valid key get 401.
+func TestAPIKeys_Authentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{}, handlers.WithAPIKeys(newKeyRing(t)))
+
+	w := authRequest(router, http.MethodGet, "/v1/files?filePath=/a.txt", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, handlers.CodeUnauthenticated, errorCode(t, w))
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = authRequest(router, http.MethodGet, "/v1/files?filePath=/a.txt", "wrong-key", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = authRequest(router, http.MethodGet, "/v1/files?filePath=/a.txt", "reader-key", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/files?filePath=/a.txt", nil)
+	req.Header.Set("Authorization", "Bearer reader-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestAPIKeys_Scopes tests that keys only reach the routes of their scopes and paths under their prefixes.
+func TestAPIKeys_Scopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var saved []string
+	mockC := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			saved = append(saved, filePath)
+			return testTxHash, nil
+		},
+		getFunc: func(filePath string) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	mockIPFS := &mockIPFSClient{
+		addFunc: func(ctx *gin.Context, file []byte) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithAPIKeys(newKeyRing(t)))
+	upload := func(filePath string) []byte {
+		return []byte(`{"filePath":"` + filePath + `","file":"` + base64.StdEncoding.EncodeToString([]byte("hi")) + `"}`)
+	}
+
+	w := authRequest(router, http.MethodPost, "/v1/files", "reader-key", upload("/team-a/a.txt"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeInsufficientScope, errorCode(t, w))
+
+	w = authRequest(router, http.MethodPost, "/v1/files", "team-a-key", upload("/team-a/a.txt"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = authRequest(router, http.MethodPost, "/v1/files", "team-a-key", upload("/team-b/a.txt"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+
+	w = authRequest(router, http.MethodGet, "/v1/files?filePath=/team-b/a.txt", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodPut, "/v1/files/team-b/a.txt", "team-a-key", []byte("hi"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodPost, "/v1/files", "ops-key", upload("/team-b/a.txt"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"/team-a/a.txt", "/team-b/a.txt"}, saved)
+
+	w = authRequest(router, http.MethodPost, "/v1/tx/"+testTxHash+"/cancel", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeInsufficientScope, errorCode(t, w))
+}
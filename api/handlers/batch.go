@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of batch uploads. DefaultBatchWorkers and DefaultMaxBatchSize are used when no Option
+// overrides them.
+const (
+	DefaultBatchWorkers       = 8
+	DefaultMaxBatchSize int64 = 64 << 20
+	MaxBatchFiles             = 1000
+)
+
+// BatchUploadRequest is the JSON body of a batch upload.
+type BatchUploadRequest struct {
+	Files []FileUploadRequest `json:"files"`
+}
+
+// BatchResult is the outcome of one file of a batch upload. Files that failed have a Code and Error;
+// the CID is set once the file was added to IPFS and the TxHash once its transaction was sent. Files
+// of asynchronous batches have the JobID of their outbox job instead.
+type BatchResult struct {
+	FilePath string `json:"filePath"`
+	CID      string `json:"cid,omitempty"`
+	TxHash   string `json:"txHash,omitempty"`
+	JobID    string `json:"jobId,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchFile is a file of a batch upload read from the request.
+type batchFile struct {
+	path string
+	data []byte
+}
+
+// WithBatchWorkers sets how many files of a batch upload are added to IPFS at a time.
+// Non-positive values keep the default.
+func WithBatchWorkers(n int) Option {
+	return func(h *Handlers) {
+		if n > 0 {
+			h.BatchWorkers = n
+		}
+	}
+}
+
+// WithMaxBatchSize limits the size of batch upload bodies, which are held in memory while their files
+// are added to IPFS. The limit never exceeds MaxUploadSize. Non-positive values keep the default.
+func WithMaxBatchSize(n int64) Option {
+	return func(h *Handlers) {
+		if n > 0 {
+			h.MaxBatchSize = n
+		}
+	}
+}
+
+// UploadBatch adds many files to IPFS and saves them with as few saveBatch transactions as the gas
+// limit allows. The body is JSON {"files": [{"filePath", "file" (base64)}]} or multipart/form-data
+// with a filePath field before each file part. The response lists the outcome of every file in
+// request order once the transactions are mined, with ?wait=true or ?confirmations=N once they have
+// enough confirmations, and answers 207 when some files failed. With ?async=true every file becomes
+// an outbox job instead, answered 202. Other batches skip the outbox, which saves every job in its own
+// transaction, so their failed files are not retried.
+func (h *Handlers) UploadBatch(c *gin.Context) {
+	async, ok := h.requestedAsync(c)
+	if !ok {
+		return
+	}
+	confirmations, ok := h.requestedConfirmations(c)
+	if !ok {
+		return
+	}
+	body, ok := limitBody(c, min(h.MaxBatchSize, h.MaxUploadSize))
+	if !ok {
+		return
+	}
+	var files []batchFile
+	if c.ContentType() == "multipart/form-data" {
+		files, ok = h.readBatchMultipart(c, body)
+	} else {
+		files, ok = h.readBatchJSON(c, body)
+	}
+	if !ok {
+		return
+	}
+	if len(files) == 0 {
+		abortBadRequest(c, "Missing files")
+		return
+	}
+	if len(files) > MaxBatchFiles {
+		abortBadRequest(c, fmt.Sprintf("A batch holds at most %d files", MaxBatchFiles))
+		return
+	}
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if seen[file.path] {
+			abortBadRequest(c, "Duplicate filePath: "+file.path)
+			return
+		}
+		seen[file.path] = true
+		if !h.allowWrite(c, file.path) {
+			return
+		}
+	}
+	if async {
+		h.enqueueBatch(c, files)
+		return
+	}
+
+	results := h.addBatch(c, files)
+	var paths, cids []string
+	var pending []int
+	for i, result := range results {
+		if result.Code == "" {
+			paths, cids, pending = append(paths, result.FilePath), append(cids, result.CID), append(pending, i)
+		}
+	}
+	if len(pending) > 0 {
+		saves, err := h.Contract.SaveBatch(paths, cids)
+		if err != nil {
+			abortContractError(c, "Contract save error: ", err)
+			return
+		}
+		for j, save := range saves {
+			result := &results[pending[j]]
+			if save.Err != nil {
+				result.Code, result.Error = string(contracts.Classify(save.Err).Code), save.Err.Error()
+				continue
+			}
+			result.TxHash = save.TxHash
+		}
+	}
+
+	transactions := []string{}
+	saved := make(map[string][]*BatchResult)
+	for i := range results {
+		if hash := results[i].TxHash; hash != "" {
+			if _, ok := saved[hash]; !ok {
+				transactions = append(transactions, hash)
+			}
+			saved[hash] = append(saved[hash], &results[i])
+		}
+	}
+	h.confirmBatch(c, transactions, saved, max(confirmations, 1))
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Code != "" {
+			status = http.StatusMultiStatus
+		}
+	}
+	c.JSON(status, gin.H{"results": results, "transactions": transactions})
+}
+
+// confirmBatch waits, within TxWaitTimeout, for the transactions of a batch to be mined with enough
+// confirmations, and fails the files of the transactions that reverted, were cancelled or were not
+// mined in time. The saves of transactions that are not final yet are reported in the background.
+func (h *Handlers) confirmBatch(c *gin.Context, transactions []string, files map[string][]*BatchResult, confirmations uint64) {
+	ctx, cancel := context.WithTimeout(c, h.TxWaitTimeout)
+	defer cancel()
+
+	statuses := make([]*contracts.TxStatus, len(transactions))
+	errs := make([]error, len(transactions))
+	var wg sync.WaitGroup
+	for i, hash := range transactions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], errs[i] = h.Contract.WaitMined(ctx, hash, confirmations)
+		}()
+	}
+	wg.Wait()
+
+	signer := WalletFrom(c)
+	for i, hash := range transactions {
+		status, err := statuses[i], errs[i]
+		code, message := "", ""
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			code, message = CodeTxTimeout, "Timed out waiting for transaction"
+		case err != nil:
+			code, message = string(contracts.Classify(err).Code), "Transaction wait error: "+err.Error()
+		case status.Status == contracts.TxReverted:
+			code, message = CodeTxReverted, "Transaction reverted"
+		case status.Status == contracts.TxCancelled:
+			code, message = CodeTxCancelled, "Transaction cancelled"
+		}
+		for _, result := range files[hash] {
+			result.Code, result.Error = code, message
+		}
+		if err != nil {
+			h.watchBatch(hash, files[hash], signer)
+			continue
+		}
+		h.batchFinished(status, files[hash], signer)
+	}
+}
+
+// batchFinished records the wallet of the saves of a batch transaction sent for a wallet and reports
+// the outcome of every file it saves to the webhooks, once the transaction is final.
+func (h *Handlers) batchFinished(status *contracts.TxStatus, files []*BatchResult, signer *common.Address) {
+	if signer != nil {
+		h.recordSigner(status, *signer)
+	}
+	if h.Webhooks != nil {
+		for _, file := range files {
+			h.Webhooks.SaveFinished(file.FilePath, file.CID, status)
+		}
+	}
+}
+
+// watchBatch calls batchFinished in the background once a batch transaction is final, when there is
+// anything to record or report.
+func (h *Handlers) watchBatch(txHash string, files []*BatchResult, signer *common.Address) {
+	if (signer == nil || h.Signers == nil) && h.Webhooks == nil {
+		return
+	}
+	// The results are answered meanwhile, so the background works on copies.
+	copies := make([]*BatchResult, len(files))
+	for i, file := range files {
+		result := *file
+		copies[i] = &result
+	}
+	h.watchTx(txHash, func(status *contracts.TxStatus) {
+		h.batchFinished(status, copies, signer)
+	})
+}
+
+// enqueueBatch writes every file of an asynchronous batch to the outbox as its own job and answers
+// 202 with the jobs, or 207 when some files could not be queued.
+func (h *Handlers) enqueueBatch(c *gin.Context, files []batchFile) {
+	status := http.StatusAccepted
+	results := make([]BatchResult, len(files))
+	for i, file := range files {
+		results[i].FilePath = file.path
+		job, err := h.Outbox.Enqueue(outbox.Request{FilePath: file.path, Signer: WalletFrom(c)}, bytes.NewReader(file.data))
+		if err != nil {
+			status = http.StatusMultiStatus
+			results[i].Code, results[i].Error = CodeOutboxError, "Failed to queue upload: "+err.Error()
+			continue
+		}
+		results[i].JobID = job.ID
+	}
+	c.JSON(status, gin.H{"results": results})
+}
+
+// addBatch adds the files to IPFS, BatchWorkers at a time, and returns their results with the
+// CIDs or the IPFS errors.
+func (h *Handlers) addBatch(c *gin.Context, files []batchFile) []BatchResult {
+	results := make([]BatchResult, len(files))
+	ctx := c.Request.Context()
+	slots := make(chan struct{}, h.BatchWorkers)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		results[i].FilePath = file.path
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			cid, err := h.IPFSClient.AddReader(ctx, bytes.NewReader(file.data))
+			if err != nil {
+				results[i].Code, results[i].Error = CodeIPFSError, "IPFS Add error: "+err.Error()
+				return
+			}
+			results[i].CID = cid
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (h *Handlers) readBatchJSON(c *gin.Context, body *limitedBody) ([]batchFile, bool) {
+	var req BatchUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if body.tooLarge {
+			abortTooLarge(c, body.limit)
+			return nil, false
+		}
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return nil, false
+	}
+	files := make([]batchFile, len(req.Files))
+	for i, file := range req.Files {
+		if file.FilePath == "" {
+			abortBadRequest(c, fmt.Sprintf("Missing filePath of file %d", i))
+			return nil, false
+		}
+		data, err := base64.StdEncoding.DecodeString(file.FileB64)
+		if err != nil {
+			abortBadRequest(c, "Invalid base64 data of "+file.FilePath+": "+err.Error())
+			return nil, false
+		}
+		files[i] = batchFile{path: file.FilePath, data: data}
+	}
+	return files, true
+}
+
+// readBatchMultipart reads the files of a multipart/form-data body, each one a "file" part preceded
+// by a "filePath" field. Other parts and a filePath field without its file are rejected. The files are
+// held in memory, within MaxBatchSize, so that they can be added to IPFS in parallel.
+func (h *Handlers) readBatchMultipart(c *gin.Context, body *limitedBody) ([]batchFile, bool) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		abortBadRequest(c, "Invalid multipart body: "+err.Error())
+		return nil, false
+	}
+
+	var files []batchFile
+	filePath := ""
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			if filePath != "" {
+				abortBadRequest(c, "Missing file part after the filePath field of "+filePath)
+				return nil, false
+			}
+			return files, true
+		}
+		if err != nil {
+			if body.tooLarge {
+				abortTooLarge(c, body.limit)
+				return nil, false
+			}
+			abortBadRequest(c, "Invalid multipart body: "+err.Error())
+			return nil, false
+		}
+
+		switch part.FormName() {
+		case "filePath":
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			if err != nil {
+				abortBadRequest(c, "Invalid filePath field: "+err.Error())
+				return nil, false
+			}
+			filePath = string(value)
+		case "file":
+			if filePath == "" {
+				abortBadRequest(c, "Missing filePath field before a file part")
+				return nil, false
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				if body.tooLarge {
+					abortTooLarge(c, body.limit)
+					return nil, false
+				}
+				abortBadRequest(c, "Invalid file part of "+filePath+": "+err.Error())
+				return nil, false
+			}
+			files = append(files, batchFile{path: filePath, data: data})
+			filePath = ""
+		default:
+			abortBadRequest(c, fmt.Sprintf("Unexpected part %q, expected filePath and file parts", part.FormName()))
+			return nil, false
+		}
+		part.Close()
+	}
+}
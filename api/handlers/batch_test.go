@@ -0,0 +1,334 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/webhooks"
+)
+
+// batchIPFS adds files under the CID "Qm<content>" and fails for the content "broken". It records
+// the largest number of adds running at once.
+type batchIPFS struct {
+	mockIPFSClient
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func newBatchIPFS() *batchIPFS {
+	m := &batchIPFS{}
+	m.addReaderFunc = func(ctx context.Context, r io.Reader) (string, error) {
+		m.mu.Lock()
+		m.running++
+		m.peak = max(m.peak, m.running)
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			m.running--
+			m.mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		if string(data) == "broken" {
+			return "", errors.New("add failed")
+		}
+		return "Qm" + string(data), nil
+	}
+	return m
+}
+
+type batchResponse struct {
+	Results      []handlers.BatchResult `json:"results"`
+	Transactions []string               `json:"transactions"`
+}
+
+// minedTx is the WaitMined of a mock contract whose transactions are all mined.
+func minedTx(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+	return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7, Confirmations: confirmations}, nil
+}
+
+func batchBody(files map[string]string, order ...string) string {
+	var items []string
+	for _, path := range order {
+		items = append(items, fmt.Sprintf(`{"filePath":%q,"file":%q}`, path, base64.StdEncoding.EncodeToString([]byte(files[path]))))
+	}
+	return `{"files":[` + strings.Join(items, ",") + `]}`
+}
+
+// TestUploadBatch_PerItemResults tests that a batch is added to IPFS, saved in one call and answered
+// with the outcome of every file, failed ones included.
+func TestUploadBatch_PerItemResults(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var saved []string
+	mockC := &mockContract{
+		saveBatchFunc: func(paths, cids []string) ([]contracts.BatchSave, error) {
+			saved = paths
+			results := make([]contracts.BatchSave, len(paths))
+			for i, path := range paths {
+				results[i].TxHash = "0xaaa"
+				if path == "/release/owned.txt" {
+					results[i] = contracts.BatchSave{Err: &contracts.Error{Code: contracts.CodeUnauthorized, Err: errors.New("execution reverted: NotAuthorized")}}
+				}
+			}
+			return results, nil
+		},
+		waitMinedFunc: minedTx,
+	}
+	router := handlers.SetupRouter(mockC, newBatchIPFS())
+
+	files := map[string]string{"/release/a.txt": "a", "/release/broken.txt": "broken", "/release/owned.txt": "owned", "/release/b.txt": "b"}
+	w := sendJSON(router, http.MethodPost, "/v1/files/batch", batchBody(files, "/release/a.txt", "/release/broken.txt", "/release/owned.txt", "/release/b.txt"))
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.BatchResult{
+		{FilePath: "/release/a.txt", CID: "Qma", TxHash: "0xaaa"},
+		{FilePath: "/release/broken.txt", Code: handlers.CodeIPFSError, Error: "IPFS Add error: add failed"},
+		{FilePath: "/release/owned.txt", CID: "Qmowned", Code: string(contracts.CodeUnauthorized), Error: "execution reverted: NotAuthorized"},
+		{FilePath: "/release/b.txt", CID: "Qmb", TxHash: "0xaaa"},
+	}, resp.Results)
+	assert.Equal(t, []string{"0xaaa"}, resp.Transactions)
+	assert.Equal(t, []string{"/release/a.txt", "/release/owned.txt", "/release/b.txt"}, saved)
+}
+
+// TestUploadBatch_Multipart tests that a multipart batch is added to IPFS with bounded parallelism.
+func TestUploadBatch_Multipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		saveBatchFunc: func(paths, cids []string) ([]contracts.BatchSave, error) {
+			results := make([]contracts.BatchSave, len(paths))
+			for i := range paths {
+				results[i].TxHash = fmt.Sprintf("0x%d", i/4)
+			}
+			return results, nil
+		},
+		waitMinedFunc: minedTx,
+	}
+	ipfs := newBatchIPFS()
+	router := handlers.SetupRouter(mockC, ipfs, handlers.WithBatchWorkers(3))
+
+	var fields [][2]string
+	for i := 0; i < 10; i++ {
+		fields = append(fields, [2]string{"filePath", fmt.Sprintf("/release/%d.txt", i)}, [2]string{"file", fmt.Sprint(i)})
+	}
+	body, contentType := multipartBody(t, fields)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files/batch", body)
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 10)
+	assert.Equal(t, "/release/7.txt", resp.Results[7].FilePath)
+	assert.Equal(t, "Qm7", resp.Results[7].CID)
+	assert.Equal(t, []string{"0x0", "0x1", "0x2"}, resp.Transactions)
+	assert.LessOrEqual(t, ipfs.peak, 3)
+	assert.Greater(t, ipfs.peak, 1)
+}
+
+// TestUploadBatch_Rejects tests that invalid batches are rejected before anything is added to IPFS.
+func TestUploadBatch_Rejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ipfs := newBatchIPFS()
+	router := handlers.SetupRouter(&mockContract{}, ipfs, handlers.WithAPIKeys(newKeyRing(t)))
+
+	w := authRequest(router, http.MethodPost, "/v1/files/batch", "team-a-key", []byte(`{"files":[]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = authRequest(router, http.MethodPost, "/v1/files/batch", "team-a-key", []byte(`{"files":[{"file":"YQ=="}]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	files := map[string]string{"/team-a/a.txt": "a", "/team-b/b.txt": "b"}
+	w = authRequest(router, http.MethodPost, "/v1/files/batch", "team-a-key", []byte(batchBody(files, "/team-a/a.txt", "/team-a/a.txt")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Duplicate filePath")
+	w = authRequest(router, http.MethodPost, "/v1/files/batch", "team-a-key", []byte(batchBody(files, "/team-a/a.txt", "/team-b/b.txt")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+	w = authRequest(router, http.MethodPost, "/v1/files/batch", "reader-key", []byte(batchBody(files, "/team-a/a.txt")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	body, contentType := multipartBody(t, [][2]string{{"filePath", "/team-a/a.txt"}, {"file", "a"}, {"filePath", "/team-a/b.txt"}})
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files/batch", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-API-Key", "team-a-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Missing file part")
+	body, contentType = multipartBody(t, [][2]string{{"filePath", "/team-a/a.txt"}, {"file", "a"}, {"comment", "x"}})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/v1/files/batch", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-API-Key", "team-a-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unexpected part")
+
+	assert.Zero(t, ipfs.peak)
+}
+
+// TestUploadBatch_TooLarge tests that batches are limited to MaxBatchSize, below the limit of single uploads.
+func TestUploadBatch_TooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ipfs := newBatchIPFS()
+	router := handlers.SetupRouter(&mockContract{}, ipfs, handlers.WithMaxBatchSize(64))
+
+	files := map[string]string{"/release/a.txt": strings.Repeat("a", 64)}
+	w := sendJSON(router, http.MethodPost, "/v1/files/batch", batchBody(files, "/release/a.txt"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "maximum size of 64 bytes")
+
+	body, contentType := multipartBody(t, [][2]string{{"filePath", "/release/a.txt"}, {"file", strings.Repeat("a", 64)}})
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files/batch", body)
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Zero(t, ipfs.peak)
+}
+
+// TestUploadBatch_WaitsForTransactions tests that files are only reported saved once their transaction
+// is mined: the files of a reverted transaction fail, and the webhooks hear about every file.
+func TestUploadBatch_WaitsForTransactions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mu sync.Mutex
+	waited := make(map[string]uint64)
+	mockC := &mockContract{
+		saveBatchFunc: func(paths, cids []string) ([]contracts.BatchSave, error) {
+			results := make([]contracts.BatchSave, len(paths))
+			for i := range paths {
+				results[i].TxHash = fmt.Sprintf("0x%d", i/2)
+			}
+			return results, nil
+		},
+		waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+			mu.Lock()
+			waited[txHash] = confirmations
+			mu.Unlock()
+			if txHash == "0x1" {
+				// Another writer claimed a path of the chunk after it was planned.
+				return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 7}, nil
+			}
+			return minedTx(ctx, txHash, confirmations)
+		},
+	}
+	d := newDispatcher(t)
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	router := handlers.SetupRouter(mockC, newBatchIPFS(), handlers.WithWebhooks(d))
+
+	files := map[string]string{"/a.txt": "a", "/b.txt": "b", "/c.txt": "c"}
+	w := sendJSON(router, http.MethodPost, "/v1/files/batch?confirmations=3", batchBody(files, "/a.txt", "/b.txt", "/c.txt"))
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.BatchResult{
+		{FilePath: "/a.txt", CID: "Qma", TxHash: "0x0"},
+		{FilePath: "/b.txt", CID: "Qmb", TxHash: "0x0"},
+		{FilePath: "/c.txt", CID: "Qmc", TxHash: "0x1", Code: handlers.CodeTxReverted, Error: "Transaction reverted"},
+	}, resp.Results)
+	assert.Equal(t, []string{"0x0", "0x1"}, resp.Transactions)
+	assert.Equal(t, map[string]uint64{"0x0": 3, "0x1": 3}, waited)
+
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	types := make(map[string]webhooks.EventType)
+	for _, delivery := range deliveries {
+		types[delivery.Event.FilePath] = delivery.Event.Type
+	}
+	assert.Equal(t, map[string]webhooks.EventType{
+		"/a.txt": webhooks.EventUploadConfirmed,
+		"/b.txt": webhooks.EventUploadConfirmed,
+		"/c.txt": webhooks.EventTxReverted,
+	}, types)
+
+	// Without ?wait, the batch waits for one confirmation.
+	w = sendJSON(router, http.MethodPost, "/v1/files/batch", batchBody(files, "/a.txt"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint64(1), waited["0x0"])
+}
+
+// TestUploadBatch_Timeout tests that the files of a transaction not mined within the wait timeout fail
+// with their transaction hash.
+func TestUploadBatch_Timeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		saveBatchFunc: func(paths, cids []string) ([]contracts.BatchSave, error) {
+			return []contracts.BatchSave{{TxHash: testTxHash}}, nil
+		},
+		waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	router := handlers.SetupRouter(mockC, newBatchIPFS(), handlers.WithConfirmations(1, 10*time.Millisecond))
+
+	w := sendJSON(router, http.MethodPost, "/v1/files/batch", batchBody(map[string]string{"/a.txt": "a"}, "/a.txt"))
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.BatchResult{
+		{FilePath: "/a.txt", CID: "Qma", TxHash: testTxHash, Code: handlers.CodeTxTimeout, Error: "Timed out waiting for transaction"},
+	}, resp.Results)
+}
+
+// TestUploadBatch_Async tests that ?async=true queues every file of a batch in the outbox.
+func TestUploadBatch_Async(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ob := &mockOutbox{}
+	ipfs := newBatchIPFS()
+	router := handlers.SetupRouter(&mockContract{}, ipfs, handlers.WithOutbox(ob))
+
+	files := map[string]string{"/a.txt": "a", "/b.txt": "b"}
+	w := sendJSON(router, http.MethodPost, "/v1/files/batch?async=true", batchBody(files, "/a.txt", "/b.txt"))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp batchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.BatchResult{
+		{FilePath: "/a.txt", JobID: "job-1"},
+		{FilePath: "/b.txt", JobID: "job-1"},
+	}, resp.Results)
+	assert.Equal(t, "/b.txt", ob.request.FilePath)
+	assert.Equal(t, "b", ob.content)
+	assert.Zero(t, ipfs.peak)
+
+	w = sendJSON(router, http.MethodPost, "/v1/files/batch?async=true&wait=true", batchBody(files, "/a.txt"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	router = handlers.SetupRouter(&mockContract{}, ipfs)
+	w = sendJSON(router, http.MethodPost, "/v1/files/batch?async=true", batchBody(files, "/a.txt"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
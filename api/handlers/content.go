@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+var errInvalidRange = errors.New("invalid range")
+
+// byteRange is a single satisfiable range of a file, already resolved against the file size.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// GetFileContent resolves the filePath to a CID through the contract and streams the file bytes from IPFS.
+// The CID is used as a strong ETag, so conditional requests (If-None-Match, If-Modified-Since, If-Range)
+// and single byte ranges are answered with 304, 206 and 416 without buffering the file.
+func (h *Handlers) GetFileContent(c *gin.Context) {
+	filePath := c.Query("filePath")
+
+	if filePath == "" {
+		abortBadRequest(c, "Missing filePath query parameter")
+		return
+	}
+	if !allowPath(c, filePath) {
+		return
+	}
+
+	cid, err := h.Contract.Get(filePath)
+	if err != nil {
+		abortContractError(c, "Contract get error: ", err)
+		return
+	}
+	if cid == "" {
+		abortError(c, http.StatusNotFound, CodeNotFound, "File not found: "+filePath)
+		return
+	}
+
+	etag := `"` + cid + `"`
+	c.Header("ETag", etag)
+	c.Header("Accept-Ranges", "bytes")
+
+	// The content behind a CID never changes, so a matching ETag can be answered without touching IPFS.
+	inm := c.GetHeader("If-None-Match")
+	if inm != "" && etagMatch(inm, etag, false) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	size, modTime, err := h.IPFSClient.Stat(c, cid)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIPFSError, "IPFS stat error: "+err.Error())
+		return
+	}
+	if !modTime.IsZero() {
+		c.Header("Last-Modified", modTime.Format(http.TimeFormat))
+		if inm == "" && !modifiedSince(c.GetHeader("If-Modified-Since"), modTime) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	var rng *byteRange
+	if header := c.GetHeader("Range"); header != "" && ifRangeMatch(c.GetHeader("If-Range"), etag, modTime) {
+		rng, err = parseRange(header, size)
+		if err != nil {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			abortError(c, http.StatusRequestedRangeNotSatisfiable, CodeRangeNotSatisfiable, "Invalid range: "+header)
+			return
+		}
+	}
+
+	status, offset, length := http.StatusOK, int64(0), size
+	if rng != nil {
+		status, offset, length = http.StatusPartialContent, rng.start, rng.length
+		c.Header("Content-Range", rng.contentRange(size))
+	}
+
+	reader, err := h.IPFSClient.CatRange(c, cid, offset, length)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIPFSError, "IPFS cat error: "+err.Error())
+		return
+	}
+	defer reader.Close()
+
+	br := bufio.NewReaderSize(reader, sniffLen)
+	sniff := br
+	if offset != 0 {
+		// Sniffing the middle of a file would be meaningless.
+		sniff = nil
+	}
+	c.DataFromReader(status, length, contentType(filePath, sniff), br, nil)
+}
+
+// contentType guesses the MIME type from the file extension and falls back to sniffing the first bytes.
+// When br is nil the fallback is application/octet-stream.
+func contentType(filePath string, br *bufio.Reader) string {
+	if ct := mime.TypeByExtension(path.Ext(filePath)); ct != "" {
+		return ct
+	}
+	if br == nil {
+		return "application/octet-stream"
+	}
+	head, _ := br.Peek(sniffLen)
+	return http.DetectContentType(head)
+}
+
+// etagMatch reports whether the etag is listed in the If-None-Match / If-Range header value.
+// Weak validators (W/"...") only match when weak comparison is allowed.
+func etagMatch(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" && !strong {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedSince reports whether modTime is later than the If-Modified-Since header value.
+// A missing or malformed header counts as modified.
+func modifiedSince(header string, modTime time.Time) bool {
+	if header == "" {
+		return true
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return true
+	}
+	return modTime.Truncate(time.Second).After(since)
+}
+
+// ifRangeMatch reports whether the Range header should be honoured given the If-Range header value.
+func ifRangeMatch(header, etag string, modTime time.Time) bool {
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return etagMatch(header, etag, true)
+	}
+	at, err := http.ParseTime(header)
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(at)
+}
+
+// parseRange resolves a single "bytes=" range against the file size. It returns nil when the header
+// should be ignored (other units or multiple ranges) and errInvalidRange when it cannot be satisfied.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, errInvalidRange
+	}
+
+	if first == "" {
+		// Suffix range: the last N bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return nil, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return &byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return nil, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return &byteRange{start: start, length: end - start + 1}, nil
+}
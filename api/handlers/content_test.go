@@ -0,0 +1,219 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+// newContentMocks returns mocks serving content under QmFakeCID with the given modification time.
+func newContentMocks(content string, modTime time.Time) (*mockContract, *mockIPFSClient) {
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	mockIPFS := &mockIPFSClient{
+		statFunc: func(ctx context.Context, cid string) (int64, time.Time, error) {
+			return int64(len(content)), modTime, nil
+		},
+		catRangeFunc: func(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content[offset : offset+length])), nil
+		},
+	}
+	return mockC, mockIPFS
+}
+
+func getContent(router *gin.Engine, filePath string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/files/content?filePath="+filePath, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestGetFileContent_Success tests that the file bytes are streamed with the right headers.
+func TestGetFileContent_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello World!", w.Body.String())
+	assert.Equal(t, "12", w.Header().Get("Content-Length"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Equal(t, `"QmFakeCID"`, w.Header().Get("ETag"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+}
+
+// TestGetFileContent_SniffsContentType tests that the content type is detected when the path has no extension.
+func TestGetFileContent_SniffsContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	content := "<html><body>hi</body></html>"
+	mockC, mockIPFS := newContentMocks(content, time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/index", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+}
+
+// TestGetFileContent_NotFound tests that an empty CID from the contract returns 404.
+func TestGetFileContent_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := getContent(router, "/unknown.txt", nil)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Contains(t, resp["message"], "File not found")
+}
+
+// TestGetFileContent_MissingFilePath tests that if filePath is not provided, returns 400.
+func TestGetFileContent_MissingFilePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	w := getContent(router, "", nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestGetFileContent_IPFSError tests that if IPFS fails, returns 500.
+func TestGetFileContent_IPFSError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	mockIPFS.catRangeFunc = func(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+		return nil, errors.New("ipfs unavailable")
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", nil)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Contains(t, resp["message"], "ipfs unavailable")
+}
+
+// TestGetFileContent_Range tests that a byte range returns 206 with only the requested bytes.
+func TestGetFileContent_Range(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", map[string]string{"Range": "bytes=6-10"})
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "World", w.Body.String())
+	assert.Equal(t, "bytes 6-10/12", w.Header().Get("Content-Range"))
+	assert.Equal(t, "5", w.Header().Get("Content-Length"))
+}
+
+// TestGetFileContent_SuffixRange tests that a suffix range returns the last bytes of the file.
+func TestGetFileContent_SuffixRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", map[string]string{"Range": "bytes=-6"})
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "World!", w.Body.String())
+	assert.Equal(t, "bytes 6-11/12", w.Header().Get("Content-Range"))
+}
+
+// TestGetFileContent_RangeNotSatisfiable tests that a range past the end of the file returns 416.
+func TestGetFileContent_RangeNotSatisfiable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", map[string]string{"Range": "bytes=100-200"})
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "bytes */12", w.Header().Get("Content-Range"))
+}
+
+// TestGetFileContent_IfNoneMatch tests that a matching ETag returns 304 without reading from IPFS.
+func TestGetFileContent_IfNoneMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := getContent(router, "/test/file.txt", map[string]string{"If-None-Match": `"QmOtherCID", "QmFakeCID"`})
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, `"QmFakeCID"`, w.Header().Get("ETag"))
+}
+
+// TestGetFileContent_IfModifiedSince tests that an unmodified file returns 304.
+func TestGetFileContent_IfModifiedSince(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockC, mockIPFS := newContentMocks("Hello World!", modTime)
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	earlier := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	w = getContent(router, "/test/file.txt", map[string]string{"If-Modified-Since": earlier})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+}
+
+// TestGetFileContent_IfRangeMismatch tests that a stale If-Range validator returns the full file.
+func TestGetFileContent_IfRangeMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newContentMocks("Hello World!", time.Time{})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := getContent(router, "/test/file.txt", map[string]string{"Range": "bytes=0-4", "If-Range": `"QmOtherCID"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello World!", w.Body.String())
+
+	w = getContent(router, "/test/file.txt", map[string]string{"Range": "bytes=0-4", "If-Range": `"QmFakeCID"`})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "Hello", w.Body.String())
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReferences is how many other paths of a CID DeleteFile reports when it keeps the CID pinned.
+const maxReferences = 10
+
+// DeleteFile removes the CID of filePath on-chain. With ?unpin=true the CID is also unpinned from
+// IPFS once the removal is mined, so the request waits for the transaction as with ?wait=true. The
+// pin is kept when the event index knows other paths holding the same CID, and unpinning needs the
+// index for that reason.
+func (h *Handlers) DeleteFile(c *gin.Context) {
+	filePath := c.Query("filePath")
+	if filePath == "" {
+		abortBadRequest(c, "Missing filePath query parameter")
+		return
+	}
+	if !h.allowWrite(c, filePath) {
+		return
+	}
+	unpin := false
+	if raw := c.Query("unpin"); raw != "" {
+		var err error
+		if unpin, err = strconv.ParseBool(raw); err != nil {
+			abortBadRequest(c, "Invalid unpin query parameter: "+raw)
+			return
+		}
+	}
+	confirmations, ok := h.requestedConfirmations(c)
+	if !ok {
+		return
+	}
+	if unpin && confirmations == 0 {
+		confirmations = h.Confirmations
+	}
+	if unpin && !h.requireIndex(c) {
+		return
+	}
+
+	cid, err := h.Contract.Get(filePath)
+	if err != nil {
+		abortContractError(c, "Contract get error: ", err)
+		return
+	}
+	if cid == "" {
+		abortError(c, http.StatusNotFound, CodeNotFound, "File not found: "+filePath)
+		return
+	}
+
+	txHash, err := h.Contract.Remove(filePath)
+	if err != nil {
+		abortContractError(c, "Contract remove error: ", err)
+		return
+	}
+	if confirmations == 0 {
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash})
+		return
+	}
+	status, ok := h.waitForTx(c, txHash, confirmations)
+	if !ok {
+		return
+	}
+	if !unpin {
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash, "tx": status})
+		return
+	}
+
+	// The removal is final at this point, so an unpin failure is reported without failing the request.
+	// The index may still hold the removed path itself when it lags behind the chain.
+	paths, err := h.Index.References(cid, maxReferences+1)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash, "tx": status, "unpinned": false, "unpinError": "Index error: " + err.Error()})
+		return
+	}
+	referencedBy := []string{}
+	for _, path := range paths {
+		if path != filePath && len(referencedBy) < maxReferences {
+			referencedBy = append(referencedBy, path)
+		}
+	}
+	if len(referencedBy) > 0 {
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash, "tx": status, "unpinned": false, "referencedBy": referencedBy})
+		return
+	}
+	if err := h.IPFSClient.Unpin(c, cid); err != nil {
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash, "tx": status, "unpinned": false, "unpinError": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash, "tx": status, "unpinned": true})
+}
@@ -0,0 +1,153 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+func deleteFile(router *gin.Engine, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/v1/files"+query, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func newDeleteMocks() (*mockContract, *mockIPFSClient) {
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "QmFakeCID", nil
+		},
+		removeFunc: func(filePath string) (string, error) {
+			return testTxHash, nil
+		},
+		waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+			return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, Confirmations: confirmations}, nil
+		},
+	}
+	return mockC, &mockIPFSClient{}
+}
+
+// referencesIndex is an index where the CID is held by the given paths.
+func referencesIndex(paths ...string) *mockIndex {
+	return &mockIndex{refsFunc: func(cid string, limit int) ([]string, error) {
+		return paths, nil
+	}}
+}
+
+// TestDeleteFile_Success tests that the removal transaction hash is returned.
+func TestDeleteFile_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newDeleteMocks()
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := deleteFile(router, "?filePath=/test/file.txt")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmFakeCID", resp["cid"])
+	assert.Equal(t, testTxHash, resp["txHash"])
+}
+
+// TestDeleteFile_Unpin tests that the CID is unpinned after the removal is mined.
+func TestDeleteFile_Unpin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newDeleteMocks()
+	var unpinned string
+	mockIPFS.unpinFunc = func(ctx context.Context, cid string) error {
+		unpinned = cid
+		return nil
+	}
+	// The index has not seen the removal yet.
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithIndex(referencesIndex("/test/file.txt")))
+
+	w := deleteFile(router, "?filePath=/test/file.txt&unpin=true")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "QmFakeCID", unpinned)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, true, resp["unpinned"])
+	assert.NotNil(t, resp["tx"])
+}
+
+// TestDeleteFile_UnpinError tests that an unpin failure is reported without failing the removal.
+func TestDeleteFile_UnpinError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newDeleteMocks()
+	mockIPFS.unpinFunc = func(ctx context.Context, cid string) error {
+		return errors.New("ipfs unavailable")
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithIndex(referencesIndex()))
+
+	w := deleteFile(router, "?filePath=/test/file.txt&unpin=true")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, false, resp["unpinned"])
+	assert.Contains(t, resp["unpinError"], "ipfs unavailable")
+}
+
+// TestDeleteFile_UnpinReferenced tests that a CID other paths still hold stays pinned.
+func TestDeleteFile_UnpinReferenced(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newDeleteMocks()
+	unpinned := false
+	mockIPFS.unpinFunc = func(ctx context.Context, cid string) error {
+		unpinned = true
+		return nil
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithIndex(referencesIndex("/team-b/copy.txt", "/test/file.txt")))
+
+	w := deleteFile(router, "?filePath=/test/file.txt&unpin=true")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, unpinned)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, false, resp["unpinned"])
+	assert.Equal(t, []interface{}{"/team-b/copy.txt"}, resp["referencedBy"])
+
+	// Without the index the references are unknown, so nothing is removed.
+	removed := false
+	mockC.removeFunc = func(filePath string) (string, error) {
+		removed = true
+		return testTxHash, nil
+	}
+	router = handlers.SetupRouter(mockC, mockIPFS)
+	w = deleteFile(router, "?filePath=/test/file.txt&unpin=true")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeIndexDisabled, errorCode(t, w))
+	assert.False(t, removed)
+}
+
+// TestDeleteFile_NotFound tests that removing an unknown path returns 404 without a transaction.
+func TestDeleteFile_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getFunc: func(filePath string) (string, error) {
+			return "", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	assert.Equal(t, http.StatusNotFound, deleteFile(router, "?filePath=/unknown.txt").Code)
+	assert.Equal(t, http.StatusBadRequest, deleteFile(router, "").Code)
+	assert.Equal(t, http.StatusBadRequest, deleteFile(router, "?filePath=/a&unpin=maybe").Code)
+}
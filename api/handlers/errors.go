@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of failures detected by the handlers themselves. Contract and node failures use the
+// codes of contracts.ErrorCode.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeNotFound            = string(contracts.CodeNotFound)
+	CodeRouteNotFound       = "route_not_found"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeRangeNotSatisfiable = "range_not_satisfiable"
+	CodeIPFSError           = "ipfs_error"
+	CodeIndexError          = "index_error"
+	CodeIndexDisabled       = "index_disabled"
+	CodeTxTimeout           = "tx_timeout"
+	CodeTxReverted          = "tx_reverted"
+	CodeTxCancelled         = "tx_cancelled"
+)
+
+// ErrorResponse is the body of every error answer. Code is stable and meant for programs; Message is
+// meant for people and may change.
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId"`
+}
+
+// abortError answers with an ErrorResponse and stops the handler chain.
+func abortError(c *gin.Context, status int, code, message string) {
+	abortErrorDetails(c, status, code, message, nil)
+}
+
+// abortErrorDetails is abortError with structured details.
+func abortErrorDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: RequestIDFrom(c),
+	})
+}
+
+// abortBadRequest answers 400 for malformed requests.
+func abortBadRequest(c *gin.Context, message string) {
+	abortError(c, http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+// contractStatuses maps contract error codes to HTTP statuses. Failures the client can fix are 4xx;
+// failures of the API account or the node are 5xx, with 503 for those worth retrying later.
+var contractStatuses = map[contracts.ErrorCode]int{
+	contracts.CodeInvalidArgument:   http.StatusBadRequest,
+	contracts.CodeUnauthorized:      http.StatusForbidden,
+	contracts.CodeNotFound:          http.StatusNotFound,
+	contracts.CodeConflict:          http.StatusConflict,
+	contracts.CodeReverted:          http.StatusUnprocessableEntity,
+	contracts.CodeGas:               http.StatusUnprocessableEntity,
+	contracts.CodeInsufficientFunds: http.StatusServiceUnavailable,
+	contracts.CodeNonce:             http.StatusServiceUnavailable,
+	contracts.CodeFeeTooHigh:        http.StatusServiceUnavailable,
+	contracts.CodeQuotaExceeded:     http.StatusTooManyRequests,
+	contracts.CodeRPCUnavailable:    http.StatusBadGateway,
+	contracts.CodeRPCTimeout:        http.StatusGatewayTimeout,
+	contracts.CodeInternal:          http.StatusInternalServerError,
+}
+
+// abortContractError answers a failed contract call or transaction with the status of its error
+// code, the code itself and the details of decoded reverts.
+func abortContractError(c *gin.Context, prefix string, err error) {
+	classified := contracts.Classify(err)
+	var details interface{}
+	if classified.Details != nil {
+		details = classified.Details
+	}
+	abortErrorDetails(c, contractStatus(classified.Code), string(classified.Code), prefix+err.Error(), details)
+}
+
+func contractStatus(code contracts.ErrorCode) int {
+	if status, ok := contractStatuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// routeNotFound answers requests to unknown routes.
+func routeNotFound(c *gin.Context) {
+	abortError(c, http.StatusNotFound, CodeRouteNotFound, "No route for "+c.Request.Method+" "+c.Request.URL.Path)
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+// TestUploadFile_ContractErrorCodes tests that contract failures are answered with their error code
+// and the matching status.
+func TestUploadFile_ContractErrorCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		err    error
+		status int
+		code   contracts.ErrorCode
+	}{
+		{errors.New("insufficient funds for gas * price + value"), http.StatusServiceUnavailable, contracts.CodeInsufficientFunds},
+		{errors.New("nonce too low"), http.StatusServiceUnavailable, contracts.CodeNonce},
+		{errors.New("gas required exceeds allowance (30000000)"), http.StatusUnprocessableEntity, contracts.CodeGas},
+		{errors.New("dial tcp 127.0.0.1:8545: connect: connection refused"), http.StatusBadGateway, contracts.CodeRPCUnavailable},
+		{fmt.Errorf("%w: 100 wei", contracts.ErrFeeCapExceeded), http.StatusServiceUnavailable, contracts.CodeFeeTooHigh},
+		{&contracts.RevertError{Name: "Error", Args: map[string]interface{}{"reason": "paused"}}, http.StatusUnprocessableEntity, contracts.CodeReverted},
+	}
+	for _, tc := range cases {
+		mockC, mockIPFS := newWaitMocks(nil)
+		mockC.saveFunc = func(filePath, cid string) (string, error) {
+			return "", tc.err
+		}
+		router := handlers.SetupRouter(mockC, mockIPFS)
+
+		w := postUpload(router, "")
+		assert.Equal(t, tc.status, w.Code, tc.err.Error())
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, string(tc.code), resp["code"], tc.err.Error())
+	}
+}
+
+// TestUploadFile_RevertDetails tests that decoded custom errors are returned as details.
+func TestUploadFile_RevertDetails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newWaitMocks(nil)
+	mockC.saveFunc = func(filePath, cid string) (string, error) {
+		return "", &contracts.RevertError{Name: "NotAuthorized", Args: map[string]interface{}{"path": filePath}}
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := postUpload(router, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var resp struct {
+		Code    string `json:"code"`
+		Details struct {
+			Error string            `json:"error"`
+			Args  map[string]string `json:"args"`
+		} `json:"details"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unauthorized", resp.Code)
+	assert.Equal(t, "NotAuthorized", resp.Details.Error)
+	assert.Equal(t, "/test/file.txt", resp.Details.Args["path"])
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avkos/file-registry/api/events"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// EventStream streams FileSaved events as they are mined.
+type EventStream interface {
+	Subscribe(ctx context.Context, prefix string, from *events.Position) (<-chan events.Event, error)
+}
+
+// Error codes of the event stream endpoints.
+const (
+	CodeEventsDisabled = "events_disabled"
+	CodeEventsError    = "events_error"
+)
+
+// Event names of the stream endpoints.
+const (
+	// EventFileSaved is the event name of FileSaved events.
+	EventFileSaved = "file.saved"
+	// EventFileSavedRemoved is the event name of FileSaved events dropped by a chain reorganisation.
+	EventFileSavedRemoved = "file.saved.removed"
+)
+
+// eventKeepAlive is how often an idle stream sends a comment or ping, so that proxies keep it open.
+const eventKeepAlive = 15 * time.Second
+
+// WithEvents enables the event stream endpoints.
+func WithEvents(stream EventStream) Option {
+	return func(h *Handlers) {
+		h.Events = stream
+	}
+}
+
+// WithEventOrigins sets the origins of the pages allowed to open WebSocket event streams, besides
+// pages served from the host of the API. "*" allows every origin.
+func WithEventOrigins(origins []string) Option {
+	return func(h *Handlers) {
+		h.EventOrigins = origins
+	}
+}
+
+// checkOrigin accepts WebSocket requests without an Origin header, from the host of the API and from
+// the event origins.
+func (h *Handlers) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.EventOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// StreamEvents sends FileSaved events as Server-Sent Events while they are mined. The prefix query
+// parameter selects the files. A client resumes after the last event it got with the Last-Event-ID
+// header or the lastEventId query parameter, or starts at a block with the fromBlock query parameter.
+// Events the client got whose block was dropped by a chain reorganisation are sent again as
+// file.saved.removed, with the ID to resume at their position.
+func (h *Handlers) StreamEvents(c *gin.Context) {
+	stream, ok := h.subscribeEvents(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			name := EventFileSaved
+			if event.Removed {
+				name = EventFileSavedRemoved
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ResumeID(), name, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// StreamEventsWebSocket sends FileSaved events as JSON WebSocket messages while they are mined. It
+// takes the query parameters of StreamEvents; the event ID of every message is the lastEventId to
+// resume after it. Events dropped by a chain reorganisation are sent again with removed set; a
+// client resumes after them at their position, see events.Event.ResumeID.
+func (h *Handlers) StreamEventsWebSocket(c *gin.Context) {
+	stream, ok := h.subscribeEvents(c)
+	if !ok {
+		return
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader answered the request.
+		return
+	}
+	defer conn.Close()
+
+	// Messages of the client are discarded; reading them handles pings and notices the close.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventKeepAlive)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// subscribeEvents subscribes to the events of the request, answering an error and returning false
+// when the request is invalid. The stream ends with the request.
+func (h *Handlers) subscribeEvents(c *gin.Context) (<-chan events.Event, bool) {
+	prefix := c.Query("prefix")
+	if !allowPath(c, prefix) || !h.requireEvents(c) {
+		return nil, false
+	}
+	from, ok := eventPosition(c)
+	if !ok {
+		return nil, false
+	}
+	stream, err := h.Events.Subscribe(c.Request.Context(), prefix, from)
+	if errors.Is(err, events.ErrBacklogTooLong) {
+		abortBadRequest(c, "Invalid stream start: "+err.Error())
+		return nil, false
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeEventsError, "Failed to subscribe to events: "+err.Error())
+		return nil, false
+	}
+	return stream, true
+}
+
+// eventPosition returns the position a stream starts at, nil for live events only. The
+// Last-Event-ID header wins over the query parameters, as EventSource reconnects with the URL it
+// was opened with.
+func eventPosition(c *gin.Context) (*events.Position, bool) {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("lastEventId")
+	}
+	if id != "" {
+		last, err := events.ParseID(id)
+		if err != nil {
+			abortBadRequest(c, "Invalid last event ID: "+id)
+			return nil, false
+		}
+		from := last.Next()
+		return &from, true
+	}
+	if raw := c.Query("fromBlock"); raw != "" {
+		block, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			abortBadRequest(c, "Invalid fromBlock query parameter: "+raw)
+			return nil, false
+		}
+		return &events.Position{Block: block}, true
+	}
+	return nil, true
+}
+
+// requireEvents answers 503 and returns false when the event streams are disabled.
+func (h *Handlers) requireEvents(c *gin.Context) bool {
+	if h.Events == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeEventsDisabled, "Event streams are not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/events"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+// mockEventStream sends its events to every subscriber, then waits for the subscriber to leave.
+type mockEventStream struct {
+	events []events.Event
+	err    error
+	prefix string
+	from   *events.Position
+}
+
+func (m *mockEventStream) Subscribe(ctx context.Context, prefix string, from *events.Position) (<-chan events.Event, error) {
+	m.prefix, m.from = prefix, from
+	if m.err != nil {
+		return nil, m.err
+	}
+	stream := make(chan events.Event)
+	go func() {
+		defer close(stream)
+		for _, event := range m.events {
+			select {
+			case stream <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return stream, nil
+}
+
+func testEvents() []events.Event {
+	return []events.Event{
+		{ID: "12-0", FilePath: "/team-a/one.txt", CID: "QmOne", BlockNumber: 12},
+		{ID: "12-1", FilePath: "/team-a/two.txt", CID: "QmTwo", BlockNumber: 12, LogIndex: 1},
+	}
+}
+
+// TestStreamEvents tests that FileSaved events are sent as Server-Sent Events and resumed after the
+// Last-Event-ID.
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	stream := &mockEventStream{events: testEvents()}
+	server := httptest.NewServer(handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(stream)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/events/stream?prefix=/team-a/&fromBlock=3", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "11-4")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 6 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "id: 12-0", lines[0])
+	assert.Equal(t, "event: file.saved", lines[1])
+	var event events.Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event))
+	assert.Equal(t, "/team-a/one.txt", event.FilePath)
+	assert.Equal(t, "QmOne", event.CID)
+	assert.Equal(t, "id: 12-1", lines[3])
+
+	assert.Equal(t, "/team-a/", stream.prefix)
+	assert.Equal(t, &events.Position{Block: 11, Index: 5}, stream.from)
+}
+
+// TestStreamEvents_Errors tests that invalid positions, disallowed prefixes and disabled streams
+// are rejected before streaming.
+func TestStreamEvents_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	stream := &mockEventStream{}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(stream), handlers.WithAPIKeys(newKeyRing(t)))
+
+	w := authRequest(router, http.MethodGet, "/v1/events/stream?prefix=/team-a/&lastEventId=bad", "team-a-key", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = authRequest(router, http.MethodGet, "/v1/events/stream?prefix=/team-a/&fromBlock=-1", "team-a-key", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = authRequest(router, http.MethodGet, "/v1/events/ws?prefix=/team-b/", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+	w = authRequest(router, http.MethodGet, "/v1/events/stream", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	stream.err = fmt.Errorf("%w: the earliest block is 100", events.ErrBacklogTooLong)
+	w = authRequest(router, http.MethodGet, "/v1/events/stream?prefix=/team-a/&fromBlock=0", "team-a-key", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "the earliest block is 100")
+
+	router = handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w = getPath(router, "/v1/events/stream")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeEventsDisabled, errorCode(t, w))
+}
+
+// TestStreamEventsWebSocket tests that FileSaved events are sent as JSON WebSocket messages.
+func TestStreamEventsWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	stream := &mockEventStream{events: testEvents()}
+	server := httptest.NewServer(handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(stream)))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/events/ws?prefix=/team-a/&fromBlock=3"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	for _, want := range testEvents() {
+		var event events.Event
+		require.NoError(t, conn.ReadJSON(&event))
+		assert.Equal(t, want, event)
+	}
+	assert.Equal(t, "/team-a/", stream.prefix)
+	assert.Equal(t, &events.Position{Block: 3}, stream.from)
+}
+
+// TestStreamEvents_Removed tests that events dropped by a reorganisation are sent with their own event
+// name and the ID to resume at their position.
+func TestStreamEvents_Removed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	removed := testEvents()[1]
+	removed.Removed = true
+	stream := &mockEventStream{events: []events.Event{removed}}
+	server := httptest.NewServer(handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(stream)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/events/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "id: 12-0", lines[0])
+	assert.Equal(t, "event: file.saved.removed", lines[1])
+	assert.Contains(t, lines[2], `"id":"12-1"`)
+	assert.Contains(t, lines[2], `"removed":true`)
+}
+
+// TestStreamEventsWebSocket_Origins tests that WebSocket streams are only opened from the API host
+// and the configured origins.
+func TestStreamEventsWebSocket_Origins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dial := func(server *httptest.Server, origin string) (*http.Response, error) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/events/ws"
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+
+	server := httptest.NewServer(handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(&mockEventStream{})))
+	defer server.Close()
+	_, err := dial(server, server.URL)
+	assert.NoError(t, err)
+	resp, err := dial(server, "https://dashboard.example.com")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	server = httptest.NewServer(handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithEvents(&mockEventStream{}),
+		handlers.WithEventOrigins([]string{"https://dashboard.example.com"})))
+	defer server.Close()
+	_, err = dial(server, "https://dashboard.example.com")
+	assert.NoError(t, err)
+	_, err = dial(server, "https://other.example.com")
+	assert.Error(t, err)
+}
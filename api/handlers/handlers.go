@@ -1,24 +1,112 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 )
 
 type Contract interface {
 	Save(filePath string, cid string) (string, error)
+	SaveBatch(paths, cids []string) ([]contracts.BatchSave, error)
+	Remove(filePath string) (string, error)
 	Get(filePath string) (string, error)
+	GetAt(filePath string, block uint64) (string, error)
+	TxStatus(ctx context.Context, txHash string) (*contracts.TxStatus, error)
+	WaitMined(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error)
+	SpeedUp(ctx context.Context, txHash string) (string, error)
+	Cancel(ctx context.Context, txHash string) (string, error)
+	ClaimNamespace(namespace string) (string, error)
+	GrantWriter(path string, account common.Address) (string, error)
+	RevokeWriter(path string, account common.Address) (string, error)
+	TransferOwnership(path string, newOwner common.Address) (string, error)
+	Access(path string, account *common.Address) (*contracts.Access, error)
 }
 
 type IPFSClient interface {
 	Add(ctx *gin.Context, file []byte) (string, error)
+	AddReader(ctx context.Context, r io.Reader) (string, error)
+	Stat(ctx context.Context, cid string) (int64, time.Time, error)
+	CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+	Unpin(ctx context.Context, cid string) error
 }
 
+// FileIndex is the local database of FileSaved events built by the indexer.
+type FileIndex interface {
+	History(filePath, cursor string, limit int) ([]indexer.Event, string, error)
+	At(filePath string, at time.Time) (*indexer.Event, error)
+	List(prefix, cursor string, depth, limit int) ([]indexer.Entry, string, error)
+	References(cid string, limit int) ([]string, error)
+}
+
+// DefaultMaxUploadSize is the upload body limit used when none is configured.
+const DefaultMaxUploadSize int64 = 1 << 30
+
+// DefaultTxWaitTimeout bounds how long an upload with ?wait=true waits for its transaction.
+const DefaultTxWaitTimeout = 2 * time.Minute
+
 type Handlers struct {
-	Contract   Contract
-	IPFSClient IPFSClient
+	Contract      Contract
+	IPFSClient    IPFSClient
+	MaxUploadSize int64
+	BatchWorkers  int   // files of a batch upload added to IPFS at a time
+	MaxBatchSize  int64 // body limit of batch uploads, which are held in memory
+	Confirmations uint64
+	TxWaitTimeout time.Duration
+	Index         FileIndex      // nil when the indexer is disabled
+	Keys          *auth.KeyRing  // nil when API keys are disabled
+	Sessions      *auth.Sessions // nil when Sign-In with Ethereum is disabled
+	SIWEDomain    string         // domain sign-in messages must name
+	UploadDomain  *auth.UploadDomain
+	Signers       SignerStore  // nil when the wallets of saves are not recorded
+	Relayer       Relayer      // nil when relaying is disabled
+	Accounts      AccountPool  // nil when the account pool is not exposed
+	Metrics       http.Handler // nil when metrics are disabled
+	Outbox        Outbox       // nil when uploads are saved synchronously
+	Webhooks      Webhooks     // nil when webhooks are disabled
+	Events        EventStream  // nil when event streams are disabled
+	EventOrigins  []string     // origins allowed to open WebSocket event streams besides the API host
+}
+
+// Option configures optional Handlers settings in SetupRouter.
+type Option func(*Handlers)
+
+// WithConfirmations sets the confirmation depth and timeout used by uploads with ?wait=true.
+// Zero values keep the defaults.
+func WithConfirmations(depth uint64, timeout time.Duration) Option {
+	return func(h *Handlers) {
+		if depth > 0 {
+			h.Confirmations = depth
+		}
+		if timeout > 0 {
+			h.TxWaitTimeout = timeout
+		}
+	}
+}
+
+// WithIndex enables the endpoints served from the event index.
+func WithIndex(index FileIndex) Option {
+	return func(h *Handlers) {
+		h.Index = index
+	}
+}
+
+// WithMaxUploadSize limits the size of upload request bodies. Non-positive values keep the default.
+func WithMaxUploadSize(n int64) Option {
+	return func(h *Handlers) {
+		if n > 0 {
+			h.MaxUploadSize = n
+		}
+	}
 }
 
 type FileUploadRequest struct {
@@ -26,58 +114,205 @@ type FileUploadRequest struct {
 	FileB64  string `json:"file"`
 }
 
+// UploadFile accepts a base64 file in a JSON body, a multipart/form-data body with filePath and file
+// fields, or a raw application/octet-stream body with the filePath query parameter.
+// With ?async=true the upload is answered 202 with its job as soon as it is stored in the outbox.
 func (h *Handlers) UploadFile(c *gin.Context) {
+	if _, ok := h.requestedAsync(c); !ok {
+		return
+	}
+	body, ok := limitBody(c, h.MaxUploadSize)
+	if !ok {
+		return
+	}
+	switch c.ContentType() {
+	case "multipart/form-data":
+		h.uploadMultipart(c, body)
+		return
+	case "application/octet-stream":
+		h.uploadRaw(c, c.Query("filePath"), body)
+		return
+	}
+
 	var req FileUploadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse JSON: " + err.Error()})
+		if body.tooLarge {
+			abortTooLarge(c, body.limit)
+			return
+		}
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
 		return
 	}
 	if req.FilePath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing filePath query parameter"})
+		abortBadRequest(c, "Missing filePath field")
+		return
+	}
+	if !h.allowWrite(c, req.FilePath) {
 		return
 	}
 	fileBytes, err := base64.StdEncoding.DecodeString(req.FileB64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid base64 data: " + err.Error()})
+		abortBadRequest(c, "Invalid base64 data: "+err.Error())
+		return
+	}
+	if h.Outbox != nil {
+		h.enqueueUpload(c, req.FilePath, bytes.NewReader(fileBytes), body)
 		return
 	}
 
 	cid, err := h.IPFSClient.Add(c, fileBytes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "IPFS Add error: " + err.Error()})
+		abortError(c, http.StatusInternalServerError, CodeIPFSError, "IPFS Add error: "+err.Error())
+		return
+	}
+
+	h.saveFile(c, req.FilePath, cid)
+}
+
+// saveFile stores the CID on-chain and writes the upload response. With ?wait=true or ?confirmations=N
+// the response is only sent once the transaction is mined with enough confirmations.
+func (h *Handlers) saveFile(c *gin.Context, filePath, cid string) {
+	confirmations, ok := h.requestedConfirmations(c)
+	if !ok {
 		return
 	}
 
-	txHash, err := h.Contract.Save(req.FilePath, cid)
+	txHash, err := h.Contract.Save(filePath, cid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Contract save error: " + err.Error()})
+		abortContractError(c, "Contract save error: ", err)
+		return
+	}
+
+	response := gin.H{"cid": cid, "txHash": txHash}
+	signer := WalletFrom(c)
+	if signer != nil {
+		response["signer"] = signer.Hex()
+	}
+	if confirmations == 0 {
+		h.watchSave(txHash, filePath, cid, signer)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	status, ok := h.waitForTx(c, txHash, confirmations)
+	if status != nil {
+		h.saveFinished(filePath, cid, signer, status)
+	} else {
+		// The wait timed out or failed, so the outcome is reported once the transaction is final.
+		h.watchSave(txHash, filePath, cid, signer)
+	}
+	if !ok {
 		return
 	}
+	response["tx"] = status
+	c.JSON(http.StatusOK, response)
+}
 
-	c.JSON(http.StatusOK, gin.H{"cid": cid, "txHash": txHash})
+// saveFinished records the wallet of a save sent for a wallet and reports the outcome of the save to
+// the webhooks, once its transaction is final.
+func (h *Handlers) saveFinished(filePath, cid string, signer *common.Address, status *contracts.TxStatus) {
+	if signer != nil {
+		h.recordSigner(status, *signer)
+	}
+	if h.Webhooks != nil {
+		h.Webhooks.SaveFinished(filePath, cid, status)
+	}
 }
 
+// watchSave calls saveFinished in the background once the transaction of a save is final, when there
+// is anything to record or report.
+func (h *Handlers) watchSave(txHash, filePath, cid string, signer *common.Address) {
+	if (signer == nil || h.Signers == nil) && h.Webhooks == nil {
+		return
+	}
+	h.watchTx(txHash, func(status *contracts.TxStatus) {
+		h.saveFinished(filePath, cid, signer, status)
+	})
+}
+
+// GetFile returns the CID stored for filePath. With ?block=N the CID is read from the state at that
+// block, and with ?at=<RFC3339> it is looked up in the event history. Without filePath, ?prefix=
+// lists the files below a prefix.
 func (h *Handlers) GetFile(c *gin.Context) {
 	filePath := c.Query("filePath")
+	if _, ok := c.GetQuery("prefix"); ok && filePath == "" {
+		h.ListFiles(c)
+		return
+	}
 
 	if filePath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing filePath query parameter"})
+		abortBadRequest(c, "Missing filePath query parameter")
+		return
+	}
+	if !allowPath(c, filePath) {
+		return
+	}
+	if c.Query("block") != "" || c.Query("at") != "" {
+		h.getFileAt(c, filePath)
 		return
 	}
 
 	cid, err := h.Contract.Get(filePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Contract get error: " + err.Error()})
+		abortContractError(c, "Contract get error: ", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"cid": cid})
 }
 
-func SetupRouter(contract Contract, ipfsClient IPFSClient) *gin.Engine {
-	h := &Handlers{Contract: contract, IPFSClient: ipfsClient}
+func SetupRouter(contract Contract, ipfsClient IPFSClient, opts ...Option) *gin.Engine {
+	h := &Handlers{
+		Contract:      contract,
+		IPFSClient:    ipfsClient,
+		MaxUploadSize: DefaultMaxUploadSize,
+		BatchWorkers:  DefaultBatchWorkers,
+		MaxBatchSize:  DefaultMaxBatchSize,
+		Confirmations: 1,
+		TxWaitTimeout: DefaultTxWaitTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
 	router := gin.Default()
-	router.POST("/v1/files", h.UploadFile)
-	router.GET("/v1/files", h.GetFile)
+	router.Use(RequestID())
+	router.NoRoute(routeNotFound)
+	read := h.requireScope(auth.ScopeFilesRead)
+	write := h.requireScope(auth.ScopeFilesWrite)
+	admin := h.requireScope(auth.ScopeAdmin)
+	router.GET("/v1/auth/nonce", h.GetNonce)
+	router.POST("/v1/auth/login", h.Login)
+	router.POST("/v1/files", write, h.UploadFile)
+	router.POST("/v1/files/signed", write, h.UploadSigned)
+	router.POST("/v1/files/batch", write, h.UploadBatch)
+	router.PUT("/v1/files/*path", write, h.PutFile)
+	router.GET("/v1/files", read, h.GetFile)
+	router.DELETE("/v1/files", write, h.DeleteFile)
+	router.GET("/v1/files/content", read, h.GetFileContent)
+	router.GET("/v1/files/history", read, h.GetFileHistory)
+	router.GET("/v1/files/tree", read, h.GetFileTree)
+	router.GET("/v1/jobs", read, h.ListJobs)
+	router.GET("/v1/jobs/:id", read, h.GetJob)
+	router.GET("/v1/tx/:hash", read, h.GetTx)
+	router.POST("/v1/tx/:hash/speedup", admin, h.SpeedUpTx)
+	router.POST("/v1/tx/:hash/cancel", admin, h.CancelTx)
+	router.GET("/v1/acl", read, h.GetAccess)
+	router.POST("/v1/acl/namespaces", admin, h.ClaimNamespace)
+	router.POST("/v1/acl/writers", admin, h.GrantWriter)
+	router.DELETE("/v1/acl/writers", admin, h.RevokeWriter)
+	router.PUT("/v1/acl/owner", admin, h.TransferOwnership)
+	router.GET("/v1/relay/nonce", h.GetRelayNonce)
+	router.POST("/v1/relay", write, h.Relay)
+	router.GET("/v1/accounts", admin, h.GetAccounts)
+	router.POST("/v1/webhooks", admin, h.CreateWebhook)
+	router.GET("/v1/webhooks", admin, h.ListWebhooks)
+	router.GET("/v1/webhooks/:id", admin, h.GetWebhook)
+	router.DELETE("/v1/webhooks/:id", admin, h.DeleteWebhook)
+	router.GET("/v1/webhooks/:id/deliveries", admin, h.GetWebhookDeliveries)
+	router.POST("/v1/webhooks/:id/deliveries/:deliveryId/replay", admin, h.ReplayWebhookDelivery)
+	router.GET("/v1/events/stream", read, h.StreamEvents)
+	router.GET("/v1/events/ws", read, h.StreamEventsWebSocket)
+	if h.Metrics != nil {
+		router.GET("/metrics", admin, gin.WrapH(h.Metrics))
+	}
 	return router
 }
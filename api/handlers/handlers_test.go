@@ -2,42 +2,127 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/avkos/file-registry/api/contracts"
 	"github.com/avkos/file-registry/api/handlers"
 )
 
 // Mock implementations for Contract and IPFSClient
 type mockContract struct {
-	saveFunc func(filePath, cid string) (string, error)
-	getFunc  func(filePath string) (string, error)
+	saveFunc      func(filePath, cid string) (string, error)
+	saveBatchFunc func(paths, cids []string) ([]contracts.BatchSave, error)
+	removeFunc    func(filePath string) (string, error)
+	getFunc       func(filePath string) (string, error)
+	getAtFunc     func(filePath string, block uint64) (string, error)
+	txStatusFunc  func(ctx context.Context, txHash string) (*contracts.TxStatus, error)
+	waitMinedFunc func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error)
+	speedUpFunc   func(ctx context.Context, txHash string) (string, error)
+	cancelFunc    func(ctx context.Context, txHash string) (string, error)
+	claimFunc     func(namespace string) (string, error)
+	grantFunc     func(path string, account common.Address) (string, error)
+	revokeFunc    func(path string, account common.Address) (string, error)
+	transferFunc  func(path string, newOwner common.Address) (string, error)
+	accessFunc    func(path string, account *common.Address) (*contracts.Access, error)
 }
 
 func (m *mockContract) Save(filePath, cid string) (string, error) {
 	return m.saveFunc(filePath, cid)
 }
 
+func (m *mockContract) SaveBatch(paths, cids []string) ([]contracts.BatchSave, error) {
+	return m.saveBatchFunc(paths, cids)
+}
+
+func (m *mockContract) Remove(filePath string) (string, error) {
+	return m.removeFunc(filePath)
+}
+
 func (m *mockContract) Get(filePath string) (string, error) {
 	return m.getFunc(filePath)
 }
 
+func (m *mockContract) GetAt(filePath string, block uint64) (string, error) {
+	return m.getAtFunc(filePath, block)
+}
+
+func (m *mockContract) TxStatus(ctx context.Context, txHash string) (*contracts.TxStatus, error) {
+	return m.txStatusFunc(ctx, txHash)
+}
+
+func (m *mockContract) WaitMined(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+	return m.waitMinedFunc(ctx, txHash, confirmations)
+}
+
+func (m *mockContract) SpeedUp(ctx context.Context, txHash string) (string, error) {
+	return m.speedUpFunc(ctx, txHash)
+}
+
+func (m *mockContract) Cancel(ctx context.Context, txHash string) (string, error) {
+	return m.cancelFunc(ctx, txHash)
+}
+
+func (m *mockContract) ClaimNamespace(namespace string) (string, error) {
+	return m.claimFunc(namespace)
+}
+
+func (m *mockContract) GrantWriter(path string, account common.Address) (string, error) {
+	return m.grantFunc(path, account)
+}
+
+func (m *mockContract) RevokeWriter(path string, account common.Address) (string, error) {
+	return m.revokeFunc(path, account)
+}
+
+func (m *mockContract) TransferOwnership(path string, newOwner common.Address) (string, error) {
+	return m.transferFunc(path, newOwner)
+}
+
+func (m *mockContract) Access(path string, account *common.Address) (*contracts.Access, error) {
+	return m.accessFunc(path, account)
+}
+
 type mockIPFSClient struct {
-	addFunc func(ctx *gin.Context, file []byte) (string, error)
+	addFunc       func(ctx *gin.Context, file []byte) (string, error)
+	statFunc      func(ctx context.Context, cid string) (int64, time.Time, error)
+	catRangeFunc  func(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+	addReaderFunc func(ctx context.Context, r io.Reader) (string, error)
+	unpinFunc     func(ctx context.Context, cid string) error
 }
 
 func (m *mockIPFSClient) Add(ctx *gin.Context, file []byte) (string, error) {
 	return m.addFunc(ctx, file)
 }
 
+func (m *mockIPFSClient) AddReader(ctx context.Context, r io.Reader) (string, error) {
+	return m.addReaderFunc(ctx, r)
+}
+
+func (m *mockIPFSClient) Stat(ctx context.Context, cid string) (int64, time.Time, error) {
+	return m.statFunc(ctx, cid)
+}
+
+func (m *mockIPFSClient) Unpin(ctx context.Context, cid string) error {
+	return m.unpinFunc(ctx, cid)
+}
+
+func (m *mockIPFSClient) CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	return m.catRangeFunc(ctx, cid, offset, length)
+}
+
 // TestUploadFile_Success tests that uploading a file returns a 200 status and the expected CID.
 func TestUploadFile_Success(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -95,7 +180,7 @@ func TestUploadFile_InvalidBase64(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Contains(t, resp["error"], "Invalid base64 data")
+	assert.Contains(t, resp["message"], "Invalid base64 data")
 }
 
 // TestUploadFile_ContractError tests that if the contract save fails, we return 500.
@@ -128,7 +213,7 @@ func TestUploadFile_ContractError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Contains(t, resp["error"], "contract save failed")
+	assert.Contains(t, resp["message"], "contract save failed")
 }
 
 func TestUploadFile_MissingFilePath(t *testing.T) {
@@ -152,7 +237,8 @@ func TestUploadFile_MissingFilePath(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Contains(t, resp["error"], "Missing filePath query parameter")
+	assert.Contains(t, resp["message"], "Missing filePath field")
+	assert.Equal(t, handlers.CodeInvalidRequest, resp["code"])
 }
 
 // TestGetFile_Success tests that retrieving a file CID returns 200 and correct CID.
@@ -195,7 +281,7 @@ func TestGetFile_MissingFilePath(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Contains(t, resp["error"], "Missing filePath query parameter")
+	assert.Contains(t, resp["message"], "Missing filePath query parameter")
 }
 
 // TestGetFile_ContractError tests that if contract get fails, returns 500.
@@ -218,5 +304,37 @@ func TestGetFile_ContractError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Contains(t, resp["error"], "contract get failed")
+	assert.Contains(t, resp["message"], "contract get failed")
+}
+
+// TestUploadFile_FeeBudgetExceeded tests that a transaction over the fee budget returns 503.
+func TestUploadFile_FeeBudgetExceeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			return "", fmt.Errorf("%w: 100 wei, budget 10 wei", contracts.ErrBudgetExceeded)
+		},
+	}
+	mockIPFS := &mockIPFSClient{
+		addFunc: func(ctx *gin.Context, file []byte) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	fileB64 := base64.StdEncoding.EncodeToString([]byte("Hello World!"))
+	body := []byte(`{"filePath":"/test/file.txt","file":"` + fileB64 + `"}`)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Contains(t, resp["message"], "budget")
 }
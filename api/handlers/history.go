@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of paginated endpoints.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// GetFileHistory lists every CID a path has held, oldest first, with the block, time, transaction and
+// sender of each save. Results are paginated with the limit and cursor query parameters; the response
+// carries nextCursor until the last page.
+func (h *Handlers) GetFileHistory(c *gin.Context) {
+	filePath := c.Query("filePath")
+	if filePath == "" {
+		abortBadRequest(c, "Missing filePath query parameter")
+		return
+	}
+	if !allowPath(c, filePath) {
+		return
+	}
+	if !h.requireIndex(c) {
+		return
+	}
+	limit, ok := pageSize(c)
+	if !ok {
+		return
+	}
+
+	events, next, err := h.Index.History(filePath, c.Query("cursor"), limit)
+	if errors.Is(err, indexer.ErrInvalidCursor) {
+		abortBadRequest(c, "Invalid cursor query parameter: "+c.Query("cursor"))
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIndexError, "Index error: "+err.Error())
+		return
+	}
+	if events == nil {
+		events = []indexer.Event{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"filePath": filePath, "history": events, "nextCursor": next})
+}
+
+// getFileAt answers a point-in-time lookup: ?block=N reads the contract state at that block and
+// ?at=<RFC3339> takes the last save mined at or before that time from the event history.
+func (h *Handlers) getFileAt(c *gin.Context, filePath string) {
+	rawBlock, rawAt := c.Query("block"), c.Query("at")
+	if rawBlock != "" && rawAt != "" {
+		abortBadRequest(c, "Use either the block or the at query parameter")
+		return
+	}
+
+	if rawBlock != "" {
+		block, err := strconv.ParseUint(rawBlock, 10, 64)
+		if err != nil {
+			abortBadRequest(c, "Invalid block query parameter: "+rawBlock)
+			return
+		}
+		cid, err := h.Contract.GetAt(filePath, block)
+		if err != nil {
+			abortContractError(c, "Contract get error: ", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"cid": cid, "block": block})
+		return
+	}
+
+	at, err := time.Parse(time.RFC3339, rawAt)
+	if err != nil {
+		abortBadRequest(c, "Invalid at query parameter: "+rawAt)
+		return
+	}
+	if !h.requireIndex(c) {
+		return
+	}
+	event, err := h.Index.At(filePath, at)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIndexError, "Index error: "+err.Error())
+		return
+	}
+	if event == nil {
+		c.JSON(http.StatusOK, gin.H{"cid": "", "at": at})
+		return
+	}
+	if event.Removed {
+		c.JSON(http.StatusOK, gin.H{"cid": "", "at": at, "block": event.BlockNumber, "txHash": event.TxHash, "removed": true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cid": event.CID, "at": at, "block": event.BlockNumber, "txHash": event.TxHash})
+}
+
+// requireIndex answers 503 and returns false when the event indexer is disabled.
+func (h *Handlers) requireIndex(c *gin.Context) bool {
+	if h.Index == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeIndexDisabled, "Event index is not enabled")
+		return false
+	}
+	return true
+}
+
+// pageSize reads the limit query parameter, answering 400 and returning false when it is malformed.
+func pageSize(c *gin.Context) (int, bool) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return defaultPageSize, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 || n > maxPageSize {
+		abortBadRequest(c, "Invalid limit query parameter: "+raw)
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,169 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/indexer"
+)
+
+type mockIndex struct {
+	historyFunc func(filePath, cursor string, limit int) ([]indexer.Event, string, error)
+	atFunc      func(filePath string, at time.Time) (*indexer.Event, error)
+	listFunc    func(prefix, cursor string, depth, limit int) ([]indexer.Entry, string, error)
+	refsFunc    func(cid string, limit int) ([]string, error)
+}
+
+func (m *mockIndex) History(filePath, cursor string, limit int) ([]indexer.Event, string, error) {
+	return m.historyFunc(filePath, cursor, limit)
+}
+
+func (m *mockIndex) At(filePath string, at time.Time) (*indexer.Event, error) {
+	return m.atFunc(filePath, at)
+}
+
+func (m *mockIndex) List(prefix, cursor string, depth, limit int) ([]indexer.Entry, string, error) {
+	return m.listFunc(prefix, cursor, depth, limit)
+}
+
+func (m *mockIndex) References(cid string, limit int) ([]string, error) {
+	return m.refsFunc(cid, limit)
+}
+
+func getPath(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestGetFileHistory_Success tests that a page of history is returned with the next cursor.
+func TestGetFileHistory_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	index := &mockIndex{
+		historyFunc: func(filePath, cursor string, limit int) ([]indexer.Event, string, error) {
+			assert.Equal(t, "/test/file.txt", filePath)
+			assert.Equal(t, "abc", cursor)
+			assert.Equal(t, 2, limit)
+			return []indexer.Event{
+				{FilePath: filePath, CID: "QmFirst", BlockNumber: 3, TxHash: "0x01", Timestamp: at, Sender: "0xSender"},
+				{FilePath: filePath, CID: "QmSecond", BlockNumber: 7, TxHash: "0x02", Timestamp: at, Sender: "0xSender"},
+			}, "def", nil
+		},
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(index))
+
+	w := getPath(router, "/v1/files/history?filePath=/test/file.txt&limit=2&cursor=abc")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		History    []indexer.Event `json:"history"`
+		NextCursor string          `json:"nextCursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.History, 2)
+	assert.Equal(t, "QmSecond", resp.History[1].CID)
+	assert.Equal(t, at, resp.History[0].Timestamp)
+	assert.Equal(t, "0xSender", resp.History[0].Sender)
+	assert.Equal(t, "def", resp.NextCursor)
+}
+
+// TestGetFileHistory_BadRequests tests missing paths, bad limits and bad cursors.
+func TestGetFileHistory_BadRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	index := &mockIndex{
+		historyFunc: func(filePath, cursor string, limit int) ([]indexer.Event, string, error) {
+			return nil, "", indexer.ErrInvalidCursor
+		},
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(index))
+
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files/history").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files/history?filePath=/a&limit=0").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files/history?filePath=/a&limit=1000").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files/history?filePath=/a&cursor=zz").Code)
+}
+
+// TestGetFileHistory_IndexDisabled tests that 503 is returned when the indexer is not configured.
+func TestGetFileHistory_IndexDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	w := getPath(router, "/v1/files/history?filePath=/a")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// TestGetFile_AtBlock tests that ?block=N reads the contract state at that block.
+func TestGetFile_AtBlock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getAtFunc: func(filePath string, block uint64) (string, error) {
+			assert.Equal(t, uint64(42), block)
+			return "QmOldCID", nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := getPath(router, "/v1/files?filePath=/test/file.txt&block=42")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmOldCID", resp["cid"])
+	assert.Equal(t, float64(42), resp["block"])
+}
+
+// TestGetFile_AtBlockErrors tests that invalid and future blocks return 400.
+func TestGetFile_AtBlockErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		getAtFunc: func(filePath string, block uint64) (string, error) {
+			return "", fmt.Errorf("%w: block 99, latest 10", contracts.ErrFutureBlock)
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?filePath=/a&block=-1").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?filePath=/a&block=99").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?filePath=/a&block=1&at=2024-01-01T00:00:00Z").Code)
+}
+
+// TestGetFile_AtTime tests that ?at= resolves the CID through the event history.
+func TestGetFile_AtTime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	index := &mockIndex{
+		atFunc: func(filePath string, at time.Time) (*indexer.Event, error) {
+			assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), at.UTC())
+			return &indexer.Event{FilePath: filePath, CID: "QmOldCID", BlockNumber: 7, TxHash: "0x01"}, nil
+		},
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(index))
+
+	w := getPath(router, "/v1/files?filePath=/test/file.txt&at=2024-01-02T03:04:05Z")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmOldCID", resp["cid"])
+	assert.Equal(t, float64(7), resp["block"])
+
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?filePath=/a&at=yesterday").Code)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/gin-gonic/gin"
+)
+
+// jobStages are the stages accepted by the stage filter of ListJobs.
+var jobStages = map[outbox.Stage]bool{
+	outbox.StageQueued:    true,
+	outbox.StagePinned:    true,
+	outbox.StageBroadcast: true,
+	outbox.StageMined:     true,
+	outbox.StageFailed:    true,
+}
+
+// GetJob returns an upload job of the outbox: its stage (queued, pinned, broadcast, mined or failed),
+// CID, transaction hash and, after a failure, the error code and message.
+func (h *Handlers) GetJob(c *gin.Context) {
+	if !h.requireOutbox(c) {
+		return
+	}
+	job, err := h.Outbox.Job(c.Param("id"))
+	if errors.Is(err, outbox.ErrJobNotFound) {
+		abortError(c, http.StatusNotFound, CodeJobNotFound, "No job "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeOutboxError, "Failed to read job: "+err.Error())
+		return
+	}
+	// Wallet sessions only see their own uploads.
+	if wallet := WalletFrom(c); wallet != nil && job.Signer != wallet.Hex() {
+		abortError(c, http.StatusNotFound, CodeJobNotFound, "No job "+c.Param("id"))
+		return
+	}
+	if !allowPath(c, job.FilePath) {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ListJobs lists the upload jobs of the outbox, newest first. The stage, prefix and signer query
+// parameters filter them; results are paginated with the limit and cursor query parameters.
+func (h *Handlers) ListJobs(c *gin.Context) {
+	filter := outbox.Filter{Stage: outbox.Stage(c.Query("stage")), Prefix: c.Query("prefix")}
+	if filter.Stage != "" && !jobStages[filter.Stage] {
+		abortBadRequest(c, "Invalid stage query parameter: "+c.Query("stage"))
+		return
+	}
+	if raw := c.Query("signer"); raw != "" {
+		signer, ok := parseAccount(c, raw)
+		if !ok {
+			return
+		}
+		filter.Signer = signer.Hex()
+	}
+	if wallet := WalletFrom(c); wallet != nil {
+		if filter.Signer != "" && filter.Signer != wallet.Hex() {
+			abortError(c, http.StatusForbidden, CodePathNotAllowed, "Wallet "+wallet.Hex()+" may only list its own jobs")
+			return
+		}
+		filter.Signer = wallet.Hex()
+	}
+	if !allowPath(c, filter.Prefix) || !h.requireOutbox(c) {
+		return
+	}
+	limit, ok := pageSize(c)
+	if !ok {
+		return
+	}
+
+	jobs, next, err := h.Outbox.List(filter, c.Query("cursor"), limit)
+	if errors.Is(err, outbox.ErrInvalidCursor) {
+		abortBadRequest(c, "Invalid cursor query parameter: "+c.Query("cursor"))
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeOutboxError, "Failed to list jobs: "+err.Error())
+		return
+	}
+	if jobs == nil {
+		jobs = []outbox.Job{}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs, "nextCursor": next})
+}
@@ -0,0 +1,117 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/outbox"
+)
+
+// TestUploadFile_Async tests that an asynchronous upload is answered 202 with its job right away.
+func TestUploadFile_Async(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Wait blocks, so the upload must not wait for its save.
+	ob := &mockOutbox{}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(ob))
+
+	w := postUpload(router, "?async=true")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v1/jobs/job-1", w.Header().Get("Location"))
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "job-1", resp["jobId"])
+	assert.Equal(t, "queued", resp["stage"])
+	assert.Equal(t, "/v1/jobs/job-1", resp["statusUrl"])
+	assert.Equal(t, "Hello World!", ob.content)
+}
+
+// TestUploadFile_AsyncInvalid tests that asynchronous uploads need the outbox and cannot wait.
+func TestUploadFile_AsyncInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w := postUpload(router, "?async=true")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeOutboxDisabled, errorCode(t, w))
+
+	router = handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(&mockOutbox{}))
+	w = postUpload(router, "?async=true&wait=true")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postUpload(router, "?async=maybe")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestGetJob tests that a job is returned with its stage, CID and transaction hash.
+func TestGetJob(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ob := &mockOutbox{jobs: []outbox.Job{
+		{ID: "job-1", FilePath: "/team-a/file.txt", Stage: outbox.StageBroadcast, CID: "QmFakeCID", TxHash: testTxHash},
+		{ID: "job-2", FilePath: "/team-b/file.txt", Stage: outbox.StageFailed, Code: outbox.CodeIPFSError, Error: "IPFS add error"},
+	}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(ob), handlers.WithAPIKeys(newKeyRing(t)))
+
+	w := authRequest(router, http.MethodGet, "/v1/jobs/job-1", "team-a-key", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var job outbox.Job
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, outbox.StageBroadcast, job.Stage)
+	assert.Equal(t, "QmFakeCID", job.CID)
+	assert.Equal(t, testTxHash, job.TxHash)
+
+	w = authRequest(router, http.MethodGet, "/v1/jobs/job-2", "reader-key", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"ipfs_error"`)
+
+	// The key of team-a may not see uploads of other teams.
+	w = authRequest(router, http.MethodGet, "/v1/jobs/job-2", "team-a-key", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodGet, "/v1/jobs/unknown", "reader-key", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, handlers.CodeJobNotFound, errorCode(t, w))
+}
+
+// TestListJobs tests that jobs are listed with the requested filters and paginated.
+func TestListJobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ob := &mockOutbox{jobs: []outbox.Job{
+		{ID: "job-2", FilePath: "/test/b.txt", Stage: outbox.StageQueued},
+		{ID: "job-1", FilePath: "/test/a.txt", Stage: outbox.StageQueued},
+	}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(ob))
+
+	w := getPath(router, "/v1/jobs?stage=queued&prefix=/test/&signer=0x0000000000000000000000000000000000000001&limit=1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Jobs       []outbox.Job `json:"jobs"`
+		NextCursor string       `json:"nextCursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Jobs, 1)
+	assert.Equal(t, "job-2", resp.Jobs[0].ID)
+	assert.Equal(t, "job-2", resp.NextCursor)
+	assert.Equal(t, outbox.Filter{Stage: outbox.StageQueued, Prefix: "/test/", Signer: "0x0000000000000000000000000000000000000001"}, ob.filter)
+
+	w = getPath(router, "/v1/jobs?cursor=job-2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "job-2", ob.cursor)
+
+	for _, query := range []string{"stage=done", "signer=0x01", "cursor=bad", "limit=0"} {
+		w = getPath(router, "/v1/jobs?"+query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	router = handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w = getPath(router, "/v1/jobs")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
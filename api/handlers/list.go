@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/gin-gonic/gin"
+)
+
+// TreeNode is a directory or file in the tree view of GetFileTree.
+type TreeNode struct {
+	Name        string      `json:"name"`
+	Path        string      `json:"path"`
+	Type        string      `json:"type"`
+	CID         string      `json:"cid,omitempty"`
+	BlockNumber uint64      `json:"blockNumber,omitempty"`
+	Children    []*TreeNode `json:"children,omitempty"`
+}
+
+// ListFiles lists the files whose path starts with the prefix query parameter, with their current
+// CID and last modified block. ?depth=N collapses paths more than N segments below the prefix into
+// directory entries. Results are paginated with limit and cursor.
+func (h *Handlers) ListFiles(c *gin.Context) {
+	prefix := c.Query("prefix")
+	if !allowPath(c, prefix) || !h.requireIndex(c) {
+		return
+	}
+	depth, ok := listDepth(c)
+	if !ok {
+		return
+	}
+	limit, ok := pageSize(c)
+	if !ok {
+		return
+	}
+
+	entries, next, ok := h.listEntries(c, prefix, depth, limit)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prefix": prefix, "entries": entries, "nextCursor": next})
+}
+
+// GetFileTree returns the files below the prefix query parameter as a nested tree of directories.
+// It takes the same depth, limit and cursor parameters as ListFiles; a page holds limit entries.
+func (h *Handlers) GetFileTree(c *gin.Context) {
+	prefix := c.Query("prefix")
+	if !allowPath(c, prefix) || !h.requireIndex(c) {
+		return
+	}
+	depth, ok := listDepth(c)
+	if !ok {
+		return
+	}
+	limit, ok := pageSize(c)
+	if !ok {
+		return
+	}
+
+	entries, next, ok := h.listEntries(c, prefix, depth, limit)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prefix": prefix, "tree": buildTree(prefix, entries), "nextCursor": next})
+}
+
+func (h *Handlers) listEntries(c *gin.Context, prefix string, depth, limit int) ([]indexer.Entry, string, bool) {
+	entries, next, err := h.Index.List(prefix, c.Query("cursor"), depth, limit)
+	if errors.Is(err, indexer.ErrInvalidCursor) {
+		abortBadRequest(c, "Invalid cursor query parameter: "+c.Query("cursor"))
+		return nil, "", false
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIndexError, "Index error: "+err.Error())
+		return nil, "", false
+	}
+	if entries == nil {
+		entries = []indexer.Entry{}
+	}
+	return entries, next, true
+}
+
+// listDepth reads the depth query parameter. 0, the default, means no limit.
+func listDepth(c *gin.Context) (int, bool) {
+	raw := c.Query("depth")
+	if raw == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		abortBadRequest(c, "Invalid depth query parameter: "+raw)
+		return 0, false
+	}
+	return n, true
+}
+
+// buildTree nests the entries below prefix by their slash separated segments.
+func buildTree(prefix string, entries []indexer.Entry) []*TreeNode {
+	root := &TreeNode{Path: prefix, Type: indexer.EntryDir}
+	for _, entry := range entries {
+		node := root
+		segments := strings.Split(strings.TrimSuffix(entry.Path[len(prefix):], "/"), "/")
+		for i, name := range segments {
+			last := i == len(segments)-1
+			path := node.Path + name
+			if !last || entry.Type == indexer.EntryDir {
+				path += "/"
+			}
+			child := node.child(path)
+			if child == nil {
+				child = &TreeNode{Name: name, Path: path, Type: indexer.EntryDir}
+				node.Children = append(node.Children, child)
+			}
+			if last && entry.Type == indexer.EntryFile {
+				child.Type, child.CID, child.BlockNumber = indexer.EntryFile, entry.CID, entry.BlockNumber
+			}
+			node = child
+		}
+	}
+	if root.Children == nil {
+		return []*TreeNode{}
+	}
+	return root.Children
+}
+
+func (n *TreeNode) child(path string) *TreeNode {
+	for _, child := range n.Children {
+		if child.Path == path {
+			return child
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/indexer"
+)
+
+func newListIndex(t *testing.T) *mockIndex {
+	return &mockIndex{
+		listFunc: func(prefix, cursor string, depth, limit int) ([]indexer.Entry, string, error) {
+			assert.Equal(t, "/reports/", prefix)
+			if cursor == "bad" {
+				return nil, "", indexer.ErrInvalidCursor
+			}
+			return []indexer.Entry{
+				{Path: "/reports/2026/old/", Type: indexer.EntryDir},
+				{Path: "/reports/2026/q1.csv", Type: indexer.EntryFile, CID: "QmQ1", BlockNumber: 5},
+				{Path: "/reports/a.txt", Type: indexer.EntryFile, CID: "QmA", BlockNumber: 2},
+			}, "next", nil
+		},
+	}
+}
+
+// TestListFiles_Success tests that files below a prefix are listed with the pagination cursor.
+func TestListFiles_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	index := newListIndex(t)
+	list := index.listFunc
+	index.listFunc = func(prefix, cursor string, depth, limit int) ([]indexer.Entry, string, error) {
+		assert.Equal(t, 2, depth)
+		assert.Equal(t, 10, limit)
+		return list(prefix, cursor, depth, limit)
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(index))
+
+	w := getPath(router, "/v1/files?prefix=/reports/&depth=2&limit=10")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Entries    []indexer.Entry `json:"entries"`
+		NextCursor string          `json:"nextCursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 3)
+	assert.Equal(t, "QmQ1", resp.Entries[1].CID)
+	assert.Equal(t, uint64(5), resp.Entries[1].BlockNumber)
+	assert.Equal(t, "next", resp.NextCursor)
+}
+
+// TestListFiles_BadRequests tests invalid depth and cursor values.
+func TestListFiles_BadRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(newListIndex(t)))
+
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?prefix=/reports/&depth=-1").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files?prefix=/reports/&cursor=bad").Code)
+	assert.Equal(t, http.StatusBadRequest, getPath(router, "/v1/files/tree?prefix=/reports/&limit=x").Code)
+}
+
+// TestGetFileTree_Success tests that entries are nested by directory.
+func TestGetFileTree_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithIndex(newListIndex(t)))
+
+	w := getPath(router, "/v1/files/tree?prefix=/reports/")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Tree []*handlers.TreeNode `json:"tree"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Tree, 2)
+
+	year := resp.Tree[0]
+	assert.Equal(t, "2026", year.Name)
+	assert.Equal(t, "/reports/2026/", year.Path)
+	assert.Equal(t, indexer.EntryDir, year.Type)
+	require.Len(t, year.Children, 2)
+	assert.Equal(t, "/reports/2026/old/", year.Children[0].Path)
+	assert.Equal(t, indexer.EntryDir, year.Children[0].Type)
+	assert.Equal(t, "q1.csv", year.Children[1].Name)
+	assert.Equal(t, "QmQ1", year.Children[1].CID)
+
+	assert.Equal(t, "a.txt", resp.Tree[1].Name)
+	assert.Equal(t, indexer.EntryFile, resp.Tree[1].Type)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/gin-gonic/gin"
+)
+
+// Outbox accepts uploads durably and adds, saves and confirms them in the background.
+type Outbox interface {
+	Enqueue(req outbox.Request, r io.Reader) (*outbox.Job, error)
+	Wait(ctx context.Context, id string, stage outbox.Stage) (*outbox.Job, error)
+	Job(id string) (*outbox.Job, error)
+	List(filter outbox.Filter, cursor string, limit int) ([]outbox.Job, string, error)
+}
+
+// Error codes of the outbox.
+const (
+	CodeOutboxError    = "outbox_error"
+	CodeOutboxDisabled = "outbox_disabled"
+	CodeJobNotFound    = "job_not_found"
+)
+
+// WithOutbox makes uploads go through the outbox, so that they are completed even when adding or
+// saving them fails for a while or the process restarts.
+func WithOutbox(ob Outbox) Option {
+	return func(h *Handlers) {
+		h.Outbox = ob
+	}
+}
+
+// requestedAsync reads the async query parameter. Asynchronous uploads need the outbox and cannot wait
+// for their transaction; otherwise it answers 400 or 503 and returns false.
+func (h *Handlers) requestedAsync(c *gin.Context) (bool, bool) {
+	raw := c.Query("async")
+	if raw == "" {
+		return false, true
+	}
+	async, err := strconv.ParseBool(raw)
+	if err != nil {
+		abortBadRequest(c, "Invalid async query parameter: "+raw)
+		return false, false
+	}
+	if !async {
+		return false, true
+	}
+	if c.Query("wait") != "" || c.Query("confirmations") != "" {
+		abortBadRequest(c, "Asynchronous uploads cannot wait for their transaction; poll /v1/jobs/:id instead")
+		return false, false
+	}
+	if !h.requireOutbox(c) {
+		return false, false
+	}
+	return true, true
+}
+
+// requireOutbox answers 503 and returns false when the outbox is disabled.
+func (h *Handlers) requireOutbox(c *gin.Context) bool {
+	if h.Outbox == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeOutboxDisabled, "Upload outbox is not enabled")
+		return false
+	}
+	return true
+}
+
+// enqueueUpload writes the upload to the outbox. Asynchronous uploads are answered 202 with their job
+// right away; others are answered like saveFile once their save is sent. When that takes longer than
+// TxWaitTimeout, the upload goes on in the background and 202 is answered as well.
+func (h *Handlers) enqueueUpload(c *gin.Context, filePath string, r io.Reader, body *limitedBody) {
+	async, ok := h.requestedAsync(c)
+	if !ok {
+		return
+	}
+	confirmations, ok := h.requestedConfirmations(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.Outbox.Enqueue(outbox.Request{FilePath: filePath, Signer: WalletFrom(c)}, r)
+	if err != nil {
+		if body.tooLarge {
+			abortTooLarge(c, body.limit)
+			return
+		}
+		abortError(c, http.StatusInternalServerError, CodeOutboxError, "Failed to queue upload: "+err.Error())
+		return
+	}
+	if async {
+		acceptJob(c, job)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, h.TxWaitTimeout)
+	defer cancel()
+	job, err = h.Outbox.Wait(ctx, job.ID, outbox.StageBroadcast)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		acceptJob(c, job)
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeOutboxError, "Failed to read upload job: "+err.Error())
+		return
+	}
+	if job.Stage == outbox.StageFailed {
+		abortErrorDetails(c, jobStatus(job.Code), job.Code, "Upload failed: "+job.Error, gin.H{"jobId": job.ID, "cid": job.CID, "txHash": job.TxHash})
+		return
+	}
+
+	response := gin.H{"cid": job.CID, "txHash": job.TxHash, "jobId": job.ID}
+	if job.Signer != "" {
+		response["signer"] = job.Signer
+	}
+	if confirmations == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	status, ok := h.waitForTx(c, job.TxHash, confirmations)
+	if !ok {
+		return
+	}
+	response["tx"] = status
+	c.JSON(http.StatusOK, response)
+}
+
+// acceptJob answers 202 with the job of an upload that goes on in the background.
+func acceptJob(c *gin.Context, job *outbox.Job) {
+	location := "/v1/jobs/" + job.ID
+	c.Header("Location", location)
+	c.JSON(http.StatusAccepted, gin.H{"jobId": job.ID, "stage": job.Stage, "statusUrl": location})
+}
+
+// jobStatus returns the HTTP status answered for a failed job with the given code.
+func jobStatus(code string) int {
+	switch code {
+	case outbox.CodeIPFSError:
+		return http.StatusInternalServerError
+	case outbox.CodeTxReverted:
+		return http.StatusUnprocessableEntity
+	case outbox.CodeTxCancelled:
+		return http.StatusConflict
+	case outbox.CodeTxTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return contractStatus(contracts.ErrorCode(code))
+}
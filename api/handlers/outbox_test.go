@@ -0,0 +1,112 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/outbox"
+)
+
+type mockOutbox struct {
+	request outbox.Request
+	content string
+	// job is returned by Wait once the requested stage is reached; Wait blocks until the context ends
+	// when it is nil.
+	job *outbox.Job
+	// jobs are returned by Job and List.
+	jobs   []outbox.Job
+	filter outbox.Filter
+	cursor string
+}
+
+func (m *mockOutbox) Enqueue(req outbox.Request, r io.Reader) (*outbox.Job, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m.request, m.content = req, string(data)
+	return &outbox.Job{ID: "job-1", FilePath: req.FilePath, Stage: outbox.StageQueued}, nil
+}
+
+func (m *mockOutbox) Wait(ctx context.Context, id string, stage outbox.Stage) (*outbox.Job, error) {
+	if m.job != nil {
+		return m.job, nil
+	}
+	<-ctx.Done()
+	return &outbox.Job{ID: id, Stage: outbox.StageQueued}, ctx.Err()
+}
+
+func (m *mockOutbox) Job(id string) (*outbox.Job, error) {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return &job, nil
+		}
+	}
+	return nil, outbox.ErrJobNotFound
+}
+
+func (m *mockOutbox) List(filter outbox.Filter, cursor string, limit int) ([]outbox.Job, string, error) {
+	m.filter, m.cursor = filter, cursor
+	if cursor == "bad" {
+		return nil, "", outbox.ErrInvalidCursor
+	}
+	if len(m.jobs) > limit {
+		return m.jobs[:limit], m.jobs[limit-1].ID, nil
+	}
+	return m.jobs, "", nil
+}
+
+// TestUploadFile_Outbox tests that uploads go through the outbox and answer once their save is sent.
+func TestUploadFile_Outbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ob := &mockOutbox{job: &outbox.Job{ID: "job-1", Stage: outbox.StageBroadcast, CID: "QmFakeCID", TxHash: testTxHash}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(ob))
+
+	w := postUpload(router, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "QmFakeCID", resp["cid"])
+	assert.Equal(t, testTxHash, resp["txHash"])
+	assert.Equal(t, "job-1", resp["jobId"])
+	assert.Equal(t, "/test/file.txt", ob.request.FilePath)
+	assert.Equal(t, "Hello World!", ob.content)
+}
+
+// TestUploadFile_OutboxAccepted tests that a 202 with the job is returned when the save is not sent in time.
+func TestUploadFile_OutboxAccepted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{},
+		handlers.WithOutbox(&mockOutbox{}), handlers.WithConfirmations(1, 10*time.Millisecond))
+
+	w := postUpload(router, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "job-1", resp["jobId"])
+	assert.Equal(t, "queued", resp["stage"])
+}
+
+// TestUploadFile_OutboxFailed tests that a failed job is answered with its error code.
+func TestUploadFile_OutboxFailed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ob := &mockOutbox{job: &outbox.Job{ID: "job-1", Stage: outbox.StageFailed, CID: "QmFakeCID", Code: "unauthorized", Error: "not a writer"}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithOutbox(ob))
+
+	w := postUpload(router, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "unauthorized", errorCode(t, w))
+	assert.Contains(t, w.Body.String(), "job-1")
+}
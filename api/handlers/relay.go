@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+// Relayer submits forward requests signed by users to the trusted forwarder, paying their gas.
+type Relayer interface {
+	Relay(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error)
+	WritePaths(data []byte) ([]string, error)
+	Nonce(ctx context.Context, from common.Address) (*big.Int, error)
+	Remaining(from common.Address) int
+	Forwarder() common.Address
+	ChainID() *big.Int
+}
+
+// Error codes of the relay endpoints.
+const (
+	CodeRelayDisabled  = "relay_disabled"
+	CodeCallNotAllowed = "call_not_allowed"
+)
+
+// WithRelayer enables gasless saves through the trusted forwarder of the registry.
+func WithRelayer(relayer Relayer) Option {
+	return func(h *Handlers) {
+		h.Relayer = relayer
+	}
+}
+
+// ForwardRequestBody is a contracts.ForwardRequest in JSON: addresses and data in hex, numbers as
+// decimal strings. Value may be omitted.
+type ForwardRequestBody struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value,omitempty"`
+	Gas   string `json:"gas"`
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+// RelayRequest is a forward request with the EIP-712 signature of its sender.
+type RelayRequest struct {
+	Request   ForwardRequestBody `json:"request"`
+	Signature string             `json:"signature"`
+}
+
+// GetRelayNonce returns what a wallet needs to sign its next forward request: the forwarder nonce
+// of ?address=, the EIP-712 domain of the forwarder and the relays left in its quota.
+func (h *Handlers) GetRelayNonce(c *gin.Context) {
+	if !h.requireRelayer(c) {
+		return
+	}
+	address, ok := parseAccount(c, c.Query("address"))
+	if !ok {
+		return
+	}
+	nonce, err := h.Relayer.Nonce(c.Request.Context(), address)
+	if err != nil {
+		abortContractError(c, "Forwarder nonce error: ", err)
+		return
+	}
+	response := gin.H{
+		"address": address.Hex(),
+		"nonce":   nonce.String(),
+		"domain": gin.H{
+			"name":              contracts.ForwarderDomainName,
+			"version":           contracts.ForwarderDomainVersion,
+			"chainId":           h.Relayer.ChainID().String(),
+			"verifyingContract": h.Relayer.Forwarder().Hex(),
+		},
+	}
+	if remaining := h.Relayer.Remaining(address); remaining >= 0 {
+		response["remaining"] = remaining
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Relay sends a signed forward request to the forwarder. The registry acts for the signer, so its
+// ownership and write grants apply rather than those of the API account. The request needs the
+// files:write scope, so only API clients spend gas on relays, and a SIWE session relays requests of
+// its own wallet only. Only saves and removals are relayed, of paths the API key may use.
+func (h *Handlers) Relay(c *gin.Context) {
+	if !h.requireRelayer(c) {
+		return
+	}
+	var body RelayRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return
+	}
+	req, ok := parseForwardRequest(c, body.Request)
+	if !ok {
+		return
+	}
+	signature, err := hexutil.Decode(body.Signature)
+	if err != nil {
+		abortBadRequest(c, "Invalid signature: "+err.Error())
+		return
+	}
+	if wallet := WalletFrom(c); wallet != nil && *wallet != req.From {
+		abortError(c, http.StatusForbidden, CodeWalletNotAllowed, "Wallet "+wallet.Hex()+" may not relay requests of "+req.From.Hex())
+		return
+	}
+	paths, err := h.Relayer.WritePaths(req.Data)
+	if err != nil {
+		abortError(c, http.StatusForbidden, CodeCallNotAllowed, "Request may not be relayed: "+err.Error())
+		return
+	}
+	for _, path := range paths {
+		if !allowPath(c, path) {
+			return
+		}
+	}
+
+	txHash, err := h.Relayer.Relay(c.Request.Context(), req, signature)
+	if err != nil {
+		abortContractError(c, "Relay error: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"txHash": txHash, "from": req.From.Hex()})
+}
+
+// parseForwardRequest converts body, answering 400 and returning false when a field is malformed.
+func parseForwardRequest(c *gin.Context, body ForwardRequestBody) (contracts.ForwardRequest, bool) {
+	var req contracts.ForwardRequest
+	if !common.IsHexAddress(body.From) || !common.IsHexAddress(body.To) {
+		abortBadRequest(c, "Invalid from or to address")
+		return req, false
+	}
+	req.From, req.To = common.HexToAddress(body.From), common.HexToAddress(body.To)
+	if body.Value == "" {
+		body.Value = "0"
+	}
+	numbers := []struct {
+		name  string
+		value string
+		dst   **big.Int
+	}{
+		{"value", body.Value, &req.Value},
+		{"gas", body.Gas, &req.Gas},
+		{"nonce", body.Nonce, &req.Nonce},
+	}
+	for _, n := range numbers {
+		v, ok := new(big.Int).SetString(n.value, 10)
+		if !ok || v.Sign() < 0 || v.BitLen() > 256 {
+			abortBadRequest(c, "Invalid "+n.name+": "+n.value)
+			return req, false
+		}
+		*n.dst = v
+	}
+	data, err := hexutil.Decode(body.Data)
+	if err != nil {
+		abortBadRequest(c, "Invalid data: "+err.Error())
+		return req, false
+	}
+	req.Data = data
+	return req, true
+}
+
+// requireRelayer answers 503 and returns false when relaying is disabled.
+func (h *Handlers) requireRelayer(c *gin.Context) bool {
+	if h.Relayer == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeRelayDisabled, "Relaying is not enabled")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+type mockRelayer struct {
+	relayFunc      func(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error)
+	writePathsFunc func(data []byte) ([]string, error)
+}
+
+func (m *mockRelayer) Relay(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error) {
+	return m.relayFunc(ctx, req, signature)
+}
+
+func (m *mockRelayer) WritePaths(data []byte) ([]string, error) {
+	if m.writePathsFunc != nil {
+		return m.writePathsFunc(data)
+	}
+	return []string{"/team-a/relayed.txt"}, nil
+}
+
+func (m *mockRelayer) Nonce(ctx context.Context, from common.Address) (*big.Int, error) {
+	return big.NewInt(4), nil
+}
+
+func (m *mockRelayer) Remaining(from common.Address) int {
+	return 7
+}
+
+func (m *mockRelayer) Forwarder() common.Address {
+	return common.HexToAddress("0x00000000000000000000000000000000000000ff")
+}
+
+func (m *mockRelayer) ChainID() *big.Int {
+	return big.NewInt(1337)
+}
+
+var (
+	relayFrom = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	relayTo   = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+)
+
+func relayBody(gas string) string {
+	return fmt.Sprintf(`{"request":{"from":"%s","to":"%s","gas":"%s","nonce":"4","data":"0xdeadbeef"},"signature":"0x0102"}`, relayFrom.Hex(), relayTo.Hex(), gas)
+}
+
+// TestRelay_Success tests that a signed forward request is passed to the relayer as sent.
+func TestRelay_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got contracts.ForwardRequest
+	relayer := &mockRelayer{relayFunc: func(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error) {
+		got = req
+		assert.Equal(t, []byte{1, 2}, signature)
+		return testTxHash, nil
+	}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithAPIKeys(newKeyRing(t)), handlers.WithRelayer(relayer))
+
+	// Relays spend the gas of the API account, so they need the files:write scope.
+	w := sendJSON(router, http.MethodPost, "/v1/relay", relayBody("100000"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = authRequest(router, http.MethodPost, "/v1/relay", "reader-key", []byte(relayBody("100000")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodPost, "/v1/relay", "team-a-key", []byte(relayBody("100000")))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, testTxHash, resp["txHash"])
+	assert.Equal(t, relayFrom.Hex(), resp["from"])
+	assert.Equal(t, relayFrom, got.From)
+	assert.Equal(t, relayTo, got.To)
+	assert.Equal(t, int64(0), got.Value.Int64())
+	assert.Equal(t, int64(100000), got.Gas.Int64())
+	assert.Equal(t, int64(4), got.Nonce.Int64())
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, got.Data)
+}
+
+// TestRelay_Errors tests how malformed requests and relay failures are answered.
+func TestRelay_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	relayer := &mockRelayer{relayFunc: func(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error) {
+		return "", &contracts.Error{Code: contracts.CodeQuotaExceeded, Err: contracts.ErrRelayQuotaExceeded}
+	}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithRelayer(relayer))
+
+	w := sendJSON(router, http.MethodPost, "/v1/relay", relayBody("100000"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, string(contracts.CodeQuotaExceeded), errorCode(t, w))
+
+	w = sendJSON(router, http.MethodPost, "/v1/relay", relayBody("lots"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = sendJSON(router, http.MethodPost, "/v1/relay", `{"request":{"from":"0x1"},"signature":"0x"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	router = handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w = sendJSON(router, http.MethodPost, "/v1/relay", relayBody("100000"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeRelayDisabled, errorCode(t, w))
+}
+
+// TestRelay_Calls tests that only saves and removals of paths the API key may use are relayed.
+func TestRelay_Calls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var paths []string
+	var pathsErr error
+	relayed := false
+	relayer := &mockRelayer{
+		relayFunc: func(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error) {
+			relayed = true
+			return testTxHash, nil
+		},
+		writePathsFunc: func(data []byte) ([]string, error) {
+			assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, data)
+			return paths, pathsErr
+		},
+	}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithAPIKeys(newKeyRing(t)), handlers.WithRelayer(relayer))
+
+	paths = []string{"/team-a/a.txt", "/team-b/b.txt"}
+	w := authRequest(router, http.MethodPost, "/v1/relay", "team-a-key", []byte(relayBody("100000")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+
+	paths, pathsErr = nil, &contracts.Error{Code: contracts.CodeInvalidArgument, Err: contracts.ErrInvalidForwardRequest}
+	w = authRequest(router, http.MethodPost, "/v1/relay", "team-a-key", []byte(relayBody("100000")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeCallNotAllowed, errorCode(t, w))
+	assert.False(t, relayed)
+
+	paths, pathsErr = []string{"/team-a/a.txt", "/team-a/b.txt"}, nil
+	w = authRequest(router, http.MethodPost, "/v1/relay", "team-a-key", []byte(relayBody("100000")))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, relayed)
+}
+
+// TestRelay_SessionWallet tests that a SIWE session relays the requests of its own wallet only.
+func TestRelay_SessionWallet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	relayer := &mockRelayer{relayFunc: func(ctx context.Context, req contracts.ForwardRequest, signature []byte) (string, error) {
+		return testTxHash, nil
+	}}
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{},
+		handlers.WithAPIKeys(walletKeyRing(t, wallet)),
+		handlers.WithSIWE("files.example.com", auth.NewSessions(1337, time.Hour)),
+		handlers.WithRelayer(relayer),
+	)
+	token := login(t, router, key)
+
+	w := authRequest(router, http.MethodPost, "/v1/relay", token, []byte(relayBody("100000")))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeWalletNotAllowed, errorCode(t, w))
+
+	own := strings.Replace(relayBody("100000"), relayFrom.Hex(), wallet.Hex(), 1)
+	w = authRequest(router, http.MethodPost, "/v1/relay", token, []byte(own))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// TestGetRelayNonce tests that the nonce endpoint returns what a wallet needs to sign a request.
+func TestGetRelayNonce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithRelayer(&mockRelayer{}))
+
+	w := getPath(router, "/v1/relay/nonce?address="+relayFrom.Hex())
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Nonce     string            `json:"nonce"`
+		Remaining int               `json:"remaining"`
+		Domain    map[string]string `json:"domain"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "4", resp.Nonce)
+	assert.Equal(t, 7, resp.Remaining)
+	assert.Equal(t, contracts.ForwarderDomainName, resp.Domain["name"])
+	assert.Equal(t, "1337", resp.Domain["chainId"])
+
+	w = getPath(router, "/v1/relay/nonce?address=nope")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
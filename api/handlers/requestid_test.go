@@ -0,0 +1,64 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+// TestRequestID_Propagated tests that a client request ID is echoed in the header and error body.
+func TestRequestID_Propagated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/files", nil)
+	req.Header.Set(handlers.RequestIDHeader, "client-id-1")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "client-id-1", w.Header().Get(handlers.RequestIDHeader))
+	var resp handlers.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, handlers.ErrorResponse{
+		Code:      handlers.CodeInvalidRequest,
+		Message:   "Missing filePath query parameter",
+		RequestID: "client-id-1",
+	}, resp)
+}
+
+// TestRequestID_Generated tests that missing or unusable request IDs are replaced with generated ones.
+func TestRequestID_Generated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	seen := make(map[string]bool)
+	for _, header := range []string{"", "has space", strings.Repeat("a", 200)} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/nowhere", nil)
+		if header != "" {
+			req.Header.Set(handlers.RequestIDHeader, header)
+		}
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(handlers.RequestIDHeader)
+		assert.Len(t, id, 32)
+		assert.False(t, seen[id])
+		seen[id] = true
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		var resp handlers.ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, handlers.CodeRouteNotFound, resp.Code)
+		assert.Equal(t, id, resp.RequestID)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/gin-gonic/gin"
+)
+
+var txHashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+// GetTx reports whether a transaction is pending, mined or reverted.
+func (h *Handlers) GetTx(c *gin.Context) {
+	txHash := c.Param("hash")
+	if !txHashPattern.MatchString(txHash) {
+		abortBadRequest(c, "Invalid transaction hash: "+txHash)
+		return
+	}
+
+	status, err := h.Contract.TxStatus(c, txHash)
+	if errors.Is(err, contracts.ErrTxNotFound) {
+		abortError(c, http.StatusNotFound, CodeNotFound, "Transaction not found: "+txHash)
+		return
+	}
+	if err != nil {
+		abortContractError(c, "Transaction status error: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// SpeedUpTx replaces a pending transaction with the same call at higher fees.
+func (h *Handlers) SpeedUpTx(c *gin.Context) {
+	h.replaceTx(c, h.Contract.SpeedUp)
+}
+
+// CancelTx replaces a pending transaction with an empty transfer at higher fees, so the original call
+// never executes.
+func (h *Handlers) CancelTx(c *gin.Context) {
+	h.replaceTx(c, h.Contract.Cancel)
+}
+
+func (h *Handlers) replaceTx(c *gin.Context, replace func(ctx context.Context, txHash string) (string, error)) {
+	txHash := c.Param("hash")
+	if !txHashPattern.MatchString(txHash) {
+		abortBadRequest(c, "Invalid transaction hash: "+txHash)
+		return
+	}
+
+	replacement, err := replace(c, txHash)
+	if err != nil {
+		abortContractError(c, "Transaction replace error: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"txHash": txHash, "replacementTxHash": replacement})
+}
+
+// requestedConfirmations reads the wait and confirmations query parameters. It returns 0 when the
+// client does not want to wait, and answers 400 and returns false when they are malformed.
+func (h *Handlers) requestedConfirmations(c *gin.Context) (uint64, bool) {
+	if raw := c.Query("confirmations"); raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || n == 0 {
+			abortBadRequest(c, "Invalid confirmations query parameter: "+raw)
+			return 0, false
+		}
+		return n, true
+	}
+	if raw := c.Query("wait"); raw != "" {
+		wait, err := strconv.ParseBool(raw)
+		if err != nil {
+			abortBadRequest(c, "Invalid wait query parameter: "+raw)
+			return 0, false
+		}
+		if wait {
+			return h.Confirmations, true
+		}
+	}
+	return 0, true
+}
+
+// waitForTx waits for the transaction within TxWaitTimeout. It answers 504 when the timeout elapses,
+// 422 when the transaction reverted and 409 when it was cancelled, returning false in these cases.
+// The status is returned whenever the transaction is final, even when it reverted or was cancelled.
+func (h *Handlers) waitForTx(c *gin.Context, txHash string, confirmations uint64) (*contracts.TxStatus, bool) {
+	ctx, cancel := context.WithTimeout(c, h.TxWaitTimeout)
+	defer cancel()
+
+	status, err := h.Contract.WaitMined(ctx, txHash, confirmations)
+	if errors.Is(err, context.DeadlineExceeded) {
+		abortErrorDetails(c, http.StatusGatewayTimeout, CodeTxTimeout, "Timed out waiting for transaction", gin.H{"txHash": txHash, "tx": status})
+		return nil, false
+	}
+	if err != nil {
+		code := contracts.Classify(err).Code
+		abortErrorDetails(c, contractStatus(code), string(code), "Transaction wait error: "+err.Error(), gin.H{"txHash": txHash})
+		return nil, false
+	}
+	if status.Status == contracts.TxReverted {
+		abortErrorDetails(c, http.StatusUnprocessableEntity, CodeTxReverted, "Transaction reverted", gin.H{"txHash": txHash, "tx": status})
+		return status, false
+	}
+	if status.Status == contracts.TxCancelled {
+		abortErrorDetails(c, http.StatusConflict, CodeTxCancelled, "Transaction cancelled", gin.H{"txHash": txHash, "tx": status})
+		return status, false
+	}
+	return status, true
+}
@@ -0,0 +1,251 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+const testTxHash = "0x1111111111111111111111111111111111111111111111111111111111111111"
+
+func postUpload(router *gin.Engine, query string) *httptest.ResponseRecorder {
+	fileB64 := base64.StdEncoding.EncodeToString([]byte("Hello World!"))
+	body := []byte(`{"filePath":"/test/file.txt","file":"` + fileB64 + `"}`)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files"+query, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func newWaitMocks(waitMined func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error)) (*mockContract, *mockIPFSClient) {
+	mockC := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			return testTxHash, nil
+		},
+		waitMinedFunc: waitMined,
+	}
+	mockIPFS := &mockIPFSClient{
+		addFunc: func(ctx *gin.Context, file []byte) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	return mockC, mockIPFS
+}
+
+// TestUploadFile_Wait tests that ?wait=true waits for the configured confirmation depth.
+func TestUploadFile_Wait(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var gotConfirmations uint64
+	mockC, mockIPFS := newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		gotConfirmations = confirmations
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7, GasUsed: 21000, Confirmations: confirmations}, nil
+	})
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithConfirmations(3, time.Second))
+
+	w := postUpload(router, "?wait=true")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint64(3), gotConfirmations)
+	var resp struct {
+		CID    string             `json:"cid"`
+		TxHash string             `json:"txHash"`
+		Tx     contracts.TxStatus `json:"tx"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmFakeCID", resp.CID)
+	assert.Equal(t, contracts.TxMined, resp.Tx.Status)
+	assert.Equal(t, uint64(7), resp.Tx.BlockNumber)
+
+	w = postUpload(router, "?confirmations=5")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, uint64(5), gotConfirmations)
+}
+
+// TestUploadFile_WaitReverted tests that a reverted transaction returns 422.
+func TestUploadFile_WaitReverted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 7, Confirmations: 1}, nil
+	})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := postUpload(router, "?wait=true")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "Transaction reverted", resp["message"])
+	assert.Equal(t, handlers.CodeTxReverted, resp["code"])
+	assert.Equal(t, testTxHash, resp["details"].(map[string]interface{})["txHash"])
+}
+
+// TestUploadFile_WaitCancelled tests that a save cancelled by a replacement returns 409.
+func TestUploadFile_WaitCancelled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxCancelled, BlockNumber: 7, Confirmations: 1}, nil
+	})
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := postUpload(router, "?wait=true")
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, handlers.CodeTxCancelled, errorCode(t, w))
+}
+
+// TestUploadFile_WaitTimeout tests that an unmined transaction returns 504 after the wait timeout.
+func TestUploadFile_WaitTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC, mockIPFS := newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithConfirmations(1, 10*time.Millisecond))
+
+	w := postUpload(router, "?wait=true")
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+// TestUploadFile_InvalidWait tests that a malformed wait parameter returns 400 before anything is saved.
+func TestUploadFile_InvalidWait(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{
+		addFunc: func(ctx *gin.Context, file []byte) (string, error) {
+			return "QmFakeCID", nil
+		},
+	})
+
+	w := postUpload(router, "?wait=maybe")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestGetTx_Success tests that the transaction status is returned.
+func TestGetTx_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		txStatusFunc: func(ctx context.Context, txHash string) (*contracts.TxStatus, error) {
+			return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 10, GasUsed: 50000, Confirmations: 2}, nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/tx/"+testTxHash, nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp contracts.TxStatus
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, contracts.TxMined, resp.Status)
+	assert.Equal(t, uint64(10), resp.BlockNumber)
+	assert.Equal(t, uint64(50000), resp.GasUsed)
+	assert.Equal(t, uint64(2), resp.Confirmations)
+}
+
+// TestGetTx_NotFound tests that an unknown transaction returns 404.
+func TestGetTx_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockC := &mockContract{
+		txStatusFunc: func(ctx context.Context, txHash string) (*contracts.TxStatus, error) {
+			return nil, contracts.ErrTxNotFound
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/tx/"+testTxHash, nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestGetTx_InvalidHash tests that a malformed hash returns 400.
+func TestGetTx_InvalidHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/tx/0x1234", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func postTx(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestSpeedUpTx_Success tests that the replacement hash is returned.
+func TestSpeedUpTx_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	replacement := "0x2222222222222222222222222222222222222222222222222222222222222222"
+	mockC := &mockContract{
+		speedUpFunc: func(ctx context.Context, txHash string) (string, error) {
+			assert.Equal(t, testTxHash, txHash)
+			return replacement, nil
+		},
+	}
+	router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+	w := postTx(router, "/v1/tx/"+testTxHash+"/speedup")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, testTxHash, resp["txHash"])
+	assert.Equal(t, replacement, resp["replacementTxHash"])
+}
+
+// TestCancelTx_Errors tests how replacement errors map to status codes.
+func TestCancelTx_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := map[error]int{
+		contracts.ErrTxNotTracked:   http.StatusNotFound,
+		contracts.ErrTxAlreadyMined: http.StatusConflict,
+		contracts.ErrFeeCapExceeded: http.StatusServiceUnavailable,
+		errors.New("node down"):     http.StatusInternalServerError,
+	}
+	for replaceErr, code := range cases {
+		mockC := &mockContract{
+			cancelFunc: func(ctx context.Context, txHash string) (string, error) {
+				return "", replaceErr
+			},
+		}
+		router := handlers.SetupRouter(mockC, &mockIPFSClient{})
+
+		w := postTx(router, "/v1/tx/"+testTxHash+"/cancel")
+		assert.Equal(t, code, w.Code, replaceErr.Error())
+	}
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w := postTx(router, "/v1/tx/0x1234/cancel")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFieldSize caps non-file multipart fields such as filePath.
+const maxFieldSize = 4096
+
+// limitedBody wraps a request body capped by http.MaxBytesReader and remembers whether the cap was hit,
+// so that errors surfacing from deeper layers (IPFS, JSON, multipart) can be reported as 413.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	tooLarge bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		b.tooLarge = true
+	}
+	return n, err
+}
+
+// limitBody caps the request body at limit bytes. It answers 413 and returns false right away
+// when the declared Content-Length is already over the limit.
+func limitBody(c *gin.Context, limit int64) (*limitedBody, bool) {
+	if c.Request.ContentLength > limit {
+		abortTooLarge(c, limit)
+		return nil, false
+	}
+	body := &limitedBody{ReadCloser: http.MaxBytesReader(c.Writer, c.Request.Body, limit), limit: limit}
+	c.Request.Body = body
+	return body, true
+}
+
+func abortTooLarge(c *gin.Context, limit int64) {
+	abortError(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", limit))
+}
+
+// PutFile streams the raw request body to IPFS and stores its CID under the path from the URL.
+func (h *Handlers) PutFile(c *gin.Context) {
+	if _, ok := h.requestedAsync(c); !ok {
+		return
+	}
+	body, ok := limitBody(c, h.MaxUploadSize)
+	if !ok {
+		return
+	}
+	filePath := c.Param("path")
+	if strings.TrimPrefix(filePath, "/") == "" {
+		filePath = ""
+	}
+	h.uploadRaw(c, filePath, body)
+}
+
+// uploadRaw streams the whole request body to IPFS.
+func (h *Handlers) uploadRaw(c *gin.Context, filePath string, body *limitedBody) {
+	if filePath == "" {
+		abortBadRequest(c, "Missing filePath")
+		return
+	}
+	if !h.allowWrite(c, filePath) {
+		return
+	}
+	if h.Outbox != nil {
+		h.enqueueUpload(c, filePath, body, body)
+		return
+	}
+
+	cid, err := h.IPFSClient.AddReader(c, body)
+	if err != nil {
+		h.abortAddError(c, body, err)
+		return
+	}
+
+	h.saveFile(c, filePath, cid)
+}
+
+// uploadMultipart streams the "file" part of a multipart/form-data body to IPFS. The filePath is taken
+// from the query string or from a "filePath" field, which must precede the file part.
+func (h *Handlers) uploadMultipart(c *gin.Context, body *limitedBody) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		abortBadRequest(c, "Invalid multipart body: "+err.Error())
+		return
+	}
+
+	filePath := c.Query("filePath")
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if body.tooLarge {
+				abortTooLarge(c, body.limit)
+				return
+			}
+			abortBadRequest(c, "Invalid multipart body: "+err.Error())
+			return
+		}
+
+		switch part.FormName() {
+		case "filePath":
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			if err != nil {
+				abortBadRequest(c, "Invalid filePath field: "+err.Error())
+				return
+			}
+			filePath = string(value)
+		case "file":
+			if filePath == "" {
+				abortBadRequest(c, "Missing filePath field before the file part")
+				return
+			}
+			if !h.allowWrite(c, filePath) {
+				return
+			}
+			if h.Outbox != nil {
+				h.enqueueUpload(c, filePath, part, body)
+				return
+			}
+			cid, err := h.IPFSClient.AddReader(c, part)
+			if err != nil {
+				h.abortAddError(c, body, err)
+				return
+			}
+			h.saveFile(c, filePath, cid)
+			return
+		}
+		part.Close()
+	}
+
+	abortBadRequest(c, "Missing file part")
+}
+
+func (h *Handlers) abortAddError(c *gin.Context, body *limitedBody, err error) {
+	if body.tooLarge {
+		abortTooLarge(c, body.limit)
+		return
+	}
+	abortError(c, http.StatusInternalServerError, CodeIPFSError, "IPFS Add error: "+err.Error())
+}
@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/avkos/file-registry/api/handlers"
+)
+
+// newUploadMocks returns mocks that record the streamed content and the saved path.
+func newUploadMocks(savedPath, streamed *string) (*mockContract, *mockIPFSClient) {
+	mockC := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			*savedPath = filePath
+			return "0x1234567890abcdef", nil
+		},
+	}
+	mockIPFS := &mockIPFSClient{
+		addReaderFunc: func(ctx context.Context, r io.Reader) (string, error) {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return "", err
+			}
+			*streamed = string(b)
+			return "QmFakeCID", nil
+		},
+	}
+	return mockC, mockIPFS
+}
+
+func multipartBody(t *testing.T, fields [][2]string) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range fields {
+		if f[0] == "file" {
+			fw, err := mw.CreateFormFile("file", "file.txt")
+			assert.NoError(t, err)
+			fw.Write([]byte(f[1]))
+			continue
+		}
+		assert.NoError(t, mw.WriteField(f[0], f[1]))
+	}
+	assert.NoError(t, mw.Close())
+	return &buf, mw.FormDataContentType()
+}
+
+// TestUploadFile_Multipart tests that a multipart upload is streamed to IPFS and saved.
+func TestUploadFile_Multipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var savedPath, streamed string
+	mockC, mockIPFS := newUploadMocks(&savedPath, &streamed)
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	body, contentType := multipartBody(t, [][2]string{{"filePath", "/test/file.txt"}, {"file", "Hello World!"}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files", body)
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmFakeCID", resp["cid"])
+	assert.Equal(t, "0x1234567890abcdef", resp["txHash"])
+	assert.Equal(t, "/test/file.txt", savedPath)
+	assert.Equal(t, "Hello World!", streamed)
+}
+
+// TestUploadFile_MultipartMissingFilePath tests that a file part without a preceding filePath returns 400.
+func TestUploadFile_MultipartMissingFilePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+
+	body, contentType := multipartBody(t, [][2]string{{"file", "Hello World!"}, {"filePath", "/test/file.txt"}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files", body)
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Contains(t, resp["message"], "Missing filePath")
+}
+
+// TestUploadFile_OctetStream tests that a raw POST body is saved under the filePath query parameter.
+func TestUploadFile_OctetStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var savedPath, streamed string
+	mockC, mockIPFS := newUploadMocks(&savedPath, &streamed)
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/v1/files?filePath=/test/raw.bin", strings.NewReader("raw bytes"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/test/raw.bin", savedPath)
+	assert.Equal(t, "raw bytes", streamed)
+}
+
+// TestPutFile_Success tests that a PUT body is saved under the path from the URL.
+func TestPutFile_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var savedPath, streamed string
+	mockC, mockIPFS := newUploadMocks(&savedPath, &streamed)
+	router := handlers.SetupRouter(mockC, mockIPFS)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/v1/files/reports/2026/q1.pdf", strings.NewReader("pdf bytes"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "QmFakeCID", resp["cid"])
+	assert.Equal(t, "/reports/2026/q1.pdf", savedPath)
+	assert.Equal(t, "pdf bytes", streamed)
+}
+
+// TestPutFile_TooLarge tests that a body over the configured limit returns 413.
+func TestPutFile_TooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var savedPath, streamed string
+	mockC, mockIPFS := newUploadMocks(&savedPath, &streamed)
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithMaxUploadSize(4))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/v1/files/test/big.bin", strings.NewReader("too many bytes"))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Without a Content-Length the limit is enforced while streaming.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPut, "/v1/files/test/big.bin", io.MultiReader(strings.NewReader("too many bytes")))
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, savedPath)
+}
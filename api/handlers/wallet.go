@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+// SignerStore keeps the wallets that authorized uploads relayed by the API and the upload nonces they used.
+type SignerStore interface {
+	RecordSigner(txHash common.Hash, logIndex uint, signer common.Address) error
+	UseNonce(signer common.Address, nonce *big.Int) error
+}
+
+// Error codes of wallet authentication failures.
+const (
+	CodeWalletAuthDisabled = "wallet_auth_disabled"
+	CodeInvalidSignature   = "invalid_signature"
+	CodeSignatureExpired   = "signature_expired"
+	CodeNonceUsed          = "nonce_used"
+	CodeCIDMismatch        = "cid_mismatch"
+)
+
+const walletKey = "wallet"
+
+// txWatchTimeout bounds how long the receipt of a save is awaited in the background to record its signer.
+const txWatchTimeout = time.Hour
+
+// WithSIWE enables Sign-In with Ethereum. Sign-in messages must name domain.
+func WithSIWE(domain string, sessions *auth.Sessions) Option {
+	return func(h *Handlers) {
+		h.SIWEDomain = domain
+		h.Sessions = sessions
+	}
+}
+
+// WithSignedUploads enables uploads authorized by an EIP-712 signature of the uploading wallet.
+func WithSignedUploads(domain auth.UploadDomain, signers SignerStore) Option {
+	return func(h *Handlers) {
+		h.UploadDomain = &domain
+		h.Signers = signers
+	}
+}
+
+// WithSignerStore records the wallets of the saves the API sends for SIWE sessions, so that they
+// keep writing the paths they created, see allowWrite.
+func WithSignerStore(signers SignerStore) Option {
+	return func(h *Handlers) {
+		h.Signers = signers
+	}
+}
+
+// WalletFrom returns the wallet a request acts for, from its SIWE session or upload signature, or nil.
+func WalletFrom(c *gin.Context) *common.Address {
+	if wallet, ok := c.Get(walletKey); ok {
+		address := wallet.(common.Address)
+		return &address
+	}
+	return nil
+}
+
+// GetNonce issues a nonce for a Sign-In with Ethereum message.
+func (h *Handlers) GetNonce(c *gin.Context) {
+	if h.Sessions == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeWalletAuthDisabled, "Sign-In with Ethereum is not enabled")
+		return
+	}
+	nonce, err := h.Sessions.Nonce()
+	if err != nil {
+		abortError(c, http.StatusTooManyRequests, string(contracts.CodeQuotaExceeded), "Sign-in nonce error: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"nonce": nonce})
+}
+
+// LoginRequest is a signed EIP-4361 message.
+type LoginRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// Login verifies a signed Sign-In with Ethereum message and returns a session token, used like an
+// API key with the file scopes of the wallet.
+func (h *Handlers) Login(c *gin.Context) {
+	if h.Sessions == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeWalletAuthDisabled, "Sign-In with Ethereum is not enabled")
+		return
+	}
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return
+	}
+	signature, err := hexutil.Decode(req.Signature)
+	if err != nil {
+		abortBadRequest(c, "Invalid signature: "+err.Error())
+		return
+	}
+
+	token, session, err := h.Sessions.Login(h.SIWEDomain, req.Message, signature)
+	switch {
+	case errors.Is(err, auth.ErrInvalidMessage):
+		abortBadRequest(c, err.Error())
+		return
+	case err != nil:
+		abortError(c, http.StatusUnauthorized, CodeInvalidSignature, "Sign-in failed: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "address": session.Address.Hex(), "expiresAt": session.ExpiresAt})
+}
+
+// SignedUploadRequest is an upload authorized by the EIP-712 signature of a wallet over
+// Upload(filePath, cid, nonce, deadline). File is optional: when set it is added to IPFS and must
+// produce cid, otherwise cid must already be available on IPFS.
+type SignedUploadRequest struct {
+	FilePath  string `json:"filePath"`
+	CID       string `json:"cid"`
+	File      string `json:"file,omitempty"`
+	Nonce     string `json:"nonce"`
+	Deadline  int64  `json:"deadline"`
+	Signature string `json:"signature"`
+}
+
+// UploadSigned saves a CID for a wallet: the signature is verified, the signer must be allowed to
+// write the path by the contract, and the signer is recorded with the save once it is mined. The
+// request itself needs the files:write scope, so only API clients spend gas on relayed uploads.
+func (h *Handlers) UploadSigned(c *gin.Context) {
+	if h.UploadDomain == nil || h.Signers == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeWalletAuthDisabled, "Signed uploads are not enabled")
+		return
+	}
+	var req SignedUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return
+	}
+	if req.FilePath == "" || req.CID == "" {
+		abortBadRequest(c, "Missing filePath or cid field")
+		return
+	}
+	nonce, ok := new(big.Int).SetString(req.Nonce, 10)
+	if !ok || nonce.Sign() < 0 || nonce.BitLen() > 256 {
+		abortBadRequest(c, "Invalid nonce: "+req.Nonce)
+		return
+	}
+	if !time.Now().Before(time.Unix(req.Deadline, 0)) {
+		abortError(c, http.StatusUnauthorized, CodeSignatureExpired, "Signature deadline has passed")
+		return
+	}
+	signature, err := hexutil.Decode(req.Signature)
+	if err != nil {
+		abortBadRequest(c, "Invalid signature: "+err.Error())
+		return
+	}
+
+	upload := auth.Upload{FilePath: req.FilePath, CID: req.CID, Nonce: nonce, Deadline: big.NewInt(req.Deadline)}
+	signer, err := h.UploadDomain.RecoverUploadSigner(upload, signature)
+	if err != nil {
+		abortError(c, http.StatusUnauthorized, CodeInvalidSignature, err.Error())
+		return
+	}
+	c.Set(walletKey, signer)
+	if !h.allowWrite(c, req.FilePath) {
+		return
+	}
+
+	if req.File != "" {
+		fileBytes, err := base64.StdEncoding.DecodeString(req.File)
+		if err != nil {
+			abortBadRequest(c, "Invalid base64 data: "+err.Error())
+			return
+		}
+		cid, err := h.IPFSClient.Add(c, fileBytes)
+		if err != nil {
+			abortError(c, http.StatusInternalServerError, CodeIPFSError, "IPFS Add error: "+err.Error())
+			return
+		}
+		if cid != req.CID {
+			abortError(c, http.StatusBadRequest, CodeCIDMismatch, "File content has CID "+cid+", not the signed "+req.CID)
+			return
+		}
+	}
+
+	if err := h.Signers.UseNonce(signer, nonce); err != nil {
+		if errors.Is(err, indexer.ErrNonceUsed) {
+			abortError(c, http.StatusConflict, CodeNonceUsed, "Nonce "+req.Nonce+" was already used by "+signer.Hex())
+			return
+		}
+		abortError(c, http.StatusInternalServerError, CodeIndexError, "Nonce store error: "+err.Error())
+		return
+	}
+	h.saveFile(c, req.FilePath, req.CID)
+}
+
+// allowWrite answers 403 and returns false when the API key of the request may not use filePath, or
+// when the request acts for a wallet the contract does not let write filePath.
+//
+// The API saves for wallets from its own accounts, so the first save of a path for a wallet makes
+// one of them the owner on-chain. The wallet keeps writing such a path when the index shows that
+// the first save of the path was its own.
+func (h *Handlers) allowWrite(c *gin.Context, filePath string) bool {
+	if !allowPath(c, filePath) {
+		return false
+	}
+	wallet := WalletFrom(c)
+	if wallet == nil {
+		return true
+	}
+	access, err := h.Contract.Access(filePath, wallet)
+	if err != nil {
+		abortContractError(c, "Contract access error: ", err)
+		return false
+	}
+	if access.CanWrite != nil && *access.CanWrite {
+		return true
+	}
+	created, err := h.createdFor(filePath, access.Owner, *wallet)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeIndexError, "Index error: "+err.Error())
+		return false
+	}
+	if !created {
+		abortError(c, http.StatusForbidden, CodePathNotAllowed, "Wallet "+wallet.Hex()+" may not write "+filePath)
+		return false
+	}
+	return true
+}
+
+// createdFor reports whether filePath is owned by an account of the API because the API saved it
+// first for wallet, as recorded with recordSigner.
+func (h *Handlers) createdFor(filePath string, owner, wallet common.Address) (bool, error) {
+	if h.Index == nil || h.Accounts == nil || owner == (common.Address{}) {
+		return false, nil
+	}
+	own := false
+	for _, account := range h.Accounts.Accounts() {
+		own = own || account.Address == owner
+	}
+	if !own {
+		return false, nil
+	}
+	events, _, err := h.Index.History(filePath, "", 1)
+	if err != nil || len(events) == 0 {
+		return false, err
+	}
+	return events[0].Signer == wallet.Hex(), nil
+}
+
+// recordSigner records the wallet of the saves of a transaction once it is mined successfully. The
+// transaction is already sent, so failures are only logged.
+func (h *Handlers) recordSigner(status *contracts.TxStatus, signer common.Address) {
+	if h.Signers == nil || status.Status != contracts.TxMined {
+		return
+	}
+	for _, logIndex := range status.SavedLogs {
+		if err := h.Signers.RecordSigner(status.MinedHash(), logIndex, signer); err != nil {
+			log.Printf("failed to record signer %s of %s: %v", signer.Hex(), status.Hash, err)
+		}
+	}
+}
+
+// watchTx waits in the background, within txWatchTimeout, for the transaction to be mined, reverted
+// or cancelled, and then calls fn with its status.
+func (h *Handlers) watchTx(txHash string, fn func(*contracts.TxStatus)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), txWatchTimeout)
+		defer cancel()
+		status, err := h.Contract.WaitMined(ctx, txHash, 1)
+		if err != nil {
+			log.Printf("failed to wait for transaction %s: %v", txHash, err)
+			return
+		}
+		fn(status)
+	}()
+}
@@ -0,0 +1,405 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/auth"
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/indexer"
+)
+
+type mockSignerStore struct {
+	mu      sync.Mutex
+	signers map[string]common.Address
+	nonces  map[string]bool
+}
+
+func newMockSignerStore() *mockSignerStore {
+	return &mockSignerStore{signers: make(map[string]common.Address), nonces: make(map[string]bool)}
+}
+
+func (m *mockSignerStore) RecordSigner(txHash common.Hash, logIndex uint, signer common.Address) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signers[txHash.Hex()+" "+strconv.FormatUint(uint64(logIndex), 10)] = signer
+	return nil
+}
+
+// signer returns the signer recorded for the log of a transaction, waiting for saves recorded in the background.
+func (m *mockSignerStore) signer(t *testing.T, txHash string, logIndex uint) common.Address {
+	key := common.HexToHash(txHash).Hex() + " " + strconv.FormatUint(uint64(logIndex), 10)
+	var signer common.Address
+	assert.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		signer = m.signers[key]
+		return signer != common.Address{}
+	}, 5*time.Second, 10*time.Millisecond, "no signer recorded for %s", key)
+	return signer
+}
+
+func (m *mockSignerStore) UseNonce(signer common.Address, nonce *big.Int) error {
+	key := signer.Hex() + nonce.String()
+	if m.nonces[key] {
+		return indexer.ErrNonceUsed
+	}
+	m.nonces[key] = true
+	return nil
+}
+
+var testUploadDomain = auth.UploadDomain{ChainID: big.NewInt(1337), Contract: common.HexToAddress("0x00000000000000000000000000000000000000cc")}
+
+// newWalletMocks returns mocks letting writer write /team-a/ paths only. Saves are mined with a
+// FileSaved event at log index 0.
+func newWalletMocks(writer common.Address) (*mockContract, *mockIPFSClient) {
+	mockC := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			return testTxHash, nil
+		},
+		waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+			return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 5, Confirmations: confirmations, SavedLogs: []uint{0}}, nil
+		},
+		accessFunc: func(path string, account *common.Address) (*contracts.Access, error) {
+			canWrite := *account == writer && strings.HasPrefix(path, "/team-a/")
+			return &contracts.Access{CanWrite: &canWrite}, nil
+		},
+	}
+	mockIPFS := &mockIPFSClient{
+		addFunc: func(ctx *gin.Context, file []byte) (string, error) {
+			return "QmFakeCID", nil
+		},
+	}
+	return mockC, mockIPFS
+}
+
+func signUpload(t *testing.T, key *ecdsa.PrivateKey, upload auth.Upload) string {
+	hash, err := testUploadDomain.Hash(upload)
+	require.NoError(t, err)
+	sig, err := crypto.Sign(hash, key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(sig)
+}
+
+func signedUploadBody(t *testing.T, key *ecdsa.PrivateKey, filePath string, nonce, deadline int64, file string) string {
+	upload := auth.Upload{FilePath: filePath, CID: "QmFakeCID", Nonce: big.NewInt(nonce), Deadline: big.NewInt(deadline)}
+	body, err := json.Marshal(handlers.SignedUploadRequest{
+		FilePath:  filePath,
+		CID:       "QmFakeCID",
+		File:      file,
+		Nonce:     strconv.FormatInt(nonce, 10),
+		Deadline:  deadline,
+		Signature: signUpload(t, key, upload),
+	})
+	require.NoError(t, err)
+	return string(body)
+}
+
+// TestUploadSigned_Success tests that a signed upload is saved and its signer recorded.
+func TestUploadSigned_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	mockC, mockIPFS := newWalletMocks(wallet)
+	signers := newMockSignerStore()
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithSignedUploads(testUploadDomain, signers))
+	deadline := time.Now().Add(time.Hour).Unix()
+
+	file := base64.StdEncoding.EncodeToString([]byte("hi"))
+	w := sendJSON(router, http.MethodPost, "/v1/files/signed", signedUploadBody(t, key, "/team-a/a.txt", 1, deadline, file))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, wallet.Hex(), resp["signer"])
+	assert.Equal(t, testTxHash, resp["txHash"])
+	assert.Equal(t, wallet, signers.signer(t, testTxHash, 0))
+
+	// The same nonce is accepted once.
+	w = sendJSON(router, http.MethodPost, "/v1/files/signed", signedUploadBody(t, key, "/team-a/a.txt", 1, deadline, ""))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, handlers.CodeNonceUsed, errorCode(t, w))
+}
+
+// TestUploadSigned_Rejected tests the checks run before a signed upload is relayed.
+func TestUploadSigned_Rejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	mockC, mockIPFS := newWalletMocks(crypto.PubkeyToAddress(key.PublicKey))
+	mockC.saveFunc = func(filePath, cid string) (string, error) {
+		t.Fatalf("unexpected save of %s", filePath)
+		return "", nil
+	}
+	mockIPFS.addFunc = func(ctx *gin.Context, file []byte) (string, error) {
+		return "QmOtherCID", nil
+	}
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithSignedUploads(testUploadDomain, newMockSignerStore()))
+	deadline := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{signedUploadBody(t, key, "/team-a/a.txt", 1, time.Now().Add(-time.Minute).Unix(), ""), http.StatusUnauthorized, handlers.CodeSignatureExpired},
+		{signedUploadBody(t, other, "/team-a/a.txt", 1, deadline, ""), http.StatusForbidden, handlers.CodePathNotAllowed},
+		{signedUploadBody(t, key, "/team-b/a.txt", 1, deadline, ""), http.StatusForbidden, handlers.CodePathNotAllowed},
+		{signedUploadBody(t, key, "/team-a/a.txt", 1, deadline, base64.StdEncoding.EncodeToString([]byte("hi"))), http.StatusBadRequest, handlers.CodeCIDMismatch},
+		{`{"filePath":"/team-a/a.txt","cid":"QmFakeCID","nonce":"x","deadline":1,"signature":"0x00"}`, http.StatusBadRequest, handlers.CodeInvalidRequest},
+	}
+	for _, tc := range cases {
+		w := sendJSON(router, http.MethodPost, "/v1/files/signed", tc.body)
+		assert.Equal(t, tc.status, w.Code, tc.body)
+		assert.Equal(t, tc.code, errorCode(t, w), tc.body)
+	}
+
+	router = handlers.SetupRouter(mockC, mockIPFS)
+	w := sendJSON(router, http.MethodPost, "/v1/files/signed", signedUploadBody(t, key, "/team-a/a.txt", 1, deadline, ""))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// walletKeyRing returns the keys of newKeyRing and a key letting wallet use its sessions on prefixes.
+func walletKeyRing(t *testing.T, wallet common.Address, prefixes ...string) *auth.KeyRing {
+	ring, err := auth.NewKeyRing([]auth.Key{
+		{ID: "team-a", Hash: auth.HashKey("team-a-key"), Scopes: []auth.Scope{auth.ScopeFilesRead, auth.ScopeFilesWrite}, Prefixes: []string{"/team-a/"}},
+		{ID: "wallet", Wallet: &wallet, Scopes: []auth.Scope{auth.ScopeFilesRead, auth.ScopeFilesWrite}, Prefixes: prefixes},
+	})
+	require.NoError(t, err)
+	return ring
+}
+
+// login signs in with a SIWE message signed by key and returns the session token.
+func login(t *testing.T, router *gin.Engine, key *ecdsa.PrivateKey) string {
+	w := getPath(router, "/v1/auth/nonce")
+	require.Equal(t, http.StatusOK, w.Code)
+	var nonce struct {
+		Nonce string `json:"nonce"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &nonce))
+
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	message := strings.Join([]string{
+		"files.example.com wants you to sign in with your Ethereum account:",
+		wallet.Hex(),
+		"",
+		"",
+		"URI: https://files.example.com",
+		"Version: 1",
+		"Chain ID: 1337",
+		"Nonce: " + nonce.Nonce,
+		"Issued At: " + time.Now().UTC().Format(time.RFC3339),
+	}, "\n")
+	sig, err := crypto.Sign(accounts.TextHash([]byte(message)), key)
+	require.NoError(t, err)
+	body, err := json.Marshal(handlers.LoginRequest{Message: message, Signature: hexutil.Encode(sig)})
+	require.NoError(t, err)
+
+	w = sendJSON(router, http.MethodPost, "/v1/auth/login", string(body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Token   string `json:"token"`
+		Address string `json:"address"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, wallet.Hex(), resp.Address)
+
+	// The nonce was consumed by the login.
+	w = sendJSON(router, http.MethodPost, "/v1/auth/login", string(body))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	return resp.Token
+}
+
+func uploadBody(filePath string) []byte {
+	return []byte(`{"filePath":"` + filePath + `","file":"` + base64.StdEncoding.EncodeToString([]byte("hi")) + `"}`)
+}
+
+// TestLogin_SessionWrites tests that a SIWE session token authorizes writes the wallet may make on-chain.
+func TestLogin_SessionWrites(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	mockC, mockIPFS := newWalletMocks(wallet)
+	signers := newMockSignerStore()
+	router := handlers.SetupRouter(mockC, mockIPFS,
+		handlers.WithAPIKeys(walletKeyRing(t, wallet)),
+		handlers.WithSIWE("files.example.com", auth.NewSessions(1337, time.Hour)),
+		handlers.WithSignedUploads(testUploadDomain, signers),
+	)
+	token := login(t, router, key)
+
+	w := authRequest(router, http.MethodPost, "/v1/files", token, uploadBody("/team-a/a.txt"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, wallet, signers.signer(t, testTxHash, 0))
+
+	w = authRequest(router, http.MethodPost, "/v1/files", token, uploadBody("/team-b/a.txt"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodPost, "/v1/tx/"+testTxHash+"/cancel", token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeInsufficientScope, errorCode(t, w))
+}
+
+// TestLogin_SessionKeys tests that with API keys, sessions need a wallet key and keep to its prefixes.
+func TestLogin_SessionKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	mockC, mockIPFS := newWalletMocks(wallet)
+	mockC.getFunc = func(filePath string) (string, error) {
+		return "QmFakeCID", nil
+	}
+	stranger, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	router := handlers.SetupRouter(mockC, mockIPFS,
+		handlers.WithAPIKeys(walletKeyRing(t, wallet, "/team-a/docs/")),
+		handlers.WithSIWE("files.example.com", auth.NewSessions(1337, time.Hour)),
+	)
+
+	// A wallet without a key signs in, but cannot use its session.
+	w := authRequest(router, http.MethodGet, "/v1/files?filePath=/team-a/docs/a.txt", login(t, router, stranger), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeWalletNotAllowed, errorCode(t, w))
+
+	token := login(t, router, key)
+	w = authRequest(router, http.MethodGet, "/v1/files?filePath=/team-a/docs/a.txt", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The contract lets the wallet write every /team-a/ path, but its key only /team-a/docs/.
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/v1/files?filePath=/team-a/a.txt"},
+		{http.MethodPost, "/v1/files"},
+	} {
+		w = authRequest(router, req.method, req.path, token, uploadBody("/team-a/a.txt"))
+		assert.Equal(t, http.StatusForbidden, w.Code, req.path)
+		assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w), req.path)
+	}
+}
+
+// TestUploadSigned_RequiresKey tests that with API keys, only authenticated clients relay signed uploads.
+func TestUploadSigned_RequiresKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	mockC, mockIPFS := newWalletMocks(crypto.PubkeyToAddress(key.PublicKey))
+	router := handlers.SetupRouter(mockC, mockIPFS,
+		handlers.WithAPIKeys(newKeyRing(t)),
+		handlers.WithSignedUploads(testUploadDomain, newMockSignerStore()),
+	)
+	body := signedUploadBody(t, key, "/team-a/a.txt", 1, time.Now().Add(time.Hour).Unix(), "")
+
+	w := sendJSON(router, http.MethodPost, "/v1/files/signed", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = authRequest(router, http.MethodPost, "/v1/files/signed", "reader-key", []byte(body))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodeInsufficientScope, errorCode(t, w))
+	w = authRequest(router, http.MethodPost, "/v1/files/signed", "team-a-key", []byte(body))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// TestUploadSigned_RevertedNotRecorded tests that the signer of a reverted save is not recorded.
+func TestUploadSigned_RevertedNotRecorded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	mockC, mockIPFS := newWalletMocks(crypto.PubkeyToAddress(key.PublicKey))
+	mockC.waitMinedFunc = func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 5}, nil
+	}
+	signers := newMockSignerStore()
+	router := handlers.SetupRouter(mockC, mockIPFS, handlers.WithSignedUploads(testUploadDomain, signers))
+
+	body := signedUploadBody(t, key, "/team-a/a.txt", 1, time.Now().Add(time.Hour).Unix(), "")
+	w := sendJSON(router, http.MethodPost, "/v1/files/signed?wait=true", body)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Empty(t, signers.signers)
+}
+
+// TestUploadSigned_SamePathTwice tests that a wallet keeps writing a path the API created for it,
+// although an account of the API owns the path on-chain, and that other wallets may not.
+func TestUploadSigned_SamePathTwice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	server := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	signers := newMockSignerStore()
+	var mu sync.Mutex
+	saved := false
+	mockC, mockIPFS := newWalletMocks(wallet)
+	mockC.saveFunc = func(filePath, cid string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = true
+		return testTxHash, nil
+	}
+	mockC.accessFunc = func(path string, account *common.Address) (*contracts.Access, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		// The first save makes the API account the owner of the path.
+		if saved {
+			canWrite := *account == server
+			return &contracts.Access{Owner: server, CanWrite: &canWrite}, nil
+		}
+		canWrite := true
+		return &contracts.Access{CanWrite: &canWrite}, nil
+	}
+	index := &mockIndex{historyFunc: func(filePath, cursor string, limit int) ([]indexer.Event, string, error) {
+		assert.Equal(t, 1, limit)
+		signers.mu.Lock()
+		defer signers.mu.Unlock()
+		signer, ok := signers.signers[common.HexToHash(testTxHash).Hex()+" 0"]
+		if !ok {
+			return nil, "", nil
+		}
+		return []indexer.Event{{FilePath: filePath, TxHash: testTxHash, Signer: signer.Hex()}}, "", nil
+	}}
+	router := handlers.SetupRouter(mockC, mockIPFS,
+		handlers.WithSignedUploads(testUploadDomain, signers),
+		handlers.WithIndex(index),
+		handlers.WithAccounts(mockAccountPool{{Address: server}}),
+	)
+	deadline := time.Now().Add(time.Hour).Unix()
+
+	w := sendJSON(router, http.MethodPost, "/v1/files/signed?wait=true", signedUploadBody(t, key, "/team-a/a.txt", 1, deadline, ""))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = sendJSON(router, http.MethodPost, "/v1/files/signed?wait=true", signedUploadBody(t, key, "/team-a/a.txt", 2, deadline, ""))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = sendJSON(router, http.MethodPost, "/v1/files/signed", signedUploadBody(t, otherKey, "/team-a/a.txt", 1, deadline, ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, handlers.CodePathNotAllowed, errorCode(t, w))
+}
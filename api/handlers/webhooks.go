@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// Webhooks manages webhook subscriptions and their delivery logs, and reports the uploads the API
+// saves outside the outbox.
+type Webhooks interface {
+	Subscribe(sub webhooks.Subscription) (*webhooks.Subscription, error)
+	Subscription(id string) (*webhooks.Subscription, error)
+	Subscriptions() ([]webhooks.Subscription, error)
+	Unsubscribe(id string) error
+	Deliveries(subscriptionID, cursor string, limit int) ([]webhooks.Delivery, string, error)
+	Replay(subscriptionID, deliveryID string) (*webhooks.Delivery, error)
+	SaveFinished(filePath, cid string, status *contracts.TxStatus)
+}
+
+// Error codes of the webhook endpoints.
+const (
+	CodeWebhooksDisabled = "webhooks_disabled"
+	CodeWebhookError     = "webhook_error"
+)
+
+// WebhookRequest registers a webhook: the URL receives the events of files under Prefix. Events
+// selects the event types, every type when empty. Secret is generated when empty.
+type WebhookRequest struct {
+	URL    string               `json:"url"`
+	Prefix string               `json:"prefix"`
+	Events []webhooks.EventType `json:"events"`
+	Secret string               `json:"secret"`
+}
+
+// WithWebhooks enables the webhook endpoints.
+func WithWebhooks(hooks Webhooks) Option {
+	return func(h *Handlers) {
+		h.Webhooks = hooks
+	}
+}
+
+// CreateWebhook registers a webhook and returns it with its secret, which is not shown again.
+func (h *Handlers) CreateWebhook(c *gin.Context) {
+	if !h.requireWebhooks(c) {
+		return
+	}
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortBadRequest(c, "Failed to parse JSON: "+err.Error())
+		return
+	}
+	sub, err := h.Webhooks.Subscribe(webhooks.Subscription{URL: req.URL, Prefix: req.Prefix, Events: req.Events, Secret: req.Secret})
+	if errors.Is(err, webhooks.ErrInvalidSubscription) {
+		abortBadRequest(c, err.Error())
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeWebhookError, "Failed to create webhook: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+// ListWebhooks lists the webhooks, oldest first, without their secrets.
+func (h *Handlers) ListWebhooks(c *gin.Context) {
+	if !h.requireWebhooks(c) {
+		return
+	}
+	subs, err := h.Webhooks.Subscriptions()
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, CodeWebhookError, "Failed to list webhooks: "+err.Error())
+		return
+	}
+	if subs == nil {
+		subs = []webhooks.Subscription{}
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": subs})
+}
+
+// GetWebhook returns a webhook without its secret.
+func (h *Handlers) GetWebhook(c *gin.Context) {
+	sub, ok := h.webhook(c)
+	if !ok {
+		return
+	}
+	sub.Secret = ""
+	c.JSON(http.StatusOK, sub)
+}
+
+// DeleteWebhook deletes a webhook with its delivery log.
+func (h *Handlers) DeleteWebhook(c *gin.Context) {
+	if !h.requireWebhooks(c) {
+		return
+	}
+	if err := h.Webhooks.Unsubscribe(c.Param("id")); err != nil {
+		abortWebhookError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook, newest first, with the status, attempts
+// and latest response of every delivery. Results are paginated with the limit and cursor query parameters.
+func (h *Handlers) GetWebhookDeliveries(c *gin.Context) {
+	if !h.requireWebhooks(c) {
+		return
+	}
+	limit, ok := pageSize(c)
+	if !ok {
+		return
+	}
+	deliveries, next, err := h.Webhooks.Deliveries(c.Param("id"), c.Query("cursor"), limit)
+	if err != nil {
+		abortWebhookError(c, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []webhooks.Delivery{}
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "nextCursor": next})
+}
+
+// ReplayWebhookDelivery sends the event of a logged delivery again and returns the new delivery.
+func (h *Handlers) ReplayWebhookDelivery(c *gin.Context) {
+	if !h.requireWebhooks(c) {
+		return
+	}
+	delivery, err := h.Webhooks.Replay(c.Param("id"), c.Param("deliveryId"))
+	if err != nil {
+		abortWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// webhook returns the webhook of the id path parameter, answering an error and returning false
+// when there is none.
+func (h *Handlers) webhook(c *gin.Context) (*webhooks.Subscription, bool) {
+	if !h.requireWebhooks(c) {
+		return nil, false
+	}
+	sub, err := h.Webhooks.Subscription(c.Param("id"))
+	if err != nil {
+		abortWebhookError(c, err)
+		return nil, false
+	}
+	return sub, true
+}
+
+// requireWebhooks answers 503 and returns false when webhooks are disabled.
+func (h *Handlers) requireWebhooks(c *gin.Context) bool {
+	if h.Webhooks == nil {
+		abortError(c, http.StatusServiceUnavailable, CodeWebhooksDisabled, "Webhooks are not enabled")
+		return false
+	}
+	return true
+}
+
+// abortWebhookError answers 404 for unknown webhooks and deliveries, 400 for invalid cursors and 500
+// otherwise.
+func abortWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrSubscriptionNotFound), errors.Is(err, webhooks.ErrDeliveryNotFound):
+		abortError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, webhooks.ErrInvalidCursor):
+		abortBadRequest(c, "Invalid cursor query parameter: "+c.Query("cursor"))
+	default:
+		abortError(c, http.StatusInternalServerError, CodeWebhookError, "Webhook error: "+err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/avkos/file-registry/api/webhooks"
+)
+
+func newDispatcher(t *testing.T) *webhooks.Dispatcher {
+	store, err := webhooks.OpenStore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return webhooks.New(store)
+}
+
+// TestWebhooks_Lifecycle tests that webhooks are created, listed without secrets and deleted.
+func TestWebhooks_Lifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithWebhooks(newDispatcher(t)), handlers.WithAPIKeys(newKeyRing(t)))
+
+	body := []byte(`{"url":"https://example.com/hook","prefix":"/team-a/","events":["upload.confirmed"]}`)
+	w := authRequest(router, http.MethodPost, "/v1/webhooks", "team-a-key", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = authRequest(router, http.MethodPost, "/v1/webhooks", "ops-key", body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var sub webhooks.Subscription
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.NotEmpty(t, sub.ID)
+	assert.NotEmpty(t, sub.Secret)
+	assert.Equal(t, "/team-a/", sub.Prefix)
+
+	w = authRequest(router, http.MethodGet, "/v1/webhooks", "ops-key", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), sub.ID)
+	assert.NotContains(t, w.Body.String(), sub.Secret)
+
+	w = authRequest(router, http.MethodGet, "/v1/webhooks/"+sub.ID, "ops-key", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), sub.Secret)
+
+	w = authRequest(router, http.MethodPost, "/v1/webhooks", "ops-key", []byte(`{"url":"not a url"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = authRequest(router, http.MethodDelete, "/v1/webhooks/"+sub.ID, "ops-key", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = authRequest(router, http.MethodGet, "/v1/webhooks/"+sub.ID, "ops-key", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestWebhooks_DeliveriesAndReplay tests that the delivery log is returned and deliveries are replayed.
+func TestWebhooks_DeliveriesAndReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	d := newDispatcher(t)
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	d.JobFinished(outbox.Job{ID: "job-1", FilePath: "/file.txt", Stage: outbox.StageMined, UpdatedAt: time.Now()})
+	router := handlers.SetupRouter(&mockContract{}, &mockIPFSClient{}, handlers.WithWebhooks(d))
+
+	w := getPath(router, "/v1/webhooks/"+sub.ID+"/deliveries")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Deliveries []webhooks.Delivery `json:"deliveries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Deliveries, 1)
+	delivery := resp.Deliveries[0]
+	assert.Equal(t, webhooks.EventUploadConfirmed, delivery.Event.Type)
+	assert.Equal(t, webhooks.DeliveryPending, delivery.Status)
+
+	w = sendJSON(router, http.MethodPost, "/v1/webhooks/"+sub.ID+"/deliveries/"+delivery.ID+"/replay", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var replay webhooks.Delivery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &replay))
+	assert.Equal(t, delivery.ID, replay.ReplayOf)
+
+	w = sendJSON(router, http.MethodPost, "/v1/webhooks/"+sub.ID+"/deliveries/unknown/replay", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = getPath(router, "/v1/webhooks/"+sub.ID+"/deliveries?cursor=bad")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = getPath(router, "/v1/webhooks/unknown/deliveries")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	router = handlers.SetupRouter(&mockContract{}, &mockIPFSClient{})
+	w = getPath(router, "/v1/webhooks")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, handlers.CodeWebhooksDisabled, errorCode(t, w))
+}
+
+// TestWebhooks_SynchronousUploads tests that uploads saved outside the outbox are reported once their
+// transaction is final, whether the request waits for it or not.
+func TestWebhooks_SynchronousUploads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	d := newDispatcher(t)
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	events := func() []webhooks.EventType {
+		deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+		require.NoError(t, err)
+		var types []webhooks.EventType
+		for _, delivery := range deliveries {
+			types = append(types, delivery.Event.Type)
+		}
+		return types
+	}
+
+	mockC, mockIPFS := newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7, Confirmations: confirmations}, nil
+	})
+	w := postUpload(handlers.SetupRouter(mockC, mockIPFS, handlers.WithWebhooks(d)), "?wait=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []webhooks.EventType{webhooks.EventUploadConfirmed}, events())
+
+	mockC, mockIPFS = newWaitMocks(func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 8, Confirmations: confirmations}, nil
+	})
+	w = postUpload(handlers.SetupRouter(mockC, mockIPFS, handlers.WithWebhooks(d)), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Eventually(t, func() bool {
+		types := events()
+		return len(types) == 2 && types[0] == webhooks.EventTxReverted
+	}, 5*time.Second, 10*time.Millisecond)
+	deliveries, _, err := d.Deliveries(sub.ID, "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "/test/file.txt", deliveries[0].Event.FilePath)
+	assert.Equal(t, "QmFakeCID", deliveries[0].Event.CID)
+	assert.Equal(t, "transaction reverted", deliveries[0].Event.Error)
+}
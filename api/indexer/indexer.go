@@ -0,0 +1,284 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// Defaults used when no Option overrides them.
+const (
+	DefaultBatchSize    uint64 = 2000
+	DefaultPollInterval        = 15 * time.Second
+)
+
+// Backend is the part of the Ethereum client the indexer reads logs from. SubscribeFilterLogs only
+// works over websocket or IPC connections; over HTTP the indexer falls back to polling.
+type Backend interface {
+	ethereum.LogFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}
+
+// Indexer builds the local path to CID database from the FileSaved and FileRemoved logs of the
+// registry contract.
+type Indexer struct {
+	backend      Backend
+	registry     *contracts.FileRegistry
+	forwarder    *contracts.Forwarder
+	store        *Store
+	startBlock   uint64
+	batchSize    uint64
+	pollInterval time.Duration
+	listener     func(Event)
+}
+
+// Option configures an Indexer.
+type Option func(*Indexer)
+
+// WithStartBlock sets the block the first sync starts from, usually the contract deployment block.
+// It is ignored once the store has a checkpoint.
+func WithStartBlock(block uint64) Option {
+	return func(ix *Indexer) {
+		ix.startBlock = block
+	}
+}
+
+// WithBatchSize sets the number of blocks requested per FilterLogs call. Zero keeps the default.
+func WithBatchSize(blocks uint64) Option {
+	return func(ix *Indexer) {
+		if blocks > 0 {
+			ix.batchSize = blocks
+		}
+	}
+}
+
+// WithPollInterval sets how often the chain is polled when subscriptions are unavailable, and how
+// often a subscribed indexer moves its checkpoint. Zero keeps the default.
+func WithPollInterval(interval time.Duration) Option {
+	return func(ix *Indexer) {
+		if interval > 0 {
+			ix.pollInterval = interval
+		}
+	}
+}
+
+// WithForwarder sets the trusted forwarder of the registry, so that the Writer of saves it relays is
+// the account that signed the request.
+func WithForwarder(forwarder *contracts.Forwarder) Option {
+	return func(ix *Indexer) {
+		ix.forwarder = forwarder
+	}
+}
+
+// WithListener calls fn with every FileSaved and FileRemoved event once it is stored. Events are
+// passed in chain order, but an event seen by the subscription is passed again when the next sync
+// covers its block.
+func WithListener(fn func(Event)) Option {
+	return func(ix *Indexer) {
+		ix.listener = fn
+	}
+}
+
+// New creates an Indexer writing the logs of registry to store.
+func New(backend Backend, registry *contracts.FileRegistry, store *Store, opts ...Option) *Indexer {
+	ix := &Indexer{
+		backend:      backend,
+		registry:     registry,
+		store:        store,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(ix)
+	}
+	return ix
+}
+
+// Store returns the database the indexer writes to.
+func (ix *Indexer) Store() *Store {
+	return ix.store
+}
+
+// Sync indexes the logs from the block after the checkpoint up to the current head, in batches,
+// moving the checkpoint after every batch so an interrupted sync resumes where it stopped.
+func (ix *Indexer) Sync(ctx context.Context) error {
+	head, err := ix.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block: %w", err)
+	}
+
+	from := ix.startBlock
+	checkpoint, ok, err := ix.store.Checkpoint()
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if ok {
+		from = checkpoint + 1
+	}
+
+	for to := head.Number.Uint64(); from <= to; {
+		end := min(from+ix.batchSize-1, to)
+		logs, err := ix.backend.FilterLogs(ctx, ix.query(new(big.Int).SetUint64(from), new(big.Int).SetUint64(end)))
+		if err != nil {
+			return fmt.Errorf("failed to filter logs in blocks %d-%d: %w", from, end, err)
+		}
+		if err := ix.applyLogs(ctx, logs, &end); err != nil {
+			return err
+		}
+		from = end + 1
+	}
+	return nil
+}
+
+// Run syncs, then follows new logs until ctx is done. Logs arrive through SubscribeFilterLogs when
+// the backend supports it; otherwise, or when the subscription fails, the chain is polled.
+func (ix *Indexer) Run(ctx context.Context) {
+	ticker := time.NewTicker(ix.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := ix.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("indexer: %v", err)
+		}
+
+		logs := make(chan types.Log)
+		sub, err := ix.backend.SubscribeFilterLogs(ctx, ix.query(nil, nil), logs)
+		if err != nil {
+			// Polling only: every tick catches up with the head.
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				continue
+			}
+		}
+
+		if !ix.follow(ctx, sub, logs, ticker.C) {
+			return
+		}
+	}
+}
+
+// follow applies subscribed logs as they arrive and moves the checkpoint on every tick. It returns
+// false when ctx is done and true when the subscription failed and must be re-established.
+func (ix *Indexer) follow(ctx context.Context, sub ethereum.Subscription, logs <-chan types.Log, tick <-chan time.Time) bool {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-sub.Err():
+			log.Printf("indexer: log subscription failed, polling: %v", err)
+			return true
+		case l := <-logs:
+			// Subscribed logs may belong to a block that is not complete yet, so they never move the checkpoint.
+			if err := ix.applyLogs(ctx, []types.Log{l}, nil); err != nil {
+				log.Printf("indexer: %v", err)
+			}
+		case <-tick:
+			if err := ix.Sync(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("indexer: %v", err)
+			}
+		}
+	}
+}
+
+func (ix *Indexer) query(from, to *big.Int) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		FromBlock: from,
+		ToBlock:   to,
+		Addresses: []common.Address{ix.registry.Address()},
+		Topics:    [][]common.Hash{{ix.registry.FileSavedTopic(), ix.registry.FileRemovedTopic()}},
+	}
+}
+
+// decode turns a FileSaved or FileRemoved log into an Event, without its block time, sender and writer.
+func (ix *Indexer) decode(l types.Log) (Event, error) {
+	event := Event{
+		BlockNumber: l.BlockNumber,
+		BlockHash:   l.BlockHash.Hex(),
+		TxHash:      l.TxHash.Hex(),
+		LogIndex:    l.Index,
+	}
+	if len(l.Topics) > 0 && l.Topics[0] == ix.registry.FileRemovedTopic() {
+		removed, err := ix.registry.ParseFileRemoved(l)
+		if err != nil {
+			return Event{}, err
+		}
+		event.FilePath, event.CID, event.Removed = removed.FilePath, removed.Cid, true
+		return event, nil
+	}
+	saved, err := ix.registry.ParseFileSaved(l)
+	if err != nil {
+		return Event{}, err
+	}
+	event.FilePath, event.CID = saved.FilePath, saved.Cid
+	return event, nil
+}
+
+// applyLogs decodes FileSaved and FileRemoved logs, resolves their block time, sender and writer and
+// writes them to the store. Logs flagged as removed by a chain reorganisation are deleted again.
+func (ix *Indexer) applyLogs(ctx context.Context, logs []types.Log, checkpoint *uint64) error {
+	times := make(map[uint64]time.Time)
+	senders := make(map[common.Hash]common.Address)
+	writers := make(map[common.Hash]common.Address)
+
+	var added, removed []Event
+	for _, l := range logs {
+		event, err := ix.decode(l)
+		if err != nil {
+			return fmt.Errorf("failed to decode log %s/%d: %w", l.TxHash.Hex(), l.Index, err)
+		}
+		if l.Removed {
+			removed = append(removed, event)
+			continue
+		}
+
+		at, ok := times[l.BlockNumber]
+		if !ok {
+			head, err := ix.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(l.BlockNumber))
+			if err != nil {
+				return fmt.Errorf("failed to get block %d: %w", l.BlockNumber, err)
+			}
+			at = time.Unix(int64(head.Time), 0).UTC()
+			times[l.BlockNumber] = at
+		}
+		sender, ok := senders[l.TxHash]
+		if !ok {
+			tx, _, err := ix.backend.TransactionByHash(ctx, l.TxHash)
+			if err != nil {
+				return fmt.Errorf("failed to get transaction %s: %w", l.TxHash.Hex(), err)
+			}
+			sender, err = types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+			if err != nil {
+				return fmt.Errorf("failed to recover sender of %s: %w", l.TxHash.Hex(), err)
+			}
+			senders[l.TxHash], writers[l.TxHash] = sender, sender
+			if ix.forwarder != nil {
+				if req, ok := ix.forwarder.RequestOf(tx); ok && req.To == ix.registry.Address() {
+					writers[l.TxHash] = req.From
+				}
+			}
+		}
+		event.Timestamp, event.Sender, event.Writer = at, sender.Hex(), writers[l.TxHash].Hex()
+		added = append(added, event)
+	}
+	if err := ix.store.apply(added, removed, checkpoint); err != nil {
+		return fmt.Errorf("failed to store events: %w", err)
+	}
+	if ix.listener != nil {
+		for _, event := range added {
+			ix.listener(event)
+		}
+	}
+	return nil
+}
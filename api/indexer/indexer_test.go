@@ -0,0 +1,213 @@
+package indexer_test
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/indexer"
+)
+
+// emittingContract returns init code for a contract that emits every call's arguments as a FileSaved
+// log. The ABI encoding of save(string,string) arguments is the FileSaved data layout, so the runtime
+// only copies the calldata after the selector into memory and logs it.
+func emittingContract() []byte {
+	topic := crypto.Keccak256([]byte("FileSaved(string,string)"))
+	// CALLDATACOPY(0, 4, CALLDATASIZE-4), then LOG1(0, CALLDATASIZE-4, topic).
+	runtime := common.FromHex("0x600436038060046000377f")
+	runtime = append(runtime, topic...)
+	runtime = append(runtime, common.FromHex("0x906000a100")...)
+	initCode := common.FromHex("0x6030600c60003960306000f3")
+	return append(initCode, runtime...)
+}
+
+// forwarderStandIn is init code deploying a stand-in for FileForwarder without signature checks, see
+// the relayer tests of the contracts package: execute(req, signature) calls req.to with req.data
+// followed by req.from.
+var forwarderStandIn = common.FromHex("0x606d600c600039606d6000f360003560e01c80632d0335ab14601d576347153f8214602a57600080fd5b6004355460005260206000f35b6044358054600101905560e4356044018035808260200160003760443560601b81526014016000600082600060006064355af1606b573d600060003e3d6000fd5b00")
+
+type testChain struct {
+	sim  *simulated.Backend
+	auth *bind.TransactOpts
+	api  *contracts.ContractAPI
+}
+
+// newTestChain deploys the emitting contract on a simulated chain and returns a ContractAPI bound to it.
+func newTestChain(t *testing.T) *testChain {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(t, err)
+	sim := simulated.NewBackend(types.GenesisAlloc{auth.From: {Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))}})
+	t.Cleanup(func() { sim.Close() })
+
+	ctx := context.Background()
+	gasPrice, err := sim.Client().SuggestGasPrice(ctx)
+	require.NoError(t, err)
+	deploy, err := auth.Signer(auth.From, types.NewTx(&types.LegacyTx{Gas: 1_000_000, GasPrice: gasPrice, Data: emittingContract()}))
+	require.NoError(t, err)
+	require.NoError(t, sim.Client().SendTransaction(ctx, deploy))
+	sim.Commit()
+
+	// The ABI is loaded relative to the api directory, like in the running service.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	api, err := contracts.NewContractAPIWithBackend(sim.Client(), crypto.CreateAddress(auth.From, 0), auth)
+	require.NoError(t, err)
+	return &testChain{sim: sim, auth: auth, api: api}
+}
+
+func (c *testChain) save(t *testing.T, filePath, cid string) {
+	_, err := c.api.Save(filePath, cid)
+	require.NoError(t, err)
+	c.sim.Commit()
+}
+
+func TestIndexer_SyncAndResume(t *testing.T) {
+	chain := newTestChain(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	chain.save(t, "/a.txt", "QmFirst")
+	chain.save(t, "/b.txt", "QmOther")
+	chain.save(t, "/a.txt", "QmSecond")
+
+	store, err := indexer.OpenStore(dir)
+	require.NoError(t, err)
+	ix := indexer.New(chain.sim.Client(), chain.api.Registry(), store, indexer.WithBatchSize(2))
+	require.NoError(t, ix.Sync(ctx))
+
+	latest, err := store.Latest("/a.txt")
+	require.NoError(t, err)
+	require.NotNil(t, latest)
+	assert.Equal(t, "QmSecond", latest.CID)
+	assert.Equal(t, uint64(4), latest.BlockNumber)
+	history, _, err := store.History("/a.txt", "", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "QmFirst", history[0].CID)
+	assert.NotEmpty(t, history[0].TxHash)
+	assert.Equal(t, chain.auth.From.Hex(), history[0].Sender)
+	assert.False(t, history[0].Timestamp.IsZero())
+	checkpoint, _, err := store.Checkpoint()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), checkpoint)
+	require.NoError(t, store.Close())
+
+	// A restarted indexer continues after the checkpoint.
+	chain.save(t, "/a.txt", "QmThird")
+	store, err = indexer.OpenStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	ix = indexer.New(chain.sim.Client(), chain.api.Registry(), store)
+	require.NoError(t, ix.Sync(ctx))
+
+	history, _, err = store.History("/a.txt", "", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, "QmThird", history[2].CID)
+}
+
+func TestIndexer_RunFollowsNewLogs(t *testing.T) {
+	chain := newTestChain(t)
+	store, err := indexer.OpenStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ix := indexer.New(chain.sim.Client(), chain.api.Registry(), store, indexer.WithPollInterval(50*time.Millisecond))
+	go ix.Run(ctx)
+
+	chain.save(t, "/live.txt", "QmLive")
+
+	assert.Eventually(t, func() bool {
+		latest, err := store.Latest("/live.txt")
+		return err == nil && latest != nil && latest.CID == "QmLive"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestIndexer_ListenerHearsEvents(t *testing.T) {
+	chain := newTestChain(t)
+	store, err := indexer.OpenStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan indexer.Event, 10)
+	ix := indexer.New(chain.sim.Client(), chain.api.Registry(), store, indexer.WithPollInterval(50*time.Millisecond),
+		indexer.WithListener(func(event indexer.Event) { events <- event }))
+	go ix.Run(ctx)
+
+	chain.save(t, "/live.txt", "QmLive")
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "/live.txt", event.FilePath)
+		assert.Equal(t, chain.auth.From.Hex(), event.Sender)
+		assert.Equal(t, chain.auth.From.Hex(), event.Writer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener was not called")
+	}
+}
+
+func TestIndexer_RelayedWriter(t *testing.T) {
+	chain := newTestChain(t)
+	ctx := context.Background()
+
+	gasPrice, err := chain.sim.Client().SuggestGasPrice(ctx)
+	require.NoError(t, err)
+	nonce, err := chain.sim.Client().PendingNonceAt(ctx, chain.auth.From)
+	require.NoError(t, err)
+	deploy, err := chain.auth.Signer(chain.auth.From, types.NewTx(&types.LegacyTx{Nonce: nonce, Gas: 1_000_000, GasPrice: gasPrice, Data: forwarderStandIn}))
+	require.NoError(t, err)
+	require.NoError(t, chain.sim.Client().SendTransaction(ctx, deploy))
+	chain.sim.Commit()
+	forwarder, err := contracts.NewForwarder(crypto.CreateAddress(chain.auth.From, nonce), chain.sim.Client())
+	require.NoError(t, err)
+
+	// The stand-in does not check signatures, so the request is executed as if user had signed it.
+	stringType, err := abi.NewType("string", "", nil)
+	require.NoError(t, err)
+	args, err := abi.Arguments{{Type: stringType}, {Type: stringType}}.Pack("/relayed.txt", "QmRelayed")
+	require.NoError(t, err)
+	user := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	req := contracts.ForwardRequest{
+		From:  user,
+		To:    chain.api.Registry().Address(),
+		Value: big.NewInt(0),
+		Gas:   big.NewInt(100_000),
+		Nonce: big.NewInt(0),
+		Data:  append(crypto.Keccak256([]byte("save(string,string)"))[:4], args...),
+	}
+	_, err = forwarder.Execute(chain.auth, req, make([]byte, 65))
+	require.NoError(t, err)
+	chain.sim.Commit()
+
+	store, err := indexer.OpenStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, indexer.New(chain.sim.Client(), chain.api.Registry(), store, indexer.WithForwarder(forwarder)).Sync(ctx))
+
+	latest, err := store.Latest("/relayed.txt")
+	require.NoError(t, err)
+	require.NotNil(t, latest)
+	assert.Equal(t, chain.auth.From.Hex(), latest.Sender)
+	assert.Equal(t, user.Hex(), latest.Writer)
+}
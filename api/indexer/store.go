@@ -0,0 +1,455 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key layout of the store:
+//
+//	checkpoint                              last block whose logs are all indexed
+//	h/<filePath>\x00<block><logIndex>       every FileSaved and FileRemoved event of a path, oldest first
+//	p/<filePath>                            latest FileSaved event of a path that is not removed
+//	c/<cid>\x00<filePath>                   paths whose latest FileSaved event holds cid
+//	refs                                    set once the c/ keys are built for the p/ keys
+//	s/<txHash><logIndex>                    wallet that authorized the save of a FileSaved log through the API
+//	n/<signer><nonce>                       upload nonces used by a wallet
+var (
+	checkpointKey = []byte("checkpoint")
+	historyPrefix = []byte("h/")
+	latestPrefix  = []byte("p/")
+	cidPrefix     = []byte("c/")
+	refsKey       = []byte("refs")
+	signerPrefix  = []byte("s/")
+	noncePrefix   = []byte("n/")
+)
+
+// Event is an indexed FileSaved or FileRemoved log.
+type Event struct {
+	FilePath    string `json:"filePath"`
+	CID         string `json:"cid"`
+	BlockNumber uint64 `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	TxHash      string `json:"txHash"`
+	LogIndex    uint   `json:"logIndex"`
+	// Timestamp is the time of the block the event was mined in.
+	Timestamp time.Time `json:"timestamp"`
+	// Sender is the account that signed the saving transaction.
+	Sender string `json:"sender"`
+	// Writer is the account the registry saw as the writer: the signer of a request relayed by the
+	// trusted forwarder, the Sender otherwise.
+	Writer string `json:"writer,omitempty"`
+	// Signer is the wallet that authorized the save when the API relayed it for a wallet, see RecordSigner.
+	Signer string `json:"signer,omitempty"`
+	// Removed marks a FileRemoved event; CID is then the CID that was removed.
+	Removed bool `json:"removed,omitempty"`
+}
+
+var (
+	// ErrInvalidCursor is returned for pagination cursors the store did not issue.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrNonceUsed is returned by UseNonce for a nonce the wallet already used.
+	ErrNonceUsed = errors.New("nonce already used")
+)
+
+// Store is the embedded database holding indexed events. It is safe for concurrent use.
+type Store struct {
+	db *leveldb.DB
+}
+
+// OpenStore opens or creates the database in the directory at path.
+func OpenStore(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index database: %w", err)
+	}
+	s := &Store{db: db}
+	if err := s.buildReferences(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to index CID references: %w", err)
+	}
+	return s, nil
+}
+
+// buildReferences fills the c/ keys of databases written before they existed.
+func (s *Store) buildReferences() error {
+	if built, err := s.db.Has(refsKey, nil); err != nil || built {
+		return err
+	}
+	tr, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+	defer tr.Discard()
+
+	iter := tr.NewIterator(util.BytesPrefix(latestPrefix), nil)
+	for iter.Next() {
+		var event Event
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			iter.Release()
+			return err
+		}
+		if err := tr.Put(cidKey(event.CID, event.FilePath), nil, nil); err != nil {
+			iter.Release()
+			return err
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := tr.Put(refsKey, nil, nil); err != nil {
+		return err
+	}
+	return tr.Commit()
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Checkpoint returns the last block whose logs are all indexed. ok is false before the first sync.
+func (s *Store) Checkpoint() (block uint64, ok bool, err error) {
+	value, err := s.db.Get(checkpointKey, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(value), true, nil
+}
+
+// Latest returns the last event saved for filePath, or nil when the path was never saved or was removed.
+func (s *Store) Latest(filePath string) (*Event, error) {
+	value, err := s.db.Get(latestKey(filePath), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal(value, &event); err != nil {
+		return nil, err
+	}
+	return &event, s.withSigner(&event)
+}
+
+// History returns the events saved for filePath, oldest first. It returns at most limit events
+// (all of them when limit is not positive) after the position of cursor, and the cursor of the next
+// page, which is empty on the last page.
+func (s *Store) History(filePath, cursor string, limit int) ([]Event, string, error) {
+	prefix := historyPathPrefix(filePath)
+	rng := util.BytesPrefix(prefix)
+	if cursor != "" {
+		position, err := hex.DecodeString(cursor)
+		if err != nil || len(position) != positionLen {
+			return nil, "", ErrInvalidCursor
+		}
+		// Positions have a fixed length, so appending a byte gives the first key after the cursor.
+		rng.Start = append(append(prefix, position...), 0)
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	var events []Event
+	for iter.Next() {
+		var event Event
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			return nil, "", err
+		}
+		// A path containing a NUL byte shares the key prefix of a shorter path.
+		if event.FilePath != filePath {
+			continue
+		}
+		if limit > 0 && len(events) == limit {
+			last := events[len(events)-1]
+			return events, hex.EncodeToString(historyKey(last)[len(prefix):]), iter.Error()
+		}
+		if err := s.withSigner(&event); err != nil {
+			return nil, "", err
+		}
+		events = append(events, event)
+	}
+	return events, "", iter.Error()
+}
+
+// At returns the last event of filePath in a block mined at or before at, or nil when the path had
+// not been saved yet. The event is a removal when the path was removed at that time.
+func (s *Store) At(filePath string, at time.Time) (*Event, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(historyPathPrefix(filePath)), nil)
+	defer iter.Release()
+
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var event Event
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			return nil, err
+		}
+		if event.FilePath == filePath && !event.Timestamp.After(at) {
+			return &event, s.withSigner(&event)
+		}
+	}
+	return nil, iter.Error()
+}
+
+// References returns, in lexical order, up to limit paths whose latest save holds cid (all of them
+// when limit is not positive).
+func (s *Store) References(cid string, limit int) ([]string, error) {
+	prefix := append(append(append([]byte(nil), cidPrefix...), cid...), 0)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var paths []string
+	for iter.Next() && (limit <= 0 || len(paths) < limit) {
+		paths = append(paths, string(iter.Key()[len(prefix):]))
+	}
+	return paths, iter.Error()
+}
+
+// RecordSigner records that signer authorized the save of the FileSaved log at logIndex of the mined
+// transaction txHash, which the API sent itself. The indexed event of the log reports signer as its
+// Signer. Callers record signers once the receipt shows the transaction succeeded.
+func (s *Store) RecordSigner(txHash common.Hash, logIndex uint, signer common.Address) error {
+	return s.db.Put(signerKey(txHash, logIndex), signer.Bytes(), nil)
+}
+
+// UseNonce marks an upload nonce of signer as used, failing with ErrNonceUsed when it already was.
+func (s *Store) UseNonce(signer common.Address, nonce *big.Int) error {
+	if nonce.Sign() < 0 || nonce.BitLen() > 256 {
+		return fmt.Errorf("nonce %s is not a uint256", nonce)
+	}
+	tr, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+	defer tr.Discard()
+
+	key := append(append(append([]byte(nil), noncePrefix...), signer.Bytes()...), nonce.FillBytes(make([]byte, 32))...)
+	used, err := tr.Has(key, nil)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrNonceUsed
+	}
+	if err := tr.Put(key, nil, nil); err != nil {
+		return err
+	}
+	return tr.Commit()
+}
+
+// withSigner sets the Signer of a save recorded with RecordSigner.
+func (s *Store) withSigner(event *Event) error {
+	if event.Removed {
+		return nil
+	}
+	value, err := s.db.Get(signerKey(common.HexToHash(event.TxHash), event.LogIndex), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	event.Signer = common.BytesToAddress(value).Hex()
+	return nil
+}
+
+// Entry types returned by List.
+const (
+	EntryFile = "file"
+	EntryDir  = "dir"
+)
+
+// Entry is a file or, when the listing depth cuts a path short, a directory below a listed prefix.
+type Entry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// CID and the save fields are only set for files.
+	CID         string `json:"cid,omitempty"`
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+	TxHash      string `json:"txHash,omitempty"`
+}
+
+// List returns the saved paths starting with prefix in lexical order, with the latest CID of each.
+// With a positive depth, only paths at most depth segments below the prefix are returned; deeper
+// paths are collapsed into one directory entry. It returns at most limit entries (all of them when
+// limit is not positive) after cursor, and the cursor of the next page, which is empty on the last page.
+func (s *Store) List(prefix, cursor string, depth, limit int) ([]Entry, string, error) {
+	rng := util.BytesPrefix(latestKey(prefix))
+	if cursor != "" {
+		start, err := hex.DecodeString(cursor)
+		if err != nil || !bytes.HasPrefix(start, []byte(prefix)) {
+			return nil, "", ErrInvalidCursor
+		}
+		rng.Start = latestKey(string(start))
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	var entries []Entry
+	for ok := iter.Next(); ok; {
+		filePath := string(iter.Key()[len(latestPrefix):])
+		if limit > 0 && len(entries) == limit {
+			return entries, hex.EncodeToString([]byte(filePath)), iter.Error()
+		}
+
+		if dir, deep := cutDepth(filePath, prefix, depth); deep {
+			entries = append(entries, Entry{Path: dir, Type: EntryDir})
+			// Skip every path inside the collapsed directory.
+			ok = iter.Seek(util.BytesPrefix(latestKey(dir)).Limit)
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, Entry{Path: filePath, Type: EntryFile, CID: event.CID, BlockNumber: event.BlockNumber, TxHash: event.TxHash})
+		ok = iter.Next()
+	}
+	return entries, "", iter.Error()
+}
+
+// cutDepth returns the directory, ending in a slash, that collapses filePath when it lies more than
+// depth segments below prefix.
+func cutDepth(filePath, prefix string, depth int) (string, bool) {
+	if depth <= 0 {
+		return "", false
+	}
+	rest := filePath[len(prefix):]
+	offset := 0
+	for i := 0; i < depth; i++ {
+		slash := strings.IndexByte(rest[offset:], '/')
+		if slash < 0 {
+			return "", false
+		}
+		offset += slash + 1
+	}
+	if offset == len(rest) {
+		// A path ending in a slash at exactly the cut is still a file.
+		return "", false
+	}
+	return prefix + rest[:offset], true
+}
+
+// apply stores added events, deletes removed ones (chain reorganisations) and moves the checkpoint,
+// all in one transaction. A nil checkpoint leaves it unchanged.
+func (s *Store) apply(added, removed []Event, checkpoint *uint64) error {
+	tr, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+	defer tr.Discard()
+
+	touched := make(map[string]struct{})
+	for _, event := range removed {
+		if err := tr.Delete(historyKey(event), nil); err != nil {
+			return err
+		}
+		touched[event.FilePath] = struct{}{}
+	}
+	for _, event := range added {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := tr.Put(historyKey(event), value, nil); err != nil {
+			return err
+		}
+		touched[event.FilePath] = struct{}{}
+	}
+
+	for filePath := range touched {
+		if err := updateLatest(tr, filePath); err != nil {
+			return err
+		}
+	}
+	if checkpoint != nil {
+		if err := tr.Put(checkpointKey, binary.BigEndian.AppendUint64(nil, *checkpoint), nil); err != nil {
+			return err
+		}
+	}
+	return tr.Commit()
+}
+
+// updateLatest points the latest key of filePath at its newest history entry, or deletes it when
+// the history is empty or ends with a removal. The CID reference of the path follows it.
+func updateLatest(tr *leveldb.Transaction, filePath string) error {
+	previous, err := tr.Get(latestKey(filePath), nil)
+	if err == nil {
+		var event Event
+		if err := json.Unmarshal(previous, &event); err != nil {
+			return err
+		}
+		if err := tr.Delete(cidKey(event.CID, filePath), nil); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	iter := tr.NewIterator(util.BytesPrefix(historyPathPrefix(filePath)), nil)
+	defer iter.Release()
+
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var event Event
+		if err := json.Unmarshal(iter.Value(), &event); err != nil {
+			return err
+		}
+		if event.FilePath != filePath {
+			continue
+		}
+		if event.Removed {
+			return tr.Delete(latestKey(filePath), nil)
+		}
+		if err := tr.Put(cidKey(event.CID, filePath), nil, nil); err != nil {
+			return err
+		}
+		return tr.Put(latestKey(filePath), append([]byte(nil), iter.Value()...), nil)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return tr.Delete(latestKey(filePath), nil)
+}
+
+func latestKey(filePath string) []byte {
+	return append(append([]byte(nil), latestPrefix...), filePath...)
+}
+
+func cidKey(cid, filePath string) []byte {
+	key := append(append(append([]byte(nil), cidPrefix...), cid...), 0)
+	return append(key, filePath...)
+}
+
+func signerKey(txHash common.Hash, logIndex uint) []byte {
+	key := append(append([]byte(nil), signerPrefix...), txHash.Bytes()...)
+	return binary.BigEndian.AppendUint32(key, uint32(logIndex))
+}
+
+func historyPathPrefix(filePath string) []byte {
+	key := append(append([]byte(nil), historyPrefix...), filePath...)
+	return append(key, 0)
+}
+
+// positionLen is the length of the block number and log index suffix of history keys.
+const positionLen = 12
+
+// historyKey sorts the events of a path by block number, then log index.
+func historyKey(event Event) []byte {
+	key := binary.BigEndian.AppendUint64(historyPathPrefix(event.FilePath), event.BlockNumber)
+	return binary.BigEndian.AppendUint32(key, uint32(event.LogIndex))
+}
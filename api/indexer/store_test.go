@@ -0,0 +1,251 @@
+package indexer
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStore(t *testing.T) *Store {
+	store, err := OpenStore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore_HistoryAndLatest(t *testing.T) {
+	store := openTestStore(t)
+
+	first := Event{FilePath: "/a.txt", CID: "QmFirst", BlockNumber: 3, LogIndex: 1}
+	second := Event{FilePath: "/a.txt", CID: "QmSecond", BlockNumber: 10, LogIndex: 0}
+	other := Event{FilePath: "/a.txt\x00b", CID: "QmOther", BlockNumber: 5}
+	checkpoint := uint64(10)
+	require.NoError(t, store.apply([]Event{second, other, first}, nil, &checkpoint))
+
+	history, next, err := store.History("/a.txt", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{first, second}, history)
+	assert.Empty(t, next)
+
+	latest, err := store.Latest("/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, &second, latest)
+
+	block, ok, err := store.Checkpoint()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(10), block)
+}
+
+func TestStore_References(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	require.NoError(t, err)
+
+	a := Event{FilePath: "/team-a/a.txt", CID: "QmShared", BlockNumber: 3}
+	b := Event{FilePath: "/team-b/b.txt", CID: "QmShared", BlockNumber: 4}
+	replaced := Event{FilePath: "/team-a/a.txt", CID: "QmNew", BlockNumber: 5}
+	require.NoError(t, store.apply([]Event{a, b}, nil, nil))
+	paths, err := store.References("QmShared", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/team-a/a.txt", "/team-b/b.txt"}, paths)
+
+	// Overwrites and removals drop the reference, a reorg of them restores it.
+	require.NoError(t, store.apply([]Event{replaced, {FilePath: "/team-b/b.txt", CID: "QmShared", BlockNumber: 6, Removed: true}}, nil, nil))
+	paths, err = store.References("QmShared", 0)
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+	require.NoError(t, store.apply(nil, []Event{replaced}, nil))
+	paths, err = store.References("QmShared", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/team-a/a.txt"}, paths)
+
+	// Databases written before the references existed get them built when opened.
+	require.NoError(t, store.db.Delete(refsKey, nil))
+	require.NoError(t, store.db.Delete(cidKey("QmShared", "/team-a/a.txt"), nil))
+	require.NoError(t, store.Close())
+	store, err = OpenStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	paths, err = store.References("QmShared", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/team-a/a.txt"}, paths)
+}
+
+func TestStore_RemovedEventsRestoreLatest(t *testing.T) {
+	store := openTestStore(t)
+
+	first := Event{FilePath: "/a.txt", CID: "QmFirst", BlockNumber: 3}
+	second := Event{FilePath: "/a.txt", CID: "QmSecond", BlockNumber: 4}
+	require.NoError(t, store.apply([]Event{first, second}, nil, nil))
+
+	require.NoError(t, store.apply(nil, []Event{second}, nil))
+	latest, err := store.Latest("/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "QmFirst", latest.CID)
+
+	require.NoError(t, store.apply(nil, []Event{first}, nil))
+	latest, err = store.Latest("/a.txt")
+	require.NoError(t, err)
+	assert.Nil(t, latest)
+
+	_, ok, err := store.Checkpoint()
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestStore_HistoryPages(t *testing.T) {
+	store := openTestStore(t)
+
+	var events []Event
+	for block := uint64(1); block <= 5; block++ {
+		events = append(events, Event{FilePath: "/a.txt", CID: "Qm", BlockNumber: block})
+	}
+	require.NoError(t, store.apply(events, nil, nil))
+
+	page, next, err := store.History("/a.txt", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, events[:2], page)
+	require.NotEmpty(t, next)
+
+	page, next, err = store.History("/a.txt", next, 2)
+	require.NoError(t, err)
+	assert.Equal(t, events[2:4], page)
+
+	page, next, err = store.History("/a.txt", next, 2)
+	require.NoError(t, err)
+	assert.Equal(t, events[4:], page)
+	assert.Empty(t, next)
+
+	_, _, err = store.History("/a.txt", "zz", 2)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestStore_At(t *testing.T) {
+	store := openTestStore(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := Event{FilePath: "/a.txt", CID: "QmFirst", BlockNumber: 3, Timestamp: start}
+	second := Event{FilePath: "/a.txt", CID: "QmSecond", BlockNumber: 4, Timestamp: start.Add(time.Hour)}
+	require.NoError(t, store.apply([]Event{first, second}, nil, nil))
+
+	event, err := store.At("/a.txt", start.Add(-time.Second))
+	require.NoError(t, err)
+	assert.Nil(t, event)
+
+	event, err = store.At("/a.txt", start.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "QmFirst", event.CID)
+
+	event, err = store.At("/a.txt", start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "QmSecond", event.CID)
+}
+
+func TestStore_List(t *testing.T) {
+	store := openTestStore(t)
+
+	var events []Event
+	for i, p := range []string{"/other.txt", "/reports/a.txt", "/reports/2026/q1.csv", "/reports/2026/q2.csv", "/reports/2026/old/x.csv", "/reports/b.txt"} {
+		events = append(events, Event{FilePath: p, CID: "Qm" + p, BlockNumber: uint64(i + 1)})
+	}
+	require.NoError(t, store.apply(events, nil, nil))
+
+	entries, next, err := store.List("/reports/", "", 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"/reports/2026/old/x.csv", "/reports/2026/q1.csv", "/reports/2026/q2.csv", "/reports/a.txt", "/reports/b.txt"}, paths)
+	assert.Equal(t, Entry{Path: "/reports/a.txt", Type: EntryFile, CID: "Qm/reports/a.txt", BlockNumber: 2}, entries[3])
+
+	entries, _, err = store.List("/reports/", "", 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Path: "/reports/2026/", Type: EntryDir},
+		{Path: "/reports/a.txt", Type: EntryFile, CID: "Qm/reports/a.txt", BlockNumber: 2},
+		{Path: "/reports/b.txt", Type: EntryFile, CID: "Qm/reports/b.txt", BlockNumber: 6},
+	}, entries)
+
+	entries, next, err = store.List("/reports/", "", 2, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/reports/2026/old/", entries[0].Path)
+	assert.Equal(t, "/reports/2026/q1.csv", entries[1].Path)
+	entries, next, err = store.List("/reports/", next, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/reports/2026/q2.csv", entries[0].Path)
+	assert.Equal(t, "/reports/a.txt", entries[1].Path)
+	entries, next, err = store.List("/reports/", next, 2, 2)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Empty(t, next)
+
+	_, _, err = store.List("/reports/", "2f6f74686572", 0, 0) // "/other"
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestStore_RemovalTombstone(t *testing.T) {
+	store := openTestStore(t)
+
+	saved := Event{FilePath: "/a.txt", CID: "QmFirst", BlockNumber: 3}
+	removed := Event{FilePath: "/a.txt", CID: "QmFirst", BlockNumber: 4, Removed: true}
+	require.NoError(t, store.apply([]Event{saved, removed}, nil, nil))
+
+	latest, err := store.Latest("/a.txt")
+	require.NoError(t, err)
+	assert.Nil(t, latest)
+	entries, _, err := store.List("/", "", 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	history, _, err := store.History("/a.txt", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{saved, removed}, history)
+
+	// Undoing the removal in a reorganisation restores the file.
+	require.NoError(t, store.apply(nil, []Event{removed}, nil))
+	latest, err = store.Latest("/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, &saved, latest)
+}
+
+func TestStore_RecordSigner(t *testing.T) {
+	store := openTestStore(t)
+	signer := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	txHash := common.HexToHash("0x01")
+	require.NoError(t, store.RecordSigner(txHash, 1, signer))
+	signed := Event{FilePath: "/a.txt", CID: "QmSigned", BlockNumber: 3, TxHash: txHash.Hex(), LogIndex: 1}
+	// Another save of the same CID, or another log of the transaction, is not attributed to the signer.
+	sameCID := Event{FilePath: "/a.txt", CID: "QmSigned", BlockNumber: 4, TxHash: common.HexToHash("0x02").Hex(), LogIndex: 1}
+	otherLog := Event{FilePath: "/b.txt", CID: "QmServer", BlockNumber: 3, TxHash: txHash.Hex(), LogIndex: 2}
+	require.NoError(t, store.apply([]Event{signed, sameCID, otherLog}, nil, nil))
+
+	history, _, err := store.History("/a.txt", "", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, signer.Hex(), history[0].Signer)
+	assert.Empty(t, history[1].Signer)
+	latest, err := store.Latest("/b.txt")
+	require.NoError(t, err)
+	assert.Empty(t, latest.Signer)
+}
+
+func TestStore_UseNonce(t *testing.T) {
+	store := openTestStore(t)
+	alice := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	bob := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	require.NoError(t, store.UseNonce(alice, big.NewInt(1)))
+	assert.ErrorIs(t, store.UseNonce(alice, big.NewInt(1)), ErrNonceUsed)
+	require.NoError(t, store.UseNonce(alice, big.NewInt(2)))
+	require.NoError(t, store.UseNonce(bob, big.NewInt(1)))
+	assert.Error(t, store.UseNonce(bob, big.NewInt(-1)))
+}
@@ -1,10 +1,14 @@
 package ipfs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/interface-go-ipfs-core/path"
@@ -39,11 +43,65 @@ func NewIPFSClient(apiURL string) (*IPFSClient, error) {
 
 // Add uploads the given file content to IPFS using the Unixfs API and returns the CID.
 func (c *IPFSClient) Add(ctx *gin.Context, fileContent []byte) (string, error) {
-	f := files.NewBytesFile(fileContent)
+	return c.AddReader(ctx, bytes.NewReader(fileContent))
+}
 
-	p, err := c.api.Unixfs().Add(ctx, f)
+// AddReader streams the content of r to IPFS using the Unixfs API and returns the CID.
+// The content is never buffered in memory as a whole.
+func (c *IPFSClient) AddReader(ctx context.Context, r io.Reader) (string, error) {
+	p, err := c.api.Unixfs().Add(ctx, files.NewReaderFile(r))
 	if err != nil {
 		return "", fmt.Errorf("failed to add file to IPFS: %w", err)
 	}
 	return p.RootCid().String(), nil
 }
+
+// Stat returns the size and the UnixFS modification time (zero if not recorded) of the file stored under the given CID.
+func (c *IPFSClient) Stat(ctx context.Context, cid string) (int64, time.Time, error) {
+	var stat struct {
+		Type       string
+		Size       int64
+		Mtime      int64
+		MtimeNsecs int
+	}
+	if err := c.api.Request("files/stat", "/ipfs/"+cid).Exec(ctx, &stat); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to stat file in IPFS: %w", err)
+	}
+	if stat.Type != "file" {
+		return 0, time.Time{}, fmt.Errorf("CID %s is not a file", cid)
+	}
+
+	var modTime time.Time
+	if stat.Mtime != 0 {
+		modTime = time.Unix(stat.Mtime, int64(stat.MtimeNsecs)).UTC()
+	}
+	return stat.Size, modTime, nil
+}
+
+// CatRange streams length bytes of the file stored under the given CID starting at offset.
+// A negative length reads until the end of the file. The caller is responsible for closing the reader.
+func (c *IPFSClient) CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	req := c.api.Request("cat", "/ipfs/"+cid).Option("offset", offset)
+	if length >= 0 {
+		req = req.Option("length", length)
+	}
+
+	resp, err := req.Send(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cat file from IPFS: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("failed to cat file from IPFS: %w", resp.Error)
+	}
+	return resp.Output, nil
+}
+
+// Unpin removes the recursive pin of the given CID so the node may garbage collect it. A CID that is
+// not pinned is not an error.
+func (c *IPFSClient) Unpin(ctx context.Context, cid string) error {
+	err := c.api.Request("pin/rm", "/ipfs/"+cid).Exec(ctx, nil)
+	if err != nil && !strings.Contains(err.Error(), "not pinned") {
+		return fmt.Errorf("failed to unpin file from IPFS: %w", err)
+	}
+	return nil
+}
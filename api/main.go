@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/avkos/file-registry/api/auth"
 	"github.com/avkos/file-registry/api/config"
 	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/events"
 	"github.com/avkos/file-registry/api/handlers"
+	"github.com/avkos/file-registry/api/indexer"
 	"github.com/avkos/file-registry/api/ipfs"
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/avkos/file-registry/api/webhooks"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // main is the entry point of the application.
@@ -28,13 +39,134 @@ func main() {
 		log.Fatalf("Failed to create contract API: %v", err)
 	}
 
+	// Watch sent transactions and speed up the stuck ones
+	go contractAPI.Tracker().Run(context.Background(), 15*time.Second)
+
+	// Pause signing accounts that run low on funds
+	go contractAPI.WatchBalances(context.Background(), config.Config.BalanceInterval)
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics.MustRegister(contracts.NewPoolCollector(contractAPI))
+
+	// Send registry changes to webhook subscribers
+	var hooks *webhooks.Dispatcher
+	if config.Config.WebhooksDBPath != "" {
+		hookStore, err := webhooks.OpenStore(config.Config.WebhooksDBPath)
+		if err != nil {
+			log.Fatalf("Failed to open webhook store: %v", err)
+		}
+		defer hookStore.Close()
+		var own []common.Address
+		for _, account := range contractAPI.Accounts() {
+			own = append(own, account.Address)
+		}
+		hooks = webhooks.New(hookStore, webhooks.WithMaxAttempts(config.Config.WebhookMaxAttempts), webhooks.WithOwnAccounts(own))
+		go hooks.Run(context.Background())
+	}
+
+	// Index FileSaved events into the local database
+	var index handlers.FileIndex
+	var store *indexer.Store
+	if config.Config.IndexerDBPath != "" {
+		store, err = indexer.OpenStore(config.Config.IndexerDBPath)
+		if err != nil {
+			log.Fatalf("Failed to open indexer store: %v", err)
+		}
+		defer store.Close()
+		indexerOpts := []indexer.Option{indexer.WithStartBlock(config.Config.IndexerStartBlock)}
+		if config.Config.ForwarderAddress != (common.Address{}) {
+			forwarder, err := contracts.NewForwarder(config.Config.ForwarderAddress, contractAPI.Client())
+			if err != nil {
+				log.Fatalf("Failed to create forwarder: %v", err)
+			}
+			indexerOpts = append(indexerOpts, indexer.WithForwarder(forwarder))
+		}
+		if hooks != nil {
+			indexerOpts = append(indexerOpts, indexer.WithListener(hooks.FileSaved))
+		}
+		ix := indexer.New(contractAPI.Client(), contractAPI.Registry(), store, indexerOpts...)
+		go ix.Run(context.Background())
+		index = store
+	} else if hooks != nil {
+		log.Printf("INDEXER_DB_PATH is not set: webhooks get no file.saved events")
+	}
+
 	// Create IPFS client
 	ipfsClient, err := ipfs.NewIPFSClient(config.Config.IpfsUrl)
 	if err != nil {
 		log.Fatalf("Failed to create IPFS client: %v", err)
 	}
 
-	router := handlers.SetupRouter(contractAPI, ipfsClient)
+	opts := []handlers.Option{
+		handlers.WithMaxUploadSize(config.Config.MaxUploadSize),
+		handlers.WithBatchWorkers(config.Config.BatchConcurrency),
+		handlers.WithMaxBatchSize(config.Config.BatchMaxSize),
+		handlers.WithConfirmations(config.Config.Confirmations, config.Config.TxWaitTimeout),
+		handlers.WithIndex(index),
+		handlers.WithAccounts(contractAPI),
+		handlers.WithMetrics(promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})),
+	}
+	if config.Config.SIWEEnabled {
+		opts = append(opts, handlers.WithSIWE(config.Config.SIWEDomain, auth.NewSessions(config.Config.ChainID.Int64(), config.Config.SessionTTL)))
+		if store != nil {
+			opts = append(opts, handlers.WithSignerStore(store))
+		}
+	}
+	if config.Config.SignedUploads {
+		domain := auth.UploadDomain{ChainID: config.Config.ChainID, Contract: config.Config.ContractAddress}
+		opts = append(opts, handlers.WithSignedUploads(domain, store))
+	}
+	if config.Config.ForwarderAddress != (common.Address{}) {
+		relayer, err := contracts.NewRelayer(contractAPI, config.Config.ForwarderAddress, config.Config.ChainID, contracts.RelayPolicy{
+			MaxGas: config.Config.RelayMaxGas,
+			Quota:  config.Config.RelayQuota,
+			Window: config.Config.RelayQuotaWindow,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create relayer: %v", err)
+		}
+		opts = append(opts, handlers.WithRelayer(relayer))
+	}
+	// Accept uploads durably and complete them in the background
+	if config.Config.OutboxDir != "" {
+		jobs, err := outbox.OpenStore(config.Config.OutboxDir)
+		if err != nil {
+			log.Fatalf("Failed to open outbox: %v", err)
+		}
+		defer jobs.Close()
+		outboxOpts := []outbox.Option{
+			outbox.WithWorkers(config.Config.OutboxWorkers),
+			outbox.WithMaxAttempts(config.Config.OutboxMaxAttempts),
+			outbox.WithConfirmations(config.Config.Confirmations, config.Config.TxWaitTimeout),
+			outbox.WithRetention(config.Config.OutboxRetention),
+		}
+		if config.Config.SignedUploads {
+			outboxOpts = append(outboxOpts, outbox.WithSignerRecorder(store))
+		}
+		if hooks != nil {
+			outboxOpts = append(outboxOpts, outbox.WithListener(hooks.JobFinished))
+		}
+		ob := outbox.New(jobs, contractAPI, ipfsClient, outboxOpts...)
+		go ob.Run(context.Background())
+		opts = append(opts, handlers.WithOutbox(ob))
+	}
+	if hooks != nil {
+		opts = append(opts, handlers.WithWebhooks(hooks))
+	}
+	broadcaster := events.New(contractAPI.Client(), contractAPI.Registry(), events.WithMaxBacklog(config.Config.EventsMaxBacklog))
+	go broadcaster.Run(context.Background())
+	opts = append(opts, handlers.WithEvents(broadcaster), handlers.WithEventOrigins(config.Config.EventOrigins))
+	if config.Config.APIKeysFile != "" {
+		keys, err := auth.LoadKeyRing(config.Config.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+		opts = append(opts, handlers.WithAPIKeys(keys))
+	} else {
+		log.Printf("API_KEYS_FILE is not set: the API is open to every client")
+	}
+
+	router := handlers.SetupRouter(contractAPI, ipfsClient, opts...)
 
 	addr := ":" + config.Config.Port
 	log.Printf("Listening on %s", addr)
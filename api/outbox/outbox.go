@@ -0,0 +1,566 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/avkos/file-registry/api/contracts"
+)
+
+// Defaults used when no Option overrides them.
+const (
+	DefaultWorkers        = 4
+	DefaultMaxAttempts    = 10
+	DefaultMinBackoff     = time.Second
+	DefaultMaxBackoff     = 5 * time.Minute
+	DefaultConfirmations  = 1
+	DefaultReceiptTimeout = 2 * time.Minute
+	DefaultRetention      = 7 * 24 * time.Hour
+)
+
+// pruneInterval is how often Run deletes the finished jobs older than the retention.
+const pruneInterval = time.Hour
+
+// Codes of job failures that are not contract errors. Contract errors keep their contracts.ErrorCode.
+const (
+	CodeIPFSError   = "ipfs_error"
+	CodeTxReverted  = "tx_reverted"
+	CodeTxCancelled = "tx_cancelled"
+	CodeTxTimeout   = "tx_timeout"
+	CodeInternal    = "internal"
+)
+
+// permanentCodes are the contract error codes that retrying the same save cannot fix.
+var permanentCodes = map[contracts.ErrorCode]bool{
+	contracts.CodeInvalidArgument: true,
+	contracts.CodeUnauthorized:    true,
+	contracts.CodeNotFound:        true,
+	contracts.CodeConflict:        true,
+	contracts.CodeReverted:        true,
+}
+
+// Contract is the part of the contract API the outbox saves through.
+type Contract interface {
+	SaveRecorded(ctx context.Context, filePath, cid string, record func(*types.Transaction) error) (string, error)
+	Rebroadcast(ctx context.Context, rawTx []byte) (string, error)
+	FindMined(ctx context.Context, rawTx []byte, since time.Time) (*contracts.TxStatus, error)
+	WaitMined(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error)
+	Get(filePath string) (string, error)
+}
+
+// IPFSClient adds the content of uploads to IPFS.
+type IPFSClient interface {
+	AddReader(ctx context.Context, r io.Reader) (string, error)
+}
+
+// SignerRecorder records the wallets that authorized uploads, see indexer.Store.RecordSigner.
+type SignerRecorder interface {
+	RecordSigner(txHash common.Hash, logIndex uint, signer common.Address) error
+}
+
+// Request describes an upload to enqueue.
+type Request struct {
+	FilePath string
+	// Signer is the wallet the upload is saved for, nil when the API saves on its own behalf.
+	Signer *common.Address
+}
+
+// Outbox accepts uploads durably and completes them in the background: a pool of workers adds the
+// content to IPFS, saves the CID on-chain and waits for the receipt, retrying failed steps with
+// exponential backoff. Jobs that were not finished when the process stopped resume at the step
+// they reached.
+//
+// Jobs of the same path run one at a time in the order they were accepted, so that an older upload
+// never overwrites a newer one. The save transaction of a job is stored before it is broadcast: a job
+// whose transaction the node does not know, because it was dropped or a crash came before the send,
+// broadcasts the same transaction again instead of saving twice.
+type Outbox struct {
+	store    *Store
+	contract Contract
+	ipfs     IPFSClient
+	signers  SignerRecorder
+	listener func(Job)
+
+	workers        int
+	maxAttempts    int
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	confirmations  uint64
+	receiptTimeout time.Duration
+	retention      time.Duration
+	now            func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	changed chan struct{} // closed and replaced whenever a job changes
+	wake    chan struct{}
+}
+
+// Option configures an Outbox.
+type Option func(*Outbox)
+
+// WithWorkers sets the number of jobs processed at once. Zero keeps the default.
+func WithWorkers(n int) Option {
+	return func(o *Outbox) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithMaxAttempts sets the number of failed attempts after which a job fails. Zero keeps the default.
+func WithMaxAttempts(n int) Option {
+	return func(o *Outbox) {
+		if n > 0 {
+			o.maxAttempts = n
+		}
+	}
+}
+
+// WithBackoff sets the delay before the first retry of a job, doubled after every further failure
+// up to max. Zero values keep the defaults.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *Outbox) {
+		if min > 0 {
+			o.minBackoff = min
+		}
+		if max > 0 {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// WithConfirmations sets the confirmations a save needs for its job to be mined, and how long one
+// attempt waits for them. Zero values keep the defaults.
+func WithConfirmations(depth uint64, timeout time.Duration) Option {
+	return func(o *Outbox) {
+		if depth > 0 {
+			o.confirmations = depth
+		}
+		if timeout > 0 {
+			o.receiptTimeout = timeout
+		}
+	}
+}
+
+// WithRetention sets how long finished jobs are kept after they were accepted. Zero keeps them forever.
+func WithRetention(retention time.Duration) Option {
+	return func(o *Outbox) {
+		o.retention = retention
+	}
+}
+
+// WithSignerRecorder records the wallet of uploads saved for a wallet once their CID is known.
+func WithSignerRecorder(signers SignerRecorder) Option {
+	return func(o *Outbox) {
+		o.signers = signers
+	}
+}
+
+// WithListener calls fn with every job once it is finished, mined or failed.
+func WithListener(fn func(Job)) Option {
+	return func(o *Outbox) {
+		o.listener = fn
+	}
+}
+
+// New creates an Outbox keeping its jobs in store. Jobs are processed once Run is called.
+func New(store *Store, contract Contract, ipfs IPFSClient, opts ...Option) *Outbox {
+	o := &Outbox{
+		store:          store,
+		contract:       contract,
+		ipfs:           ipfs,
+		workers:        DefaultWorkers,
+		maxAttempts:    DefaultMaxAttempts,
+		minBackoff:     DefaultMinBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+		confirmations:  DefaultConfirmations,
+		receiptTimeout: DefaultReceiptTimeout,
+		retention:      DefaultRetention,
+		now:            time.Now,
+		running:        make(map[string]bool),
+		changed:        make(chan struct{}),
+		wake:           make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Enqueue stores the content read from r as a queued job and returns the job. The upload is complete
+// on disk when Enqueue returns, so it survives a restart.
+func (o *Outbox) Enqueue(req Request, r io.Reader) (*Job, error) {
+	now := o.now()
+	id, err := newJobID(now)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{ID: id, FilePath: req.FilePath, Stage: StageQueued, CreatedAt: now, UpdatedAt: now}
+	if req.Signer != nil {
+		job.Signer = req.Signer.Hex()
+	}
+	if err := o.store.Create(job, r); err != nil {
+		return nil, err
+	}
+	o.notify()
+	return job, nil
+}
+
+// Job returns the job with the given ID, or ErrJobNotFound.
+func (o *Outbox) Job(id string) (*Job, error) {
+	return o.store.Get(id)
+}
+
+// List returns the jobs matching filter, newest first, paginated like Store.List.
+func (o *Outbox) List(filter Filter, cursor string, limit int) ([]Job, string, error) {
+	return o.store.List(filter, cursor, limit)
+}
+
+// Wait blocks until the job reached stage or failed, or until ctx is done. It returns the latest
+// state of the job in every case.
+func (o *Outbox) Wait(ctx context.Context, id string, stage Stage) (*Job, error) {
+	for {
+		o.mu.Lock()
+		changed := o.changed
+		o.mu.Unlock()
+
+		job, err := o.store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Stage == StageFailed || job.Stage.Reached(stage) {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Run processes the pending jobs, including those left over by a previous run, until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				// A job may still be handed over while the outbox stops.
+				if ctx.Err() == nil {
+					o.process(ctx, id)
+				}
+				o.mu.Lock()
+				delete(o.running, id)
+				o.mu.Unlock()
+				o.notify()
+			}
+		}()
+	}
+	if o.retention > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.prune(ctx)
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	for {
+		next, err := o.dispatch(ctx, jobs)
+		if err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// prune deletes the finished jobs older than the retention right away and then every pruneInterval
+// until ctx is done.
+func (o *Outbox) prune(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := o.store.Prune(o.now().Add(-o.retention)); err != nil {
+			log.Printf("outbox: failed to prune jobs: %v", err)
+		} else if n > 0 {
+			log.Printf("outbox: pruned %d finished jobs", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch hands the pending jobs that are due and not being processed to the workers, skipping those
+// waiting for an older job of their path. It returns the time until the next retry is due.
+func (o *Outbox) dispatch(ctx context.Context, jobs chan<- string) (time.Duration, error) {
+	next := o.maxBackoff
+	ids, err := o.store.Pending()
+	if err != nil {
+		return next, fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+	busy := make(map[string]bool) // paths with an older pending job
+	for _, id := range ids {
+		job, err := o.store.Get(id)
+		if err != nil {
+			return next, fmt.Errorf("failed to read job %s: %w", id, err)
+		}
+		if busy[job.FilePath] {
+			continue
+		}
+		busy[job.FilePath] = true
+		o.mu.Lock()
+		running := o.running[id]
+		o.mu.Unlock()
+		if running {
+			continue
+		}
+		if job.NextAttempt != nil {
+			if wait := job.NextAttempt.Sub(o.now()); wait > 0 {
+				next = min(next, wait)
+				continue
+			}
+		}
+		o.mu.Lock()
+		o.running[id] = true
+		o.mu.Unlock()
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			return next, nil
+		}
+	}
+	return next, nil
+}
+
+// process moves a job through its remaining steps until it is finished or a step fails.
+func (o *Outbox) process(ctx context.Context, id string) {
+	job, err := o.store.Get(id)
+	if err != nil {
+		log.Printf("outbox: failed to read job %s: %v", id, err)
+		return
+	}
+	for !job.Stage.Final() {
+		var code string
+		switch job.Stage {
+		case StageQueued:
+			code, err = o.pin(ctx, job)
+		case StagePinned:
+			code, err = o.broadcast(ctx, job)
+		case StageBroadcast:
+			code, err = o.confirm(ctx, job)
+		}
+		if err != nil {
+			// Steps interrupted by a shutdown are not failures; they run again after the restart.
+			if ctx.Err() == nil {
+				o.retry(job, code, err)
+			}
+			return
+		}
+		if job.Stage != StageFailed {
+			job.Attempts, job.Code, job.Error, job.NextAttempt = 0, "", "", nil
+		}
+		if err := o.put(job); err != nil {
+			log.Printf("outbox: %v", err)
+			return
+		}
+	}
+}
+
+// pin adds the content of a queued job to IPFS.
+func (o *Outbox) pin(ctx context.Context, job *Job) (string, error) {
+	payload, err := o.store.Payload(job.ID)
+	if err != nil {
+		return CodeInternal, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer payload.Close()
+	cid, err := o.ipfs.AddReader(ctx, payload)
+	if err != nil {
+		return CodeIPFSError, fmt.Errorf("IPFS add error: %w", err)
+	}
+	job.CID, job.Stage = cid, StagePinned
+	return "", nil
+}
+
+// broadcast sends the save transaction of a pinned job, storing the signed transaction with the job
+// before it is broadcast. A send that failed after the transaction may have reached the node leaves
+// the job broadcast, for confirm to find out.
+func (o *Outbox) broadcast(ctx context.Context, job *Job) (string, error) {
+	recorded := false
+	txHash, err := o.contract.SaveRecorded(ctx, job.FilePath, job.CID, func(tx *types.Transaction) error {
+		rawTx, err := tx.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		nonce := tx.Nonce()
+		job.TxHash, job.Nonce, job.RawTx, job.Stage = tx.Hash().Hex(), &nonce, rawTx, StageBroadcast
+		if err := o.put(job); err != nil {
+			return err
+		}
+		recorded = true
+		return nil
+	})
+	switch {
+	case err == nil:
+		job.TxHash, job.Stage = txHash, StageBroadcast
+		return "", nil
+	case recorded && contracts.MaybeSent(err):
+		log.Printf("outbox: job %s: transaction %s may have been sent: %v", job.ID, job.TxHash, err)
+		return "", nil
+	}
+	job.TxHash, job.Nonce, job.RawTx, job.Stage = "", nil, nil, StagePinned
+	return string(contracts.Classify(err).Code), fmt.Errorf("contract save error: %w", err)
+}
+
+// confirm waits for the receipt of the save transaction of a job, and records the signer of the
+// job with the save once it succeeded. A transaction the node does not know is sent again first.
+func (o *Outbox) confirm(ctx context.Context, job *Job) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, o.receiptTimeout)
+	defer cancel()
+	status, err := o.contract.WaitMined(ctx, job.TxHash, o.confirmations)
+	if errors.Is(err, contracts.ErrTxNotFound) {
+		var code string
+		if code, err = o.resend(ctx, job); err != nil || job.Stage != StageBroadcast {
+			return code, err
+		}
+		status, err = o.contract.WaitMined(ctx, job.TxHash, o.confirmations)
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTxTimeout, fmt.Errorf("timed out waiting for transaction %s", job.TxHash)
+	case errors.Is(err, contracts.ErrTxNotFound):
+		return CodeTxTimeout, fmt.Errorf("transaction %s is not known to the node", job.TxHash)
+	case err != nil:
+		return string(contracts.Classify(err).Code), fmt.Errorf("transaction wait error: %w", err)
+	case status.Status == contracts.TxReverted:
+		job.Stage, job.Code, job.Error = StageFailed, CodeTxReverted, "transaction reverted"
+		job.BlockNumber = status.BlockNumber
+		return "", nil
+	case status.Status == contracts.TxCancelled:
+		job.Stage, job.Code, job.Error = StageFailed, CodeTxCancelled, "transaction cancelled"
+		job.BlockNumber = status.BlockNumber
+		return "", nil
+	}
+	if job.Signer != "" && o.signers != nil {
+		for _, logIndex := range status.SavedLogs {
+			if err := o.signers.RecordSigner(status.MinedHash(), logIndex, common.HexToAddress(job.Signer)); err != nil {
+				log.Printf("outbox: failed to record signer %s of %s: %v", job.Signer, job.FilePath, err)
+			}
+		}
+	}
+	job.Stage, job.BlockNumber = StageMined, status.BlockNumber
+	return "", nil
+}
+
+// resend broadcasts the stored transaction of a job the node does not know again. When the account
+// mined another transaction with its nonce, e.g. a replacement sent before a restart, the job waits
+// for that transaction if it is a save, so that its block and signer are recorded like for any other
+// job. Otherwise the CID stored for the path tells whether the save made it: the job is mined if so,
+// and pinned again to be sent anew otherwise.
+func (o *Outbox) resend(ctx context.Context, job *Job) (string, error) {
+	if len(job.RawTx) > 0 {
+		_, err := o.contract.Rebroadcast(ctx, job.RawTx)
+		if err == nil {
+			log.Printf("outbox: job %s: sent transaction %s again", job.ID, job.TxHash)
+			return "", nil
+		}
+		if !errors.Is(err, contracts.ErrNonceUsed) && !contracts.IsNonceError(err) {
+			return string(contracts.Classify(err).Code), fmt.Errorf("transaction rebroadcast error: %w", err)
+		}
+		status, err := o.contract.FindMined(ctx, job.RawTx, job.CreatedAt)
+		if err == nil {
+			log.Printf("outbox: job %s: transaction %s was replaced by %s", job.ID, job.TxHash, status.MinedHash().Hex())
+			job.TxHash = status.MinedHash().Hex()
+			return "", nil
+		}
+		if !errors.Is(err, contracts.ErrTxNotFound) {
+			return string(contracts.Classify(err).Code), fmt.Errorf("transaction lookup error: %w", err)
+		}
+	}
+	cid, err := o.contract.Get(job.FilePath)
+	if err != nil {
+		return string(contracts.Classify(err).Code), fmt.Errorf("contract get error: %w", err)
+	}
+	if cid == job.CID {
+		job.Stage = StageMined
+		return "", nil
+	}
+	log.Printf("outbox: job %s: transaction %s was not mined, saving again", job.ID, job.TxHash)
+	job.TxHash, job.Nonce, job.RawTx, job.Stage = "", nil, nil, StagePinned
+	return "", nil
+}
+
+// retry records a failed attempt, scheduling the next one with exponential backoff or failing the
+// job after a permanent error or too many attempts.
+func (o *Outbox) retry(job *Job, code string, err error) {
+	job.Attempts++
+	job.Code, job.Error = code, err.Error()
+	if permanentCodes[contracts.ErrorCode(code)] || job.Attempts >= o.maxAttempts {
+		job.Stage, job.NextAttempt = StageFailed, nil
+		log.Printf("outbox: job %s for %s failed after %d attempts: %v", job.ID, job.FilePath, job.Attempts, err)
+	} else {
+		next := o.now().Add(o.backoff(job.Attempts))
+		job.NextAttempt = &next
+	}
+	if err := o.put(job); err != nil {
+		log.Printf("outbox: %v", err)
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.minBackoff
+	for i := 1; i < attempts && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, o.maxBackoff)
+}
+
+// put writes the job, wakes up the waiters and passes finished jobs to the listener.
+func (o *Outbox) put(job *Job) error {
+	job.UpdatedAt = o.now()
+	if err := o.store.Put(job); err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	o.notify()
+	if job.Stage.Final() && o.listener != nil {
+		o.listener(*job)
+	}
+	return nil
+}
+
+// notify wakes up Wait callers and the dispatcher.
+func (o *Outbox) notify() {
+	o.mu.Lock()
+	close(o.changed)
+	o.changed = make(chan struct{})
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
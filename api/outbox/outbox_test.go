@@ -0,0 +1,514 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/outbox"
+)
+
+type mockContract struct {
+	saveFunc        func(filePath, cid string) (string, error)
+	sendErr         error // returned after the transaction is recorded, when the send is ambiguous
+	rebroadcastFunc func(rawTx []byte) (string, error)
+	findMinedFunc   func(rawTx []byte) (*contracts.TxStatus, error)
+	waitMinedFunc   func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error)
+	getFunc         func(filePath string) (string, error)
+}
+
+// SaveRecorded fails like a save rejected before signing when saveFunc fails, and records a
+// transaction of the path and CID otherwise.
+func (m *mockContract) SaveRecorded(ctx context.Context, filePath, cid string, record func(*types.Transaction) error) (string, error) {
+	txHash := "0xtx"
+	if m.saveFunc != nil {
+		var err error
+		if txHash, err = m.saveFunc(filePath, cid); err != nil {
+			return "", err
+		}
+	}
+	if err := record(types.NewTx(&types.LegacyTx{Nonce: 1, Data: []byte(filePath + "=" + cid)})); err != nil {
+		return "", err
+	}
+	if m.sendErr != nil {
+		return "", m.sendErr
+	}
+	return txHash, nil
+}
+
+func (m *mockContract) Rebroadcast(ctx context.Context, rawTx []byte) (string, error) {
+	if m.rebroadcastFunc != nil {
+		return m.rebroadcastFunc(rawTx)
+	}
+	return "0xtx", nil
+}
+
+func (m *mockContract) FindMined(ctx context.Context, rawTx []byte, since time.Time) (*contracts.TxStatus, error) {
+	if m.findMinedFunc != nil {
+		return m.findMinedFunc(rawTx)
+	}
+	return nil, contracts.ErrTxNotFound
+}
+
+func (m *mockContract) Get(filePath string) (string, error) {
+	if m.getFunc != nil {
+		return m.getFunc(filePath)
+	}
+	return "", nil
+}
+
+func (m *mockContract) WaitMined(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+	if m.waitMinedFunc != nil {
+		return m.waitMinedFunc(ctx, txHash, confirmations)
+	}
+	return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7, Confirmations: confirmations, SavedLogs: []uint{3}}, nil
+}
+
+type mockIPFS struct {
+	mu    sync.Mutex
+	added []string
+	err   error
+}
+
+func (m *mockIPFS) AddReader(ctx context.Context, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return "", m.err
+	}
+	m.added = append(m.added, string(data))
+	return "QmFakeCID", nil
+}
+
+func (m *mockIPFS) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+type mockRecorder struct {
+	txHash   common.Hash
+	logIndex uint
+	signer   common.Address
+}
+
+func (m *mockRecorder) RecordSigner(txHash common.Hash, logIndex uint, signer common.Address) error {
+	m.txHash, m.logIndex, m.signer = txHash, logIndex, signer
+	return nil
+}
+
+func openStore(t *testing.T, dir string) *outbox.Store {
+	store, err := outbox.OpenStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// run starts ob until the test ends and returns a function stopping it early.
+func run(t *testing.T, ob *outbox.Outbox) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ob.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func wait(t *testing.T, ob *outbox.Outbox, id string, stage outbox.Stage) *outbox.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, err := ob.Wait(ctx, id, stage)
+	require.NoError(t, err)
+	return job
+}
+
+func TestOutbox_CompletesUpload(t *testing.T) {
+	dir := t.TempDir()
+	ipfs := &mockIPFS{}
+	recorder := &mockRecorder{}
+	var saved []string
+	contract := &mockContract{saveFunc: func(filePath, cid string) (string, error) {
+		saved = append(saved, filePath+"="+cid)
+		return "0xtx", nil
+	}}
+	ob := outbox.New(openStore(t, dir), contract, ipfs, outbox.WithSignerRecorder(recorder))
+	run(t, ob)
+
+	signer := common.HexToAddress("0x01")
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt", Signer: &signer}, strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, outbox.StageQueued, job.Stage)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageMined, job.Stage)
+	assert.Equal(t, "QmFakeCID", job.CID)
+	assert.Equal(t, "0xtx", job.TxHash)
+	assert.Equal(t, uint64(7), job.BlockNumber)
+	assert.Equal(t, []string{"hello"}, ipfs.added)
+	assert.Equal(t, []string{"/test/file.txt=QmFakeCID"}, saved)
+	assert.Equal(t, signer, recorder.signer)
+	assert.Equal(t, common.HexToHash("0xtx"), recorder.txHash)
+	assert.Equal(t, uint(3), recorder.logIndex)
+
+	// The local copy of the content is deleted once it is in IPFS.
+	entries, err := os.ReadDir(filepath.Join(dir, "payloads"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOutbox_RetriesWithBackoff(t *testing.T) {
+	ipfs := &mockIPFS{}
+	ipfs.fail(errors.New("connection refused"))
+	ob := outbox.New(openStore(t, t.TempDir()), &mockContract{}, ipfs, outbox.WithBackoff(20*time.Millisecond, time.Second))
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		job, err := ob.Job(job.ID)
+		return err == nil && job.Attempts >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	job, err = ob.Job(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.StageQueued, job.Stage)
+	assert.Equal(t, outbox.CodeIPFSError, job.Code)
+	assert.Contains(t, job.Error, "connection refused")
+	assert.True(t, job.NextAttempt.After(job.UpdatedAt))
+
+	ipfs.fail(nil)
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageMined, job.Stage)
+	assert.Zero(t, job.Attempts)
+	assert.Empty(t, job.Error)
+}
+
+func TestOutbox_PermanentError(t *testing.T) {
+	calls := 0
+	contract := &mockContract{saveFunc: func(filePath, cid string) (string, error) {
+		calls++
+		return "", &contracts.Error{Code: contracts.CodeUnauthorized, Err: contracts.ErrUnauthorized}
+	}}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{}, outbox.WithBackoff(time.Millisecond, time.Millisecond))
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageFailed, job.Stage)
+	assert.Equal(t, string(contracts.CodeUnauthorized), job.Code)
+	assert.Equal(t, "QmFakeCID", job.CID)
+	assert.Equal(t, 1, calls)
+}
+
+func TestOutbox_MaxAttempts(t *testing.T) {
+	contract := &mockContract{saveFunc: func(filePath, cid string) (string, error) {
+		return "", errors.New("connection refused")
+	}}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{}, outbox.WithBackoff(time.Millisecond, time.Millisecond), outbox.WithMaxAttempts(3))
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageFailed, job.Stage)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Equal(t, string(contracts.CodeRPCUnavailable), job.Code)
+}
+
+func TestOutbox_Reverted(t *testing.T) {
+	contract := &mockContract{waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 9}, nil
+	}}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{})
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageFailed, job.Stage)
+	assert.Equal(t, outbox.CodeTxReverted, job.Code)
+	assert.Equal(t, "0xtx", job.TxHash)
+}
+
+func TestOutbox_ListenerHearsFinishedJobs(t *testing.T) {
+	contract := &mockContract{waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 9}, nil
+	}}
+	finished := make(chan outbox.Job, 1)
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{}, outbox.WithListener(func(job outbox.Job) {
+		finished <- job
+	}))
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	select {
+	case got := <-finished:
+		assert.Equal(t, job.ID, got.ID)
+		assert.Equal(t, outbox.StageFailed, got.Stage)
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener was not called")
+	}
+}
+
+func TestOutbox_RevertedSignerNotRecorded(t *testing.T) {
+	contract := &mockContract{waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxReverted, BlockNumber: 9}, nil
+	}}
+	recorder := &mockRecorder{}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{}, outbox.WithSignerRecorder(recorder))
+	run(t, ob)
+
+	signer := common.HexToAddress("0x01")
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt", Signer: &signer}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageFailed, job.Stage)
+	assert.Equal(t, common.Address{}, recorder.signer)
+}
+
+func TestOutbox_ResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// The first run pins the content and stops while the save is being sent.
+	store, err := outbox.OpenStore(dir)
+	require.NoError(t, err)
+	saving, release := make(chan struct{}), make(chan struct{})
+	blocked := &mockContract{saveFunc: func(filePath, cid string) (string, error) {
+		close(saving)
+		<-release
+		return "", errors.New("connection refused")
+	}}
+	ob := outbox.New(store, blocked, &mockIPFS{})
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ob.Run(ctx)
+		close(done)
+	}()
+	<-saving
+	cancel()
+	close(release)
+	<-done
+	require.NoError(t, store.Close())
+
+	// The second run resumes at the save, without adding the content again.
+	ipfs := &mockIPFS{}
+	ob = outbox.New(openStore(t, dir), &mockContract{}, ipfs)
+	pinned, err := ob.Job(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.StagePinned, pinned.Stage)
+	run(t, ob)
+
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageMined, job.Stage)
+	assert.Empty(t, ipfs.added)
+}
+
+func TestOutbox_WaitTimeout(t *testing.T) {
+	ob := outbox.New(openStore(t, t.TempDir()), &mockContract{}, &mockIPFS{})
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	// Nothing runs the outbox, so the job stays queued.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	job, err = ob.Wait(ctx, job.ID, outbox.StageBroadcast)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, outbox.StageQueued, job.Stage)
+
+	_, err = ob.Job("unknown")
+	assert.ErrorIs(t, err, outbox.ErrJobNotFound)
+}
+
+func TestOutbox_OneJobPerPath(t *testing.T) {
+	var mu sync.Mutex
+	var saved []string
+	release := make(chan struct{})
+	contract := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			saved = append(saved, filePath)
+			return fmt.Sprintf("0x%d", len(saved)), nil
+		},
+		waitMinedFunc: func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+			if txHash == "0x1" {
+				<-release
+			}
+			return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7}, nil
+		},
+	}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{})
+	run(t, ob)
+
+	first, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("old"))
+	require.NoError(t, err)
+	wait(t, ob, first.ID, outbox.StageBroadcast)
+	second, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("new"))
+	require.NoError(t, err)
+	other, err := ob.Enqueue(outbox.Request{FilePath: "/test/other.txt"}, strings.NewReader("other"))
+	require.NoError(t, err)
+
+	// Other paths go on while the newer upload of the path waits for the older one.
+	wait(t, ob, other.ID, outbox.StageMined)
+	job, err := ob.Job(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, outbox.StageQueued, job.Stage)
+
+	close(release)
+	assert.Equal(t, outbox.StageMined, wait(t, ob, second.ID, outbox.StageMined).Stage)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/test/file.txt", "/test/other.txt", "/test/file.txt"}, saved)
+}
+
+func TestOutbox_RebroadcastsUnknownTx(t *testing.T) {
+	saves := 0
+	var rebroadcast []byte
+	contract := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			saves++
+			return "0xtx", nil
+		},
+		// The send times out although the transaction may have reached the node, which then drops it.
+		sendErr: fmt.Errorf("failed to send: %w", context.DeadlineExceeded),
+		rebroadcastFunc: func(rawTx []byte) (string, error) {
+			rebroadcast = rawTx
+			return "0xtx", nil
+		},
+	}
+	contract.waitMinedFunc = func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		if rebroadcast == nil {
+			return nil, contracts.ErrTxNotFound
+		}
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7}, nil
+	}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{})
+	run(t, ob)
+
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageMined, job.Stage)
+	assert.Equal(t, 1, saves)
+	require.NotNil(t, job.Nonce)
+	assert.Equal(t, uint64(1), *job.Nonce)
+
+	// The same signed transaction is sent again.
+	tx := new(types.Transaction)
+	require.NoError(t, tx.UnmarshalBinary(rebroadcast))
+	assert.Equal(t, job.TxHash, tx.Hash().Hex())
+	assert.Equal(t, "/test/file.txt=QmFakeCID", string(tx.Data()))
+}
+
+func TestOutbox_NonceUsed(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stored string
+		saves  int
+	}{
+		{name: "saved", stored: "QmFakeCID", saves: 1},
+		{name: "not saved", stored: "QmOther", saves: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			saves := 0
+			contract := &mockContract{
+				saveFunc: func(filePath, cid string) (string, error) {
+					saves++
+					return fmt.Sprintf("0x%d", saves), nil
+				},
+				rebroadcastFunc: func(rawTx []byte) (string, error) {
+					return "", &contracts.Error{Code: contracts.CodeConflict, Err: contracts.ErrNonceUsed}
+				},
+				getFunc: func(filePath string) (string, error) { return tt.stored, nil },
+			}
+			// The node knows nothing of the first transaction, and another one took its nonce.
+			contract.waitMinedFunc = func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+				if txHash == "0x1" {
+					return nil, contracts.ErrTxNotFound
+				}
+				return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 7}, nil
+			}
+			ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{})
+			run(t, ob)
+
+			job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+			require.NoError(t, err)
+			job = wait(t, ob, job.ID, outbox.StageMined)
+			assert.Equal(t, outbox.StageMined, job.Stage)
+			assert.Equal(t, tt.saves, saves)
+		})
+	}
+}
+
+func TestOutbox_NonceUsedByReplacement(t *testing.T) {
+	replacement := common.HexToHash("0x02")
+	saves := 0
+	contract := &mockContract{
+		saveFunc: func(filePath, cid string) (string, error) {
+			saves++
+			return "0xtx", nil
+		},
+		rebroadcastFunc: func(rawTx []byte) (string, error) {
+			return "", &contracts.Error{Code: contracts.CodeConflict, Err: contracts.ErrNonceUsed}
+		},
+		// A speed-up sent before a restart took the nonce, and the tracker forgot it.
+		findMinedFunc: func(rawTx []byte) (*contracts.TxStatus, error) {
+			return &contracts.TxStatus{Hash: "0xtx", Status: contracts.TxMined, BlockNumber: 9, ReplacedBy: replacement.Hex()}, nil
+		},
+		getFunc: func(filePath string) (string, error) { return "QmFakeCID", nil },
+	}
+	contract.waitMinedFunc = func(ctx context.Context, txHash string, confirmations uint64) (*contracts.TxStatus, error) {
+		if txHash == "0xtx" {
+			return nil, contracts.ErrTxNotFound
+		}
+		return &contracts.TxStatus{Hash: txHash, Status: contracts.TxMined, BlockNumber: 9, Confirmations: confirmations, SavedLogs: []uint{4}}, nil
+	}
+	recorder := &mockRecorder{}
+	ob := outbox.New(openStore(t, t.TempDir()), contract, &mockIPFS{}, outbox.WithSignerRecorder(recorder))
+	run(t, ob)
+
+	signer := common.HexToAddress("0x01")
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt", Signer: &signer}, strings.NewReader("hello"))
+	require.NoError(t, err)
+	job = wait(t, ob, job.ID, outbox.StageMined)
+	assert.Equal(t, outbox.StageMined, job.Stage)
+	assert.Equal(t, 1, saves)
+	assert.Equal(t, replacement.Hex(), job.TxHash)
+	assert.Equal(t, uint64(9), job.BlockNumber)
+	assert.Equal(t, replacement, recorder.txHash)
+	assert.Equal(t, uint(4), recorder.logIndex)
+	assert.Equal(t, signer, recorder.signer)
+}
@@ -0,0 +1,397 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Stage is the step an upload job has reached.
+type Stage string
+
+const (
+	// StageQueued jobs are accepted and their content is stored locally.
+	StageQueued Stage = "queued"
+	// StagePinned jobs have their content added to IPFS.
+	StagePinned Stage = "pinned"
+	// StageBroadcast jobs have their save transaction sent.
+	StageBroadcast Stage = "broadcast"
+	// StageMined jobs have their save transaction mined. It is a final stage.
+	StageMined Stage = "mined"
+	// StageFailed jobs gave up after a permanent error or too many attempts. It is a final stage.
+	StageFailed Stage = "failed"
+)
+
+var stageOrder = map[Stage]int{StageQueued: 0, StagePinned: 1, StageBroadcast: 2, StageMined: 3}
+
+// stages are all the stages, in order.
+var stages = []Stage{StageQueued, StagePinned, StageBroadcast, StageMined, StageFailed}
+
+// Final reports whether a job in the stage is finished.
+func (s Stage) Final() bool {
+	return s == StageMined || s == StageFailed
+}
+
+// Reached reports whether a job in the stage got at least as far as target. Failed jobs reach no stage.
+func (s Stage) Reached(target Stage) bool {
+	if s == StageFailed || target == StageFailed {
+		return s == target
+	}
+	return stageOrder[s] >= stageOrder[target]
+}
+
+// Job is an upload going through the outbox.
+type Job struct {
+	ID       string `json:"id"`
+	FilePath string `json:"filePath"`
+	Stage    Stage  `json:"stage"`
+	CID      string `json:"cid,omitempty"`
+	TxHash   string `json:"txHash,omitempty"`
+	// Nonce is the nonce of the save transaction, and RawTx the signed transaction, kept to send it again.
+	Nonce *uint64 `json:"nonce,omitempty"`
+	RawTx []byte  `json:"-"`
+	// Signer is the wallet that authorized the upload, when the API saves for a wallet.
+	Signer      string `json:"signer,omitempty"`
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+	// Attempts counts the failed attempts of the current step; Code and Error describe the latest failure.
+	Attempts    int        `json:"attempts"`
+	Code        string     `json:"code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	NextAttempt *time.Time `json:"nextAttemptAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// Key layout of the job database:
+//
+//	j/<id>           every job
+//	q/<id>           jobs that are not finished, to resume them after a restart
+//	s/<stage>/<id>   every job by stage, to list and prune them without reading the others
+//	m/stage-index    set once the stage index covers the jobs stored before it existed
+//
+// Job IDs start with the creation time, so every prefix sorts its jobs by age. The content of queued
+// jobs is kept in files named after the job ID, until it is added to IPFS.
+var (
+	jobPrefix     = []byte("j/")
+	pendingPrefix = []byte("q/")
+	stagePrefix   = []byte("s/")
+	stageIndexKey = []byte("m/stage-index")
+)
+
+var (
+	// ErrJobNotFound is returned for unknown job IDs.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrInvalidCursor is returned for pagination cursors the store did not issue.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Filter selects the jobs returned by List. Zero fields match every job.
+type Filter struct {
+	Stage Stage
+	// Prefix matches the jobs whose file path starts with it.
+	Prefix string
+	// Signer matches the jobs saved for the wallet, as returned in Job.Signer.
+	Signer string
+}
+
+func (f Filter) match(job *Job) bool {
+	return (f.Stage == "" || job.Stage == f.Stage) &&
+		strings.HasPrefix(job.FilePath, f.Prefix) &&
+		(f.Signer == "" || job.Signer == f.Signer)
+}
+
+// Store keeps upload jobs in an embedded database and their content in a directory next to it. It is
+// safe for concurrent use.
+type Store struct {
+	db       *leveldb.DB
+	payloads string
+}
+
+// OpenStore opens or creates the outbox in the directory at dir, deleting the content of uploads that
+// were not completely accepted when the process stopped.
+func OpenStore(dir string) (*Store, error) {
+	payloads := filepath.Join(dir, "payloads")
+	if err := os.MkdirAll(payloads, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	db, err := leveldb.OpenFile(filepath.Join(dir, "jobs"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox database: %w", err)
+	}
+	s := &Store{db: db, payloads: payloads}
+	if err := s.removeOrphans(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to clean up outbox: %w", err)
+	}
+	if err := s.indexStages(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to index outbox: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// jobIDLen is the length of job IDs in bytes, before hex encoding.
+const jobIDLen = 16
+
+// newJobID returns a random ID starting with the creation time, so that IDs sort by age.
+func newJobID(now time.Time) (string, error) {
+	id := make([]byte, jobIDLen)
+	binary.BigEndian.PutUint64(id, uint64(now.UnixNano()))
+	if _, err := rand.Read(id[8:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Create stores the content read from r and then the job, which must be queued. The job is only
+// visible once its content is complete on disk.
+func (s *Store) Create(job *Job, r io.Reader) error {
+	tmp, err := os.CreateTemp(s.payloads, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.payloadPath(job.ID)); err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := s.Put(job); err != nil {
+		os.Remove(s.payloadPath(job.ID))
+		return err
+	}
+	return nil
+}
+
+// Put writes job, keeping it in the pending jobs until it is finished. The content of jobs past the
+// queued stage is deleted.
+func (s *Store) Put(job *Job) error {
+	value, err := json.Marshal(storedJob{Job: job, RawTx: job.RawTx})
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(jobKey(job.ID), value)
+	putStage(batch, job)
+	if job.Stage.Final() {
+		batch.Delete(pendingKey(job.ID))
+	} else {
+		batch.Put(pendingKey(job.ID), nil)
+	}
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return fmt.Errorf("failed to write job: %w", err)
+	}
+	if job.Stage != StageQueued {
+		if err := os.Remove(s.payloadPath(job.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete upload: %w", err)
+		}
+	}
+	return nil
+}
+
+// Get returns the job with the given ID, or ErrJobNotFound.
+func (s *Store) Get(id string) (*Job, error) {
+	value, err := s.db.Get(jobKey(id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeJob(value)
+}
+
+// Pending returns the IDs of the jobs that are not finished, oldest first.
+func (s *Store) Pending() ([]string, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(pendingPrefix), nil)
+	defer iter.Release()
+
+	var ids []string
+	for iter.Next() {
+		ids = append(ids, string(iter.Key()[len(pendingPrefix):]))
+	}
+	return ids, iter.Error()
+}
+
+// List returns the jobs matching filter, newest first. It returns at most limit jobs (all of them when
+// limit is not positive) older than the job of cursor, and the cursor of the next page, which is empty
+// on the last page. A stage filter only reads the jobs of the stage.
+func (s *Store) List(filter Filter, cursor string, limit int) ([]Job, string, error) {
+	prefix := jobPrefix
+	if filter.Stage != "" {
+		prefix = stageKey(filter.Stage, "")
+	}
+	rng := util.BytesPrefix(prefix)
+	if cursor != "" {
+		if id, err := hex.DecodeString(cursor); err != nil || len(id) != jobIDLen {
+			return nil, "", ErrInvalidCursor
+		}
+		rng.Limit = append(append([]byte(nil), prefix...), cursor...)
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	var jobs []Job
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var job *Job
+		var err error
+		if filter.Stage != "" {
+			job, err = s.Get(string(iter.Key()[len(prefix):]))
+		} else {
+			job, err = decodeJob(iter.Value())
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !filter.match(job) {
+			continue
+		}
+		if limit > 0 && len(jobs) == limit {
+			return jobs, jobs[len(jobs)-1].ID, iter.Error()
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, "", iter.Error()
+}
+
+// Prune deletes the finished jobs created before the given time and returns how many it deleted.
+func (s *Store) Prune(before time.Time) (int, error) {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, uint64(before.UnixNano()))
+	batch := new(leveldb.Batch)
+	for _, stage := range []Stage{StageMined, StageFailed} {
+		prefix := stageKey(stage, "")
+		iter := s.db.NewIterator(&util.Range{Start: prefix, Limit: stageKey(stage, hex.EncodeToString(id))}, nil)
+		for iter.Next() {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+			batch.Delete(jobKey(string(iter.Key()[len(prefix):])))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return 0, err
+		}
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %w", err)
+	}
+	return batch.Len() / 2, nil
+}
+
+// Payload opens the content of a queued job.
+func (s *Store) Payload(id string) (io.ReadCloser, error) {
+	return os.Open(s.payloadPath(id))
+}
+
+// removeOrphans deletes stored content that no queued job refers to, left behind when the process
+// stopped while accepting an upload.
+func (s *Store) removeOrphans() error {
+	entries, err := os.ReadDir(s.payloads)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		job, err := s.Get(entry.Name())
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			return err
+		}
+		if job == nil || job.Stage != StageQueued {
+			if err := os.Remove(filepath.Join(s.payloads, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storedJob is the database record of a job, which keeps the fields the API does not show as well.
+type storedJob struct {
+	*Job
+	RawTx []byte `json:"rawTx,omitempty"`
+}
+
+func decodeJob(value []byte) (*Job, error) {
+	stored := storedJob{Job: new(Job)}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+	stored.Job.RawTx = stored.RawTx
+	return stored.Job, nil
+}
+
+// indexStages adds the jobs stored before the stage index existed to it.
+func (s *Store) indexStages() error {
+	if ok, err := s.db.Has(stageIndexKey, nil); err != nil || ok {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	iter := s.db.NewIterator(util.BytesPrefix(jobPrefix), nil)
+	for iter.Next() {
+		job, err := decodeJob(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		putStage(batch, job)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	batch.Put(stageIndexKey, nil)
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// putStage moves job to its current stage in the stage index.
+func putStage(batch *leveldb.Batch, job *Job) {
+	for _, stage := range stages {
+		if stage != job.Stage {
+			batch.Delete(stageKey(stage, job.ID))
+		}
+	}
+	batch.Put(stageKey(job.Stage, job.ID), nil)
+}
+
+func (s *Store) payloadPath(id string) string {
+	return filepath.Join(s.payloads, id)
+}
+
+func jobKey(id string) []byte {
+	return append(append([]byte(nil), jobPrefix...), id...)
+}
+
+func stageKey(stage Stage, id string) []byte {
+	key := append(append([]byte(nil), stagePrefix...), stage...)
+	return append(append(key, '/'), id...)
+}
+
+func pendingKey(id string) []byte {
+	return append(append([]byte(nil), pendingPrefix...), id...)
+}
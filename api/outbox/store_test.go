@@ -0,0 +1,160 @@
+package outbox_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/avkos/file-registry/api/outbox"
+)
+
+func TestStore_RemovesOrphanedUploads(t *testing.T) {
+	dir := t.TempDir()
+	store, err := outbox.OpenStore(dir)
+	require.NoError(t, err)
+	ob := outbox.New(store, &mockContract{}, &mockIPFS{})
+	job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// Content written before a crash, without its job.
+	payloads := filepath.Join(dir, "payloads")
+	require.NoError(t, os.WriteFile(filepath.Join(payloads, ".upload-123"), []byte("partial"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(payloads, "0123"), []byte("orphan"), 0o600))
+
+	store = openStore(t, dir)
+	entries, err := os.ReadDir(payloads)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, job.ID, entries[0].Name())
+
+	pending, err := store.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, []string{job.ID}, pending)
+}
+
+func TestStore_List(t *testing.T) {
+	ob := outbox.New(openStore(t, t.TempDir()), &mockContract{}, &mockIPFS{})
+	signer := common.HexToAddress("0x01")
+	var ids []string
+	for _, req := range []outbox.Request{
+		{FilePath: "/a/one.txt"},
+		{FilePath: "/b/two.txt", Signer: &signer},
+		{FilePath: "/a/three.txt"},
+		{FilePath: "/a/four.txt", Signer: &signer},
+	} {
+		job, err := ob.Enqueue(req, strings.NewReader("hello"))
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+	}
+
+	jobs, next, err := ob.List(outbox.Filter{Prefix: "/a/"}, "", 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, ids[3], jobs[0].ID)
+	assert.Equal(t, ids[2], jobs[1].ID)
+	assert.Equal(t, ids[2], next)
+
+	jobs, next, err = ob.List(outbox.Filter{Prefix: "/a/"}, next, 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, ids[0], jobs[0].ID)
+	assert.Empty(t, next)
+
+	jobs, _, err = ob.List(outbox.Filter{Signer: signer.Hex(), Stage: outbox.StageQueued}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, ids[3], jobs[0].ID)
+	assert.Equal(t, ids[1], jobs[1].ID)
+
+	jobs, _, err = ob.List(outbox.Filter{Stage: outbox.StageMined}, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	_, _, err = ob.List(outbox.Filter{}, "not-a-cursor", 10)
+	assert.ErrorIs(t, err, outbox.ErrInvalidCursor)
+}
+
+func TestStore_StageIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := outbox.OpenStore(dir)
+	require.NoError(t, err)
+	ob := outbox.New(store, &mockContract{}, &mockIPFS{})
+	var jobs []*outbox.Job
+	for i := 0; i < 3; i++ {
+		job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+		require.NoError(t, err)
+		jobs = append(jobs, job)
+	}
+	jobs[1].Stage = outbox.StageMined
+	require.NoError(t, store.Put(jobs[1]))
+
+	listed, _, err := ob.List(outbox.Filter{Stage: outbox.StageQueued}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, jobs[2].ID, listed[0].ID)
+	assert.Equal(t, jobs[0].ID, listed[1].ID)
+	listed, next, err := ob.List(outbox.Filter{Stage: outbox.StageQueued}, "", 1)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	listed, _, err = ob.List(outbox.Filter{Stage: outbox.StageQueued}, next, 1)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, jobs[0].ID, listed[0].ID)
+
+	// Jobs stored before the index existed are indexed when the store is opened.
+	require.NoError(t, store.Close())
+	db, err := leveldb.OpenFile(filepath.Join(dir, "jobs"), nil)
+	require.NoError(t, err)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		if key := string(iter.Key()); strings.HasPrefix(key, "s/") || strings.HasPrefix(key, "m/") {
+			require.NoError(t, db.Delete(iter.Key(), nil))
+		}
+	}
+	iter.Release()
+	require.NoError(t, db.Close())
+	ob = outbox.New(openStore(t, dir), &mockContract{}, &mockIPFS{})
+	listed, _, err = ob.List(outbox.Filter{Stage: outbox.StageMined}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, jobs[1].ID, listed[0].ID)
+}
+
+func TestStore_Prune(t *testing.T) {
+	store := openStore(t, t.TempDir())
+	ob := outbox.New(store, &mockContract{}, &mockIPFS{})
+	var jobs []*outbox.Job
+	for _, stage := range []outbox.Stage{outbox.StageMined, outbox.StageFailed, outbox.StageBroadcast} {
+		job, err := ob.Enqueue(outbox.Request{FilePath: "/test/file.txt"}, strings.NewReader("hello"))
+		require.NoError(t, err)
+		job.Stage = stage
+		require.NoError(t, store.Put(job))
+		jobs = append(jobs, job)
+	}
+
+	pruned, err := store.Prune(jobs[0].CreatedAt)
+	require.NoError(t, err)
+	assert.Zero(t, pruned)
+
+	// Finished jobs go, unfinished ones stay whatever their age.
+	pruned, err = store.Prune(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, pruned)
+	_, err = store.Get(jobs[0].ID)
+	assert.ErrorIs(t, err, outbox.ErrJobNotFound)
+	listed, _, err := ob.List(outbox.Filter{}, "", 0)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, jobs[2].ID, listed[0].ID)
+	listed, _, err = ob.List(outbox.Filter{Stage: outbox.StageFailed}, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+}
@@ -0,0 +1,373 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EventType names what happened to a file.
+type EventType string
+
+const (
+	// EventUploadConfirmed is sent when the save of an upload is mined.
+	EventUploadConfirmed EventType = "upload.confirmed"
+	// EventTxReverted is sent when the save of an upload reverted.
+	EventTxReverted EventType = "tx.reverted"
+	// EventFileSaved is sent for FileSaved logs of saves the API did not send itself.
+	EventFileSaved EventType = "file.saved"
+)
+
+// EventTypes are the event types subscriptions may select.
+var EventTypes = map[EventType]bool{
+	EventUploadConfirmed: true,
+	EventTxReverted:      true,
+	EventFileSaved:       true,
+}
+
+// Event is the payload of a webhook delivery. Its ID is the same for every delivery of the event,
+// including replays, so that receivers can ignore duplicates.
+type Event struct {
+	ID          string    `json:"id"`
+	Type        EventType `json:"type"`
+	FilePath    string    `json:"filePath"`
+	CID         string    `json:"cid,omitempty"`
+	TxHash      string    `json:"txHash,omitempty"`
+	BlockNumber uint64    `json:"blockNumber,omitempty"`
+	// Sender is the account that signed the transaction of a file.saved event.
+	Sender string `json:"sender,omitempty"`
+	// Writer is the account the registry saw as the writer of a file.saved event: the wallet that
+	// signed a relayed request, the Sender otherwise.
+	Writer string `json:"writer,omitempty"`
+	// JobID is the outbox job of an upload event sent through the outbox.
+	JobID      string    `json:"jobId,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// Subscription is a URL that receives the events of files under Prefix.
+type Subscription struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Prefix string `json:"prefix"`
+	// Events selects the event types sent to the URL, every type when empty.
+	Events []EventType `json:"events,omitempty"`
+	// Secret is the key deliveries are signed with, see Sign.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeliveryStatus is the state of a delivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Delivery is the sending of an event to a subscription, kept as the delivery log.
+type Delivery struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscriptionId"`
+	Event          Event          `json:"event"`
+	Status         DeliveryStatus `json:"status"`
+	// Attempts counts the requests sent; ResponseStatus and Error describe the latest one.
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	NextAttempt    *time.Time `json:"nextAttemptAt,omitempty"`
+	// ReplayOf is the delivery this one replays.
+	ReplayOf  string    `json:"replayOf,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Key layout of the webhook database:
+//
+//	s/<subscription>                 every subscription
+//	d/<subscription>/<delivery>      deliveries of a subscription, oldest first
+//	q/<delivery>                     subscription of deliveries that are still pending
+//	e/<subscription>/<event>         events already delivered to a subscription, with the time
+//	                                 of their first delivery
+var (
+	subscriptionPrefix = []byte("s/")
+	deliveryPrefix     = []byte("d/")
+	pendingPrefix      = []byte("q/")
+	eventPrefix        = []byte("e/")
+)
+
+var (
+	// ErrSubscriptionNotFound is returned for unknown subscription IDs.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrDeliveryNotFound is returned for unknown delivery IDs.
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrInvalidCursor is returned for pagination cursors the store did not issue.
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// idLen is the length of subscription and delivery IDs in bytes, before hex encoding.
+const idLen = 16
+
+// newID returns a random ID starting with the creation time, so that IDs sort by age.
+func newID(now time.Time) (string, error) {
+	id := make([]byte, idLen)
+	binary.BigEndian.PutUint64(id, uint64(now.UnixNano()))
+	if _, err := rand.Read(id[8:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// pendingDelivery identifies a delivery that is still pending.
+type pendingDelivery struct {
+	subscriptionID, id string
+}
+
+// Store keeps subscriptions and their deliveries in an embedded database. It is safe for concurrent use.
+type Store struct {
+	db *leveldb.DB
+	// mu makes checking a subscription and writing one of its deliveries atomic.
+	mu sync.Mutex
+}
+
+// OpenStore opens or creates the database in the directory at path.
+func OpenStore(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// PutSubscription writes sub.
+func (s *Store) PutSubscription(sub *Subscription) error {
+	value, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(key(subscriptionPrefix, sub.ID), value, &opt.WriteOptions{Sync: true})
+}
+
+// Subscription returns the subscription with the given ID, or ErrSubscriptionNotFound.
+func (s *Store) Subscription(id string) (*Subscription, error) {
+	value, err := s.db.Get(key(subscriptionPrefix, id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sub Subscription
+	if err := json.Unmarshal(value, &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Subscriptions returns every subscription, oldest first.
+func (s *Store) Subscriptions() ([]Subscription, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(subscriptionPrefix), nil)
+	defer iter.Release()
+
+	var subs []Subscription
+	for iter.Next() {
+		var sub Subscription
+		if err := json.Unmarshal(iter.Value(), &sub); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, iter.Error()
+}
+
+// DeleteSubscription deletes the subscription with the given ID together with its deliveries.
+func (s *Store) DeleteSubscription(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Get(key(subscriptionPrefix, id), nil); errors.Is(err, leveldb.ErrNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete(key(subscriptionPrefix, id))
+	deliveries := key(deliveryPrefix, id+"/")
+	err := s.deleteRange(batch, deliveries, func(k []byte) {
+		batch.Delete(key(pendingPrefix, string(k[len(deliveries):])))
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.deleteRange(batch, key(eventPrefix, id+"/"), nil); err != nil {
+		return err
+	}
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// deleteRange adds the deletion of every key starting with prefix to batch, calling fn with each key.
+func (s *Store) deleteRange(batch *leveldb.Batch, prefix []byte, fn func(k []byte)) error {
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+		if fn != nil {
+			fn(iter.Key())
+		}
+	}
+	return iter.Error()
+}
+
+// AddDelivery writes a new delivery. A delivery of an event its subscription already received is
+// skipped unless it is a replay; AddDelivery then returns false.
+func (s *Store) AddDelivery(d *Delivery) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := key(eventPrefix, d.SubscriptionID+"/"+d.Event.ID)
+	if d.ReplayOf == "" {
+		ok, err := s.db.Has(seen, nil)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(seen, binary.BigEndian.AppendUint64(nil, uint64(d.CreatedAt.UnixNano())))
+	if err := s.putDelivery(batch, d); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneEvents forgets the events first delivered before before, so that publishing one of them
+// again delivers it again. It returns the number of events forgotten.
+func (s *Store) PruneEvents(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	iter := s.db.NewIterator(util.BytesPrefix(eventPrefix), nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		// Events stored without a time are older than any retention.
+		if v := iter.Value(); len(v) == 8 && int64(binary.BigEndian.Uint64(v)) >= before.UnixNano() {
+			continue
+		}
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	return batch.Len(), nil
+}
+
+// PutDelivery updates d, keeping it in the pending deliveries until it is delivered or failed. It
+// returns ErrSubscriptionNotFound when the subscription was deleted in the meantime.
+func (s *Store) PutDelivery(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putDelivery(new(leveldb.Batch), d)
+}
+
+func (s *Store) putDelivery(batch *leveldb.Batch, d *Delivery) error {
+	if _, err := s.db.Get(key(subscriptionPrefix, d.SubscriptionID), nil); errors.Is(err, leveldb.ErrNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	batch.Put(key(deliveryPrefix, d.SubscriptionID+"/"+d.ID), value)
+	if d.Status == DeliveryPending {
+		batch.Put(key(pendingPrefix, d.ID), []byte(d.SubscriptionID))
+	} else {
+		batch.Delete(key(pendingPrefix, d.ID))
+	}
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return fmt.Errorf("failed to write delivery: %w", err)
+	}
+	return nil
+}
+
+// Delivery returns a delivery of the subscription, or ErrDeliveryNotFound.
+func (s *Store) Delivery(subscriptionID, id string) (*Delivery, error) {
+	value, err := s.db.Get(key(deliveryPrefix, subscriptionID+"/"+id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d Delivery
+	if err := json.Unmarshal(value, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Deliveries returns the deliveries of a subscription, newest first. It returns at most limit
+// deliveries (all of them when limit is not positive) older than the delivery of cursor, and the
+// cursor of the next page, which is empty on the last page.
+func (s *Store) Deliveries(subscriptionID, cursor string, limit int) ([]Delivery, string, error) {
+	prefix := key(deliveryPrefix, subscriptionID+"/")
+	rng := util.BytesPrefix(prefix)
+	if cursor != "" {
+		if id, err := hex.DecodeString(cursor); err != nil || len(id) != idLen {
+			return nil, "", ErrInvalidCursor
+		}
+		rng.Limit = key(deliveryPrefix, subscriptionID+"/"+cursor)
+	}
+	iter := s.db.NewIterator(rng, nil)
+	defer iter.Release()
+
+	var deliveries []Delivery
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		if limit > 0 && len(deliveries) == limit {
+			return deliveries, deliveries[len(deliveries)-1].ID, iter.Error()
+		}
+		var d Delivery
+		if err := json.Unmarshal(iter.Value(), &d); err != nil {
+			return nil, "", err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, "", iter.Error()
+}
+
+// pending returns the deliveries that are still pending, oldest first.
+func (s *Store) pending() ([]pendingDelivery, error) {
+	iter := s.db.NewIterator(util.BytesPrefix(pendingPrefix), nil)
+	defer iter.Release()
+
+	var deliveries []pendingDelivery
+	for iter.Next() {
+		deliveries = append(deliveries, pendingDelivery{
+			subscriptionID: string(iter.Value()),
+			id:             string(iter.Key()[len(pendingPrefix):]),
+		})
+	}
+	return deliveries, iter.Error()
+}
+
+func key(prefix []byte, id string) []byte {
+	return append(append([]byte(nil), prefix...), id...)
+}
@@ -0,0 +1,576 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/avkos/file-registry/api/outbox"
+)
+
+// Defaults used when no Option overrides them.
+const (
+	DefaultWorkers     = 4
+	DefaultMaxAttempts = 8
+	DefaultMinBackoff  = 5 * time.Second
+	DefaultMaxBackoff  = 30 * time.Minute
+	DefaultTimeout     = 10 * time.Second
+	DefaultRetention   = 7 * 24 * time.Hour
+)
+
+// pruneInterval is how often Run forgets the delivered events older than the retention.
+const pruneInterval = time.Hour
+
+// Headers of webhook requests.
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// ErrInvalidSubscription is returned by Subscribe for malformed subscriptions.
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+// Sign returns the signature of a delivery sent at timestamp (Unix seconds) with body: "sha256="
+// followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the subscription secret.
+// Receivers recompute it to authenticate deliveries, and reject old timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends the events of the registry to the subscribed URLs. Every delivery is stored
+// before it is sent and retried with exponential backoff until the receiver answers 2xx, so pending
+// deliveries survive a restart.
+type Dispatcher struct {
+	store  *Store
+	client *http.Client
+	// own are the accounts of the API, whose saves are reported as uploads instead of file.saved.
+	own map[common.Address]bool
+
+	workers     int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	retention   time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	wake    chan struct{}
+}
+
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client deliveries are sent with. The default one does not follow redirects.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithWorkers sets the number of deliveries sent at once. Zero keeps the default.
+func WithWorkers(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.workers = n
+		}
+	}
+}
+
+// WithMaxAttempts sets the number of attempts after which a delivery fails. Zero keeps the default.
+func WithMaxAttempts(n int) Option {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.maxAttempts = n
+		}
+	}
+}
+
+// WithBackoff sets the delay before the first retry of a delivery, doubled after every further
+// failure up to max. Zero values keep the defaults.
+func WithBackoff(min, max time.Duration) Option {
+	return func(d *Dispatcher) {
+		if min > 0 {
+			d.minBackoff = min
+		}
+		if max > 0 {
+			d.maxBackoff = max
+		}
+	}
+}
+
+// WithRetention sets how long delivered events are remembered, so that publishing one of them again
+// does not deliver it twice. Zero remembers them forever.
+func WithRetention(retention time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.retention = retention
+	}
+}
+
+// WithOwnAccounts sets the accounts the API writes files from. Their FileSaved logs are not sent as
+// file.saved events, since JobFinished and SaveFinished report the uploads they belong to. Saves the
+// API relays for wallets are still sent, as the registry sees the wallet as their writer.
+func WithOwnAccounts(accounts []common.Address) Option {
+	return func(d *Dispatcher) {
+		for _, account := range accounts {
+			d.own[account] = true
+		}
+	}
+}
+
+// noRedirect makes the client return redirects as they are, which fails the attempt, so that a
+// receiver cannot point deliveries at another host.
+func noRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// New creates a Dispatcher keeping its subscriptions and deliveries in store. Deliveries are sent
+// once Run is called.
+func New(store *Store, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: DefaultTimeout, CheckRedirect: noRedirect},
+		own:         make(map[common.Address]bool),
+		workers:     DefaultWorkers,
+		maxAttempts: DefaultMaxAttempts,
+		minBackoff:  DefaultMinBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+		retention:   DefaultRetention,
+		now:         time.Now,
+		running:     make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Subscribe stores a new subscription and returns it. A secret is generated when sub has none.
+func (d *Dispatcher) Subscribe(sub Subscription) (*Subscription, error) {
+	target, err := url.Parse(sub.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidSubscription)
+	}
+	for _, event := range sub.Events {
+		if !EventTypes[event] {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidSubscription, event)
+		}
+	}
+
+	now := d.now()
+	if sub.ID, err = newID(now); err != nil {
+		return nil, err
+	}
+	if sub.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		sub.Secret = hex.EncodeToString(secret)
+	}
+	sub.CreatedAt = now
+	if err := d.store.PutSubscription(&sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Subscription returns the subscription with the given ID, or ErrSubscriptionNotFound.
+func (d *Dispatcher) Subscription(id string) (*Subscription, error) {
+	return d.store.Subscription(id)
+}
+
+// Subscriptions returns every subscription, oldest first.
+func (d *Dispatcher) Subscriptions() ([]Subscription, error) {
+	return d.store.Subscriptions()
+}
+
+// Unsubscribe deletes a subscription with its delivery log. Its pending deliveries are not sent.
+func (d *Dispatcher) Unsubscribe(id string) error {
+	return d.store.DeleteSubscription(id)
+}
+
+// Deliveries returns the delivery log of a subscription, newest first, paginated like Store.Deliveries.
+func (d *Dispatcher) Deliveries(subscriptionID, cursor string, limit int) ([]Delivery, string, error) {
+	if _, err := d.store.Subscription(subscriptionID); err != nil {
+		return nil, "", err
+	}
+	return d.store.Deliveries(subscriptionID, cursor, limit)
+}
+
+// Replay sends the event of a logged delivery again, as a new delivery.
+func (d *Dispatcher) Replay(subscriptionID, deliveryID string) (*Delivery, error) {
+	original, err := d.store.Delivery(subscriptionID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := d.enqueue(subscriptionID, original.Event, original.ID)
+	if err != nil {
+		return nil, err
+	}
+	d.notify()
+	return delivery, nil
+}
+
+// Publish queues a delivery of event to every subscription it matches: its file path is under the
+// prefix of the subscription, its type is selected and it occurred after the subscription was created.
+// Events are delivered once per subscription, however often they are published.
+func (d *Dispatcher) Publish(event Event) error {
+	subs, err := d.store.Subscriptions()
+	if err != nil {
+		return fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	queued := false
+	for _, sub := range subs {
+		if !matches(&sub, &event) {
+			continue
+		}
+		delivery, err := d.enqueue(sub.ID, event, "")
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to queue delivery to %s: %w", sub.ID, err)
+		}
+		queued = queued || delivery != nil
+	}
+	if queued {
+		d.notify()
+	}
+	return nil
+}
+
+func matches(sub *Subscription, event *Event) bool {
+	// Block times have a precision of one second.
+	if !strings.HasPrefix(event.FilePath, sub.Prefix) || event.OccurredAt.Before(sub.CreatedAt.Truncate(time.Second)) {
+		return false
+	}
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, t := range sub.Events {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue stores a pending delivery of event. It returns nil when the subscription already received
+// the event and the delivery is not a replay.
+func (d *Dispatcher) enqueue(subscriptionID string, event Event, replayOf string) (*Delivery, error) {
+	now := d.now()
+	id, err := newID(now)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &Delivery{
+		ID:             id,
+		SubscriptionID: subscriptionID,
+		Event:          event,
+		Status:         DeliveryPending,
+		ReplayOf:       replayOf,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	added, err := d.store.AddDelivery(delivery)
+	if err != nil || !added {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// FileSaved publishes an indexed FileSaved event as file.saved, unless the API wrote it from one of
+// its own accounts. It is meant as the listener of the indexer.
+func (d *Dispatcher) FileSaved(e indexer.Event) {
+	writer := e.Writer
+	if writer == "" {
+		writer = e.Sender
+	}
+	if e.Removed || d.own[common.HexToAddress(writer)] {
+		return
+	}
+	err := d.Publish(Event{
+		ID:          fmt.Sprintf("%s:%s:%d", EventFileSaved, e.TxHash, e.LogIndex),
+		Type:        EventFileSaved,
+		FilePath:    e.FilePath,
+		CID:         e.CID,
+		TxHash:      e.TxHash,
+		BlockNumber: e.BlockNumber,
+		Sender:      e.Sender,
+		Writer:      writer,
+		OccurredAt:  e.Timestamp,
+	})
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+}
+
+// JobFinished publishes upload.confirmed for mined outbox jobs and tx.reverted for jobs whose save
+// reverted. It is meant as the listener of the outbox.
+func (d *Dispatcher) JobFinished(job outbox.Job) {
+	event := Event{
+		FilePath:    job.FilePath,
+		CID:         job.CID,
+		TxHash:      job.TxHash,
+		BlockNumber: job.BlockNumber,
+		JobID:       job.ID,
+		OccurredAt:  job.UpdatedAt,
+	}
+	switch {
+	case job.Stage == outbox.StageMined:
+		event.Type = EventUploadConfirmed
+	case job.Stage == outbox.StageFailed && job.Code == outbox.CodeTxReverted:
+		event.Type, event.Error = EventTxReverted, job.Error
+	default:
+		return
+	}
+	event.ID = string(event.Type) + ":" + job.ID
+	if err := d.Publish(event); err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+}
+
+// SaveFinished publishes upload.confirmed when the save of cid at filePath the API sent outside the
+// outbox is mined and tx.reverted when it reverted. Other statuses are ignored.
+func (d *Dispatcher) SaveFinished(filePath, cid string, status *contracts.TxStatus) {
+	event := Event{
+		FilePath:    filePath,
+		CID:         cid,
+		TxHash:      status.MinedHash().Hex(),
+		BlockNumber: status.BlockNumber,
+		OccurredAt:  d.now(),
+	}
+	switch status.Status {
+	case contracts.TxMined:
+		event.Type = EventUploadConfirmed
+	case contracts.TxReverted:
+		event.Type, event.Error = EventTxReverted, "transaction reverted"
+	default:
+		return
+	}
+	// A batch saves several files in one transaction.
+	event.ID = fmt.Sprintf("%s:%s:%s", event.Type, event.TxHash, filePath)
+	if err := d.Publish(event); err != nil {
+		log.Printf("webhooks: %v", err)
+	}
+}
+
+// Run sends the pending deliveries, including those left over by a previous run, until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	deliveries := make(chan pendingDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range deliveries {
+				// A delivery may still be handed over while the dispatcher stops.
+				if ctx.Err() == nil {
+					d.deliver(ctx, p)
+				}
+				d.mu.Lock()
+				delete(d.running, p.id)
+				d.mu.Unlock()
+				d.notify()
+			}
+		}()
+	}
+	if d.retention > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.prune(ctx)
+		}()
+	}
+	defer wg.Wait()
+	defer close(deliveries)
+
+	for {
+		next, err := d.dispatch(ctx, deliveries)
+		if err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// prune forgets the delivered events older than the retention right away and then every
+// pruneInterval until ctx is done.
+func (d *Dispatcher) prune(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := d.store.PruneEvents(d.now().Add(-d.retention)); err != nil {
+			log.Printf("webhooks: failed to prune events: %v", err)
+		} else if n > 0 {
+			log.Printf("webhooks: pruned %d delivered events", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch hands the pending deliveries that are due and not being sent to the workers. It returns
+// the time until the next retry is due.
+func (d *Dispatcher) dispatch(ctx context.Context, deliveries chan<- pendingDelivery) (time.Duration, error) {
+	next := d.maxBackoff
+	pending, err := d.store.pending()
+	if err != nil {
+		return next, fmt.Errorf("failed to list pending deliveries: %w", err)
+	}
+	for _, p := range pending {
+		d.mu.Lock()
+		running := d.running[p.id]
+		d.mu.Unlock()
+		if running {
+			continue
+		}
+		delivery, err := d.store.Delivery(p.subscriptionID, p.id)
+		if err != nil {
+			return next, fmt.Errorf("failed to read delivery %s: %w", p.id, err)
+		}
+		if delivery.NextAttempt != nil {
+			if wait := delivery.NextAttempt.Sub(d.now()); wait > 0 {
+				next = min(next, wait)
+				continue
+			}
+		}
+		d.mu.Lock()
+		d.running[p.id] = true
+		d.mu.Unlock()
+		select {
+		case deliveries <- p:
+		case <-ctx.Done():
+			return next, nil
+		}
+	}
+	return next, nil
+}
+
+// deliver sends one attempt of a delivery and records its outcome.
+func (d *Dispatcher) deliver(ctx context.Context, p pendingDelivery) {
+	err := d.attempt(ctx, p)
+	// Deliveries of a subscription deleted in the meantime are dropped.
+	if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+		log.Printf("webhooks: delivery %s: %v", p.id, err)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, p pendingDelivery) error {
+	sub, err := d.store.Subscription(p.subscriptionID)
+	if err != nil {
+		return err
+	}
+	delivery, err := d.store.Delivery(p.subscriptionID, p.id)
+	if err != nil {
+		return err
+	}
+	status, err := d.send(ctx, sub, delivery)
+	if ctx.Err() != nil {
+		// Attempts interrupted by a shutdown are sent again after the restart.
+		return nil
+	}
+	return d.record(delivery, status, err)
+}
+
+// send posts the event of a delivery to the subscription and returns the response status.
+func (d *Dispatcher) send(ctx context.Context, sub *Subscription, delivery *Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, delivery.ID)
+	req.Header.Set(HeaderEvent, string(delivery.Event.Type))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, scheduling the next one with exponential backoff or failing
+// the delivery after too many attempts.
+func (d *Dispatcher) record(delivery *Delivery, status int, err error) error {
+	delivery.Attempts++
+	delivery.ResponseStatus, delivery.Error, delivery.NextAttempt = status, "", nil
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status, delivery.Error = DeliveryFailed, err.Error()
+		log.Printf("webhooks: delivery %s to %s failed after %d attempts: %v", delivery.ID, delivery.SubscriptionID, delivery.Attempts, err)
+	default:
+		next := d.now().Add(d.backoff(delivery.Attempts))
+		delivery.Error, delivery.NextAttempt = err.Error(), &next
+	}
+	delivery.UpdatedAt = d.now()
+	return d.store.PutDelivery(delivery)
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.minBackoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxBackoff)
+}
+
+// notify wakes up the dispatcher.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
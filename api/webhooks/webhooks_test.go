@@ -0,0 +1,329 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/avkos/file-registry/api/contracts"
+	"github.com/avkos/file-registry/api/indexer"
+	"github.com/avkos/file-registry/api/outbox"
+	"github.com/avkos/file-registry/api/webhooks"
+)
+
+// receiver is an httptest server recording the webhook requests it gets. It answers the statuses
+// in fail first, then 204.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	fail     []int
+	events   []webhooks.Event
+	verified []bool
+}
+
+func newReceiver(t *testing.T, secret string, fail ...int) *receiver {
+	r := &receiver{secret: secret, fail: fail}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		timestamp, err := strconv.ParseInt(req.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+		require.NoError(t, err)
+		var event webhooks.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, event)
+		r.verified = append(r.verified, req.Header.Get(webhooks.HeaderSignature) == webhooks.Sign(r.secret, timestamp, body))
+		if len(r.fail) > 0 {
+			status := r.fail[0]
+			r.fail = r.fail[1:]
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() ([]webhooks.Event, []bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhooks.Event(nil), r.events...), append([]bool(nil), r.verified...)
+}
+
+func (r *receiver) heal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = nil
+}
+
+func openStore(t *testing.T) *webhooks.Store {
+	store, err := webhooks.OpenStore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func run(t *testing.T, d *webhooks.Dispatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// lastDelivery waits until the newest delivery of the subscription has the given status.
+func lastDelivery(t *testing.T, d *webhooks.Dispatcher, subscriptionID string, status webhooks.DeliveryStatus) webhooks.Delivery {
+	var delivery webhooks.Delivery
+	require.Eventually(t, func() bool {
+		deliveries, _, err := d.Deliveries(subscriptionID, "", 1)
+		if err != nil || len(deliveries) == 0 {
+			return false
+		}
+		delivery = deliveries[0]
+		return delivery.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return delivery
+}
+
+func savedEvent(filePath, sender string) indexer.Event {
+	return indexer.Event{
+		FilePath:    filePath,
+		CID:         "QmFakeCID",
+		BlockNumber: 12,
+		TxHash:      "0xabc",
+		LogIndex:    3,
+		Timestamp:   time.Now(),
+		Sender:      sender,
+	}
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	r := newReceiver(t, "s3cret")
+	own := common.HexToAddress("0x01")
+	d := webhooks.New(openStore(t), webhooks.WithOwnAccounts([]common.Address{own}))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: r.URL, Prefix: "/a/", Secret: "s3cret"})
+	require.NoError(t, err)
+
+	// Published before the dispatcher runs, like deliveries left over by a previous run.
+	other := common.HexToAddress("0x02").Hex()
+	d.FileSaved(savedEvent("/a/file.txt", other))
+	d.FileSaved(savedEvent("/a/file.txt", other)) // seen again by the next sync
+	d.FileSaved(savedEvent("/b/file.txt", other))
+	d.FileSaved(savedEvent("/a/own.txt", own.Hex()))
+	run(t, d)
+
+	delivery := lastDelivery(t, d, sub.ID, webhooks.DeliveryDelivered)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusNoContent, delivery.ResponseStatus)
+	events, verified := r.received()
+	require.Len(t, events, 1)
+	assert.Equal(t, []bool{true}, verified)
+	assert.Equal(t, webhooks.EventFileSaved, events[0].Type)
+	assert.Equal(t, "/a/file.txt", events[0].FilePath)
+	assert.Equal(t, "QmFakeCID", events[0].CID)
+	assert.Equal(t, other, events[0].Sender)
+}
+
+func TestDispatcher_RelayedWriter(t *testing.T) {
+	own := common.HexToAddress("0x01")
+	d := webhooks.New(openStore(t), webhooks.WithOwnAccounts([]common.Address{own}))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+
+	// The API relayed the save for a wallet, so the wallet is its writer.
+	wallet := common.HexToAddress("0x02").Hex()
+	relayed := savedEvent("/relayed.txt", own.Hex())
+	relayed.Writer = wallet
+	d.FileSaved(relayed)
+	ownSave := savedEvent("/own.txt", own.Hex())
+	ownSave.TxHash, ownSave.Writer = "0xdef", own.Hex()
+	d.FileSaved(ownSave)
+
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "/relayed.txt", deliveries[0].Event.FilePath)
+	assert.Equal(t, own.Hex(), deliveries[0].Event.Sender)
+	assert.Equal(t, wallet, deliveries[0].Event.Writer)
+}
+
+func TestDispatcher_SaveFinished(t *testing.T) {
+	d := webhooks.New(openStore(t))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+
+	status := &contracts.TxStatus{Hash: "0x01", Status: contracts.TxMined, BlockNumber: 7}
+	d.SaveFinished("/a.txt", "QmA", status)
+	d.SaveFinished("/b.txt", "QmB", status) // saved by the same batch transaction
+	d.SaveFinished("/a.txt", "QmA", status) // reported again
+	d.SaveFinished("/c.txt", "QmC", &contracts.TxStatus{Hash: "0x02", Status: contracts.TxReverted, BlockNumber: 8})
+	d.SaveFinished("/d.txt", "QmD", &contracts.TxStatus{Hash: "0x03", Status: contracts.TxCancelled})
+
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+	reverted := deliveries[0].Event
+	assert.Equal(t, webhooks.EventTxReverted, reverted.Type)
+	assert.Equal(t, "/c.txt", reverted.FilePath)
+	assert.Equal(t, "transaction reverted", reverted.Error)
+	confirmed := deliveries[2].Event
+	assert.Equal(t, webhooks.EventUploadConfirmed, confirmed.Type)
+	assert.Equal(t, "QmA", confirmed.CID)
+	assert.Equal(t, common.HexToHash("0x01").Hex(), confirmed.TxHash)
+	assert.Equal(t, uint64(7), confirmed.BlockNumber)
+	assert.Empty(t, confirmed.JobID)
+}
+
+func TestDispatcher_DoesNotFollowRedirects(t *testing.T) {
+	target := newReceiver(t, "")
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	d := webhooks.New(openStore(t), webhooks.WithMaxAttempts(1))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: redirect.URL})
+	require.NoError(t, err)
+	run(t, d)
+
+	d.FileSaved(savedEvent("/file.txt", common.HexToAddress("0x02").Hex()))
+
+	failed := lastDelivery(t, d, sub.ID, webhooks.DeliveryFailed)
+	assert.Equal(t, http.StatusTemporaryRedirect, failed.ResponseStatus)
+	events, _ := target.received()
+	assert.Empty(t, events)
+}
+
+func TestStore_PruneEvents(t *testing.T) {
+	store := openStore(t)
+	d := webhooks.New(store)
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+	event := savedEvent("/file.txt", common.HexToAddress("0x02").Hex())
+	d.FileSaved(event)
+
+	n, err := store.PruneEvents(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	d.FileSaved(event)
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	// A forgotten event is delivered again, while its delivery log is kept.
+	n, err = store.PruneEvents(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	d.FileSaved(event)
+	deliveries, _, err = d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	r := newReceiver(t, "", http.StatusInternalServerError, http.StatusBadGateway)
+	d := webhooks.New(openStore(t), webhooks.WithBackoff(10*time.Millisecond, time.Second))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: r.URL})
+	require.NoError(t, err)
+	assert.Len(t, sub.Secret, 64)
+	r.secret = sub.Secret
+	run(t, d)
+
+	d.JobFinished(outbox.Job{ID: "job-1", FilePath: "/file.txt", Stage: outbox.StageMined, CID: "QmFakeCID", TxHash: "0xabc", UpdatedAt: time.Now()})
+
+	delivery := lastDelivery(t, d, sub.ID, webhooks.DeliveryDelivered)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Empty(t, delivery.Error)
+	events, verified := r.received()
+	require.Len(t, events, 3)
+	assert.Equal(t, []bool{true, true, true}, verified)
+	assert.Equal(t, webhooks.EventUploadConfirmed, events[2].Type)
+	assert.Equal(t, "job-1", events[2].JobID)
+}
+
+func TestDispatcher_FailsAndReplays(t *testing.T) {
+	r := newReceiver(t, "s3cret", http.StatusInternalServerError, http.StatusInternalServerError)
+	d := webhooks.New(openStore(t), webhooks.WithBackoff(time.Millisecond, time.Millisecond), webhooks.WithMaxAttempts(2))
+	sub, err := d.Subscribe(webhooks.Subscription{URL: r.URL, Secret: "s3cret", Events: []webhooks.EventType{webhooks.EventTxReverted}})
+	require.NoError(t, err)
+	run(t, d)
+
+	d.JobFinished(outbox.Job{ID: "job-1", FilePath: "/file.txt", Stage: outbox.StageMined, UpdatedAt: time.Now()})
+	d.JobFinished(outbox.Job{ID: "job-2", FilePath: "/file.txt", Stage: outbox.StageFailed, Code: outbox.CodeTxReverted, Error: "transaction reverted", UpdatedAt: time.Now()})
+
+	failed := lastDelivery(t, d, sub.ID, webhooks.DeliveryFailed)
+	assert.Equal(t, 2, failed.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, failed.ResponseStatus)
+	assert.Contains(t, failed.Error, "500")
+	assert.Equal(t, webhooks.EventTxReverted, failed.Event.Type)
+	assert.Equal(t, "transaction reverted", failed.Event.Error)
+
+	r.heal()
+	replay, err := d.Replay(sub.ID, failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, failed.ID, replay.ReplayOf)
+	delivered := lastDelivery(t, d, sub.ID, webhooks.DeliveryDelivered)
+	assert.Equal(t, replay.ID, delivered.ID)
+	assert.Equal(t, failed.Event.ID, delivered.Event.ID)
+
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+
+	_, err = d.Replay(sub.ID, "unknown")
+	assert.ErrorIs(t, err, webhooks.ErrDeliveryNotFound)
+}
+
+func TestDispatcher_Subscriptions(t *testing.T) {
+	d := webhooks.New(openStore(t))
+
+	_, err := d.Subscribe(webhooks.Subscription{URL: "ftp://example.com/hook"})
+	assert.ErrorIs(t, err, webhooks.ErrInvalidSubscription)
+	_, err = d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook", Events: []webhooks.EventType{"file.deleted"}})
+	assert.ErrorIs(t, err, webhooks.ErrInvalidSubscription)
+
+	sub, err := d.Subscribe(webhooks.Subscription{URL: "https://example.com/hook", Prefix: "/a/"})
+	require.NoError(t, err)
+	d.FileSaved(savedEvent("/a/file.txt", common.HexToAddress("0x02").Hex()))
+	deliveries, _, err := d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, webhooks.DeliveryPending, deliveries[0].Status)
+
+	// Events that occurred before the subscription are not sent.
+	old := savedEvent("/a/old.txt", common.HexToAddress("0x02").Hex())
+	old.TxHash, old.Timestamp = "0xdef", time.Now().Add(-time.Hour)
+	d.FileSaved(old)
+	deliveries, _, err = d.Deliveries(sub.ID, "", 0)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	subs, err := d.Subscriptions()
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	assert.Equal(t, "/a/", subs[0].Prefix)
+
+	require.NoError(t, d.Unsubscribe(sub.ID))
+	_, err = d.Subscription(sub.ID)
+	assert.ErrorIs(t, err, webhooks.ErrSubscriptionNotFound)
+	_, _, err = d.Deliveries(sub.ID, "", 0)
+	assert.ErrorIs(t, err, webhooks.ErrSubscriptionNotFound)
+	assert.ErrorIs(t, d.Unsubscribe(sub.ID), webhooks.ErrSubscriptionNotFound)
+}
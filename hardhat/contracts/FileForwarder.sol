@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.27;
+
+// FileForwarder is a minimal EIP-2771 forwarder. Users sign ForwardRequests as EIP-712 typed data and
+// a relayer submits them with execute, paying the gas. The target receives the request data followed
+// by the signer address, which FileRegistry reads back through _msgSender.
+contract FileForwarder {
+    struct ForwardRequest {
+        address from;
+        address to;
+        uint256 value;
+        uint256 gas;
+        uint256 nonce;
+        bytes data;
+    }
+
+    bytes32 private constant DOMAIN_TYPEHASH =
+        keccak256("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)");
+    bytes32 private constant FORWARD_REQUEST_TYPEHASH =
+        keccak256("ForwardRequest(address from,address to,uint256 value,uint256 gas,uint256 nonce,bytes data)");
+
+    mapping(address => uint256) private nonces;
+
+    error InvalidSignature(address from);
+    error InvalidNonce(address from, uint256 expected);
+    error InsufficientGas(uint256 requested);
+
+    function getNonce(address from) public view returns (uint256) {
+        return nonces[from];
+    }
+
+    function domainSeparator() public view returns (bytes32) {
+        return keccak256(abi.encode(DOMAIN_TYPEHASH, keccak256("FileForwarder"), keccak256("1"), block.chainid, address(this)));
+    }
+
+    // verify reports whether req carries the next nonce of its sender and is signed by it.
+    function verify(ForwardRequest calldata req, bytes calldata signature) public view returns (bool) {
+        return nonces[req.from] == req.nonce && _recover(req, signature) == req.from;
+    }
+
+    // execute calls req.to with req.data followed by req.from. A revert of the target is bubbled up, so
+    // the relayed transaction fails with the error of the target and the nonce is not spent.
+    function execute(ForwardRequest calldata req, bytes calldata signature) public payable returns (bytes memory) {
+        if (nonces[req.from] != req.nonce) {
+            revert InvalidNonce(req.from, nonces[req.from]);
+        }
+        if (_recover(req, signature) != req.from) {
+            revert InvalidSignature(req.from);
+        }
+        nonces[req.from] = req.nonce + 1;
+
+        (bool success, bytes memory result) = req.to.call{gas: req.gas, value: req.value}(abi.encodePacked(req.data, req.from));
+        if (!success) {
+            assembly {
+                revert(add(result, 32), mload(result))
+            }
+        }
+        // The call gets at most 63/64 of the remaining gas, so a relayer sending too little gas could make
+        // it fail while the request still counts as executed.
+        if (gasleft() <= req.gas / 63) {
+            revert InsufficientGas(req.gas);
+        }
+        return result;
+    }
+
+    // _recover returns the signer of req. Signatures ecrecover cannot recover a signer from revert, so a
+    // request from the zero address is never executed.
+    function _recover(ForwardRequest calldata req, bytes calldata signature) private view returns (address) {
+        require(signature.length == 65, InvalidSignature(req.from));
+        bytes32 structHash = keccak256(
+            abi.encode(FORWARD_REQUEST_TYPEHASH, req.from, req.to, req.value, req.gas, req.nonce, keccak256(req.data))
+        );
+        bytes32 digest = keccak256(abi.encodePacked("\x19\x01", domainSeparator(), structHash));
+        bytes32 r = bytes32(signature[0:32]);
+        bytes32 s = bytes32(signature[32:64]);
+        uint8 v = uint8(signature[64]);
+        if (v < 27) {
+            v += 27;
+        }
+        address recovered = ecrecover(digest, v, r, s);
+        require(recovered != address(0), InvalidSignature(req.from));
+        return recovered;
+    }
+}
@@ -1,17 +1,239 @@
 // SPDX-License-Identifier: MIT
 pragma solidity ^0.8.27;
 
+// FileRegistry maps file paths to IPFS CIDs.
+//
+// Access control: the first account to save a path owns it. A namespace is a path prefix ending in
+// "/", which files never do; its owner controls every path below it, and paths inside an owned namespace are never owned
+// on their own. Owners grant and revoke writers for their path or namespace, and the admin may write
+// anything and manage every grant.
+//
+// Calls made through the trusted forwarder (EIP-2771) act for the account the forwarder appends to the
+// calldata, so users can write without paying gas while keeping ownership of what they save.
 contract FileRegistry {
     mapping(string => string) private fileToCid;
 
+    address public admin;
+    address public immutable trustedForwarder;
+    // Owners and writers are keyed by keccak256 of the path or namespace.
+    mapping(bytes32 => address) private owners;
+    mapping(bytes32 => mapping(address => bool)) private writers;
+    // Prefixes of saved paths, which can no longer be claimed as namespaces except by the admin.
+    mapping(bytes32 => bool) private usedPrefixes;
+
     event FileSaved(string filePath, string cid);
+    event FileRemoved(string filePath, string cid);
+    event OwnershipClaimed(string path, address indexed owner);
+    event OwnershipTransferred(string path, address indexed previousOwner, address indexed newOwner);
+    event WriterGranted(string path, address indexed account);
+    event WriterRevoked(string path, address indexed account);
+    event AdminChanged(address indexed previousAdmin, address indexed newAdmin);
+
+    error FileNotFound(string filePath);
+    error NotAuthorized(string path, address account);
+    error NotOwner(string path, address account);
+    error NotAdmin(address account);
+    error AlreadyOwned(string path, address owner);
+    error InvalidFilePath(string filePath);
+    error InvalidNamespace(string namespace);
+    error NamespaceInUse(string namespace);
+    error LengthMismatch(uint256 paths, uint256 cids);
+    error InvalidOwner(address newOwner);
 
-    function save(string memory filePath, string memory cid) public {
+    constructor(address forwarder) {
+        trustedForwarder = forwarder;
+        admin = msg.sender;
+        emit AdminChanged(address(0), msg.sender);
+    }
+
+    modifier onlyWriter(string memory filePath) {
+        address sender = _msgSender();
+        if (!canWrite(filePath, sender)) {
+            revert NotAuthorized(filePath, sender);
+        }
+        _;
+    }
+
+    function save(string memory filePath, string memory cid) public onlyWriter(filePath) {
+        // Owners of files and namespaces share one map, so a file named like a namespace would own it
+        // without the checks of claimNamespace.
+        bytes memory path = bytes(filePath);
+        if (path.length > 0 && path[path.length - 1] == "/") {
+            revert InvalidFilePath(filePath);
+        }
+        // A path outside any namespace is owned by its first writer.
+        if (_namespaceOf(filePath) == bytes32(0) && owners[keccak256(bytes(filePath))] == address(0)) {
+            address sender = _msgSender();
+            owners[keccak256(bytes(filePath))] = sender;
+            emit OwnershipClaimed(filePath, sender);
+            bytes32[] memory prefixes = _prefixKeys(filePath);
+            for (uint256 i = 0; i < prefixes.length; i++) {
+                usedPrefixes[prefixes[i]] = true;
+            }
+        }
         fileToCid[filePath] = cid;
         emit FileSaved(filePath, cid);
     }
 
+    // saveBatch saves many files in one transaction, with the same checks and events as save. The
+    // whole batch reverts when the caller may not write one of the paths.
+    function saveBatch(string[] memory paths, string[] memory cids) public {
+        if (paths.length != cids.length) {
+            revert LengthMismatch(paths.length, cids.length);
+        }
+        for (uint256 i = 0; i < paths.length; i++) {
+            save(paths[i], cids[i]);
+        }
+    }
+
     function get(string memory filePath) public view returns (string memory) {
         return fileToCid[filePath];
     }
-}
\ No newline at end of file
+
+    function remove(string memory filePath) public onlyWriter(filePath) {
+        string memory cid = fileToCid[filePath];
+        if (bytes(cid).length == 0) {
+            revert FileNotFound(filePath);
+        }
+        delete fileToCid[filePath];
+        emit FileRemoved(filePath, cid);
+    }
+
+    // claimNamespace makes the caller owner of a namespace that is neither owned nor inside an owned
+    // one. Only the admin may claim a namespace that already holds files of other owners.
+    function claimNamespace(string memory namespace) public {
+        bytes memory ns = bytes(namespace);
+        if (ns.length == 0 || ns[ns.length - 1] != "/") {
+            revert InvalidNamespace(namespace);
+        }
+        bytes32 key = keccak256(ns);
+        if (owners[key] != address(0)) {
+            revert AlreadyOwned(namespace, owners[key]);
+        }
+        bytes32 enclosing = _namespaceOf(namespace);
+        if (enclosing != bytes32(0)) {
+            revert AlreadyOwned(namespace, owners[enclosing]);
+        }
+        address sender = _msgSender();
+        if (usedPrefixes[key] && sender != admin) {
+            revert NamespaceInUse(namespace);
+        }
+        owners[key] = sender;
+        emit OwnershipClaimed(namespace, sender);
+    }
+
+    function grantWriter(string memory path, address account) public {
+        bytes32 key = _ownedKey(path);
+        writers[key][account] = true;
+        emit WriterGranted(path, account);
+    }
+
+    function revokeWriter(string memory path, address account) public {
+        bytes32 key = _ownedKey(path);
+        writers[key][account] = false;
+        emit WriterRevoked(path, account);
+    }
+
+    // transferOwnership hands a path or namespace to newOwner. The zero address would leave it unowned,
+    // and so open to every writer, so it is rejected.
+    function transferOwnership(string memory path, address newOwner) public {
+        if (newOwner == address(0)) {
+            revert InvalidOwner(newOwner);
+        }
+        bytes32 key = _ownedKey(path);
+        address previousOwner = owners[key];
+        owners[key] = newOwner;
+        emit OwnershipTransferred(path, previousOwner, newOwner);
+    }
+
+    function setAdmin(address newAdmin) public {
+        address sender = _msgSender();
+        if (sender != admin) {
+            revert NotAdmin(sender);
+        }
+        emit AdminChanged(admin, newAdmin);
+        admin = newAdmin;
+    }
+
+    function isTrustedForwarder(address forwarder) public view returns (bool) {
+        return forwarder == trustedForwarder;
+    }
+
+    function ownerOf(string memory path) public view returns (address) {
+        return owners[keccak256(bytes(path))];
+    }
+
+    function isWriter(string memory path, address account) public view returns (bool) {
+        return writers[keccak256(bytes(path))][account];
+    }
+
+    // canWrite reports whether account may save or remove filePath: the admin always can, otherwise
+    // the owning namespace decides, then the owner of the path itself. Unowned paths are open.
+    function canWrite(string memory filePath, address account) public view returns (bool) {
+        if (account == admin) {
+            return true;
+        }
+        bytes32 key = _namespaceOf(filePath);
+        if (key == bytes32(0)) {
+            key = keccak256(bytes(filePath));
+            if (owners[key] == address(0)) {
+                return true;
+            }
+        }
+        return owners[key] == account || writers[key][account];
+    }
+
+    // _msgSender returns the account a call acts for: the address the trusted forwarder appended to the
+    // calldata, or the caller itself.
+    function _msgSender() private view returns (address) {
+        if (msg.sender == trustedForwarder && msg.data.length >= 20) {
+            return address(bytes20(msg.data[msg.data.length - 20:]));
+        }
+        return msg.sender;
+    }
+
+    // _ownedKey returns the key of an owned path or namespace, reverting unless the caller is its owner or the admin.
+    function _ownedKey(string memory path) private view returns (bytes32) {
+        bytes32 key = keccak256(bytes(path));
+        address sender = _msgSender();
+        if (sender != admin && (owners[key] == address(0) || owners[key] != sender)) {
+            revert NotOwner(path, sender);
+        }
+        return key;
+    }
+
+    // _namespaceOf returns the key of the outermost owned namespace strictly containing path, or zero.
+    function _namespaceOf(string memory path) private view returns (bytes32) {
+        bytes32[] memory prefixes = _prefixKeys(path);
+        for (uint256 i = 0; i < prefixes.length; i++) {
+            if (owners[prefixes[i]] != address(0)) {
+                return prefixes[i];
+            }
+        }
+        return bytes32(0);
+    }
+
+    // _prefixKeys returns the keys of every prefix of path ending in "/", shortest first, excluding path itself.
+    function _prefixKeys(string memory path) private pure returns (bytes32[] memory) {
+        bytes memory p = bytes(path);
+        uint256 count = 0;
+        for (uint256 i = 0; i + 1 < p.length; i++) {
+            if (p[i] == "/") {
+                count++;
+            }
+        }
+        bytes32[] memory keys = new bytes32[](count);
+        uint256 n = 0;
+        for (uint256 i = 0; i + 1 < p.length; i++) {
+            if (p[i] != "/") {
+                continue;
+            }
+            bytes memory prefix = new bytes(i + 1);
+            for (uint256 j = 0; j <= i; j++) {
+                prefix[j] = p[j];
+            }
+            keys[n++] = keccak256(prefix);
+        }
+        return keys;
+    }
+}
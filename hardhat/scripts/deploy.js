@@ -1,8 +1,13 @@
 const { ethers } = require("hardhat");
 
 async function main() {
+    const FileForwarder = await ethers.getContractFactory("FileForwarder");
+    const forwarder = await FileForwarder.deploy();
+    const forwarderAddress = await forwarder.getAddress()
+    console.log("FileForwarder deployed to:", forwarderAddress)
+
     const FileRegistry = await ethers.getContractFactory("FileRegistry");
-    const registry = await FileRegistry.deploy();
+    const registry = await FileRegistry.deploy(forwarderAddress);
     // get private key from hardhat config
 
     const contractAddress = await registry.getAddress()
@@ -1,14 +1,41 @@
 import { expect } from "chai";
 import hre from "hardhat";
 import {v4} from "uuid";
-import {FileRegistry} from "../typechain-types";
+import {FileForwarder, FileRegistry} from "../typechain-types";
 
 describe("FileRegister", function () {
   async function deploy() {
-    const [owner] = await hre.ethers.getSigners();
+    const [owner, alice, bob] = await hre.ethers.getSigners();
+    const FileForwarder = await hre.ethers.getContractFactory("FileForwarder");
+    const forwarder = await FileForwarder.deploy();
     const FileRegistry = await hre.ethers.getContractFactory("FileRegistry");
-    const fileRegistry = await FileRegistry.deploy();
-    return { fileRegistry, owner };
+    const fileRegistry = await FileRegistry.deploy(await forwarder.getAddress());
+    return { fileRegistry, forwarder, owner, alice, bob };
+  }
+
+  // signRequest has from sign a ForwardRequest calling the registry with data.
+  async function signRequest(forwarder: FileForwarder, registry: FileRegistry, from: any, data: string) {
+    const {chainId} = await hre.ethers.provider.getNetwork()
+    const domain = {name: "FileForwarder", version: "1", chainId, verifyingContract: await forwarder.getAddress()}
+    const types = {
+      ForwardRequest: [
+        {name: "from", type: "address"},
+        {name: "to", type: "address"},
+        {name: "value", type: "uint256"},
+        {name: "gas", type: "uint256"},
+        {name: "nonce", type: "uint256"},
+        {name: "data", type: "bytes"},
+      ],
+    }
+    const request = {
+      from: from.address,
+      to: await registry.getAddress(),
+      value: 0,
+      gas: 500000,
+      nonce: await forwarder.getNonce(from.address),
+      data,
+    }
+    return {request, signature: await from.signTypedData(domain, types, request)}
   }
 
   describe("Deployment", function () {
@@ -57,5 +84,204 @@ describe("FileRegister", function () {
       expect(storedCid).to.equal("");
     });
 
+    it("Should remove a cid", async function () {
+      const cid = v4()
+      const path = `some/file/path/check/remove`
+      await contract.save(path, cid)
+      await expect(contract.remove(path))
+        .to.emit(contract, "FileRemoved")
+        .withArgs(path, cid)
+      const resCid = await contract.get(path)
+      expect(resCid).to.equal("");
+    });
+
+    it("Should revert when removing an unknown file path", async function () {
+      const unknownFilePath = "/unknown/removed.txt";
+      await expect(contract.remove(unknownFilePath))
+        .to.be.revertedWithCustomError(contract, "FileNotFound")
+        .withArgs(unknownFilePath)
+    });
+
+    it("Should save a batch of files", async function () {
+      const paths = [`batch/${v4()}/a.txt`, `batch/${v4()}/b.txt`, `batch/${v4()}/c.txt`]
+      const cids = [v4(), v4(), v4()]
+      await expect(contract.saveBatch(paths, cids))
+        .to.emit(contract, "FileSaved").withArgs(paths[0], cids[0])
+        .and.to.emit(contract, "FileSaved").withArgs(paths[2], cids[2])
+      for (let i = 0; i < paths.length; i++) {
+        expect(await contract.get(paths[i])).to.equal(cids[i]);
+      }
+    });
+
+    it("Should revert a batch with mismatched lengths", async function () {
+      await expect(contract.saveBatch(["batch/one.txt", "batch/two.txt"], [v4()]))
+        .to.be.revertedWithCustomError(contract, "LengthMismatch")
+        .withArgs(2, 1)
+    });
+
+  });
+
+  describe("Access control", function () {
+    it("Should make the deployer admin", async function () {
+      const {fileRegistry, owner} = await deploy()
+      expect(await fileRegistry.admin()).to.equal(owner.address);
+    });
+
+    it("Should give a path to its first writer", async function () {
+      const {fileRegistry, alice, bob} = await deploy()
+      const path = "/alice/notes.txt"
+      await expect(fileRegistry.connect(alice).save(path, v4()))
+        .to.emit(fileRegistry, "OwnershipClaimed")
+        .withArgs(path, alice.address)
+      expect(await fileRegistry.ownerOf(path)).to.equal(alice.address);
+
+      await expect(fileRegistry.connect(bob).save(path, v4()))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+        .withArgs(path, bob.address)
+      await expect(fileRegistry.connect(bob).remove(path))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+    });
+
+    it("Should revert a whole batch when one path is not writable", async function () {
+      const {fileRegistry, alice, bob} = await deploy()
+      const path = "/alice/batch.txt"
+      await fileRegistry.connect(alice).save(path, v4())
+
+      await expect(fileRegistry.connect(bob).saveBatch(["/bob/batch.txt", path], [v4(), v4()]))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+        .withArgs(path, bob.address)
+      expect(await fileRegistry.get("/bob/batch.txt")).to.equal("");
+    });
+
+    it("Should let owners grant and revoke writers", async function () {
+      const {fileRegistry, alice, bob} = await deploy()
+      const path = "/alice/shared.txt"
+      await fileRegistry.connect(alice).save(path, v4())
+
+      await expect(fileRegistry.connect(bob).grantWriter(path, bob.address))
+        .to.be.revertedWithCustomError(fileRegistry, "NotOwner")
+      await expect(fileRegistry.connect(alice).grantWriter(path, bob.address))
+        .to.emit(fileRegistry, "WriterGranted")
+        .withArgs(path, bob.address)
+      expect(await fileRegistry.isWriter(path, bob.address)).to.equal(true);
+      await fileRegistry.connect(bob).save(path, v4())
+
+      await fileRegistry.connect(alice).revokeWriter(path, bob.address)
+      expect(await fileRegistry.canWrite(path, bob.address)).to.equal(false);
+      await expect(fileRegistry.connect(bob).save(path, v4()))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+    });
+
+    it("Should let a namespace owner control every path below it", async function () {
+      const {fileRegistry, alice, bob} = await deploy()
+      await expect(fileRegistry.connect(alice).claimNamespace("/team"))
+        .to.be.revertedWithCustomError(fileRegistry, "InvalidNamespace")
+      await fileRegistry.connect(alice).claimNamespace("/team/")
+      await expect(fileRegistry.connect(bob).claimNamespace("/team/sub/"))
+        .to.be.revertedWithCustomError(fileRegistry, "AlreadyOwned")
+        .withArgs("/team/sub/", alice.address)
+
+      await fileRegistry.connect(alice).save("/team/a.txt", v4())
+      expect(await fileRegistry.ownerOf("/team/a.txt")).to.equal(hre.ethers.ZeroAddress);
+      await expect(fileRegistry.connect(bob).save("/team/b.txt", v4()))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+
+      await fileRegistry.connect(alice).grantWriter("/team/", bob.address)
+      await fileRegistry.connect(bob).save("/team/deep/b.txt", v4())
+    });
+
+    it("Should not let a namespace take over files of other owners", async function () {
+      const {fileRegistry, owner, alice, bob} = await deploy()
+      await fileRegistry.connect(alice).save("/docs/a.txt", v4())
+      await expect(fileRegistry.connect(bob).claimNamespace("/docs/"))
+        .to.be.revertedWithCustomError(fileRegistry, "NamespaceInUse")
+      await fileRegistry.connect(owner).claimNamespace("/docs/")
+    });
+
+    it("Should not let a save of a path ending in / take over a namespace", async function () {
+      const {fileRegistry, alice, bob} = await deploy()
+      await fileRegistry.connect(alice).save("/reports/a.txt", v4())
+      await expect(fileRegistry.connect(bob).save("/reports/", v4()))
+        .to.be.revertedWithCustomError(fileRegistry, "InvalidFilePath")
+        .withArgs("/reports/")
+      await expect(fileRegistry.connect(bob).saveBatch(["/bob/a.txt", "/reports/"], [v4(), v4()]))
+        .to.be.revertedWithCustomError(fileRegistry, "InvalidFilePath")
+      expect(await fileRegistry.ownerOf("/reports/")).to.equal(hre.ethers.ZeroAddress);
+      expect(await fileRegistry.canWrite("/reports/a.txt", bob.address)).to.equal(false);
+      await expect(fileRegistry.connect(bob).save("/reports/a.txt", v4()))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+    });
+
+    it("Should let the admin write anything and hand over the role", async function () {
+      const {fileRegistry, owner, alice} = await deploy()
+      await fileRegistry.connect(alice).save("/alice/x.txt", v4())
+      await fileRegistry.connect(owner).save("/alice/x.txt", v4())
+      await fileRegistry.connect(owner).transferOwnership("/alice/x.txt", owner.address)
+      expect(await fileRegistry.ownerOf("/alice/x.txt")).to.equal(owner.address);
+      await expect(fileRegistry.connect(owner).transferOwnership("/alice/x.txt", hre.ethers.ZeroAddress))
+        .to.be.revertedWithCustomError(fileRegistry, "InvalidOwner")
+
+      await expect(fileRegistry.connect(alice).setAdmin(alice.address))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAdmin")
+      await expect(fileRegistry.connect(owner).setAdmin(alice.address))
+        .to.emit(fileRegistry, "AdminChanged")
+        .withArgs(owner.address, alice.address)
+    });
+  });
+
+  describe("Meta-transactions", function () {
+    it("Should attribute relayed saves to the signer", async function () {
+      const {fileRegistry, forwarder, owner, alice} = await deploy()
+      expect(await fileRegistry.isTrustedForwarder(await forwarder.getAddress())).to.equal(true);
+      const path = "/alice/relayed.txt"
+      const data = fileRegistry.interface.encodeFunctionData("save", [path, v4()])
+      const {request, signature} = await signRequest(forwarder, fileRegistry, alice, data)
+      expect(await forwarder.verify(request, signature)).to.equal(true);
+
+      await expect(forwarder.connect(owner).execute(request, signature))
+        .to.emit(fileRegistry, "OwnershipClaimed")
+        .withArgs(path, alice.address)
+      expect(await fileRegistry.ownerOf(path)).to.equal(alice.address);
+      expect(await forwarder.getNonce(alice.address)).to.equal(1);
+
+      await expect(forwarder.connect(owner).execute(request, signature))
+        .to.be.revertedWithCustomError(forwarder, "InvalidNonce")
+        .withArgs(alice.address, 1)
+    });
+
+    it("Should reject requests not signed by their sender", async function () {
+      const {fileRegistry, forwarder, alice, bob} = await deploy()
+      const data = fileRegistry.interface.encodeFunctionData("save", ["/alice/forged.txt", v4()])
+      const {request, signature} = await signRequest(forwarder, fileRegistry, bob, data)
+      const forged = {...request, from: alice.address}
+      expect(await forwarder.verify(forged, signature)).to.equal(false);
+      await expect(forwarder.execute(forged, signature))
+        .to.be.revertedWithCustomError(forwarder, "InvalidSignature")
+        .withArgs(alice.address)
+    });
+
+    it("Should reject requests from the zero address", async function () {
+      const {fileRegistry, forwarder, alice} = await deploy()
+      const data = fileRegistry.interface.encodeFunctionData("save", ["/zero/file.txt", v4()])
+      const {request} = await signRequest(forwarder, fileRegistry, alice, data)
+      const fromZero = {...request, from: hre.ethers.ZeroAddress, nonce: 0}
+      for (const signature of ["0x", "0x" + "00".repeat(65)]) {
+        await expect(forwarder.execute(fromZero, signature))
+          .to.be.revertedWithCustomError(forwarder, "InvalidSignature")
+          .withArgs(hre.ethers.ZeroAddress)
+      }
+    });
+
+    it("Should bubble up reverts of the registry", async function () {
+      const {fileRegistry, forwarder, alice, bob} = await deploy()
+      const path = "/alice/owned.txt"
+      await fileRegistry.connect(alice).save(path, v4())
+      const data = fileRegistry.interface.encodeFunctionData("save", [path, v4()])
+      const {request, signature} = await signRequest(forwarder, fileRegistry, bob, data)
+      await expect(forwarder.execute(request, signature))
+        .to.be.revertedWithCustomError(fileRegistry, "NotAuthorized")
+        .withArgs(path, bob.address)
+      expect(await forwarder.getNonce(bob.address)).to.equal(0);
+    });
   });
 });
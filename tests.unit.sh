@@ -1,10 +1,14 @@
 #!/bin/sh
 set -e
 
+# Compile the contracts, whose Hardhat artifacts the Go tests deploy
+echo "Compiling contracts..."
+(cd hardhat && npx hardhat compile)
+
 # Run tests
 echo "Running Go tests..."
 cd api
-go test ./... -v
+CONTRACT_ARTIFACTS_REQUIRED=1 go test ./... -v
 echo "Tests passed."
 
 # Start the main application